@@ -3,29 +3,38 @@ module github.com/li6in9muyou/jwt-auth-proxy
 go 1.19
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-playground/validator v9.31.0+incompatible
-	github.com/google/uuid v1.3.0
+	github.com/google/uuid v1.6.0
 	github.com/gorilla/mux v1.8.0
+	github.com/oschwald/geoip2-golang v1.8.0
+	github.com/pires/go-proxyproto v0.7.0
 	github.com/pquerna/otp v1.4.0
+	github.com/redis/go-redis/v9 v9.0.5
 	go.mongodb.org/mongo-driver v1.11.6
 	golang.org/x/crypto v0.9.0
+	golang.org/x/net v0.10.0
+	golang.org/x/text v0.9.0
 )
 
 require (
 	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/leodido/go-urn v1.2.4 // indirect
 	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
+	github.com/oschwald/maxminddb-golang v1.10.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
 	github.com/xdg-go/scram v1.1.1 // indirect
 	github.com/xdg-go/stringprep v1.0.3 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
-	golang.org/x/text v0.9.0 // indirect
+	golang.org/x/sys v0.8.0 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 )
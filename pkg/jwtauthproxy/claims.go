@@ -0,0 +1,41 @@
+// Package jwtauthproxy verifies and issues the access tokens of JWT Auth Proxy,
+// so Go services can authenticate requests directly instead of running behind the proxy.
+package jwtauthproxy
+
+import (
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Claims holds payload the issued JWTs
+type Claims struct {
+	Email                  string                 `json:"email"`
+	UserID                 string                 `json:"userID"`
+	OTPEnrollment          bool                   `json:"otpEnrollment,omitempty"`
+	Scopes                 []string               `json:"scopes,omitempty"`
+	Guest                  bool                   `json:"guest,omitempty"`
+	GuestID                string                 `json:"guestID,omitempty"`
+	Organization           string                 `json:"organization,omitempty"`
+	OrganizationRole       string                 `json:"organizationRole,omitempty"`
+	Phone                  string                 `json:"phone,omitempty"`
+	Confirmed              bool                   `json:"confirmed,omitempty"`
+	Roles                  []string               `json:"roles,omitempty"`
+	Metadata               map[string]interface{} `json:"metadata,omitempty"`
+	AppMetadata            map[string]interface{} `json:"appMetadata,omitempty"`
+	PasswordChangeRequired bool                   `json:"pwChangeRequired,omitempty"`
+	jwt.StandardClaims
+}
+
+// IsRestricted checks if the token may only be used to enroll a second factor or change the password
+func (c *Claims) IsRestricted() bool {
+	return c.OTPEnrollment || c.PasswordChangeRequired
+}
+
+// HasRole checks if the user has the given role
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package jwtauthproxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var testSigningKey = []byte("ohzeeg0aiv5Ohth4eiPh9aiNgoh3Ahx7")
+
+func TestSignAndParseToken(t *testing.T) {
+	token, err := SignToken(&Claims{UserID: "123", Roles: []string{"admin"}}, testSigningKey, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ParseToken(token, testSigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != "123" || !claims.HasRole("admin") {
+		t.Error("Expected claims to be preserved")
+	}
+	if _, err := ParseToken(token, []byte("other")); err == nil {
+		t.Error("Expected token signed with other key to be rejected")
+	}
+	anonymous, _ := SignToken(&Claims{}, testSigningKey, time.Minute)
+	if _, err := ParseToken(anonymous, testSigningKey); err != ErrInvalidToken {
+		t.Error("Expected token without UserID to be rejected")
+	}
+
+	expired, _ := SignToken(&Claims{UserID: "123", StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(-time.Minute).Unix()}}, testSigningKey, time.Minute)
+	_, err = ParseToken(expired, testSigningKey)
+	var validationErr *jwt.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Errors&jwt.ValidationErrorExpired == 0 {
+		t.Error("Expected expired token to be rejected with validation error")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var claims *Claims
+	handler := NewMiddleware(testSigningKey).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims = ClaimsFromContext(r.Context())
+	}))
+	token, _ := SignToken(&Claims{UserID: "123"}, testSigningKey, time.Minute)
+	guestToken, _ := SignToken(&Claims{Guest: true, GuestID: "456"}, testSigningKey, time.Minute)
+
+	for _, tc := range []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"invalid", http.StatusUnauthorized},
+		{guestToken, http.StatusUnauthorized},
+		{token, http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != tc.status {
+			t.Errorf("Expected HTTP Status %d, but got %d", tc.status, res.Code)
+		}
+	}
+	if claims == nil || claims.UserID != "123" {
+		t.Error("Expected claims in request context")
+	}
+}
@@ -0,0 +1,92 @@
+package jwtauthproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const contextKeyClaims = contextKey("Claims")
+
+// ErrMissingToken is returned for requests without a bearer token
+var ErrMissingToken = errors.New("missing bearer token")
+
+// ErrRestrictedToken is returned for guest tokens if not allowed, and tokens only valid for enrolling a second factor or changing the password
+var ErrRestrictedToken = errors.New("restricted token")
+
+// Middleware authenticates requests by the access token in their Authorization header
+type Middleware struct {
+	// SigningKey is the JWT_SIGNING_KEY of JWT Auth Proxy
+	SigningKey []byte
+	// Optional passes requests without valid token to the next handler instead of rejecting them
+	Optional bool
+	// AllowGuests accepts guest tokens, which don't identify a user
+	AllowGuests bool
+	// ErrorHandler is called for rejected requests, defaults to answering 401 with a Bearer challenge
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// NewMiddleware creates a middleware rejecting requests without a valid access token signed with signingKey
+func NewMiddleware(signingKey []byte) *Middleware {
+	return &Middleware{SigningKey: signingKey}
+}
+
+// Handler wraps next, storing the verified claims in the request context
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := m.ExtractClaims(r)
+		if err != nil {
+			if m.Optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if m.ErrorHandler != nil {
+				m.ErrorHandler(w, r, err)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+	})
+}
+
+// ExtractClaims verifies the request's bearer token and returns its claims
+func (m *Middleware) ExtractClaims(r *http.Request) (*Claims, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+	claims, err := ParseToken(token, m.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	if claims.IsRestricted() || (claims.Guest && !m.AllowGuests) {
+		return nil, ErrRestrictedToken
+	}
+	return claims, nil
+}
+
+// BearerToken returns the token of the request's "Authorization: Bearer" header, or an empty string
+func BearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(authHeader, "Bearer ")
+}
+
+// ContextWithClaims stores the verified claims in the context
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKeyClaims, claims)
+}
+
+// ClaimsFromContext returns the claims stored by the middleware, or nil for unauthenticated requests
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(contextKeyClaims).(*Claims)
+	return claims
+}
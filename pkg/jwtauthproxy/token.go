@@ -0,0 +1,45 @@
+package jwtauthproxy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ErrInvalidToken is returned for tokens failing verification for other reasons than a validation error of the jwt package
+var ErrInvalidToken = errors.New("invalid JWT")
+
+// KeyFunc returns a jwt.Keyfunc accepting HMAC-signed tokens only, as issued by JWT Auth Proxy
+func KeyFunc(signingKey []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return signingKey, nil
+	}
+}
+
+// SignToken signs the claims using HS512. Claims without expiry expire after lifetime.
+func SignToken(claims *Claims, signingKey []byte, lifetime time.Duration) (string, error) {
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Now().Add(lifetime).Unix()
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(signingKey)
+}
+
+// ParseToken verifies the token's signature and expiry and returns its claims. Tokens must identify a user
+// unless they are guest tokens. Errors of the jwt package are returned as they are, so callers can inspect
+// them as *jwt.ValidationError.
+func ParseToken(tokenString string, signingKey []byte) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, KeyFunc(signingKey))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || (claims.UserID == "" && !claims.Guest) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,18 @@
+From: {{.From}}
+To: {{.To}}
+Subject: Confirm your additional email address
+
+Hello,
+
+You have requested to add this email address to your account.
+
+To activate it, please confirm your email address by clicking this link:
+
+http://localhost/confirm.html?id={{.ConfirmID}}
+
+Once confirmed, you can use this address to log in and to reset your password.
+
+If you didn't initiate this change, please don't click the link above.
+
+Kind regards,
+Your service
\ No newline at end of file
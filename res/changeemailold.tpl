@@ -0,0 +1,18 @@
+From: {{.From}}
+To: {{.To}}
+Subject: Confirm the change of your email address
+
+Hello,
+
+You have requested to change your email address.
+
+To activate your change, please confirm it from your current email address by clicking this link:
+
+http://localhost/confirm.html?id={{.ConfirmID}}
+
+You also need to confirm your new email address using the link sent to it.
+
+If you didn't initiate this change, please don't click the link above and change your password immediately.
+
+Kind regards,
+Your service
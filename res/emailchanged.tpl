@@ -0,0 +1,12 @@
+From: {{.From}}
+To: {{.To}}
+Subject: Your email address was changed
+
+Hello,
+
+the email address of your account has been changed to {{.NewEmail}}.
+
+If you didn't initiate this change, please contact us immediately.
+
+Kind regards,
+Your service
@@ -0,0 +1,16 @@
+From: {{.From}}
+To: {{.To}}
+Subject: You have been invited
+
+Hello,
+
+you have been invited to join our service{{if .Organization}} ({{.Organization}}){{end}}!
+
+To create your account, please click this link and choose a password:
+
+http://localhost/signup.html?invitation={{.InvitationID}}
+
+If you weren't expecting this invitation, you can ignore this email.
+
+Kind regards,
+Your service
\ No newline at end of file
@@ -6,6 +6,7 @@ TEMPLATE_SIGNUP=../res/signup.tpl \
 TEMPLATE_CHANGE_EMAIL=../res/changeemail.tpl \
 TEMPLATE_RESET_PASSWORD=../res/resetpassword.tpl \
 TEMPLATE_NEW_PASSWORD=../res/newpassword.tpl \
+TEMPLATE_INVITATION=../res/invitation.tpl \
 PROXY_TARGET=http://localhost:8090 \
 CORS_ENABLE=1 \
 BACKEND_GENERATE_CERT=1 \
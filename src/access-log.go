@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	guuid "github.com/google/uuid"
+)
+
+const (
+	AccessLogCommon = "common"
+	AccessLogJSON   = "json"
+)
+
+// AccessLogEntry holds the fields of one access log line.
+// It is stored in the request context so that inner handlers can fill in the user and upstream.
+type AccessLogEntry struct {
+	Time      time.Time `json:"time"`
+	RemoteIP  string    `json:"remoteIP"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	LatencyMs int64     `json:"latencyMs"`
+	UserID    string    `json:"userID,omitempty"`
+	RequestID string    `json:"requestID"`
+	Upstream  string    `json:"upstream,omitempty"`
+}
+
+// String formats the entry in Common Log Format, followed by the latency in ms, the request ID and the upstream
+func (e *AccessLogEntry) String() string {
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d %d %s %s",
+		e.RemoteIP,
+		_OrDash(e.UserID),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method,
+		e.Path,
+		e.Protocol,
+		e.Status,
+		e.Bytes,
+		e.LatencyMs,
+		e.RequestID,
+		_OrDash(e.Upstream),
+	)
+}
+
+var _accessLoggerInstance *log.Logger
+var _accessLoggerOnce sync.Once
+
+// GetAccessLogger returns the logger writing to ACCESS_LOG_FILE, or to stdout if none is set
+func GetAccessLogger() *log.Logger {
+	_accessLoggerOnce.Do(func() {
+		var out io.Writer = os.Stdout
+		if GetConfig().AccessLogFile != "" {
+			file, err := os.OpenFile(GetConfig().AccessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+			if err != nil {
+				log.Fatal(err)
+			}
+			out = file
+		}
+		_accessLoggerInstance = log.New(out, "", 0)
+	})
+	return _accessLoggerInstance
+}
+
+// GetAccessLogEntryFromContext returns the entry of the request being logged, or nil if access logging is disabled
+func GetAccessLogEntryFromContext(r *http.Request) *AccessLogEntry {
+	entry, _ := r.Context().Value(contextKeyAccessLog).(*AccessLogEntry)
+	return entry
+}
+
+// AccessLogMiddleware assigns each request an ID, passed in the X-Request-Id header, and logs it once it has been served
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetConfig().AccessLog == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		requestID := r.Header.Get("X-Request-Id")
+		if requestID == "" || len(requestID) > 128 {
+			requestID = guuid.New().String()
+			r.Header.Set("X-Request-Id", requestID)
+		}
+		w.Header().Set("X-Request-Id", requestID)
+		entry := &AccessLogEntry{
+			Time:      time.Now(),
+			RemoteIP:  GetClientIP(r),
+			Method:    r.Method,
+			Path:      r.URL.EscapedPath(),
+			Protocol:  r.Proto,
+			RequestID: requestID,
+		}
+		lw := &accessLogResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), contextKeyAccessLog, entry)))
+		entry.Status = lw.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Bytes = lw.bytes
+		entry.LatencyMs = time.Since(entry.Time).Milliseconds()
+		if GetConfig().AccessLog == AccessLogJSON {
+			line, _ := json.Marshal(entry)
+			GetAccessLogger().Println(string(line))
+		} else {
+			GetAccessLogger().Println(entry.String())
+		}
+	})
+}
+
+// accessLogResponseWriter records the status and size of the response
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer does not support hijacking")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func _OrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogEntryString(t *testing.T) {
+	entry := &AccessLogEntry{
+		Time:      time.Date(2023, 5, 1, 13, 55, 36, 0, time.UTC),
+		RemoteIP:  "192.0.2.1",
+		Method:    "GET",
+		Path:      "/some/route",
+		Protocol:  "HTTP/1.1",
+		Status:    200,
+		Bytes:     1234,
+		LatencyMs: 12,
+		RequestID: "abc",
+	}
+	checkTestString(t, `192.0.2.1 - - [01/May/2023:13:55:36 +0000] "GET /some/route HTTP/1.1" 200 1234 12 abc -`, entry.String())
+}
+
+func TestProxyAccessLog(t *testing.T) {
+	os.Setenv("ACCESS_LOG", "json")
+	GetConfig().ReadConfig()
+	var buf bytes.Buffer
+	GetAccessLogger().SetOutput(&buf)
+	defer func() {
+		os.Unsetenv("ACCESS_LOG")
+		GetConfig().ReadConfig()
+		GetAccessLogger().SetOutput(os.Stdout)
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	buf.Reset()
+	req := newHTTPRequest("GET", "/some/route/test.html?access_token=secret", loginResponse.AccessToken, nil)
+	req.Header.Set("X-Request-Id", "my-request")
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "my-request", res.Header().Get("X-Request-Id"))
+	checkTestString(t, "my-request", handler.Headers.Get("X-Request-Id"))
+	var entry AccessLogEntry
+	if err := json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &entry); err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "GET", entry.Method)
+	checkTestString(t, "/some/route/test.html", entry.Path)
+	checkTestString(t, "my-request", entry.RequestID)
+	checkTestString(t, "127.0.0.1:8090", entry.Upstream)
+	checkStringNotEmpty(t, entry.UserID)
+	if entry.Status != http.StatusOK {
+		t.Errorf("Expected status 200 in access log, got %d", entry.Status)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryAPIKeyStore stores API keys in memory, see STORAGE
+type MemoryAPIKeyStore struct {
+	keys *MemoryCollection[APIKey]
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: NewMemoryCollection[APIKey]()}
+}
+
+func (r *MemoryAPIKeyStore) _ByID(id primitive.ObjectID) func(*APIKey) bool {
+	return func(k *APIKey) bool {
+		return k.ID == id
+	}
+}
+
+func (r *MemoryAPIKeyStore) Create(u *APIKey) {
+	u.ID = primitive.NewObjectID()
+	r.keys.Insert(u)
+}
+
+func (r *MemoryAPIKeyStore) GetOne(id string) *APIKey {
+	return r.keys.FindOne(func(k *APIKey) bool {
+		return k.ID.Hex() == id
+	})
+}
+
+func (r *MemoryAPIKeyStore) GetByHashedKey(hashedKey string) *APIKey {
+	return r.keys.FindOne(func(k *APIKey) bool {
+		return k.HashedKey == hashedKey
+	})
+}
+
+func (r *MemoryAPIKeyStore) GetAllForUser(userID string) []*APIKey {
+	return r.keys.Find(func(k *APIKey) bool {
+		return k.UserID == UserID(userID)
+	})
+}
+
+func (r *MemoryAPIKeyStore) UpdateLastUseDate(u *APIKey) {
+	u.LastUseDate = time.Now()
+	r.keys.Update(r._ByID(u.ID), func(k *APIKey) {
+		k.LastUseDate = u.LastUseDate
+	})
+}
+
+func (r *MemoryAPIKeyStore) Delete(u *APIKey) {
+	r.keys.Delete(r._ByID(u.ID))
+}
+
+func (r *MemoryAPIKeyStore) DeleteAllForUser(userID string) {
+	r.keys.Delete(func(k *APIKey) bool {
+		return k.UserID == UserID(userID)
+	})
+}
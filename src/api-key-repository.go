@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type APIKey struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      UserID             `json:"userId" bson:"userId"`
+	Name        string             `json:"name" bson:"name"`
+	HashedKey   string             `json:"-" bson:"hashedKey"`
+	Scopes      []string           `json:"scopes" bson:"scopes"`
+	CreateDate  time.Time          `json:"createDate" bson:"createDate"`
+	LastUseDate time.Time          `json:"lastUseDate" bson:"lastUseDate"`
+}
+
+// APIKeyStore persists API keys, see STORAGE
+type APIKeyStore interface {
+	Create(u *APIKey)
+	GetOne(id string) *APIKey
+	GetByHashedKey(hashedKey string) *APIKey
+	GetAllForUser(userID string) []*APIKey
+	UpdateLastUseDate(u *APIKey)
+	Delete(u *APIKey)
+	DeleteAllForUser(userID string)
+}
+
+// APIKeyRepository manages API keys, storing them in the APIKeyStore of the configured storage backend
+type APIKeyRepository struct {
+	APIKeyStore
+}
+
+var _apiKeyRepositoryInstance *APIKeyRepository
+var _apiKeyRepositoryOnce sync.Once
+
+func GetAPIKeyRepository() *APIKeyRepository {
+	_apiKeyRepositoryOnce.Do(func() {
+		_apiKeyRepositoryInstance = &APIKeyRepository{GetStorageDriver().NewAPIKeyStore()}
+	})
+	return _apiKeyRepositoryInstance
+}
+
+type MongoAPIKeyStore struct {
+}
+
+func NewMongoAPIKeyStore() *MongoAPIKeyStore {
+	return &MongoAPIKeyStore{}
+}
+
+func (r *MongoAPIKeyStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("api_keys")
+}
+
+func (r *MongoAPIKeyStore) Create(u *APIKey) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+// CreateForUser creates a new API key for a user and returns the plain text key
+func (r *APIKeyRepository) CreateForUser(user *User, name string, scopes []string) (*APIKey, string) {
+	key := r.FindUnusedKey()
+	if scopes == nil {
+		scopes = make([]string, 0)
+	}
+	apiKey := &APIKey{
+		UserID:     user.ID,
+		Name:       name,
+		HashedKey:  HashToken(key),
+		Scopes:     scopes,
+		CreateDate: time.Now(),
+	}
+	r.Create(apiKey)
+	return apiKey, key
+}
+
+func (r *MongoAPIKeyStore) GetOne(id string) *APIKey {
+	var apiKey APIKey
+	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&apiKey)
+	if err != nil {
+		return nil
+	}
+	return &apiKey
+}
+
+func (r *APIKeyRepository) GetByKey(key string) *APIKey {
+	return r.GetByHashedKey(HashToken(key))
+}
+
+func (r *MongoAPIKeyStore) GetByHashedKey(hashedKey string) *APIKey {
+	var apiKey APIKey
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"hashedKey": hashedKey}).Decode(&apiKey)
+	if err != nil {
+		return nil
+	}
+	return &apiKey
+}
+
+func (r *MongoAPIKeyStore) GetAllForUser(userID string) []*APIKey {
+	results := make([]*APIKey, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var apiKey APIKey
+		err := cur.Decode(&apiKey)
+		if err != nil {
+			return results
+		}
+		results = append(results, &apiKey)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *MongoAPIKeyStore) UpdateLastUseDate(u *APIKey) {
+	u.LastUseDate = time.Now()
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"lastUseDate": u.LastUseDate}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoAPIKeyStore) Delete(u *APIKey) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoAPIKeyStore) DeleteAllForUser(userID string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// FindUnusedKey returns a new random API key in plain text; only its hash is ever stored
+func (r *APIKeyRepository) FindUnusedKey() string {
+	var key string = ""
+	for i := 1; i <= 20 && key == ""; i++ {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Println(err)
+			continue
+		}
+		key = hex.EncodeToString(b)
+		if r.GetByKey(key) != nil {
+			key = ""
+		}
+	}
+	return key
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAPIKeyStoredHashed(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+
+	apiKey, key := GetAPIKeyRepository().CreateForUser(user, "test", []string{"read"})
+	checkStringNotEmpty(t, key)
+	if apiKey.HashedKey == key {
+		t.Error("Expected API key not to be stored in plain text")
+	}
+
+	apiKey = GetAPIKeyRepository().GetByKey(key)
+	if apiKey == nil {
+		t.Fatal("Expected API key not to be nil")
+	}
+	checkTestString(t, "read", apiKey.Scopes[0])
+	if GetAPIKeyRepository().GetByKey(apiKey.HashedKey) != nil {
+		t.Error("Expected hashed key not to be usable as API key")
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"os"
@@ -13,9 +15,11 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"golang.org/x/crypto/acme"
 )
 
 var _appInstance *App
@@ -32,8 +36,19 @@ type App struct {
 	PublicRouter              *mux.Router
 	BackendRouter             *mux.Router
 	Proxy                     *httputil.ReverseProxy
+	Upstreams                 *UpstreamPool
+	CanaryUpstreams           *UpstreamPool
 	CleanRefreshTokensTicker  *time.Ticker
 	CleanPendingActionsTicker *time.Ticker
+	CleanTrustedDevicesTicker *time.Ticker
+	CleanDeviceCodesTicker    *time.Ticker
+	CleanInvitationsTicker    *time.Ticker
+	CleanDeletedUsersTicker   *time.Ticker
+	CleanAuditLogTicker       *time.Ticker
+	CleanMailQueueTicker      *time.Ticker
+	MailQueueTicker           *time.Ticker
+	DisposableEmailTicker     *time.Ticker
+	UpstreamHealthTicker      *time.Ticker
 }
 
 func (a *App) InitializePublicRouter() {
@@ -45,28 +60,61 @@ func (a *App) InitializePublicRouter() {
 		subRouter := a.PublicRouter.PathPrefix(route).Subrouter()
 		router.setupRoutes(subRouter)
 	}
+	a.PublicRouter.Use(AccessLogMiddleware)
+	a.PublicRouter.Use(IPAccessMiddleware)
+	a.PublicRouter.Use(GeoIPMiddleware)
+	a.PublicRouter.Use(MaintenanceMiddleware)
 	if GetConfig().EnableCors {
 		a.PublicRouter.PathPrefix("/").Methods("OPTIONS").HandlerFunc(CorsHandler)
 		a.PublicRouter.Use(CorsMiddleware)
 	}
+	if GetConfig().StaticDir != "" {
+		a.PublicRouter.MatcherFunc(func(r *http.Request, rm *mux.RouteMatch) bool {
+			return IsStaticRequest(r)
+		}).HandlerFunc(StaticHandler)
+	}
 	a.PublicRouter.PathPrefix("/").HandlerFunc(ProxyHandler)
+	a.PublicRouter.Use(CompressionMiddleware)
 	a.PublicRouter.Use(VerifyJwtMiddleware)
+	a.PublicRouter.Use(RateLimitMiddleware)
+	a.PublicRouter.Use(AuthzRulesMiddleware)
 }
 
 func (a *App) InitializeBackendRouter() {
 	a.BackendRouter = mux.NewRouter()
 	routers := make(map[string]Route)
 	routers["/users/"] = &UserRouter{}
+	routers["/organizations/"] = &OrganizationRouter{}
+	routers["/audit/"] = &AuditRouter{}
+	routers["/stats/"] = &StatsRouter{}
+	routers["/maintenance/"] = &MaintenanceRouter{}
+	routers["/rules/"] = &RouteRulesRouter{}
+	if GetConfig().SignedURLKey != "" {
+		routers["/signed-urls/"] = &SignedURLRouter{}
+	}
+	if GetConfig().AllowInvitations {
+		routers["/invitations/"] = &InvitationRouter{}
+	}
+	if GetConfig().MailQueueEnable {
+		routers["/mail-queue/"] = &MailQueueRouter{}
+	}
 	for route, router := range routers {
 		subRouter := a.BackendRouter.PathPrefix(route).Subrouter()
 		router.setupRoutes(subRouter)
 	}
+	a.BackendRouter.Use(BackendPermissionMiddleware)
+	a.BackendRouter.Use(AuditBackendMiddleware)
 }
 
 func (a *App) InitializeProxy() {
-	target := GetConfig().ProxyTarget
-	targetQuery := target.RawQuery
+	a.Upstreams = NewUpstreamPool(GetConfig().ProxyTargets, GetConfig().ProxyTargetWeights, GetConfig().ProxyLoadBalancing)
+	a.CanaryUpstreams = nil
+	if len(GetConfig().ProxyCanaryTargets) > 0 {
+		a.CanaryUpstreams = NewUpstreamPool(GetConfig().ProxyCanaryTargets, nil, GetConfig().ProxyLoadBalancing)
+	}
 	director := func(req *http.Request) {
+		target := GetUpstreamFromContext(req).RequestURL()
+		targetQuery := target.RawQuery
 		req.URL.Scheme = target.Scheme
 		req.URL.Host = target.Host
 		req.URL.Path = a._SingleJoiningSlash(target.Path, req.URL.Path)
@@ -80,7 +128,27 @@ func (a *App) InitializeProxy() {
 			req.Header.Set("User-Agent", "")
 		}
 	}
-	a.Proxy = &httputil.ReverseProxy{Director: director}
+	errorHandler := func(w http.ResponseWriter, req *http.Request, err error) {
+		log.Println("Proxying to", req.URL.Host, "failed:", err)
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			SendErrorPage(w, req, http.StatusGatewayTimeout, ErrorCodeUpstreamTimeout, "")
+			return
+		}
+		SendErrorPage(w, req, http.StatusBadGateway, ErrorCodeUpstreamUnavailable, "")
+	}
+	modifyResponse := func(res *http.Response) error {
+		path, _ := res.Request.Context().Value(contextKeyProxyPath).(string)
+		ScrubResponseHeaders(res.Header)
+		ApplyHeaderRules(HeaderRuleResponse, path, res.Header)
+		return nil
+	}
+	a.Proxy = &httputil.ReverseProxy{Director: director, ErrorHandler: errorHandler, ModifyResponse: modifyResponse, BufferPool: GetProxyBufferPool()}
+	a.Proxy.Transport = &RetryTransport{
+		Transport: a.Upstreams.Transport,
+		Retries:   GetConfig().ProxyRetries,
+		Backoff:   time.Millisecond * GetConfig().ProxyRetryBackoff,
+	}
 }
 
 func (a *App) InitializeTimers() {
@@ -104,6 +172,107 @@ func (a *App) InitializeTimers() {
 			}
 		}
 	}()
+	a.CleanTrustedDevicesTicker = time.NewTicker(time.Hour * 1)
+	go func() {
+		for {
+			select {
+			case <-a.CleanTrustedDevicesTicker.C:
+				log.Println("Cleaning up expired trusted devices...")
+				GetTrustedDeviceRepository().CleanUp()
+			}
+		}
+	}()
+	a.CleanDeviceCodesTicker = time.NewTicker(time.Hour * 1)
+	go func() {
+		for {
+			select {
+			case <-a.CleanDeviceCodesTicker.C:
+				log.Println("Cleaning up expired device codes...")
+				GetDeviceCodeRepository().CleanUp()
+			}
+		}
+	}()
+	a.CleanInvitationsTicker = time.NewTicker(time.Hour * 1)
+	go func() {
+		for {
+			select {
+			case <-a.CleanInvitationsTicker.C:
+				log.Println("Cleaning up expired invitations...")
+				GetInvitationRepository().CleanUp()
+			}
+		}
+	}()
+	a.CleanDeletedUsersTicker = time.NewTicker(time.Hour * 1)
+	go func() {
+		for {
+			select {
+			case <-a.CleanDeletedUsersTicker.C:
+				log.Println("Purging deleted users...")
+				GetUserRepository().CleanUp()
+			}
+		}
+	}()
+	a.CleanAuditLogTicker = time.NewTicker(time.Hour * 1)
+	go func() {
+		for {
+			select {
+			case <-a.CleanAuditLogTicker.C:
+				log.Println("Cleaning up expired audit events...")
+				GetAuditLogRepository().CleanUp()
+			}
+		}
+	}()
+	if GetConfig().MailQueueEnable {
+		a.MailQueueTicker = time.NewTicker(mailQueuePollInterval)
+		go func() {
+			for {
+				ProcessMailQueue()
+				select {
+				case <-a.MailQueueTicker.C:
+				case <-mailQueueWakeup:
+				}
+			}
+		}()
+		a.CleanMailQueueTicker = time.NewTicker(time.Hour * 1)
+		go func() {
+			for {
+				select {
+				case <-a.CleanMailQueueTicker.C:
+					log.Println("Purging dead mails...")
+					GetMailQueueRepository().CleanUp()
+				}
+			}
+		}()
+	}
+	go UpdateDisposableEmailDomains()
+	a.DisposableEmailTicker = time.NewTicker(time.Hour * GetConfig().DisposableEmailListRefresh)
+	go func() {
+		for {
+			select {
+			case <-a.DisposableEmailTicker.C:
+				UpdateDisposableEmailDomains()
+			}
+		}
+	}()
+	if GetConfig().ProxyHealthCheckPath != "" {
+		go a._CheckUpstreamHealth()
+		a.UpstreamHealthTicker = time.NewTicker(time.Second * GetConfig().ProxyHealthCheckInterval)
+		go func() {
+			for {
+				select {
+				case <-a.UpstreamHealthTicker.C:
+					a._CheckUpstreamHealth()
+				}
+			}
+		}()
+	}
+}
+
+func (a *App) _CheckUpstreamHealth() {
+	a.Upstreams.CheckHealth(GetConfig().ProxyHealthCheckPath, time.Second*GetConfig().ProxyHealthCheckTimeout)
+	if a.CanaryUpstreams != nil {
+		a.CanaryUpstreams.CheckHealth(GetConfig().ProxyHealthCheckPath, time.Second*GetConfig().ProxyHealthCheckTimeout)
+	}
 }
 
 func (a *App) GenerateBackendCert() {
@@ -150,24 +319,69 @@ func (a *App) Run(publicListenAddr, backendListenAddr string) {
 	log.Println("Initializing REST services...")
 	publicServer := &http.Server{
 		Addr:         publicListenAddr,
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
+		WriteTimeout: time.Second * GetConfig().ServerWriteTimeout,
+		ReadTimeout:  time.Second * GetConfig().ServerReadTimeout,
+		IdleTimeout:  time.Second * GetConfig().ServerIdleTimeout,
 		Handler:      a.PublicRouter,
 	}
-	go func() {
-		if err := publicServer.ListenAndServe(); err != nil {
-			log.Fatal(err)
-			os.Exit(-1)
+	var redirectHandler http.Handler = http.HandlerFunc(HTTPSRedirectHandler)
+	if len(GetConfig().PublicACMEDomains) > 0 {
+		manager := CreateACMEManager()
+		publicServer.TLSConfig = a._CreatePublicTLSConfig()
+		publicServer.TLSConfig.GetCertificate = manager.GetCertificate
+		publicServer.TLSConfig.NextProtos = append(publicServer.TLSConfig.NextProtos, acme.ALPNProto)
+		redirectHandler = manager.HTTPHandler(redirectHandler)
+		ConfigurePublicHTTP2(publicServer, true)
+		go func() {
+			if err := publicServer.ServeTLS(ListenPublic(publicListenAddr), "", ""); err != nil {
+				log.Fatal(err)
+				os.Exit(-1)
+			}
+		}()
+		log.Println("Public HTTPS Server listening on", publicListenAddr, "with ACME certificates for", strings.Join(GetConfig().PublicACMEDomains, ", "))
+	} else if GetConfig().PublicTLSCert != "" {
+		publicServer.TLSConfig = a._CreatePublicTLSConfig()
+		ConfigurePublicHTTP2(publicServer, true)
+		go func() {
+			if err := publicServer.ServeTLS(ListenPublic(publicListenAddr), GetConfig().PublicTLSCert, GetConfig().PublicTLSKey); err != nil {
+				log.Fatal(err)
+				os.Exit(-1)
+			}
+		}()
+		log.Println("Public HTTPS Server listening on", publicListenAddr)
+	} else {
+		ConfigurePublicHTTP2(publicServer, false)
+		go func() {
+			if err := publicServer.Serve(ListenPublic(publicListenAddr)); err != nil {
+				log.Fatal(err)
+				os.Exit(-1)
+			}
+		}()
+		log.Println("Public HTTP Server listening on", publicListenAddr)
+	}
+	var redirectServer *http.Server
+	if GetConfig().PublicHTTPRedirectAddr != "" {
+		redirectServer = &http.Server{
+			Addr:         GetConfig().PublicHTTPRedirectAddr,
+			WriteTimeout: time.Second * GetConfig().ServerWriteTimeout,
+			ReadTimeout:  time.Second * GetConfig().ServerReadTimeout,
+			IdleTimeout:  time.Second * GetConfig().ServerIdleTimeout,
+			Handler:      redirectHandler,
 		}
-	}()
-	log.Println("Public HTTP Server listening on", publicListenAddr)
+		go func() {
+			if err := redirectServer.Serve(ListenPublic(GetConfig().PublicHTTPRedirectAddr)); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+				os.Exit(-1)
+			}
+		}()
+		log.Println("HTTPS Redirect Server listening on", GetConfig().PublicHTTPRedirectAddr)
+	}
 	tlsConfig := a._CreateTLSConfig()
 	backendServer := &http.Server{
 		Addr:         backendListenAddr,
-		WriteTimeout: time.Second * 15,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Second * 60,
+		WriteTimeout: time.Second * GetConfig().ServerWriteTimeout,
+		ReadTimeout:  time.Second * GetConfig().ServerReadTimeout,
+		IdleTimeout:  time.Second * GetConfig().ServerIdleTimeout,
 		Handler:      a.BackendRouter,
 		TLSConfig:    tlsConfig,
 	}
@@ -178,6 +392,13 @@ func (a *App) Run(publicListenAddr, backendListenAddr string) {
 		}
 	}()
 	log.Println("Backend HTTPS Server listening on", backendListenAddr)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			ReloadRouteRules()
+		}
+	}()
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
@@ -186,8 +407,20 @@ func (a *App) Run(publicListenAddr, backendListenAddr string) {
 	defer cancel()
 	a.CleanPendingActionsTicker.Stop()
 	a.CleanRefreshTokensTicker.Stop()
+	a.CleanTrustedDevicesTicker.Stop()
+	a.CleanDeviceCodesTicker.Stop()
+	a.CleanInvitationsTicker.Stop()
+	a.CleanDeletedUsersTicker.Stop()
+	a.CleanAuditLogTicker.Stop()
+	a.DisposableEmailTicker.Stop()
+	if a.UpstreamHealthTicker != nil {
+		a.UpstreamHealthTicker.Stop()
+	}
 	backendServer.Shutdown(ctx)
 	publicServer.Shutdown(ctx)
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
 }
 
 func (a *App) _CreateTLSConfig() *tls.Config {
@@ -208,6 +441,24 @@ func (a *App) _CreateTLSConfig() *tls.Config {
 	return tlsConfig
 }
 
+// _CreatePublicTLSConfig requests optional client certificates for user authentication if enabled
+func (a *App) _CreatePublicTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if GetConfig().EnableClientCertAuth {
+		clientCaCert, err := ioutil.ReadFile(GetConfig().ClientCertCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(clientCaCert)
+		tlsConfig.ClientCAs = caCertPool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig
+}
+
 func (app *App) _SingleJoiningSlash(a, b string) string {
 	aslash := strings.HasSuffix(a, "/")
 	bslash := strings.HasPrefix(b, "/")
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryAuditLogStore stores audit events in memory, see STORAGE
+type MemoryAuditLogStore struct {
+	events *MemoryCollection[AuditEvent]
+}
+
+func NewMemoryAuditLogStore() *MemoryAuditLogStore {
+	return &MemoryAuditLogStore{events: NewMemoryCollection[AuditEvent]()}
+}
+
+func (r *MemoryAuditLogStore) Create(u *AuditEvent) {
+	u.ID = primitive.NewObjectID()
+	r.events.Insert(u)
+}
+
+func (r *MemoryAuditLogStore) Find(filter *AuditEventFilter) []*AuditEvent {
+	results := r.events.Find(func(e *AuditEvent) bool {
+		return (filter.UserID == "" || e.UserID == filter.UserID) &&
+			(filter.Type == "" || e.Type == filter.Type) &&
+			(filter.From.IsZero() || !e.CreateDate.Before(filter.From)) &&
+			(filter.To.IsZero() || !e.CreateDate.After(filter.To))
+	})
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreateDate.After(results[j].CreateDate)
+	})
+	if filter.Limit > 0 && int64(len(results)) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+	return results
+}
+
+func (r *MemoryAuditLogStore) DeleteBefore(date time.Time) {
+	r.events.Delete(func(e *AuditEvent) bool {
+		return !e.CreateDate.After(date)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AuditEventLoginSuccess   = "login_success"
+	AuditEventLoginFailure   = "login_failure"
+	AuditEventSignup         = "signup"
+	AuditEventPasswordChange = "password_change"
+	AuditEventPasswordReset  = "password_reset"
+	AuditEventTokenRefresh   = "token_refresh"
+	AuditEventEmailChange    = "email_change"
+	AuditEventAccountDelete  = "account_delete"
+	AuditEventAdminAction    = "admin_action"
+)
+
+const (
+	AuditActorUser  = "user"
+	AuditActorAdmin = "admin"
+)
+
+type AuditEvent struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type       string             `json:"type" bson:"type"`
+	UserID     string             `json:"userId,omitempty" bson:"userId,omitempty"`
+	Actor      string             `json:"actor" bson:"actor"`
+	IP         string             `json:"ip" bson:"ip"`
+	UserAgent  string             `json:"userAgent" bson:"userAgent"`
+	Details    string             `json:"details,omitempty" bson:"details,omitempty"`
+	CreateDate time.Time          `json:"createDate" bson:"createDate"`
+}
+
+// AuditEventFilter holds the criteria for querying audit events, empty fields match all events
+type AuditEventFilter struct {
+	UserID string
+	Type   string
+	From   time.Time
+	To     time.Time
+	Limit  int64
+}
+
+// AuditLogStore persists audit events, see STORAGE
+type AuditLogStore interface {
+	Create(u *AuditEvent)
+	Find(filter *AuditEventFilter) []*AuditEvent
+	DeleteBefore(date time.Time)
+}
+
+// AuditLogRepository manages audit events, storing them in the AuditLogStore of the configured storage backend
+type AuditLogRepository struct {
+	AuditLogStore
+}
+
+var _auditLogRepositoryInstance *AuditLogRepository
+var _auditLogRepositoryOnce sync.Once
+
+func GetAuditLogRepository() *AuditLogRepository {
+	_auditLogRepositoryOnce.Do(func() {
+		_auditLogRepositoryInstance = &AuditLogRepository{GetStorageDriver().NewAuditLogStore()}
+	})
+	return _auditLogRepositoryInstance
+}
+
+type MongoAuditLogStore struct {
+}
+
+func NewMongoAuditLogStore() *MongoAuditLogStore {
+	return &MongoAuditLogStore{}
+}
+
+func (r *MongoAuditLogStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("audit_events")
+}
+
+func (r *MongoAuditLogStore) Create(u *AuditEvent) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+// Find returns the audit events matching the filter, newest first
+func (r *MongoAuditLogStore) Find(filter *AuditEventFilter) []*AuditEvent {
+	results := make([]*AuditEvent, 0)
+	query := bson.M{}
+	if filter.UserID != "" {
+		query["userId"] = filter.UserID
+	}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	dateQuery := bson.M{}
+	if !filter.From.IsZero() {
+		dateQuery["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		dateQuery["$lte"] = filter.To
+	}
+	if len(dateQuery) != 0 {
+		query["createDate"] = dateQuery
+	}
+	opts := options.Find().SetSort(bson.M{"createDate": -1})
+	if filter.Limit > 0 {
+		opts.SetLimit(filter.Limit)
+	}
+	cur, err := r.GetCollection().Find(context.TODO(), query, opts)
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var event AuditEvent
+		err := cur.Decode(&event)
+		if err != nil {
+			return results
+		}
+		results = append(results, &event)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *AuditLogRepository) CleanUp() {
+	if GetConfig().AuditLogRetention == 0 {
+		return
+	}
+	r.DeleteBefore(time.Now().Add(-GetConfig().AuditLogRetention * 24 * time.Hour))
+}
+
+// DeleteBefore deletes the audit events created on or before the given date
+func (r *MongoAuditLogStore) DeleteBefore(date time.Time) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"createDate": bson.M{"$lte": date}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// RecordAuditEvent stores an audit event for the request if the audit log is enabled, logins are always counted for the statistics
+func RecordAuditEvent(r *http.Request, eventType, actor, userID, details string) {
+	if eventType == AuditEventLoginSuccess || eventType == AuditEventLoginFailure {
+		GetLoginStatsRepository().CountLogin(eventType == AuditEventLoginSuccess)
+	}
+	if !GetConfig().AuditLogEnable {
+		return
+	}
+	GetAuditLogRepository().Create(&AuditEvent{
+		Type:       eventType,
+		UserID:     userID,
+		Actor:      actor,
+		IP:         GetClientIP(r),
+		UserAgent:  r.UserAgent(),
+		Details:    details,
+		CreateDate: time.Now(),
+	})
+}
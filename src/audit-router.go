@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type AuditRouter struct {
+}
+
+func (router *AuditRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/", router.getAll).Methods("GET")
+}
+
+func (router *AuditRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := &AuditEventFilter{
+		UserID: query.Get("userId"),
+		Type:   query.Get("type"),
+		Limit:  100,
+	}
+	var err error
+	if s := query.Get("from"); s != "" {
+		if filter.From, err = time.Parse(time.RFC3339, s); err != nil {
+			SendBadRequest(w)
+			return
+		}
+	}
+	if s := query.Get("to"); s != "" {
+		if filter.To, err = time.Parse(time.RFC3339, s); err != nil {
+			SendBadRequest(w)
+			return
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		if filter.Limit, err = strconv.ParseInt(s, 10, 64); err != nil || filter.Limit < 1 {
+			SendBadRequest(w)
+			return
+		}
+	}
+	SendJSON(w, GetAuditLogRepository().Find(filter))
+}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *auditResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// AuditBackendMiddleware records successful modifying requests to the backend API as admin actions
+func AuditBackendMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !GetConfig().AuditLogEnable || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		aw := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(aw, r)
+		if aw.status >= 400 {
+			return
+		}
+		vars := mux.Vars(r)
+		userID := vars["userId"]
+		if userID == "" && strings.HasPrefix(r.URL.Path, "/users/") {
+			userID = vars["id"]
+		}
+		details := r.Method + " " + r.URL.Path
+		if client := GetBackendClientName(r); client != "" {
+			details += " by " + client
+		}
+		RecordAuditEvent(r, AuditEventAdminAction, AuditActorAdmin, userID, details)
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func setAuditLogTestConfig() func() {
+	os.Setenv("AUDIT_LOG_ENABLE", "1")
+	GetConfig().ReadConfig()
+	return func() {
+		os.Unsetenv("AUDIT_LOG_ENABLE")
+		GetConfig().ReadConfig()
+	}
+}
+
+func getTestAuditEvents(t *testing.T, query string) []AuditEvent {
+	req, _ := http.NewRequest("GET", "/audit/"+query, nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var events []AuditEvent
+	json.Unmarshal(res.Body.Bytes(), &events)
+	return events
+}
+
+func TestAuditLogLogin(t *testing.T) {
+	defer setAuditLogTestConfig()()
+	clearTestDB()
+	user := createTestUser(true)
+
+	payload := `{"email": "foo@bar.com", "password": "wrong-password"}`
+	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	req.Header.Set("User-Agent", "audit-test")
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	loginUser("foo@bar.com", "12345678")
+
+	events := getTestAuditEvents(t, "?userId="+user.ID.String())
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 audit events, got %d", len(events))
+	}
+	checkTestString(t, AuditEventLoginSuccess, events[0].Type)
+	checkTestString(t, AuditEventLoginFailure, events[1].Type)
+	checkTestString(t, "invalid password", events[1].Details)
+	checkTestString(t, "audit-test", events[1].UserAgent)
+	checkTestString(t, AuditActorUser, events[1].Actor)
+
+	events = getTestAuditEvents(t, "?type="+AuditEventLoginFailure)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 audit event, got %d", len(events))
+	}
+}
+
+func TestAuditLogAdminAction(t *testing.T) {
+	defer setAuditLogTestConfig()()
+	clearTestDB()
+	user := createTestUser(true)
+
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/disable", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	req, _ = http.NewRequest("PUT", "/users/000000000000000000000000/disable", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	events := getTestAuditEvents(t, "?type="+AuditEventAdminAction)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 audit event, got %d", len(events))
+	}
+	checkTestString(t, user.ID.String(), events[0].UserID)
+	checkTestString(t, AuditActorAdmin, events[0].Actor)
+	checkTestString(t, "PUT /users/"+user.ID.String()+"/disable", events[0].Details)
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	clearTestDB()
+	createLoginTestUser()
+
+	events := getTestAuditEvents(t, "")
+	if len(events) != 0 {
+		t.Fatalf("Expected no audit events, got %d", len(events))
+	}
+}
+
+func TestAuditLogInvalidQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/audit/?from=yesterday", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
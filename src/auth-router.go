@@ -2,9 +2,14 @@ package main
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
 	"encoding/base64"
+	"fmt"
 	"image/png"
 	"log"
+	"math/big"
 	"net/http"
 	"strings"
 	"time"
@@ -12,10 +17,17 @@ import (
 	"github.com/pquerna/otp/totp"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/li6in9muyou/jwt-auth-proxy/pkg/jwtauthproxy"
 
 	"github.com/gorilla/mux"
+
+	guuid "github.com/google/uuid"
 )
 
+const TrustedDeviceCookieName = "trusted_device"
+
+const phoneVerifyMaxAttempts = 5
+
 // AuthRouter handles authentication related REST requests
 type AuthRouter struct {
 }
@@ -25,7 +37,12 @@ func (router *AuthRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/refresh", router.Refresh).Methods("POST")
 	s.HandleFunc("/logout", router.Logout).Methods("POST")
 	s.HandleFunc("/ping", router.Ping).Methods("GET")
-	if GetConfig().AllowSignup {
+	s.HandleFunc("/me", router.Me).Methods("GET")
+	s.HandleFunc("/verify", router.Verify)
+	if GetConfig().EnableGuest {
+		s.HandleFunc("/guest", router.Guest).Methods("POST")
+	}
+	if GetConfig().AllowSignup || GetConfig().AllowInvitations {
 		s.HandleFunc("/signup", router.Signup).Methods("POST")
 	}
 	if GetConfig().AllowChangePassword {
@@ -34,6 +51,12 @@ func (router *AuthRouter) setupRoutes(s *mux.Router) {
 	if GetConfig().AllowChangeEmail {
 		s.HandleFunc("/changeemail", router.ChangeEmail).Methods("POST")
 	}
+	if GetConfig().MaxAdditionalEmails > 0 {
+		s.HandleFunc("/emails", router.GetEmails).Methods("GET")
+		s.HandleFunc("/emails", router.AddEmail).Methods("POST")
+		s.HandleFunc("/emails/primary", router.PromoteEmail).Methods("PUT")
+		s.HandleFunc("/emails/{email}", router.RemoveEmail).Methods("DELETE")
+	}
 	if GetConfig().AllowForgotPassword {
 		s.HandleFunc("/initpwreset", router.InitForgotPassword).Methods("POST")
 	}
@@ -44,7 +67,45 @@ func (router *AuthRouter) setupRoutes(s *mux.Router) {
 		s.HandleFunc("/otp/init", router.OTPInit).Methods("POST")
 		s.HandleFunc("/otp/confirm", router.OTPConfirm).Methods("POST")
 		s.HandleFunc("/otp/disable", router.OTPDisable).Methods("POST")
+		s.HandleFunc("/otp/devices/revoke", router.RevokeTrustedDevices).Methods("POST")
+	}
+	if GetConfig().EnableAPIKeys {
+		s.HandleFunc("/apikeys", router.GetAPIKeys).Methods("GET")
+		s.HandleFunc("/apikeys", router.CreateAPIKey).Methods("POST")
+		s.HandleFunc("/apikeys/{id}", router.DeleteAPIKey).Methods("DELETE")
+	}
+	if GetConfig().SignedURLKey != "" {
+		s.HandleFunc("/signedurl", router.CreateSignedURL).Methods("POST")
+	}
+	if GetConfig().EnableDeviceFlow {
+		s.HandleFunc("/device/code", router.DeviceAuthorize).Methods("POST")
+		s.HandleFunc("/device/verify", router.DeviceVerify).Methods("POST")
+		s.HandleFunc("/device/token", router.DeviceToken).Methods("POST")
 	}
+	if GetConfig().EnableClientCertAuth {
+		s.HandleFunc("/certlogin", router.CertLogin).Methods("POST")
+	}
+	if GetConfig().OIDCIssuer != "" {
+		s.HandleFunc("/oidc/login", router.OIDCLogin).Methods("GET")
+		s.HandleFunc("/oidc/callback", router.OIDCCallback).Methods("POST")
+		s.HandleFunc("/oidc/link", router.OIDCLink).Methods("POST")
+	}
+	if IsSMSEnabled() {
+		s.HandleFunc("/phone", router.SetPhone).Methods("PUT")
+		s.HandleFunc("/phone", router.DeletePhone).Methods("DELETE")
+		s.HandleFunc("/phone/verify", router.VerifyPhone).Methods("POST")
+	}
+	s.HandleFunc("/sessions", router.GetSessions).Methods("GET")
+	s.HandleFunc("/sessions/{id}", router.DeleteSession).Methods("DELETE")
+	s.HandleFunc("/metadata", router.GetMetadata).Methods("GET")
+	s.HandleFunc("/metadata", router.SetMetadata).Methods("PUT")
+	s.HandleFunc("/preferences", router.GetPreferences).Methods("GET")
+	s.HandleFunc("/preferences", router.SetPreferences).Methods("PUT")
+	s.HandleFunc("/identities", router.GetLinkedIdentities).Methods("GET")
+	s.HandleFunc("/identities/{id}", router.UnlinkIdentity).Methods("DELETE")
+	s.HandleFunc("/organization/members", router.GetOrganizationMembers).Methods("GET")
+	s.HandleFunc("/organization/members/{id}", router.SetOrganizationMember).Methods("PUT")
+	s.HandleFunc("/organization/members/{id}", router.RemoveOrganizationMember).Methods("DELETE")
 	s.HandleFunc("/confirm/{id}", router.Confirm).Methods("POST")
 	s.PathPrefix("/").Methods("OPTIONS").HandlerFunc(CorsHandler)
 	s.PathPrefix("/").HandlerFunc(router.NotFound)
@@ -66,38 +127,135 @@ func (router *AuthRouter) Login(w http.ResponseWriter, r *http.Request) {
 	user := GetUserRepository().GetByEmail(data.Email)
 	if user == nil {
 		log.Println("Invalid login attempt: invalid username", data.Email)
+		RecordAuditEvent(r, AuditEventLoginFailure, AuditActorUser, "", "invalid username")
 		SendUnauthorized(w)
 		return
 	}
 	if user.Confirmed == false {
-		log.Println("Invalid login attempt: unconfirmed account", user.ID.Hex())
+		log.Println("Invalid login attempt: unconfirmed account", user.ID.String())
+		RecordAuditEvent(r, AuditEventLoginFailure, AuditActorUser, user.ID.String(), "unconfirmed account")
 		SendUnauthorized(w)
 		return
 	}
 	if user.Enabled == false {
-		log.Println("Invalid login attempt: disabled account", user.ID.Hex())
+		log.Println("Invalid login attempt: disabled account", user.ID.String())
+		RecordAuditEvent(r, AuditEventLoginFailure, AuditActorUser, user.ID.String(), "disabled account")
 		SendUnauthorized(w)
 		return
 	}
+	if user.Deleted {
+		log.Println("Invalid login attempt: deleted account", user.ID.String())
+		RecordAuditEvent(r, AuditEventLoginFailure, AuditActorUser, user.ID.String(), "deleted account")
+		SendUnauthorized(w)
+		return
+	}
+	requireCaptcha := IsCaptchaEnabled() && GetConfig().CaptchaLoginFailures > 0 && user.FailedLogins >= GetConfig().CaptchaLoginFailures
+	if requireCaptcha && !VerifyCaptcha(r, data.CaptchaToken) {
+		log.Println("Invalid login attempt: missing or invalid CAPTCHA for UserID", user.ID.String())
+		SendError(w, http.StatusUnauthorized, ErrorCodeCaptchaRequired)
+		return
+	}
 	if GetUserRepository().CheckPassword(user.HashedPassword, data.Password) == false {
-		log.Println("Invalid login attempt: invalid password for UserID", user.ID.Hex())
+		log.Println("Invalid login attempt: invalid password for UserID", user.ID.String())
+		RecordAuditEvent(r, AuditEventLoginFailure, AuditActorUser, user.ID.String(), "invalid password")
+		user.FailedLogins++
+		GetUserRepository().Update(user)
+		if IsCaptchaEnabled() && GetConfig().CaptchaLoginFailures > 0 && user.FailedLogins >= GetConfig().CaptchaLoginFailures {
+			SendError(w, http.StatusUnauthorized, ErrorCodeCaptchaRequired)
+			return
+		}
 		SendUnauthorized(w)
 		return
 	}
-	if user.OTPEnabled && GetConfig().EnableTOTP {
+	if user.FailedLogins != 0 {
+		user.FailedLogins = 0
+		GetUserRepository().Update(user)
+	}
+	GetUserRepository().UpgradePasswordHash(user, data.Password)
+	if !user.OTPEnabled && GetConfig().EnforceTOTP {
+		log.Println("Login attempt successful, but OTP enrollment required for UserID", user.ID.String())
+		SendJSON(w, &LoginResponse{
+			RequireOTPEnrollment: true,
+			Error:                ErrorCodeMFAEnrollmentRequired,
+			AccessToken:          router._CreateOTPEnrollmentToken(user),
+		})
+		return
+	}
+	deviceToken := ""
+	if user.OTPEnabled && GetConfig().EnableTOTP && !router._IsTrustedDevice(user, router._GetDeviceToken(r, &data)) {
 		if len(strings.TrimSpace(data.OTP)) != 6 {
-			log.Println("Login attempt successful, but missing OTP for UserID", user.ID.Hex())
+			log.Println("Login attempt successful, but missing OTP for UserID", user.ID.String())
 			SendJSON(w, &LoginResponse{RequireOTP: true})
 			return
 		}
 		if !router._IsValidOTP(user, data.OTP) {
-			log.Println("Login attempt successful, but OTP invalid for UserID", user.ID.Hex())
+			log.Println("Login attempt successful, but OTP invalid for UserID", user.ID.String())
+			RecordAuditEvent(r, AuditEventLoginFailure, AuditActorUser, user.ID.String(), "invalid otp")
 			SendJSON(w, &LoginResponse{RequireOTP: true})
 			return
 		}
+		if data.RememberDevice && GetConfig().TrustedDeviceLifetime > 0 {
+			deviceToken = router._CreateTrustedDeviceToken(user)
+			router._SetDeviceTokenCookie(w, deviceToken, GetConfig().TrustedDeviceLifetime*24*time.Hour)
+		}
 	}
-	log.Println("Successful login for UserID", user.ID.Hex())
-	refreshToken := router._CreateRefreshToken(user)
+	if IsGuestFromContext(r) && GetGuestIDFromContext(r) != user.GuestID {
+		log.Println("Upgrading GuestID", GetGuestIDFromContext(r), "to UserID", user.ID.String())
+		user.GuestID = GetGuestIDFromContext(r)
+		GetUserRepository().Update(user)
+	}
+	if !user.PasswordChangeRequired && GetUserRepository().IsPasswordExpired(user) {
+		log.Println("Password expired for UserID", user.ID.String())
+		user.PasswordChangeRequired = true
+		GetUserRepository().Update(user)
+	}
+	log.Println("Successful login for UserID", user.ID.String())
+	RecordAuditEvent(r, AuditEventLoginSuccess, AuditActorUser, user.ID.String(), "password")
+	refreshToken := router._CreateRefreshToken(r, user)
+	accessToken := router._CreateAccessToken(user)
+	res := &LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken.Token,
+		DeviceToken:  deviceToken,
+	}
+	if user.PasswordChangeRequired {
+		res.RequirePasswordChange = true
+		res.Error = ErrorCodePasswordChangeRequired
+	}
+	SendJSON(w, res)
+}
+
+// CertLogin handles /certlogin requests authenticated by a verified TLS client certificate
+func (router *AuthRouter) CertLogin(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		log.Println("Invalid certificate login attempt: no verified client certificate")
+		SendUnauthorized(w)
+		return
+	}
+	user := router._GetUserByClientCertificate(r.TLS.VerifiedChains[0][0])
+	if user == nil {
+		log.Println("Invalid certificate login attempt: certificate not mapped to any user")
+		SendUnauthorized(w)
+		return
+	}
+	if user.Confirmed == false {
+		log.Println("Invalid certificate login attempt: unconfirmed account", user.ID.String())
+		SendUnauthorized(w)
+		return
+	}
+	if user.Enabled == false {
+		log.Println("Invalid certificate login attempt: disabled account", user.ID.String())
+		SendUnauthorized(w)
+		return
+	}
+	if user.Deleted {
+		log.Println("Invalid certificate login attempt: deleted account", user.ID.String())
+		SendUnauthorized(w)
+		return
+	}
+	log.Println("Successful certificate login for UserID", user.ID.String())
+	RecordAuditEvent(r, AuditEventLoginSuccess, AuditActorUser, user.ID.String(), "certificate")
+	refreshToken := router._CreateRefreshToken(r, user)
 	accessToken := router._CreateAccessToken(user)
 	SendJSON(w, &LoginResponse{
 		AccessToken:  accessToken,
@@ -105,6 +263,21 @@ func (router *AuthRouter) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// Guest handles /guest requests, issuing a short-lived token without refresh token to anonymous visitors
+func (router *AuthRouter) Guest(w http.ResponseWriter, r *http.Request) {
+	claims := &Claims{
+		Guest:   true,
+		GuestID: guuid.New().String(),
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(GetConfig().GuestTokenLifetime * time.Minute).Unix(),
+		},
+	}
+	log.Println("Issuing guest token for GuestID", claims.GuestID)
+	SendJSON(w, &LoginResponse{
+		AccessToken: SignAccessToken(claims),
+	})
+}
+
 // Refresh handles /refresh requests
 func (router *AuthRouter) Refresh(w http.ResponseWriter, r *http.Request) {
 	var data RefreshRequest
@@ -124,6 +297,11 @@ func (router *AuthRouter) Refresh(w http.ResponseWriter, r *http.Request) {
 		SendBadRequest(w)
 		return
 	}
+	if refreshToken.UserID.String() != GetUserIDFromContext(r) {
+		log.Println("Invalid token refresh attempt: refresh token of other user for UserID", GetUserIDFromContext(r))
+		SendBadRequest(w)
+		return
+	}
 	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
 	if user == nil {
 		log.Println("Invalid token refresh attempt: invalid UserID", GetUserIDFromContext(r))
@@ -131,16 +309,23 @@ func (router *AuthRouter) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if user.Confirmed == false {
-		log.Println("Invalid token refresh attempt: unconfirmed account", user.ID.Hex())
+		log.Println("Invalid token refresh attempt: unconfirmed account", user.ID.String())
 		SendUnauthorized(w)
 		return
 	}
 	if user.Enabled == false {
-		log.Println("Invalid token refresh attempt: disabled account", user.ID.Hex())
+		log.Println("Invalid token refresh attempt: disabled account", user.ID.String())
+		SendUnauthorized(w)
+		return
+	}
+	if user.Deleted {
+		log.Println("Invalid token refresh attempt: deleted account", user.ID.String())
 		SendUnauthorized(w)
 		return
 	}
-	log.Println("Successful token refresh for UserID", user.ID.Hex())
+	log.Println("Successful token refresh for UserID", user.ID.String())
+	RecordAuditEvent(r, AuditEventTokenRefresh, AuditActorUser, user.ID.String(), "")
+	GetRefreshTokenRepository().UpdateLastUse(refreshToken, GetClientIP(r), r.UserAgent())
 	accessToken := router._CreateAccessToken(user)
 	SendJSON(w, &LoginResponse{
 		AccessToken:  accessToken,
@@ -171,20 +356,44 @@ func (router *AuthRouter) Ping(w http.ResponseWriter, r *http.Request) {
 	SendUpdated(w)
 }
 
+// Me handles GET /me requests, returning information about the logged in user and the access token
+func (router *AuthRouter) Me(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendUnauthorized(w)
+		return
+	}
+	res := &MeResponse{
+		UserID:           user.ID.String(),
+		Email:            user.Email,
+		Confirmed:        user.Confirmed,
+		Roles:            user.Roles,
+		OTPEnabled:       user.OTPEnabled,
+		Organization:     user.Organization,
+		OrganizationRole: user.OrganizationRole,
+		Scopes:           GetScopesFromContext(r),
+	}
+	if res.Roles == nil {
+		res.Roles = make([]string, 0)
+	}
+	if expiry := GetTokenExpiryFromContext(r); !expiry.IsZero() {
+		res.ExpiresAt = &expiry
+	}
+	SendJSON(w, res)
+}
+
 func (router *AuthRouter) _CreateAccessToken(user *User) string {
+	return SignAccessToken(NewUserClaims(user))
+}
+
+// _CreateOTPEnrollmentToken creates an access token only valid for enrolling a second factor
+func (router *AuthRouter) _CreateOTPEnrollmentToken(user *User) string {
 	claims := &Claims{
-		Email:  user.Email,
-		UserID: user.ID.Hex(),
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(GetConfig().AccessTokenLifetime * time.Minute).Unix(),
-		},
-	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-	jwtString, err := accessToken.SignedString([]byte(GetConfig().JwtSigningKey))
-	if err != nil {
-		return ""
+		Email:         user.Email,
+		UserID:        user.ID.String(),
+		OTPEnrollment: true,
 	}
-	return jwtString
+	return SignAccessToken(claims)
 }
 
 // Signup handles /signup requests
@@ -194,6 +403,33 @@ func (router *AuthRouter) Signup(w http.ResponseWriter, r *http.Request) {
 		SendBadRequest(w)
 		return
 	}
+	if IsCaptchaEnabled() && GetConfig().CaptchaSignup && !VerifyCaptcha(r, data.CaptchaToken) {
+		log.Println("Invalid signup attempt: missing or invalid CAPTCHA for", data.Email)
+		SendError(w, http.StatusBadRequest, ErrorCodeCaptchaRequired)
+		return
+	}
+	if violations := CheckPasswordPolicy(data.Password, data.Email); len(violations) != 0 {
+		log.Println("Invalid signup attempt: password policy violated for", data.Email)
+		SendPasswordPolicyError(w, violations)
+		return
+	}
+	var invitation *Invitation
+	if data.InvitationToken != "" && GetConfig().AllowInvitations {
+		invitation = GetInvitationRepository().GetByToken(data.InvitationToken)
+		if invitation == nil || !strings.EqualFold(invitation.Email, data.Email) {
+			log.Println("Invalid signup attempt: invalid invitation for", data.Email)
+			SendError(w, http.StatusForbidden, ErrorCodeInvitationInvalid)
+			return
+		}
+	} else if !GetConfig().AllowSignup {
+		log.Println("Invalid signup attempt: invitation required for", data.Email)
+		SendError(w, http.StatusForbidden, ErrorCodeInvitationRequired)
+		return
+	} else if !IsEmailDomainAllowed(data.Email) {
+		log.Println("Invalid signup attempt: email domain not allowed for", data.Email)
+		SendError(w, http.StatusForbidden, ErrorCodeEmailDomainNotAllowed)
+		return
+	}
 	user := GetUserRepository().GetByEmail(data.Email)
 	if user != nil {
 		SendAleadyExists(w)
@@ -210,10 +446,42 @@ func (router *AuthRouter) Signup(w http.ResponseWriter, r *http.Request) {
 		Enabled:        true,
 		CreateDate:     time.Now(),
 	}
+	// Remember the language of the signup request, so that mails are sent localized
+	if locale := MatchMailLocale(r.Header.Get("Accept-Language")); locale != "" {
+		user.Preferences = map[string]string{PreferenceLocale: locale}
+	}
+	if IsGuestFromContext(r) {
+		log.Println("Upgrading GuestID", GetGuestIDFromContext(r), "to new account")
+		user.GuestID = GetGuestIDFromContext(r)
+	}
+	if invitation != nil {
+		// The invitation mail has already proven ownership of the email address
+		user.Confirmed = true
+		if invitation.Organization != "" {
+			user.Organization = invitation.Organization
+			user.OrganizationRole = OrganizationRoleMember
+		}
+		if invitation.Role != "" {
+			user.Roles = []string{invitation.Role}
+		}
+	}
 	GetUserRepository().Create(user)
+	if invitation != nil {
+		RecordAuditEvent(r, AuditEventSignup, AuditActorUser, user.ID.String(), "")
+		GetInvitationRepository().Delete(invitation)
+		SendCreated(w, user.ID.String())
+		return
+	}
 	pa := router._CreateConfirmPendingAction(user, PendingActionTypeConfirmAccount, "")
-	router._SendWelcomeMailToNewUser(user, pa)
-	SendCreated(w, user.ID)
+	if err := router._SendWelcomeMailToNewUser(user, pa); err != nil {
+		// Roll back, the account couldn't be confirmed and would block signing up again with the same address
+		log.Println("Signup failed: could not send confirmation mail to", data.Email)
+		GetUserRepository().Delete(user)
+		SendError(w, http.StatusServiceUnavailable, ErrorCodeEmailDeliveryFailed)
+		return
+	}
+	RecordAuditEvent(r, AuditEventSignup, AuditActorUser, user.ID.String(), "")
+	SendCreated(w, user.ID.String())
 }
 
 // ChangePassword handles /changepw requests
@@ -235,8 +503,20 @@ func (router *AuthRouter) ChangePassword(w http.ResponseWriter, r *http.Request)
 		SendUnauthorized(w)
 		return
 	}
-	user.HashedPassword = GetUserRepository().GetHashedPassword(data.NewPassword)
-	GetUserRepository().Update(user)
+	if user.OTPEnabled && GetConfig().EnableTOTP && !router._IsValidOTP(user, data.OTP) {
+		log.Println("Invalid change password attempt: missing or invalid OTP for UserID", GetUserIDFromContext(r))
+		SendError(w, http.StatusUnauthorized, ErrorCodeOTPRequired)
+		return
+	}
+	if violations := CheckPasswordPolicy(data.NewPassword, user.Email); len(violations) != 0 {
+		log.Println("Invalid change password attempt: password policy violated for UserID", GetUserIDFromContext(r))
+		SendPasswordPolicyError(w, violations)
+		return
+	}
+	GetUserRepository().SetPassword(user, data.NewPassword)
+	// Sign out all other sessions, only the one belonging to the supplied refresh token stays alive
+	GetRefreshTokenRepository().DeleteAllForUserExcept(user.ID.String(), data.RefreshToken)
+	RecordAuditEvent(r, AuditEventPasswordChange, AuditActorUser, user.ID.String(), "")
 	SendUpdated(w)
 }
 
@@ -259,6 +539,11 @@ func (router *AuthRouter) ChangeEmail(w http.ResponseWriter, r *http.Request) {
 		SendUnauthorized(w)
 		return
 	}
+	if !IsEmailDomainAllowed(data.Email) {
+		log.Println("Invalid change email attempt: email domain not allowed for UserID", user.ID.String())
+		SendError(w, http.StatusForbidden, ErrorCodeEmailDomainNotAllowed)
+		return
+	}
 	if GetUserRepository().GetByEmail(data.Email) != nil {
 		SendAleadyExists(w)
 		return
@@ -268,7 +553,126 @@ func (router *AuthRouter) ChangeEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	pa := router._CreateConfirmPendingAction(user, PendingActionTypeChangeEmail, data.Email)
-	router._SendConfirmEmailChangeMail(user, pa)
+	err := router._SendConfirmEmailChangeMail(user, pa)
+	var paOld *PendingAction
+	if err == nil && GetConfig().EmailChangeConfirmOld {
+		paOld = router._CreateConfirmPendingAction(user, PendingActionTypeConfirmEmailChangeOld, data.Email)
+		err = router._SendConfirmEmailChangeOldMail(user, paOld)
+	}
+	if err != nil {
+		// Roll back, the pending change couldn't be confirmed and would block changing to the same address again
+		log.Println("Change email failed: could not send confirmation mail for UserID", user.ID.String())
+		GetPendingActionRepository().Delete(pa)
+		if paOld != nil {
+			GetPendingActionRepository().Delete(paOld)
+		}
+		SendError(w, http.StatusServiceUnavailable, ErrorCodeEmailDeliveryFailed)
+		return
+	}
+	SendUpdated(w)
+}
+
+// GetEmails handles GET /emails requests
+func (router *AuthRouter) GetEmails(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendUnauthorized(w)
+		return
+	}
+	res := &EmailsResponse{
+		Email:            user.Email,
+		AdditionalEmails: make([]string, 0),
+		PendingEmails:    make([]string, 0),
+	}
+	res.AdditionalEmails = append(res.AdditionalEmails, user.AdditionalEmails...)
+	for _, pa := range GetPendingActionRepository().GetAllForUserByType(user.ID.String(), PendingActionTypeAddEmail) {
+		res.PendingEmails = append(res.PendingEmails, pa.Payload)
+	}
+	SendJSON(w, res)
+}
+
+// AddEmail handles POST /emails requests, sending a confirmation mail to the additional address
+func (router *AuthRouter) AddEmail(w http.ResponseWriter, r *http.Request) {
+	var data EmailRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid add email attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		log.Println("Invalid add email attempt: invalid UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	pending := GetPendingActionRepository().GetAllForUserByType(user.ID.String(), PendingActionTypeAddEmail)
+	if len(user.AdditionalEmails)+len(pending) >= GetConfig().MaxAdditionalEmails {
+		log.Println("Invalid add email attempt: too many email addresses for UserID", user.ID.String())
+		SendError(w, http.StatusBadRequest, ErrorCodeTooManyEmails)
+		return
+	}
+	if !IsEmailDomainAllowed(data.Email) {
+		log.Println("Invalid add email attempt: email domain not allowed for UserID", user.ID.String())
+		SendError(w, http.StatusForbidden, ErrorCodeEmailDomainNotAllowed)
+		return
+	}
+	if GetUserRepository().GetByEmail(data.Email) != nil {
+		SendAleadyExists(w)
+		return
+	}
+	if len(GetPendingActionRepository().GetByPayload(data.Email)) != 0 {
+		SendAleadyExists(w)
+		return
+	}
+	pa := router._CreateConfirmPendingAction(user, PendingActionTypeAddEmail, data.Email)
+	router._SendConfirmAddEmailMail(user, data.Email, pa)
+	SendUpdated(w)
+}
+
+// PromoteEmail handles PUT /emails/primary requests, making an additional address the primary one
+func (router *AuthRouter) PromoteEmail(w http.ResponseWriter, r *http.Request) {
+	var data LoginRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid promote email attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		log.Println("Invalid promote email attempt: invalid UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	if !GetUserRepository().CheckPassword(user.HashedPassword, data.Password) {
+		log.Println("Invalid promote email attempt: incorrect password for UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	if !GetUserRepository().HasAdditionalEmail(user, data.Email) {
+		SendNotFound(w)
+		return
+	}
+	oldEmail := user.Email
+	GetUserRepository().PromoteAdditionalEmail(user, data.Email)
+	RecordAuditEvent(r, AuditEventEmailChange, AuditActorUser, user.ID.String(), oldEmail+" -> "+user.Email)
+	router._SendEmailChangedMail(user, oldEmail)
+	SendUpdated(w)
+}
+
+// RemoveEmail handles DELETE /emails/{email} requests
+func (router *AuthRouter) RemoveEmail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	email := NormalizeEmail(vars["email"])
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendUnauthorized(w)
+		return
+	}
+	if !GetUserRepository().HasAdditionalEmail(user, email) {
+		SendNotFound(w)
+		return
+	}
+	GetUserRepository().RemoveAdditionalEmail(user, email)
 	SendUpdated(w)
 }
 
@@ -280,14 +684,18 @@ func (router *AuthRouter) InitForgotPassword(w http.ResponseWriter, r *http.Requ
 		SendBadRequest(w)
 		return
 	}
+	if IsCaptchaEnabled() && GetConfig().CaptchaForgotPassword && !VerifyCaptcha(r, data.CaptchaToken) {
+		log.Println("Invalid init forgot password attempt: missing or invalid CAPTCHA for", data.Email)
+		SendError(w, http.StatusBadRequest, ErrorCodeCaptchaRequired)
+		return
+	}
 	user := GetUserRepository().GetByEmail(data.Email)
 	if user == nil {
 		log.Println("Invalid init forgot password attempt: invalid email", data.Email)
 		SendBadRequest(w)
 		return
 	}
-	pa := router._CreateConfirmPendingAction(user, PendingActionTypeInitPasswordReset, "")
-	router._SendConfirmPasswordResetMail(user, pa)
+	InitPasswordReset(user, data.Email)
 	SendUpdated(w)
 }
 
@@ -310,7 +718,8 @@ func (router *AuthRouter) DeleteAccount(w http.ResponseWriter, r *http.Request)
 		SendUnauthorized(w)
 		return
 	}
-	GetUserRepository().Delete(user)
+	GetUserRepository().SoftDelete(user)
+	RecordAuditEvent(r, AuditEventAccountDelete, AuditActorUser, user.ID.String(), "")
 	SendUpdated(w)
 }
 
@@ -323,12 +732,12 @@ func (router *AuthRouter) Confirm(w http.ResponseWriter, r *http.Request) {
 		SendNotFound(w)
 		return
 	}
-	user := GetUserRepository().GetOne(pa.UserID.Hex())
+	user := GetUserRepository().GetOne(pa.UserID.String())
 	if user == nil {
 		SendNotFound(w)
 		return
 	}
-	if !user.Enabled {
+	if !user.Enabled || user.Deleted {
 		SendNotFound(w)
 		return
 	}
@@ -336,11 +745,14 @@ func (router *AuthRouter) Confirm(w http.ResponseWriter, r *http.Request) {
 	case PendingActionTypeConfirmAccount:
 		router._ConfirmAccountActivation(w, pa, user)
 		break
-	case PendingActionTypeChangeEmail:
-		router._ConfirmEmailChange(w, pa, user)
+	case PendingActionTypeChangeEmail, PendingActionTypeConfirmEmailChangeOld:
+		router._ConfirmEmailChange(w, r, pa, user)
 		break
 	case PendingActionTypeInitPasswordReset:
-		router._ConfirmPasswordReset(w, pa, user)
+		router._ConfirmPasswordReset(w, r, pa, user)
+		break
+	case PendingActionTypeAddEmail:
+		router._ConfirmAddEmail(w, pa, user)
 		break
 	default:
 		SendInternalServerError(w)
@@ -386,9 +798,14 @@ func (router *AuthRouter) OTPInit(w http.ResponseWriter, r *http.Request) {
 
 func (router *AuthRouter) OTPDisable(w http.ResponseWriter, r *http.Request) {
 	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
-	user.OTPSecret = ""
-	user.OTPEnabled = false
-	GetUserRepository().Update(user)
+	GetUserRepository().DisableOTP(user)
+	SendUpdated(w)
+}
+
+// RevokeTrustedDevices handles /otp/devices/revoke requests
+func (router *AuthRouter) RevokeTrustedDevices(w http.ResponseWriter, r *http.Request) {
+	GetTrustedDeviceRepository().DeleteAllForUser(GetUserIDFromContext(r))
+	router._SetDeviceTokenCookie(w, "", -1)
 	SendUpdated(w)
 }
 
@@ -419,6 +836,308 @@ func (router *AuthRouter) OTPConfirm(w http.ResponseWriter, r *http.Request) {
 	SendUpdated(w)
 }
 
+// GetAPIKeys handles GET /apikeys requests
+func (router *AuthRouter) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, GetAPIKeyRepository().GetAllForUser(GetUserIDFromContext(r)))
+}
+
+// CreateAPIKey handles POST /apikeys requests
+func (router *AuthRouter) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var data CreateAPIKeyRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid create API key attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		log.Println("Invalid create API key attempt: invalid UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	apiKey, key := GetAPIKeyRepository().CreateForUser(user, data.Name, data.Scopes)
+	w.Header().Set("X-Object-ID", apiKey.ID.Hex())
+	SendJSONWithStatus(w, http.StatusCreated, &CreateAPIKeyResponse{
+		ID:  apiKey.ID.Hex(),
+		Key: key,
+	})
+}
+
+// DeleteAPIKey handles DELETE /apikeys/{id} requests
+func (router *AuthRouter) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	apiKey := GetAPIKeyRepository().GetOne(vars["id"])
+	if apiKey == nil || apiKey.UserID.String() != GetUserIDFromContext(r) {
+		SendNotFound(w)
+		return
+	}
+	GetAPIKeyRepository().Delete(apiKey)
+	SendUpdated(w)
+}
+
+// CreateSignedURL handles POST /signedurl requests, signing a URL for the logged in user
+func (router *AuthRouter) CreateSignedURL(w http.ResponseWriter, r *http.Request) {
+	var data SignedURLRequest
+	if UnmarshalValidateBody(r, &data) != nil || data.UserID != "" {
+		log.Println("Invalid signed URL attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	if GetUserIDFromContext(r) == "" {
+		SendUnauthorized(w)
+		return
+	}
+	_CreateSignedURL(w, GetUserIDFromContext(r), &data)
+}
+
+// SetPhone handles PUT /phone requests, sending a verification code to the new phone number
+func (router *AuthRouter) SetPhone(w http.ResponseWriter, r *http.Request) {
+	var data PhoneRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid set phone attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		log.Println("Invalid set phone attempt: invalid UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	for _, pa := range GetPendingActionRepository().GetAllForUserByType(user.ID.String(), PendingActionTypeVerifyPhone) {
+		GetPendingActionRepository().Delete(pa)
+	}
+	pa := router._CreateConfirmPendingAction(user, PendingActionTypeVerifyPhone, data.Phone)
+	if err := SendSMS(data.Phone, "Your verification code is "+pa.Code); err != nil {
+		GetPendingActionRepository().Delete(pa)
+		SendInternalServerError(w)
+		return
+	}
+	SendUpdated(w)
+}
+
+// VerifyPhone handles POST /phone/verify requests
+func (router *AuthRouter) VerifyPhone(w http.ResponseWriter, r *http.Request) {
+	var data PhoneVerifyRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid verify phone attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		log.Println("Invalid verify phone attempt: invalid UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	pas := GetPendingActionRepository().GetAllForUserByType(user.ID.String(), PendingActionTypeVerifyPhone)
+	if len(pas) == 0 {
+		log.Println("Invalid verify phone attempt: no pending verification for UserID", user.ID.String())
+		SendNotFound(w)
+		return
+	}
+	pa := pas[0]
+	if subtle.ConstantTimeCompare([]byte(pa.Code), []byte(data.Code)) != 1 {
+		log.Println("Invalid verify phone attempt: incorrect code for UserID", user.ID.String())
+		GetPendingActionRepository().IncrementAttempts(pa)
+		if pa.Attempts >= phoneVerifyMaxAttempts {
+			GetPendingActionRepository().Delete(pa)
+		}
+		SendBadRequest(w)
+		return
+	}
+	GetPendingActionRepository().Delete(pa)
+	user.Phone = pa.Payload
+	user.PhoneVerified = true
+	GetUserRepository().Update(user)
+	SendUpdated(w)
+}
+
+// DeletePhone handles DELETE /phone requests
+func (router *AuthRouter) DeletePhone(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendUnauthorized(w)
+		return
+	}
+	user.Phone = ""
+	user.PhoneVerified = false
+	GetUserRepository().Update(user)
+	SendUpdated(w)
+}
+
+// GetSessions handles GET /sessions requests, listing the user's active refresh tokens
+func (router *AuthRouter) GetSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := make([]*SessionResponse, 0)
+	for _, refreshToken := range GetRefreshTokenRepository().GetAllForUser(GetUserIDFromContext(r)) {
+		sessions = append(sessions, &SessionResponse{
+			ID:          refreshToken.ID.Hex(),
+			CreateDate:  refreshToken.CreateDate,
+			LastUseDate: refreshToken.LastUseDate,
+			ExpiryDate:  refreshToken.ExpiryDate,
+			IP:          refreshToken.IP,
+			UserAgent:   refreshToken.UserAgent,
+		})
+	}
+	SendJSON(w, sessions)
+}
+
+// DeleteSession handles DELETE /sessions/{id} requests
+func (router *AuthRouter) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	refreshToken := GetRefreshTokenRepository().GetOne(vars["id"])
+	if refreshToken == nil || refreshToken.UserID.String() != GetUserIDFromContext(r) {
+		SendNotFound(w)
+		return
+	}
+	GetRefreshTokenRepository().Delete(refreshToken)
+	SendUpdated(w)
+}
+
+// GetMetadata handles GET /metadata requests
+func (router *AuthRouter) GetMetadata(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, &MetadataResponse{
+		Metadata:    NonNilMetadata(user.Metadata),
+		AppMetadata: NonNilMetadata(user.AppMetadata),
+	})
+}
+
+// SetMetadata handles PUT /metadata requests, app metadata can only be set via the backend API
+func (router *AuthRouter) SetMetadata(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	metadata, err := UnmarshalMetadataBody(r)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	GetUserRepository().SetMetadata(user, metadata)
+	SendUpdated(w)
+}
+
+// GetPreferences handles GET /preferences requests
+func (router *AuthRouter) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	preferences := user.Preferences
+	if preferences == nil {
+		preferences = make(map[string]string)
+	}
+	SendJSON(w, preferences)
+}
+
+// SetPreferences handles PUT /preferences requests, merging the supplied keys into the user's preferences
+func (router *AuthRouter) SetPreferences(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	var data map[string]string
+	if UnmarshalBody(r, &data) != nil || data == nil {
+		SendBadRequest(w)
+		return
+	}
+	preferences, err := MergePreferences(user.Preferences, data)
+	if err != nil {
+		log.Println("Invalid set preferences attempt for UserID", user.ID.String()+":", err)
+		SendBadRequest(w)
+		return
+	}
+	GetUserRepository().SetPreferences(user, preferences)
+	SendUpdated(w)
+}
+
+// GetLinkedIdentities handles GET /identities requests
+func (router *AuthRouter) GetLinkedIdentities(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, GetLinkedIdentityRepository().GetAllForUser(GetUserIDFromContext(r)))
+}
+
+// UnlinkIdentity handles DELETE /identities/{id} requests
+func (router *AuthRouter) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	identity := GetLinkedIdentityRepository().GetOne(vars["id"])
+	if identity == nil || identity.UserID.String() != GetUserIDFromContext(r) {
+		SendNotFound(w)
+		return
+	}
+	GetLinkedIdentityRepository().Delete(identity)
+	log.Println("Unlinked", identity.Provider, "identity for UserID", GetUserIDFromContext(r))
+	SendUpdated(w)
+}
+
+// GetOrganizationMembers handles GET /organization/members requests
+func (router *AuthRouter) GetOrganizationMembers(w http.ResponseWriter, r *http.Request) {
+	admin := router._GetOrganizationAdmin(r)
+	if admin == nil {
+		SendForbidden(w)
+		return
+	}
+	SendJSON(w, GetOrganizationMembers(admin.Organization))
+}
+
+// SetOrganizationMember handles PUT /organization/members/{id} requests
+func (router *AuthRouter) SetOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	admin := router._GetOrganizationAdmin(r)
+	if admin == nil {
+		SendForbidden(w)
+		return
+	}
+	var data OrganizationMemberRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		SendBadRequest(w)
+		return
+	}
+	vars := mux.Vars(r)
+	user := GetUserRepository().GetOne(vars["id"])
+	if user == nil || user.Organization != admin.Organization {
+		SendNotFound(w)
+		return
+	}
+	GetUserRepository().SetOrganization(user, admin.Organization, data.Role)
+	log.Println("Organization admin", admin.ID.String(), "set role", data.Role, "for UserID", user.ID.String())
+	SendUpdated(w)
+}
+
+// RemoveOrganizationMember handles DELETE /organization/members/{id} requests
+func (router *AuthRouter) RemoveOrganizationMember(w http.ResponseWriter, r *http.Request) {
+	admin := router._GetOrganizationAdmin(r)
+	if admin == nil {
+		SendForbidden(w)
+		return
+	}
+	vars := mux.Vars(r)
+	user := GetUserRepository().GetOne(vars["id"])
+	if user == nil || user.Organization != admin.Organization {
+		SendNotFound(w)
+		return
+	}
+	GetUserRepository().SetOrganization(user, "", "")
+	log.Println("Organization admin", admin.ID.String(), "removed UserID", user.ID.String())
+	SendUpdated(w)
+}
+
+// _GetOrganizationAdmin returns the current user if it is an admin of its organization,
+// checking the stored membership rather than the (possibly outdated) token claims
+func (router *AuthRouter) _GetOrganizationAdmin(r *http.Request) *User {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil || user.Organization == "" || user.OrganizationRole != OrganizationRoleAdmin {
+		return nil
+	}
+	return user
+}
+
 func (router *AuthRouter) _IsValidOTP(user *User, passcode string) bool {
 	secret, err := Decrypt(GetConfig().TOTPSecretEncryptionKey, user.OTPSecret)
 	if err != nil {
@@ -428,6 +1147,68 @@ func (router *AuthRouter) _IsValidOTP(user *User, passcode string) bool {
 	return totp.Validate(passcode, secret)
 }
 
+func (router *AuthRouter) _CreateTrustedDeviceToken(user *User) string {
+	td := &TrustedDevice{
+		Token:      GetTrustedDeviceRepository().FindUnusedToken(),
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().Add(GetConfig().TrustedDeviceLifetime * 24 * time.Hour),
+		UserID:     user.ID,
+	}
+	GetTrustedDeviceRepository().Create(td)
+	return td.Token
+}
+
+func (router *AuthRouter) _IsTrustedDevice(user *User, deviceToken string) bool {
+	if deviceToken == "" || GetConfig().TrustedDeviceLifetime <= 0 {
+		return false
+	}
+	td := GetTrustedDeviceRepository().GetByToken(deviceToken)
+	if td == nil || td.UserID != user.ID {
+		return false
+	}
+	log.Println("Skipping OTP for trusted device of UserID", user.ID.String())
+	return true
+}
+
+func (router *AuthRouter) _GetDeviceToken(r *http.Request, data *LoginRequest) string {
+	if data.DeviceToken != "" {
+		return data.DeviceToken
+	}
+	if cookie, err := r.Cookie(TrustedDeviceCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (router *AuthRouter) _SetDeviceTokenCookie(w http.ResponseWriter, deviceToken string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     TrustedDeviceCookieName,
+		Value:    deviceToken,
+		Path:     GetConfig().PublicAPIPath,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// _GetUserByClientCertificate maps a client certificate to a user by its email SAN or its registered fingerprint
+func (router *AuthRouter) _GetUserByClientCertificate(cert *x509.Certificate) *User {
+	if GetConfig().ClientCertMapping == ClientCertMappingSAN {
+		for _, email := range cert.EmailAddresses {
+			if user := GetUserRepository().GetByEmail(email); user != nil {
+				return user
+			}
+		}
+		return nil
+	}
+	clientCert := GetClientCertificateRepository().GetByFingerprint(GetClientCertificateRepository().GetFingerprint(cert))
+	if clientCert == nil {
+		return nil
+	}
+	GetClientCertificateRepository().UpdateLastUseDate(clientCert)
+	return GetUserRepository().GetOne(clientCert.UserID.String())
+}
+
 func (router *AuthRouter) _ConfirmAccountActivation(w http.ResponseWriter, pa *PendingAction, user *User) {
 	user.Confirmed = true
 	GetUserRepository().Update(user)
@@ -435,28 +1216,64 @@ func (router *AuthRouter) _ConfirmAccountActivation(w http.ResponseWriter, pa *P
 	SendUpdated(w)
 }
 
-func (router *AuthRouter) _ConfirmEmailChange(w http.ResponseWriter, pa *PendingAction, user *User) {
+// _ConfirmEmailChange changes the email address once the new address (and the old one, if required) is confirmed
+func (router *AuthRouter) _ConfirmEmailChange(w http.ResponseWriter, r *http.Request, pa *PendingAction, user *User) {
+	GetPendingActionRepository().Delete(pa)
+	for _, other := range GetPendingActionRepository().GetByPayload(pa.Payload) {
+		if other.UserID == user.ID && (other.ActionType == PendingActionTypeChangeEmail || other.ActionType == PendingActionTypeConfirmEmailChangeOld) {
+			log.Println("Email change confirmed by one address, awaiting confirmation by the other for UserID", user.ID.String())
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+	if GetUserRepository().GetByEmail(pa.Payload) != nil {
+		SendAleadyExists(w)
+		return
+	}
+	oldEmail := user.Email
 	user.Email = pa.Payload
 	GetUserRepository().Update(user)
+	RecordAuditEvent(r, AuditEventEmailChange, AuditActorUser, user.ID.String(), oldEmail+" -> "+user.Email)
+	router._SendEmailChangedMail(user, oldEmail)
+	SendUpdated(w)
+}
+
+func (router *AuthRouter) _ConfirmAddEmail(w http.ResponseWriter, pa *PendingAction, user *User) {
 	GetPendingActionRepository().Delete(pa)
+	if GetUserRepository().GetByEmail(pa.Payload) != nil {
+		SendAleadyExists(w)
+		return
+	}
+	if len(user.AdditionalEmails) >= GetConfig().MaxAdditionalEmails {
+		SendError(w, http.StatusBadRequest, ErrorCodeTooManyEmails)
+		return
+	}
+	GetUserRepository().AddAdditionalEmail(user, pa.Payload)
 	SendUpdated(w)
 }
 
-func (router *AuthRouter) _ConfirmPasswordReset(w http.ResponseWriter, pa *PendingAction, user *User) {
-	password := GetConfig().GenerateRandomPassword(8)
-	user.HashedPassword = GetUserRepository().GetHashedPassword(password)
-	GetUserRepository().Update(user)
+func (router *AuthRouter) _ConfirmPasswordReset(w http.ResponseWriter, r *http.Request, pa *PendingAction, user *User) {
+	password := GeneratePolicyPassword(user.Email)
+	GetUserRepository().SetPassword(user, password)
 	GetPendingActionRepository().Delete(pa)
-	router._SendNewPassword(user, password)
+	RecordAuditEvent(r, AuditEventPasswordReset, AuditActorUser, user.ID.String(), "")
+	email := user.Email
+	if pa.Payload != "" && GetUserRepository().HasAdditionalEmail(user, pa.Payload) {
+		email = pa.Payload
+	}
+	router._SendNewPassword(user, email, password)
 	SendUpdated(w)
 }
 
-func (router *AuthRouter) _CreateRefreshToken(user *User) *RefreshToken {
+func (router *AuthRouter) _CreateRefreshToken(r *http.Request, user *User) *RefreshToken {
 	e := &RefreshToken{
-		Token:      GetRefreshTokenRepository().FindUnusedToken(),
-		CreateDate: time.Now(),
-		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * GetConfig().RefreshTokenLifetime),
-		UserID:     user.ID,
+		Token:       GetRefreshTokenRepository().FindUnusedToken(),
+		CreateDate:  time.Now(),
+		ExpiryDate:  time.Now().Add(time.Duration(time.Minute) * GetConfig().RefreshTokenLifetime),
+		LastUseDate: time.Now(),
+		IP:          GetClientIP(r),
+		UserAgent:   r.UserAgent(),
+		UserID:      user.ID,
 	}
 	GetRefreshTokenRepository().Create(e)
 	return e
@@ -471,59 +1288,132 @@ func (router *AuthRouter) _CreateConfirmPendingAction(user *User, actionType int
 		Payload:    payload,
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
+	if actionType == PendingActionTypeVerifyPhone {
+		// Codes have to be typed in, so they're short and can only be guessed a few times
+		pa.Code = router._GenerateVerificationCode()
+	}
 	GetPendingActionRepository().Create(&pa)
 	return &pa
 }
 
-func (router *AuthRouter) _SendWelcomeMailToNewUser(user *User, pa *PendingAction) {
+func (router *AuthRouter) _GenerateVerificationCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
+
+func (router *AuthRouter) _SendWelcomeMailToNewUser(user *User, pa *PendingAction) error {
+	var buf bytes.Buffer
+	TemplateSignup.Localize(user.Preferences[PreferenceLocale]).Execute(&buf, ConfirmMailVars{
+		From:        GetConfig().SMTPSenderAddr,
+		To:          user.Email,
+		ConfirmID:   pa.Token,
+		Preferences: user.Preferences,
+	})
+	return SendMail(user.Email, buf.String())
+}
+
+func (router *AuthRouter) _SendConfirmEmailChangeMail(user *User, pa *PendingAction) error {
+	var buf bytes.Buffer
+	TemplateChangeEmail.Localize(user.Preferences[PreferenceLocale]).Execute(&buf, ConfirmMailVars{
+		From:        GetConfig().SMTPSenderAddr,
+		To:          pa.Payload,
+		ConfirmID:   pa.Token,
+		Preferences: user.Preferences,
+	})
+	return SendMail(pa.Payload, buf.String())
+}
+
+func (router *AuthRouter) _SendConfirmAddEmailMail(user *User, email string, pa *PendingAction) {
 	var buf bytes.Buffer
-	TemplateSignup.Execute(&buf, ConfirmMailVars{
-		From:      GetConfig().SMTPSenderAddr,
-		To:        user.Email,
-		ConfirmID: pa.Token,
+	TemplateAddEmail.Localize(user.Preferences[PreferenceLocale]).Execute(&buf, ConfirmMailVars{
+		From:        GetConfig().SMTPSenderAddr,
+		To:          email,
+		ConfirmID:   pa.Token,
+		Preferences: user.Preferences,
 	})
-	SendMail(user.Email, buf.String())
+	SendMail(email, buf.String())
 }
 
-func (router *AuthRouter) _SendConfirmEmailChangeMail(user *User, pa *PendingAction) {
+func (router *AuthRouter) _SendConfirmEmailChangeOldMail(user *User, pa *PendingAction) error {
 	var buf bytes.Buffer
-	TemplateChangeEmail.Execute(&buf, ConfirmMailVars{
-		From:      GetConfig().SMTPSenderAddr,
-		To:        pa.Payload,
-		ConfirmID: pa.Token,
+	TemplateChangeEmailOld.Localize(user.Preferences[PreferenceLocale]).Execute(&buf, ConfirmMailVars{
+		From:        GetConfig().SMTPSenderAddr,
+		To:          user.Email,
+		ConfirmID:   pa.Token,
+		Preferences: user.Preferences,
 	})
-	SendMail(pa.Payload, buf.String())
+	return SendMail(user.Email, buf.String())
+}
+
+func (router *AuthRouter) _SendEmailChangedMail(user *User, oldEmail string) {
+	var buf bytes.Buffer
+	TemplateEmailChanged.Localize(user.Preferences[PreferenceLocale]).Execute(&buf, EmailChangedMailVars{
+		From:        GetConfig().SMTPSenderAddr,
+		To:          oldEmail,
+		NewEmail:    user.Email,
+		Preferences: user.Preferences,
+	})
+	SendMail(oldEmail, buf.String())
+}
+
+// InitPasswordReset sends a mail to confirm a password reset to one of the user's email addresses
+func InitPasswordReset(user *User, email string) {
+	router := &AuthRouter{}
+	// Remember an additional address the reset was requested for, so the new password is sent there as well
+	payload := ""
+	if !strings.EqualFold(email, user.Email) {
+		payload = email
+	}
+	pa := router._CreateConfirmPendingAction(user, PendingActionTypeInitPasswordReset, payload)
+	router._SendConfirmPasswordResetMail(user, email, pa)
 }
 
-func (router *AuthRouter) _SendConfirmPasswordResetMail(user *User, pa *PendingAction) {
+func (router *AuthRouter) _SendConfirmPasswordResetMail(user *User, email string, pa *PendingAction) {
 	var buf bytes.Buffer
-	TemplateResetPassword.Execute(&buf, ConfirmMailVars{
-		From:      GetConfig().SMTPSenderAddr,
-		To:        user.Email,
-		ConfirmID: pa.Token,
+	TemplateResetPassword.Localize(user.Preferences[PreferenceLocale]).Execute(&buf, ConfirmMailVars{
+		From:        GetConfig().SMTPSenderAddr,
+		To:          email,
+		ConfirmID:   pa.Token,
+		Preferences: user.Preferences,
 	})
-	SendMail(user.Email, buf.String())
+	SendMail(email, buf.String())
 }
 
-func (router *AuthRouter) _SendNewPassword(user *User, password string) {
+func (router *AuthRouter) _SendNewPassword(user *User, email string, password string) {
 	var buf bytes.Buffer
-	TemplateNewPassword.Execute(&buf, PasswordMailVars{
-		From:     GetConfig().SMTPSenderAddr,
-		To:       user.Email,
-		Password: password,
+	TemplateNewPassword.Localize(user.Preferences[PreferenceLocale]).Execute(&buf, PasswordMailVars{
+		From:        GetConfig().SMTPSenderAddr,
+		To:          email,
+		Password:    password,
+		Preferences: user.Preferences,
 	})
-	SendMail(user.Email, buf.String())
+	SendMail(email, buf.String())
 }
 
 // LoginRequest holds the POST payload for login requests
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8,max=32"`
-	OTP      string `json:"otp"`
+	Email          string `json:"email" validate:"required,email"`
+	Password       string `json:"password" validate:"required,min=8,max=32"`
+	OTP            string `json:"otp"`
+	RememberDevice bool   `json:"rememberDevice"`
+	DeviceToken    string `json:"deviceToken"`
+	CaptchaToken   string `json:"captchaToken"`
+}
+
+func (r *LoginRequest) Normalize() {
+	r.Email = NormalizeEmail(r.Email)
 }
 
 type ForgotPasswordRequest struct {
-	Email string `json:"email" validate:"required,email"`
+	Email        string `json:"email" validate:"required,email"`
+	CaptchaToken string `json:"captchaToken"`
+}
+
+func (r *ForgotPasswordRequest) Normalize() {
+	r.Email = NormalizeEmail(r.Email)
 }
 
 // RefreshRequest holds the POST payload for refresh requests
@@ -531,30 +1421,38 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refreshToken" validate:"required"`
 }
 
-// Claims holds payload the issued JWTs
-type Claims struct {
-	Email  string `json:"email"`
-	UserID string `json:"userID"`
-	jwt.StandardClaims
-}
+// Claims holds payload the issued JWTs, see package jwtauthproxy
+type Claims = jwtauthproxy.Claims
 
 // LoginResponse holds the response payload for login responses
 type LoginResponse struct {
-	RequireOTP   bool   `json:"otpRequired"`
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
+	RequireOTP            bool   `json:"otpRequired"`
+	RequireOTPEnrollment  bool   `json:"otpEnrollmentRequired,omitempty"`
+	RequirePasswordChange bool   `json:"passwordChangeRequired,omitempty"`
+	Error                 string `json:"error,omitempty"`
+	AccessToken           string `json:"accessToken"`
+	RefreshToken          string `json:"refreshToken"`
+	DeviceToken           string `json:"deviceToken,omitempty"`
 }
 
 // ChangePasswordRequest holds the POST payload for password change requests
 type ChangePasswordRequest struct {
-	OldPassword string `json:"oldPassword" validate:"required,min=8,max=32"`
-	NewPassword string `json:"newPassword" validate:"required,min=8,max=32"`
+	OldPassword  string `json:"oldPassword" validate:"required,min=8,max=32"`
+	NewPassword  string `json:"newPassword" validate:"required,min=8,max=32"`
+	OTP          string `json:"otp"`
+	RefreshToken string `json:"refreshToken"`
 }
 
 // SignupRequest holds the POST payload for signup requests
 type SignupRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8,max=32"`
+	Email           string `json:"email" validate:"required,email"`
+	Password        string `json:"password" validate:"required,min=8,max=32"`
+	InvitationToken string `json:"invitationToken"`
+	CaptchaToken    string `json:"captchaToken"`
+}
+
+func (r *SignupRequest) Normalize() {
+	r.Email = NormalizeEmail(r.Email)
 }
 
 // DeleteAccountRequest holds the POST payload for account delete requests
@@ -562,6 +1460,57 @@ type DeleteAccountRequest struct {
 	Password string `json:"password" validate:"required,min=8,max=32"`
 }
 
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func (r *EmailRequest) Normalize() {
+	r.Email = NormalizeEmail(r.Email)
+}
+
+// EmailsResponse lists the user's primary, verified additional and pending additional email addresses
+type EmailsResponse struct {
+	Email            string   `json:"email"`
+	AdditionalEmails []string `json:"additionalEmails"`
+	PendingEmails    []string `json:"pendingEmails"`
+}
+
+type PhoneRequest struct {
+	Phone string `json:"phone" validate:"required,e164"`
+}
+
+type PhoneVerifyRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// SessionResponse describes an active session, i.e. a refresh token, without exposing the token itself
+type SessionResponse struct {
+	ID          string    `json:"id"`
+	CreateDate  time.Time `json:"createDate"`
+	LastUseDate time.Time `json:"lastUseDate"`
+	ExpiryDate  time.Time `json:"expiryDate"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"userAgent"`
+}
+
+// MeResponse describes the logged in user and the expiry of the access token used
+type MeResponse struct {
+	UserID           string     `json:"userId"`
+	Email            string     `json:"email"`
+	Confirmed        bool       `json:"confirmed"`
+	Roles            []string   `json:"roles"`
+	OTPEnabled       bool       `json:"otpEnabled"`
+	Organization     string     `json:"organization,omitempty"`
+	OrganizationRole string     `json:"organizationRole,omitempty"`
+	Scopes           []string   `json:"scopes,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+}
+
+type MetadataResponse struct {
+	Metadata    map[string]interface{} `json:"metadata"`
+	AppMetadata map[string]interface{} `json:"appMetadata"`
+}
+
 type OTPInitResponse struct {
 	Secret string `json:"secret"`
 	Image  string `json:"image"`
@@ -570,3 +1519,15 @@ type OTPInitResponse struct {
 type OTPValidateRequest struct {
 	Passcode string `json:"passcode" validate:"required,min=6,max=6"`
 }
+
+// CreateAPIKeyRequest holds the POST payload for API key creation requests
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=64"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateAPIKeyResponse holds the response payload for API key creation requests
+type CreateAPIKeyResponse struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
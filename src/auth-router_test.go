@@ -4,15 +4,15 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/dgrijalva/jwt-go"
 	"github.com/pquerna/otp/totp"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestAuthSignup(t *testing.T) {
@@ -106,7 +106,7 @@ func TestAuthSignupConflictingPendingChange(t *testing.T) {
 		ActionType: PendingActionTypeChangeEmail,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * GetConfig().PendingActionLifetime),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "foo@bar.com",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
@@ -150,6 +150,62 @@ func TestSignupTwice(t *testing.T) {
 	checkTestResponseCode(t, http.StatusConflict, res.Code)
 }
 
+func failTestSMTP(t *testing.T) {
+	client := smtpClient
+	smtpClient = func(addr string) (dialer, error) {
+		return nil, errors.New("connection refused")
+	}
+	t.Cleanup(func() {
+		smtpClient = client
+	})
+}
+
+func TestSignupLocalizedMail(t *testing.T) {
+	clearTestDB()
+	useTestMailLocales(t)
+
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	if !strings.HasPrefix(smtpMockContent.Buffer.DataValue, "de:") {
+		t.Errorf("Expected German confirmation mail, got %s", smtpMockContent.Buffer.DataValue)
+	}
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	checkTestString(t, "de", user.Preferences[PreferenceLocale])
+}
+
+func TestSignupMailFailureRollsBack(t *testing.T) {
+	clearTestDB()
+	failTestSMTP(t)
+
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusServiceUnavailable, res.Code)
+	if GetUserRepository().GetByEmail("foo@bar.com") != nil {
+		t.Error("Expected user to be removed after failed confirmation mail")
+	}
+	if len(GetPendingActionRepository().GetByPayload("foo@bar.com")) != 0 {
+		t.Error("Expected pending action to be removed after failed confirmation mail")
+	}
+}
+
+func TestAuthChangeEmailMailFailureRollsBack(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	failTestSMTP(t)
+
+	payload := "{\"email\": \"foo2@bar.com\", \"password\": \"12345678\"}"
+	req := newHTTPRequest("POST", "/auth/changeemail", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusServiceUnavailable, res.Code)
+	if len(GetPendingActionRepository().GetByPayload("foo2@bar.com")) != 0 {
+		t.Error("Expected pending email change to be removed after failed confirmation mail")
+	}
+}
+
 func TestAuthChangeEmail(t *testing.T) {
 	clearTestDB()
 	loginResponse := createLoginTestUser()
@@ -180,6 +236,52 @@ func TestAuthChangeEmail(t *testing.T) {
 	if GetUserRepository().GetByEmail("foo2@bar.com") == nil {
 		t.Error("Expected user to have new address")
 	}
+
+	// Check notice sent to old address
+	checkTestString(t, "foo@bar.com", smtpMockContent.RcptValue)
+	checkTestString(t, "foo2@bar.com", smtpMockContent.Buffer.DataValue)
+}
+
+func TestAuthChangeEmailConfirmOldAddress(t *testing.T) {
+	os.Setenv("EMAIL_CHANGE_CONFIRM_OLD", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("EMAIL_CHANGE_CONFIRM_OLD", "0")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"email": "foo2@bar.com", "password": "12345678"}`
+	req := newHTTPRequest("POST", "/auth/changeemail", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Confirmation mail to old address is sent last
+	checkTestString(t, "foo@bar.com", smtpMockContent.RcptValue)
+	oldAddressToken := smtpMockContent.Buffer.DataValue
+	newAddressToken := ""
+	for _, pa := range GetPendingActionRepository().GetByPayload("foo2@bar.com") {
+		if pa.ActionType == PendingActionTypeChangeEmail {
+			newAddressToken = pa.Token
+		}
+	}
+	checkStringNotEmpty(t, newAddressToken)
+
+	// Confirming the new address only is not enough
+	req, _ = http.NewRequest("POST", "/auth/confirm/"+newAddressToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusAccepted, res.Code)
+	if GetUserRepository().GetByEmail("foo2@bar.com") != nil {
+		t.Fatal("Expected user to still have old address")
+	}
+
+	req, _ = http.NewRequest("POST", "/auth/confirm/"+oldAddressToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if GetUserRepository().GetByEmail("foo2@bar.com") == nil {
+		t.Error("Expected user to have new address")
+	}
 }
 
 func TestDeleteAccount(t *testing.T) {
@@ -191,8 +293,34 @@ func TestDeleteAccount(t *testing.T) {
 	res := executePublicTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
-	if GetUserRepository().GetByEmail("foo@bar.com") != nil {
-		t.Error("Expected user to not exist anymore")
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	if user == nil || !user.Deleted {
+		t.Fatal("Expected user to be soft-deleted")
+	}
+	if GetRefreshTokenRepository().GetByToken(loginResponse.RefreshToken) != nil {
+		t.Error("Expected refresh tokens to be revoked")
+	}
+	payload = `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestDeleteAccountPurgedAfterRetention(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	GetUserRepository().SoftDelete(user)
+
+	GetUserRepository().CleanUp()
+	if GetUserRepository().GetOne(user.ID.String()) == nil {
+		t.Fatal("Expected user to be retained")
+	}
+
+	user.DeleteDate = time.Now().Add(-31 * 24 * time.Hour)
+	GetUserRepository().Update(user)
+	GetUserRepository().CleanUp()
+	if GetUserRepository().GetOne(user.ID.String()) != nil {
+		t.Error("Expected user to be purged")
 	}
 }
 
@@ -231,7 +359,7 @@ func TestAuthChangeEmailConflictingPendingChange(t *testing.T) {
 		ActionType: PendingActionTypeChangeEmail,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * GetConfig().PendingActionLifetime),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "foo2@bar.com",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
@@ -423,6 +551,61 @@ func TestRefresh(t *testing.T) {
 	}
 }
 
+func TestSessions(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	loginResponse2 := loginUser("foo@bar.com", "12345678")
+
+	req := newHTTPRequest("GET", "/auth/sessions", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var sessions []SessionResponse
+	json.Unmarshal(res.Body.Bytes(), &sessions)
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	if strings.Contains(res.Body.String(), loginResponse.RefreshToken) {
+		t.Error("Expected refresh tokens not to be exposed")
+	}
+
+	refreshToken := GetRefreshTokenRepository().GetByToken(loginResponse2.RefreshToken)
+	req = newHTTPRequest("DELETE", "/auth/sessions/"+refreshToken.ID.Hex(), loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	payload := "{\"refreshToken\": \"" + loginResponse2.RefreshToken + "\"}"
+	req = newHTTPRequest("POST", "/auth/refresh", loginResponse2.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	payload = "{\"refreshToken\": \"" + loginResponse.RefreshToken + "\"}"
+	req = newHTTPRequest("POST", "/auth/refresh", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	req.Header.Set("User-Agent", "session-test")
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	refreshToken = GetRefreshTokenRepository().GetByToken(loginResponse.RefreshToken)
+	checkTestString(t, "session-test", refreshToken.UserAgent)
+}
+
+func TestDeleteSessionOfOtherUser(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	user2 := &User{
+		Email:          "foo2@bar.com",
+		CreateDate:     time.Now(),
+		HashedPassword: GetUserRepository().GetHashedPassword("12345678"),
+		Confirmed:      true,
+		Enabled:        true,
+	}
+	GetUserRepository().Create(user2)
+	loginResponse2 := loginUser("foo2@bar.com", "12345678")
+
+	refreshToken := GetRefreshTokenRepository().GetByToken(loginResponse2.RefreshToken)
+	req := newHTTPRequest("DELETE", "/auth/sessions/"+refreshToken.ID.Hex(), loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
 func TestRefreshWithInvalidRefreshToken(t *testing.T) {
 	clearTestDB()
 	loginResponse := createLoginTestUser()
@@ -433,6 +616,27 @@ func TestRefreshWithInvalidRefreshToken(t *testing.T) {
 	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
 }
 
+func TestRefreshWithRefreshTokenOfOtherUser(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	user2 := &User{
+		Email:          "foo2@bar.com",
+		CreateDate:     time.Now(),
+		HashedPassword: GetUserRepository().GetHashedPassword("12345678"),
+		Confirmed:      true,
+		Enabled:        true,
+	}
+	GetUserRepository().Create(user2)
+	loginResponse2 := loginUser("foo2@bar.com", "12345678")
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	GetRefreshTokenRepository().DeleteAllForUser(user.ID.String())
+
+	payload := "{\"refreshToken\": \"" + loginResponse2.RefreshToken + "\"}"
+	req := newHTTPRequest("POST", "/auth/refresh", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
+
 func TestRefreshWithoutRefreshToken(t *testing.T) {
 	clearTestDB()
 	loginResponse := createLoginTestUser()
@@ -513,6 +717,48 @@ func TestChangePassword(t *testing.T) {
 	checkTestResponseCode(t, http.StatusOK, res.Code)
 }
 
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	otherLoginResponse := loginUser("foo@bar.com", "12345678")
+
+	payload := `{"oldPassword": "12345678", "newPassword": "00000000", "refreshToken": "` + loginResponse.RefreshToken + `"}`
+	req := newHTTPRequest("POST", "/auth/setpw", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	payload = `{"refreshToken": "` + otherLoginResponse.RefreshToken + `"}`
+	req = newHTTPRequest("POST", "/auth/refresh", otherLoginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	payload = `{"refreshToken": "` + loginResponse.RefreshToken + `"}`
+	req = newHTTPRequest("POST", "/auth/refresh", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+}
+
+func TestChangePasswordOTP(t *testing.T) {
+	clearTestDB()
+	_, secret := createOTPTestUser(true)
+	passcode, _ := totp.GenerateCode(secret, time.Now())
+	loginResponse := loginUserOTP("foo@bar.com", "12345678", passcode)
+
+	payload := `{"oldPassword": "12345678", "newPassword": "00000000"}`
+	req := newHTTPRequest("POST", "/auth/setpw", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeOTPRequired, errorResponse.Error)
+
+	passcode, _ = totp.GenerateCode(secret, time.Now())
+	payload = `{"oldPassword": "12345678", "newPassword": "00000000", "otp": "` + passcode + `"}`
+	req = newHTTPRequest("POST", "/auth/setpw", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+}
+
 func TestChangePasswordInvalidOld(t *testing.T) {
 	clearTestDB()
 	loginResponse := createLoginTestUser()
@@ -633,3 +879,385 @@ func TestDisableTOTP(t *testing.T) {
 		t.Fatal("Expected access and refresh tokens to be non-empty without OTP")
 	}
 }
+
+func TestLoginTOTPEnforcedWithoutEnrollment(t *testing.T) {
+	os.Setenv("TOTP_ENFORCE", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("TOTP_ENFORCE", "0")
+		GetConfig().ReadConfig()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	if !loginResponse.RequireOTPEnrollment {
+		t.Fatal("Expected login to require OTP enrollment")
+	}
+	checkTestString(t, ErrorCodeMFAEnrollmentRequired, loginResponse.Error)
+	if loginResponse.AccessToken == "" || loginResponse.RefreshToken != "" {
+		t.Fatal("Expected enrollment access token only")
+	}
+
+	// Enrollment token must not be usable for other routes
+	req := newHTTPRequest("GET", "/auth/ping", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Enrollment token can be used to enroll
+	req = newHTTPRequest("POST", "/auth/otp/init", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var otpInitResponse OTPInitResponse
+	json.Unmarshal(res.Body.Bytes(), &otpInitResponse)
+	passcode, _ := totp.GenerateCode(otpInitResponse.Secret, time.Now())
+	payload := "{\"passcode\": \"" + passcode + "\"}"
+	req = newHTTPRequest("POST", "/auth/otp/confirm", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Login with OTP now succeeds
+	passcode, _ = totp.GenerateCode(otpInitResponse.Secret, time.Now().UTC())
+	loginResponse = loginUserOTP("foo@bar.com", "12345678", passcode)
+	if loginResponse.RequireOTPEnrollment || loginResponse.AccessToken == "" || loginResponse.RefreshToken == "" {
+		t.Fatal("Expected login to be successful after OTP enrollment")
+	}
+}
+
+func TestLoginTrustedDevice(t *testing.T) {
+	clearTestDB()
+	_, secret := createOTPTestUser(true)
+
+	// Login with OTP and remember device
+	passcode, _ := totp.GenerateCode(secret, time.Now().UTC())
+	payload := "{\"email\": \"foo@bar.com\", \"password\": \"12345678\", \"otp\": \"" + passcode + "\", \"rememberDevice\": true}"
+	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var loginResponse LoginResponse
+	json.Unmarshal(res.Body.Bytes(), &loginResponse)
+	checkStringNotEmpty(t, loginResponse.DeviceToken)
+	td := GetTrustedDeviceRepository().GetByToken(loginResponse.DeviceToken)
+	if td == nil || td.HashedToken == loginResponse.DeviceToken {
+		t.Fatal("Expected trusted device to be stored with hashed token")
+	}
+
+	// Device token is not accepted as access token
+	req = newHTTPRequest("POST", "/auth/otp/devices/revoke", loginResponse.DeviceToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	// Login without OTP from trusted device
+	payload = "{\"email\": \"foo@bar.com\", \"password\": \"12345678\", \"deviceToken\": \"" + loginResponse.DeviceToken + "\"}"
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	var loginResponse2 LoginResponse
+	json.Unmarshal(res.Body.Bytes(), &loginResponse2)
+	if loginResponse2.RequireOTP || loginResponse2.AccessToken == "" {
+		t.Fatal("Expected login from trusted device to skip OTP")
+	}
+
+	// Revoke trusted devices
+	req = newHTTPRequest("POST", "/auth/otp/devices/revoke", loginResponse2.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Login without OTP requires OTP again
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	var loginResponse3 LoginResponse
+	json.Unmarshal(res.Body.Bytes(), &loginResponse3)
+	if !loginResponse3.RequireOTP {
+		t.Fatal("Expected login to require OTP after revoking trusted devices")
+	}
+}
+
+func TestAPIKeySelfService(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"name": "ci", "scopes": ["read"]}`
+	req := newHTTPRequest("POST", "/auth/apikeys", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var createResponse CreateAPIKeyResponse
+	json.Unmarshal(res.Body.Bytes(), &createResponse)
+	checkStringNotEmpty(t, createResponse.Key)
+
+	// API keys are not accepted for the public API
+	req = newHTTPRequest("GET", "/auth/apikeys", "", nil)
+	req.Header.Set("X-Api-Key", createResponse.Key)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	req = newHTTPRequest("GET", "/auth/apikeys", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var apiKeys []APIKey
+	json.Unmarshal(res.Body.Bytes(), &apiKeys)
+	if len(apiKeys) != 1 {
+		t.Fatalf("Expected 1 API key, got %d", len(apiKeys))
+	}
+
+	req = newHTTPRequest("DELETE", "/auth/apikeys/"+createResponse.ID, loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if GetAPIKeyRepository().GetByKey(createResponse.Key) != nil {
+		t.Error("Expected API key to be revoked")
+	}
+}
+
+func TestDeviceAuthorizationFlow(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	// Device requests codes
+	req := newHTTPRequest("POST", "/auth/device/code", "", nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var deviceResponse DeviceAuthorizationResponse
+	json.Unmarshal(res.Body.Bytes(), &deviceResponse)
+	checkStringNotEmpty(t, deviceResponse.DeviceCode)
+	checkStringNotEmpty(t, deviceResponse.UserCode)
+
+	// Device polls before approval
+	payload := "{\"device_code\": \"" + deviceResponse.DeviceCode + "\"}"
+	req = newHTTPRequest("POST", "/auth/device/token", "", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, "authorization_pending", errorResponse.Error)
+
+	// User approves device
+	verifyPayload := "{\"user_code\": \"" + strings.ToLower(deviceResponse.UserCode) + "\", \"approve\": true}"
+	req = newHTTPRequest("POST", "/auth/device/verify", loginResponse.AccessToken, bytes.NewBufferString(verifyPayload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Device polls after approval
+	req = newHTTPRequest("POST", "/auth/device/token", "", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var tokenResponse LoginResponse
+	json.Unmarshal(res.Body.Bytes(), &tokenResponse)
+	checkStringNotEmpty(t, tokenResponse.AccessToken)
+	checkStringNotEmpty(t, tokenResponse.RefreshToken)
+
+	// Device code can't be used twice
+	req = newHTTPRequest("POST", "/auth/device/token", "", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
+
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	req := newHTTPRequest("POST", "/auth/device/code", "", nil)
+	res := executePublicTestRequest(req)
+	var deviceResponse DeviceAuthorizationResponse
+	json.Unmarshal(res.Body.Bytes(), &deviceResponse)
+
+	verifyPayload := "{\"user_code\": \"" + deviceResponse.UserCode + "\", \"approve\": false}"
+	req = newHTTPRequest("POST", "/auth/device/verify", loginResponse.AccessToken, bytes.NewBufferString(verifyPayload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	payload := "{\"device_code\": \"" + deviceResponse.DeviceCode + "\"}"
+	req = newHTTPRequest("POST", "/auth/device/token", "", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, "access_denied", errorResponse.Error)
+}
+
+func TestGuestTokenUpgrade(t *testing.T) {
+	clearTestDB()
+
+	req := newHTTPRequest("POST", "/auth/guest", "", nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var guestResponse LoginResponse
+	json.Unmarshal(res.Body.Bytes(), &guestResponse)
+	checkStringNotEmpty(t, guestResponse.AccessToken)
+	checkTestString(t, "", guestResponse.RefreshToken)
+
+	// Guest tokens are not accepted for the public API
+	req = newHTTPRequest("GET", "/auth/ping", guestResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	// Signing up with the guest token upgrades the guest
+	claims := &Claims{}
+	jwt.ParseWithClaims(guestResponse.AccessToken, claims, JwtKeyFunc)
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req = newHTTPRequest("POST", "/auth/signup", guestResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	checkTestString(t, claims.GuestID, user.GuestID)
+}
+
+func TestSetMetadata(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	GetUserRepository().SetAppMetadata(user, map[string]interface{}{"plan": "pro"})
+	loginResponse := loginUser("foo@bar.com", "12345678")
+
+	// Users can't modify app metadata, a field of that name is just part of their metadata
+	payload := `{"nickname": "foo", "appMetadata": {"plan": "enterprise"}}`
+	req := newHTTPRequest("PUT", "/auth/metadata", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/auth/metadata", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var metadataResponse MetadataResponse
+	json.Unmarshal(res.Body.Bytes(), &metadataResponse)
+	checkTestString(t, "foo", metadataResponse.Metadata["nickname"].(string))
+	checkTestString(t, "pro", metadataResponse.AppMetadata["plan"].(string))
+
+	// Arrays and oversized documents are rejected
+	req = newHTTPRequest("PUT", "/auth/metadata", loginResponse.AccessToken, bytes.NewBufferString(`["foo"]`))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	payload = `{"nickname": "` + strings.Repeat("a", GetConfig().MetadataMaxSize) + `"}`
+	req = newHTTPRequest("PUT", "/auth/metadata", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
+
+func TestMe(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	user.Roles = []string{"admin"}
+	GetUserRepository().Update(user)
+	loginResponse := loginUser("foo@bar.com", "12345678")
+
+	req := newHTTPRequest("GET", "/auth/me", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var me MeResponse
+	json.Unmarshal(res.Body.Bytes(), &me)
+	checkTestString(t, user.ID.String(), me.UserID)
+	checkTestString(t, "foo@bar.com", me.Email)
+	if !me.Confirmed || me.OTPEnabled || len(me.Roles) != 1 || me.Roles[0] != "admin" {
+		t.Errorf("Unexpected response %+v", me)
+	}
+	if me.ExpiresAt == nil || !me.ExpiresAt.After(time.Now()) {
+		t.Error("Expected expiry date in the future")
+	}
+
+	req = newHTTPRequest("GET", "/auth/me", "", nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestPreferences(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"locale": "de-DE", "timezone": "Europe/Berlin", "notify.newsletter": "true"}`
+	req := newHTTPRequest("PUT", "/auth/preferences", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Keys are merged, empty values remove a key
+	payload = `{"locale": "en", "notify.newsletter": ""}`
+	req = newHTTPRequest("PUT", "/auth/preferences", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/auth/preferences", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var preferences map[string]string
+	json.Unmarshal(res.Body.Bytes(), &preferences)
+	if len(preferences) != 2 {
+		t.Fatalf("Expected 2 preferences, got %d", len(preferences))
+	}
+	checkTestString(t, "en", preferences["locale"])
+	checkTestString(t, "Europe/Berlin", preferences["timezone"])
+
+	// Invalid values are rejected
+	for _, payload := range []string{`{"timezone": "Mars/Olympus"}`, `{"locale": "not a locale"}`, `{"in valid": "x"}`, `["foo"]`} {
+		req = newHTTPRequest("PUT", "/auth/preferences", loginResponse.AccessToken, bytes.NewBufferString(payload))
+		res = executePublicTestRequest(req)
+		checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestAdditionalEmail(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"email": "foo2@bar.com"}`
+	req := newHTTPRequest("POST", "/auth/emails", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestString(t, "foo2@bar.com", smtpMockContent.RcptValue)
+
+	req, _ = http.NewRequest("POST", "/auth/confirm/"+smtpMockContent.Buffer.DataValue, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req = newHTTPRequest("GET", "/auth/emails", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var emails EmailsResponse
+	json.Unmarshal(res.Body.Bytes(), &emails)
+	checkTestString(t, "foo@bar.com", emails.Email)
+	if len(emails.AdditionalEmails) != 1 || emails.AdditionalEmails[0] != "foo2@bar.com" {
+		t.Fatalf("Expected additional email foo2@bar.com, got %v", emails.AdditionalEmails)
+	}
+
+	// Additional addresses can be used to log in and to reset the password
+	loginResponse = loginUser("foo2@bar.com", "12345678")
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+	payload = `{"email": "foo2@bar.com"}`
+	req = newHTTPRequest("POST", "/auth/initpwreset", "", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestString(t, "foo2@bar.com", smtpMockContent.RcptValue)
+	req, _ = http.NewRequest("POST", "/auth/confirm/"+smtpMockContent.Buffer.DataValue, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestString(t, "foo2@bar.com", smtpMockContent.RcptValue)
+	password := smtpMockContent.Buffer.DataValue
+
+	// Signing up with an additional address isn't possible
+	payload = `{"email": "foo2@bar.com", "password": "12345678"}`
+	req, _ = http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	payload = `{"email": "foo2@bar.com", "password": "` + password + `"}`
+	req = newHTTPRequest("PUT", "/auth/emails/primary", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	user := GetUserRepository().GetByEmail("foo2@bar.com")
+	checkTestString(t, "foo2@bar.com", user.Email)
+	checkTestString(t, "foo@bar.com", user.AdditionalEmails[0])
+
+	req = newHTTPRequest("DELETE", "/auth/emails/foo@bar.com", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if GetUserRepository().GetByEmail("foo@bar.com") != nil {
+		t.Error("Expected removed email not to be found")
+	}
+}
+
+func TestAdditionalEmailLimit(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	expected := map[string]int{"foo2@bar.com": http.StatusNoContent, "foo3@bar.com": http.StatusNoContent, "foo4@bar.com": http.StatusBadRequest}
+	for _, email := range []string{"foo2@bar.com", "foo3@bar.com", "foo4@bar.com"} {
+		payload := `{"email": "` + email + `"}`
+		req := newHTTPRequest("POST", "/auth/emails", loginResponse.AccessToken, bytes.NewBufferString(payload))
+		res := executePublicTestRequest(req)
+		checkTestResponseCode(t, expected[email], res.Code)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// AuthzRule requires requests to routes matching Route to be authenticated with a token satisfying all conditions.
+// Route uses the syntax of PROXY_WHITELIST entries, i.e. "POST,PUT /articles" or "^/admin/.*$".
+type AuthzRule struct {
+	Route  string                 `json:"route"`
+	Roles  []string               `json:"roles,omitempty"`
+	Scopes []string               `json:"scopes,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	rule   *ProxyRule
+}
+
+// LoadAuthzRules reads a JSON file containing a list of authorization rules
+func LoadAuthzRules(file string) ([]*AuthzRule, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*AuthzRule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, errors.New("Invalid authorization rules file: " + err.Error())
+	}
+	for _, rule := range rules {
+		if rule.rule, err = ParseProxyRule(rule.Route); err != nil {
+			return nil, err
+		}
+		for name, value := range rule.Claims {
+			if !_IsValidClaimPredicate(value) {
+				return nil, errors.New("Authorization rule claim " + name + " must be a string, number, boolean or list of these")
+			}
+		}
+	}
+	return rules, nil
+}
+
+// Matches checks if the rule applies to the request's method and escaped path
+func (rule *AuthzRule) Matches(method, path string) bool {
+	return rule.rule.Matches(method, path)
+}
+
+// Authorize checks if the claims satisfy the rule: any of the roles, all of the scopes and all claim predicates.
+// Claim predicates compare the value of a claim, nested claims separated by dots, to a value or a list of allowed values.
+func (rule *AuthzRule) Authorize(claims *Claims) bool {
+	if len(rule.Roles) > 0 && !_ContainsAny(claims.Roles, rule.Roles) {
+		return false
+	}
+	for _, scope := range rule.Scopes {
+		if !_ContainsAny(claims.Scopes, []string{scope}) {
+			return false
+		}
+	}
+	if len(rule.Claims) == 0 {
+		return true
+	}
+	values := _ClaimValues(claims)
+	for name, expected := range rule.Claims {
+		if !_MatchesClaimPredicate(_LookupClaim(values, name), expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// AuthzRulesMiddleware rejects proxied requests not satisfying the authorization rules matching their route
+func AuthzRulesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+		if r.Method == "OPTIONS" || strings.HasPrefix(path, GetConfig().PublicAPIPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, rule := range GetRouteRules().AuthzRules {
+			if !rule.Matches(r.Method, path) {
+				continue
+			}
+			claims := GetClaimsFromContext(r)
+			if (claims == nil || claims.Guest) && IsRulesDryRun(r) {
+				LogDryRunRejection(r, http.StatusUnauthorized, "authorization rule "+rule.Route+" requires an authenticated user")
+				continue
+			}
+			if claims == nil || claims.Guest {
+				AddBearerChallenge(w, "", "")
+				SendUnauthorized(w)
+				return
+			}
+			if rule.Authorize(claims) {
+				continue
+			}
+			if IsRulesDryRun(r) {
+				LogDryRunRejection(r, http.StatusForbidden, "UserID "+claims.UserID+" doesn't satisfy authorization rule "+rule.Route)
+				continue
+			}
+			log.Println("Rejecting request", r.Method, path, "of UserID", claims.UserID, "not satisfying authorization rule", rule.Route)
+			AddBearerChallenge(w, BearerErrorInsufficientScope, "Insufficient permissions")
+			SendError(w, http.StatusForbidden, ErrorCodeInsufficientPermissions)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func _ContainsAny(values []string, candidates []string) bool {
+	for _, value := range values {
+		for _, candidate := range candidates {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// _ClaimValues returns the claims as they are encoded in the token, allowing to look them up by their JSON names
+func _ClaimValues(claims *Claims) map[string]interface{} {
+	var values map[string]interface{}
+	data, _ := json.Marshal(claims)
+	json.Unmarshal(data, &values)
+	return values
+}
+
+func _LookupClaim(values map[string]interface{}, name string) interface{} {
+	var current interface{} = values
+	for _, part := range strings.Split(name, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+func _IsValidClaimPredicate(expected interface{}) bool {
+	switch v := expected.(type) {
+	case string, float64, bool:
+		return true
+	case []interface{}:
+		for _, item := range v {
+			if !_IsValidClaimPredicate(item) {
+				return false
+			}
+			if _, ok := item.([]interface{}); ok {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// _MatchesClaimPredicate checks if the actual claim equals the expected value or one of the expected values.
+// If the claim is a list itself, one of its items must match.
+func _MatchesClaimPredicate(actual interface{}, expected interface{}) bool {
+	if list, ok := actual.([]interface{}); ok {
+		for _, item := range list {
+			if _MatchesClaimPredicate(item, expected) {
+				return true
+			}
+		}
+		return false
+	}
+	if actual == nil {
+		return false
+	}
+	if list, ok := expected.([]interface{}); ok {
+		for _, item := range list {
+			if fmt.Sprint(actual) == fmt.Sprint(item) {
+				return true
+			}
+		}
+		return false
+	}
+	return fmt.Sprint(actual) == fmt.Sprint(expected)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testAuthzRules = `[
+	{"route": "/admin", "roles": ["admin"]},
+	{"route": "^/reports/[0-9]+$", "claims": {"organizationRole": "admin", "appMetadata.plan": ["pro", "enterprise"]}},
+	{"route": "GET /export", "scopes": ["export"]}
+]`
+
+func writeTestAuthzRules(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "authz.json")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadAuthzRules(t *testing.T) {
+	rules, err := LoadAuthzRules(writeTestAuthzRules(t, testAuthzRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %d", len(rules))
+	}
+	if !rules[1].Matches("GET", "/reports/42") || rules[1].Matches("GET", "/reports/abc") {
+		t.Error("Expected rule route to be matched as regular expression")
+	}
+	if _, err := LoadAuthzRules(writeTestAuthzRules(t, `[{"route": "/a", "claims": {"x": {"y": 1}}}]`)); err == nil {
+		t.Error("Expected error for invalid claim predicate")
+	}
+}
+
+func TestAuthzRuleAuthorize(t *testing.T) {
+	rules, _ := LoadAuthzRules(writeTestAuthzRules(t, testAuthzRules))
+	if rules[0].Authorize(&Claims{Roles: []string{"user"}}) || !rules[0].Authorize(&Claims{Roles: []string{"user", "admin"}}) {
+		t.Error("Expected role check")
+	}
+	claims := &Claims{OrganizationRole: "admin", AppMetadata: map[string]interface{}{"plan": "pro"}}
+	if !rules[1].Authorize(claims) {
+		t.Error("Expected claims to satisfy rule")
+	}
+	claims.AppMetadata["plan"] = "free"
+	if rules[1].Authorize(claims) {
+		t.Error("Expected claims not to satisfy rule")
+	}
+	if rules[2].Authorize(&Claims{}) || !rules[2].Authorize(&Claims{Scopes: []string{"read", "export"}}) {
+		t.Error("Expected scope check")
+	}
+}
+
+func TestProxyAuthzRules(t *testing.T) {
+	os.Setenv("PROXY_AUTHZ_RULES_FILE", writeTestAuthzRules(t, testAuthzRules))
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_AUTHZ_RULES_FILE")
+		GetConfig().ReadConfig()
+	}()
+
+	clearTestDB()
+	res := executePublicTestRequest(newHTTPRequest("GET", "/admin/users", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	loginResponse := createLoginTestUser()
+	res = executePublicTestRequest(newHTTPRequest("GET", "/admin/users", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	admin := &User{
+		Email:          "admin@bar.com",
+		CreateDate:     time.Now(),
+		HashedPassword: GetUserRepository().GetHashedPassword("12345678"),
+		Confirmed:      true,
+		Enabled:        true,
+		Roles:          []string{"admin"},
+	}
+	GetUserRepository().Create(admin)
+	adminLogin := loginUser("admin@bar.com", "12345678")
+	res = executePublicTestRequest(newHTTPRequest("GET", "/admin/users", adminLogin.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+
+	res = executePublicTestRequest(newHTTPRequest("GET", "/other", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	BackendPermissionAdmin      = "admin"
+	BackendPermissionUserRead   = "user-read"
+	BackendPermissionUserWrite  = "user-write"
+	BackendPermissionTokenAdmin = "token-admin"
+)
+
+const ErrorCodeInsufficientPermissions = "insufficient_permissions"
+
+var backendTokenAdminPathRegexp = regexp.MustCompile(`^/users/[^/]+/(apikeys|certs)(/|$)`)
+
+// GetBackendClientName returns the common name of the client certificate used to call the backend API
+func GetBackendClientName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return r.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+// GetBackendClientPermissions returns the permissions granted to the client calling the backend API
+func GetBackendClientPermissions(r *http.Request) []string {
+	if permissions, ok := GetConfig().BackendClientPermissions[GetBackendClientName(r)]; ok {
+		return permissions
+	}
+	return GetConfig().BackendDefaultPermissions
+}
+
+// RequiredBackendPermission determines the permission needed for a backend API request.
+// Managing a user's API keys and client certificates requires token-admin, other user
+// requests user-read or user-write, and all remaining endpoints full admin permission.
+func RequiredBackendPermission(r *http.Request) string {
+	path := r.URL.Path
+	if backendTokenAdminPathRegexp.MatchString(path) {
+		return BackendPermissionTokenAdmin
+	}
+	if strings.HasPrefix(path, "/users/") {
+		if r.Method == "GET" || r.Method == "HEAD" {
+			return BackendPermissionUserRead
+		}
+		return BackendPermissionUserWrite
+	}
+	return BackendPermissionAdmin
+}
+
+// HasBackendPermission checks if the granted permissions include the required one, admin includes all others
+func HasBackendPermission(granted []string, required string) bool {
+	for _, permission := range granted {
+		if permission == BackendPermissionAdmin || permission == required {
+			return true
+		}
+	}
+	return false
+}
+
+// BackendPermissionMiddleware rejects backend API requests the calling client has no permission for
+func BackendPermissionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := RequiredBackendPermission(r)
+		if !HasBackendPermission(GetBackendClientPermissions(r), required) {
+			log.Println("Rejecting backend request", r.Method, r.URL.Path, "of client", GetBackendClientName(r), "lacking permission", required)
+			SendError(w, http.StatusForbidden, ErrorCodeInsufficientPermissions)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
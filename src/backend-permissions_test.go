@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newBackendClientTestRequest(method, url, clientName string) *http.Request {
+	req, _ := http.NewRequest(method, url, nil)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: clientName}}},
+	}
+	return req
+}
+
+func TestBackendPermissions(t *testing.T) {
+	os.Setenv("BACKEND_CLIENT_PERMISSIONS", "support=user-read,token-admin writer=user-write")
+	os.Setenv("BACKEND_DEFAULT_PERMISSIONS", "user-read")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("BACKEND_CLIENT_PERMISSIONS")
+		os.Unsetenv("BACKEND_DEFAULT_PERMISSIONS")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	user := createTestUser(true)
+
+	res := executeBackendTestRequest(newBackendClientTestRequest("GET", "/users/"+user.ID.String(), "support"))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	res = executeBackendTestRequest(newBackendClientTestRequest("PUT", "/users/"+user.ID.String()+"/disable", "support"))
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	res = executeBackendTestRequest(newBackendClientTestRequest("GET", "/stats/", "support"))
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	res = executeBackendTestRequest(newBackendClientTestRequest("PUT", "/users/"+user.ID.String()+"/disable", "writer"))
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Unknown clients get the default permissions
+	res = executeBackendTestRequest(newBackendClientTestRequest("GET", "/users/"+user.ID.String(), "other"))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	res = executeBackendTestRequest(newBackendClientTestRequest("PUT", "/users/"+user.ID.String()+"/enable", "other"))
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestRequiredBackendPermission(t *testing.T) {
+	tests := map[string]string{
+		"GET /users/":               BackendPermissionUserRead,
+		"GET /users/123":            BackendPermissionUserRead,
+		"PUT /users/123/password":   BackendPermissionUserWrite,
+		"POST /users/":              BackendPermissionUserWrite,
+		"GET /users/123/apikeys":    BackendPermissionTokenAdmin,
+		"DELETE /users/123/certs/1": BackendPermissionTokenAdmin,
+		"GET /organizations/":       BackendPermissionAdmin,
+		"GET /audit/":               BackendPermissionAdmin,
+	}
+	for request, expected := range tests {
+		parts := strings.SplitN(request, " ", 2)
+		req, _ := http.NewRequest(parts[0], parts[1], nil)
+		checkTestString(t, expected, RequiredBackendPermission(req))
+	}
+	if !HasBackendPermission([]string{BackendPermissionAdmin}, BackendPermissionTokenAdmin) {
+		t.Error("Expected admin to include all permissions")
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backupFormatVersion is incremented on incompatible changes of the archive format
+const backupFormatVersion = 2
+
+// backupCollections are the MongoDB collections saved in backups
+var backupCollections = []string{
+	"users",
+	"refresh_tokens",
+	"pending_actions",
+	"trusted_devices",
+	"invitations",
+	"api_keys",
+	"device_codes",
+	"client_certificates",
+	"linked_identities",
+	"organizations",
+	"audit_events",
+	"login_stats",
+	"mail_queue",
+	"schema_version",
+}
+
+// BackupHeader is the first document of a backup archive
+type BackupHeader struct {
+	FormatVersion int             `bson:"formatVersion"`
+	CreateDate    time.Time       `bson:"createDate"`
+	Tenants       []*BackupTenant `bson:"tenants"`
+}
+
+// BackupTenant is a tenant saved in a backup, with an empty ID if TENANTS_FILE isn't set
+type BackupTenant struct {
+	ID            string `bson:"id"`
+	SchemaVersion int    `bson:"schemaVersion"`
+}
+
+// BackupDocument is a document of a collection in a backup archive
+type BackupDocument struct {
+	Tenant     string   `bson:"t,omitempty"`
+	Collection string   `bson:"c"`
+	Document   bson.Raw `bson:"d"`
+}
+
+// TenantDatabase is the database of a tenant, with an empty ID if TENANTS_FILE isn't set
+type TenantDatabase struct {
+	ID string
+	DB *Database
+}
+
+// EncodeBackup creates an archive of the documents, compressed and encrypted with BACKUP_ENCRYPT_KEY
+func EncodeBackup(key string, header *BackupHeader, docs []*BackupDocument) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	data, err := bson.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return EncryptBytes(key, buf.Bytes())
+}
+
+// DecodeBackup reads an archive created by EncodeBackup
+func DecodeBackup(key string, archive []byte) (*BackupHeader, []*BackupDocument, error) {
+	data, err := DecryptBytes(key, archive)
+	if err != nil {
+		return nil, nil, errors.New("Could not decrypt backup, wrong BACKUP_ENCRYPT_KEY or corrupted archive")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	var header BackupHeader
+	raw, err := _ReadBSONDocument(zr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := bson.Unmarshal(raw, &header); err != nil {
+		return nil, nil, err
+	}
+	if header.FormatVersion != backupFormatVersion {
+		return nil, nil, errors.New("Unsupported backup format version " + strconv.Itoa(header.FormatVersion))
+	}
+	docs := make([]*BackupDocument, 0)
+	for {
+		raw, err := _ReadBSONDocument(zr)
+		if err == io.EOF {
+			return &header, docs, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var doc BackupDocument
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return nil, nil, err
+		}
+		docs = append(docs, &doc)
+	}
+}
+
+// _ReadBSONDocument reads a single document, which starts with its length as 32-bit little-endian integer
+func _ReadBSONDocument(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint32(length[:]))
+	if size < 5 {
+		return nil, errors.New("Invalid document in backup")
+	}
+	doc := make([]byte, size)
+	copy(doc, length[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		return nil, errors.New("Truncated backup")
+	}
+	return doc, nil
+}
+
+// tenantBackupUnsupportedSettings select another database server or storage, which db isn't connected to
+var tenantBackupUnsupportedSettings = []string{
+	"STORAGE",
+	"MONGO_DB_URL",
+}
+
+// GetTenantDatabases returns the database of each tenant configured in TENANTS_FILE, or db if it isn't set.
+// Tenants overriding tenantBackupUnsupportedSettings are refused.
+func GetTenantDatabases(db *Database) ([]*TenantDatabase, error) {
+	if GetConfig().TenantsFile == "" {
+		return []*TenantDatabase{{DB: db}}, nil
+	}
+	tenants, err := LoadTenants(GetConfig().TenantsFile)
+	if err != nil {
+		return nil, err
+	}
+	dbs := make([]*TenantDatabase, 0, len(tenants))
+	for _, tenant := range tenants {
+		for _, name := range tenantBackupUnsupportedSettings {
+			if _, ok := tenant.Config[name]; ok {
+				return nil, errors.New("Tenant " + tenant.ID + " overrides " + name + ", which is not supported by backup and restore")
+			}
+		}
+		dbs = append(dbs, &TenantDatabase{ID: tenant.ID, DB: db.WithDatabase(tenant.GetDatabaseName(), tenant.CollectionPrefix)})
+	}
+	return dbs, nil
+}
+
+// BackupMongoDB reads all documents of the backupCollections of each tenant
+func BackupMongoDB(ctx context.Context, dbs []*TenantDatabase) (*BackupHeader, []*BackupDocument, error) {
+	header := &BackupHeader{
+		FormatVersion: backupFormatVersion,
+		CreateDate:    time.Now(),
+	}
+	docs := make([]*BackupDocument, 0)
+	for _, tdb := range dbs {
+		schemaVersion, err := GetSchemaVersion(ctx, tdb.DB)
+		if err != nil {
+			return nil, nil, err
+		}
+		header.Tenants = append(header.Tenants, &BackupTenant{ID: tdb.ID, SchemaVersion: schemaVersion})
+		for _, collection := range backupCollections {
+			cur, err := tdb.DB.Collection(collection).Find(ctx, bson.M{})
+			if err != nil {
+				return nil, nil, err
+			}
+			for cur.Next(ctx) {
+				docs = append(docs, &BackupDocument{Tenant: tdb.ID, Collection: collection, Document: append(bson.Raw(nil), cur.Current...)})
+			}
+			err = cur.Err()
+			cur.Close(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return header, docs, nil
+}
+
+// RestoreMongoDB inserts the documents of a backup into the databases of the tenants saved in it, which must
+// all be configured. The schemas of the backup must not be newer than the one supported, older ones are migrated
+// on the next start. The collections must be empty unless force is set, in which case they are replaced.
+//
+// Documents are inserted into staging collections first, which are removed again if that fails, leaving
+// the database untouched. Only then the staging collections replace the collections one by one.
+func RestoreMongoDB(ctx context.Context, dbs []*TenantDatabase, header *BackupHeader, docs []*BackupDocument, force bool) error {
+	targets := make(map[string]*Database)
+	for _, tenant := range header.Tenants {
+		if tenant.SchemaVersion > len(schemaMigrations) {
+			return errors.New("Backup schema version " + strconv.Itoa(tenant.SchemaVersion) + " is newer than the supported version " + strconv.Itoa(len(schemaMigrations)))
+		}
+		for _, tdb := range dbs {
+			if tdb.ID == tenant.ID {
+				targets[tenant.ID] = tdb.DB
+			}
+		}
+		if targets[tenant.ID] == nil {
+			return errors.New("Tenant " + strconv.Quote(tenant.ID) + " of backup is not configured")
+		}
+	}
+	if !force {
+		for _, db := range targets {
+			for _, collection := range backupCollections {
+				count, err := db.Collection(collection).CountDocuments(ctx, bson.M{})
+				if err != nil {
+					return err
+				}
+				if count > 0 {
+					return errors.New("Collection " + db.CollectionPrefix + collection + " of database " + db.Database.Name() + " must be empty to restore a backup, use -force to replace it")
+				}
+			}
+		}
+	}
+	if err := _DropRestoreStagingCollections(ctx, targets); err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		db := targets[doc.Tenant]
+		if db == nil {
+			return errors.New("Document of collection " + doc.Collection + " belongs to tenant " + strconv.Quote(doc.Tenant) + " not listed in the backup")
+		}
+		if _, err := _GetRestoreStagingCollection(db, doc.Collection).InsertOne(ctx, doc.Document); err != nil {
+			_DropRestoreStagingCollections(ctx, targets)
+			return errors.New("Could not restore document of collection " + doc.Collection + ": " + err.Error())
+		}
+	}
+	for _, db := range targets {
+		for _, collection := range backupCollections {
+			if err := _SwapRestoreStagingCollection(ctx, db, collection); err != nil {
+				return errors.New("Could not replace collection " + db.CollectionPrefix + collection + " of database " + db.Database.Name() + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+func _GetRestoreStagingCollection(db *Database, collection string) *mongo.Collection {
+	return db.Database.Collection("restore_" + db.CollectionPrefix + collection)
+}
+
+// _DropRestoreStagingCollections removes the staging collections, i.e. those left over by a crashed restore
+func _DropRestoreStagingCollections(ctx context.Context, targets map[string]*Database) error {
+	for _, db := range targets {
+		for _, collection := range backupCollections {
+			if err := _GetRestoreStagingCollection(db, collection).Drop(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// _SwapRestoreStagingCollection renames the staging collection to the collection, replacing it. If the backup
+// contains no documents of the collection, there's no staging collection and the collection is dropped.
+func _SwapRestoreStagingCollection(ctx context.Context, db *Database, collection string) error {
+	names, err := db.Database.ListCollectionNames(ctx, bson.M{"name": _GetRestoreStagingCollection(db, collection).Name()})
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return db.Collection(collection).Drop(ctx)
+	}
+	name := db.Database.Name()
+	return db.Client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: name + "." + _GetRestoreStagingCollection(db, collection).Name()},
+		{Key: "to", Value: name + "." + db.Collection(collection).Name()},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
+
+func _GetBackupKey() string {
+	if GetConfig().Storage != StorageMongoDB {
+		log.Fatal("Backups require STORAGE=" + StorageMongoDB)
+	}
+	key := GetConfig().BackupEncryptionKey
+	if key == "" {
+		log.Fatal("BACKUP_ENCRYPT_KEY is required for backups")
+	}
+	return key
+}
+
+// RunBackup handles the backup command, writing an encrypted archive of the MongoDB databases of all tenants to -out
+func RunBackup(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := flags.String("out", "", "file to write the backup to")
+	flags.Parse(args)
+	if *out == "" {
+		log.Fatal("-out is required")
+	}
+	key := _GetBackupKey()
+	GetDatatabase().connectMongoDb(GetConfig().MongoDbURL, GetConfig().MongoDbName)
+	defer GetDatatabase().disconnect()
+	dbs, err := GetTenantDatabases(GetDatatabase())
+	if err != nil {
+		log.Fatal(err)
+	}
+	header, docs, err := BackupMongoDB(context.Background(), dbs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	archive, err := EncodeBackup(key, header, docs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, archive, 0600); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Saved", len(docs), "documents of", len(header.Tenants), "tenant(s) to", *out)
+}
+
+// RunRestore handles the restore command, inserting the documents of the archive -in into the MongoDB databases
+// of the tenants, which must be empty unless -force is set
+func RunRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := flags.String("in", "", "file to read the backup from")
+	force := flags.Bool("force", false, "replace the existing documents")
+	flags.Parse(args)
+	if *in == "" {
+		log.Fatal("-in is required")
+	}
+	key := _GetBackupKey()
+	archive, err := ioutil.ReadFile(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+	header, docs, err := DecodeBackup(key, archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+	GetDatatabase().connectMongoDb(GetConfig().MongoDbURL, GetConfig().MongoDbName)
+	defer GetDatatabase().disconnect()
+	dbs, err := GetTenantDatabases(GetDatatabase())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := RestoreMongoDB(context.Background(), dbs, header, docs, *force); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Restored", len(docs), "documents of backup from", header.CreateDate.Format(time.RFC3339))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestEncodeDecodeBackup(t *testing.T) {
+	key := "ahthee7Iengi0ahsaiF4eeNg4Chie2ai"
+	user, _ := bson.Marshal(bson.M{"_id": "u1", "email": "foo@bar.com"})
+	header := &BackupHeader{FormatVersion: backupFormatVersion, CreateDate: time.Now(), Tenants: []*BackupTenant{{ID: "acme", SchemaVersion: 2}}}
+	docs := []*BackupDocument{{Tenant: "acme", Collection: "users", Document: user}}
+
+	archive, err := EncodeBackup(key, header, docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodedHeader, decodedDocs, err := DecodeBackup(key, archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decodedHeader.Tenants) != 1 || decodedHeader.Tenants[0].SchemaVersion != 2 || len(decodedDocs) != 1 {
+		t.Fatalf("Expected 1 tenant with schema version 2 and 1 document, got %d tenants and %d documents", len(decodedHeader.Tenants), len(decodedDocs))
+	}
+	checkTestString(t, "acme", decodedHeader.Tenants[0].ID)
+	checkTestString(t, "acme", decodedDocs[0].Tenant)
+	checkTestString(t, "users", decodedDocs[0].Collection)
+	checkTestString(t, "foo@bar.com", decodedDocs[0].Document.Lookup("email").StringValue())
+
+	if _, _, err := DecodeBackup("Kee5eiQuah1aeb6Sheeghoo3eiGh5ahX", archive); err == nil {
+		t.Error("Expected error for wrong key")
+	}
+	if _, _, err := DecodeBackup(key, archive[:len(archive)-1]); err == nil {
+		t.Error("Expected error for truncated archive")
+	}
+}
+
+func TestBackupCollections(t *testing.T) {
+	for collection := range GetMongoIndexes() {
+		found := false
+		for _, c := range backupCollections {
+			found = found || c == collection
+		}
+		if !found {
+			t.Errorf("Expected collection %s to be included in backups", collection)
+		}
+	}
+}
+
+func TestGetTenantDatabases(t *testing.T) {
+	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://127.0.0.1:27017"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &Database{Client: client, Database: client.Database("auth"), CollectionPrefix: "p_"}
+	dbs, err := GetTenantDatabases(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 1 || dbs[0].ID != "" || dbs[0].DB != db {
+		t.Fatal("Expected only the configured database without TENANTS_FILE")
+	}
+
+	GetConfig().TenantsFile = writeTestTenants(t, testTenants)
+	defer func() { GetConfig().TenantsFile = "" }()
+	dbs, err = GetTenantDatabases(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 2 {
+		t.Fatalf("Expected 2 tenant databases, got %d", len(dbs))
+	}
+	checkTestString(t, "acme", dbs[0].ID)
+	checkTestString(t, "acme.users", dbs[0].DB.Database.Name()+"."+dbs[0].DB.Collection("users").Name())
+	checkTestString(t, "globex", dbs[1].ID)
+	checkTestString(t, GetConfig().MongoDbName+".globex_users", dbs[1].DB.Database.Name()+"."+dbs[1].DB.Collection("users").Name())
+
+	for _, name := range []string{"STORAGE", "MONGO_DB_URL"} {
+		GetConfig().TenantsFile = writeTestTenants(t, `[{"id": "acme", "hosts": ["acme.example.com"], "database": "acme", "config": {"BACKEND_LISTEN_ADDR": "127.0.0.1:9001", "`+name+`": "x"}}]`)
+		if _, err := GetTenantDatabases(db); err == nil {
+			t.Errorf("Expected tenant overriding %s to be refused", name)
+		}
+	}
+}
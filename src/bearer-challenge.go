@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	BearerErrorInvalidToken      = "invalid_token"
+	BearerErrorInsufficientScope = "insufficient_scope"
+)
+
+// ErrMissingCredentials is returned by ExtractClaimsFromRequest if the request carries no credentials at all
+var ErrMissingCredentials = errors.New("JWT header verification failed: missing auth header")
+
+// AddBearerChallenge adds a WWW-Authenticate header for the Bearer scheme (RFC 6750, section 3).
+// The error code is omitted for requests lacking credentials, so clients can tell them apart from invalid or expired tokens.
+func AddBearerChallenge(w http.ResponseWriter, code, description string) {
+	challenge := "Bearer realm=" + _QuoteChallengeValue(GetConfig().BasicAuthRealm)
+	if code != "" {
+		challenge += ", error=" + _QuoteChallengeValue(code)
+	}
+	if description != "" {
+		challenge += ", error_description=" + _QuoteChallengeValue(description)
+	}
+	w.Header().Add("WWW-Authenticate", challenge)
+}
+
+// AddBearerChallengeForError adds the Bearer challenge describing why ExtractClaimsFromRequest failed
+func AddBearerChallengeForError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrMissingCredentials) {
+		AddBearerChallenge(w, "", "")
+		return
+	}
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		AddBearerChallenge(w, BearerErrorInvalidToken, "The access token expired")
+		return
+	}
+	AddBearerChallenge(w, BearerErrorInvalidToken, "The access token is invalid")
+}
+
+func _QuoteChallengeValue(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestProxyBearerChallenge(t *testing.T) {
+	setTestBlacklist(t)
+	clearTestDB()
+	res := executePublicTestRequest(newHTTPRequest("GET", "/blacklist/page", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	checkTestString(t, `Bearer realm="JWT Auth Proxy"`, res.Header().Get("WWW-Authenticate"))
+
+	res = executePublicTestRequest(newHTTPRequest("GET", "/blacklist/page", "invalid", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	checkTestString(t, `Bearer realm="JWT Auth Proxy", error="invalid_token", error_description="The access token is invalid"`, res.Header().Get("WWW-Authenticate"))
+
+	claims := &Claims{UserID: "user"}
+	claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(GetConfig().JwtSigningKey))
+	res = executePublicTestRequest(newHTTPRequest("GET", "/blacklist/page", expired, nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	checkTestString(t, `Bearer realm="JWT Auth Proxy", error="invalid_token", error_description="The access token expired"`, res.Header().Get("WWW-Authenticate"))
+}
+
+func TestAddBearerChallenge(t *testing.T) {
+	rr := httptest.NewRecorder()
+	AddBearerChallenge(rr, BearerErrorInsufficientScope, `Role "admin" required`)
+	checkTestString(t, `Bearer realm="JWT Auth Proxy", error="insufficient_scope", error_description="Role \"admin\" required"`, rr.Header().Get("WWW-Authenticate"))
+}
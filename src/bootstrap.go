@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// BootstrapAdminUser creates the initial admin account configured by ADMIN_EMAIL unless it exists already
+func BootstrapAdminUser() {
+	email := NormalizeEmail(GetConfig().AdminEmail)
+	if email == "" {
+		return
+	}
+	if GetUserRepository().GetByEmail(email) != nil {
+		return
+	}
+	password := GetConfig().AdminPassword
+	oneTimePassword := password == ""
+	if oneTimePassword {
+		password = GeneratePolicyPassword(email)
+	}
+	user := &User{
+		Email:                  email,
+		HashedPassword:         GetUserRepository().GetHashedPassword(password),
+		Confirmed:              true,
+		Enabled:                true,
+		CreateDate:             time.Now(),
+		PasswordChangeDate:     time.Now(),
+		PasswordChangeRequired: oneTimePassword,
+		Roles:                  []string{GetConfig().AdminRole},
+	}
+	GetUserRepository().Create(user)
+	if oneTimePassword {
+		log.Println("Created admin account", email, "with one-time password", password, "- change it on first login")
+	} else {
+		log.Println("Created admin account", email)
+	}
+}
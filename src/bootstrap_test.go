@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBootstrapAdminUser(t *testing.T) {
+	clearTestDB()
+	os.Setenv("ADMIN_EMAIL", "Admin@bar.com")
+	os.Setenv("ADMIN_PASSWORD", "12345678")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("ADMIN_EMAIL")
+		os.Unsetenv("ADMIN_PASSWORD")
+		GetConfig().ReadConfig()
+	}()
+
+	BootstrapAdminUser()
+	user := GetUserRepository().GetByEmail("admin@bar.com")
+	if user == nil {
+		t.Fatal("Expected admin user to be created")
+	}
+	if !user.Confirmed || user.PasswordChangeRequired || len(user.Roles) != 1 || user.Roles[0] != "admin" {
+		t.Errorf("Unexpected admin user %+v", user)
+	}
+	loginResponse := loginUser("admin@bar.com", "12345678")
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+
+	// Existing accounts are left untouched
+	os.Setenv("ADMIN_PASSWORD", "87654321")
+	GetConfig().ReadConfig()
+	BootstrapAdminUser()
+	user = GetUserRepository().GetByEmail("admin@bar.com")
+	if !GetUserRepository().CheckPassword(user.HashedPassword, "12345678") {
+		t.Error("Expected password of existing admin not to change")
+	}
+}
+
+func TestBootstrapAdminUserOneTimePassword(t *testing.T) {
+	clearTestDB()
+	os.Setenv("ADMIN_EMAIL", "admin@bar.com")
+	os.Setenv("ADMIN_ROLE", "superuser")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("ADMIN_EMAIL")
+		os.Unsetenv("ADMIN_ROLE")
+		GetConfig().ReadConfig()
+	}()
+
+	BootstrapAdminUser()
+	user := GetUserRepository().GetByEmail("admin@bar.com")
+	if user == nil {
+		t.Fatal("Expected admin user to be created")
+	}
+	if !user.PasswordChangeRequired || len(user.Roles) != 1 || user.Roles[0] != "superuser" {
+		t.Errorf("Unexpected admin user %+v", user)
+	}
+}
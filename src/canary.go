@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"strings"
+)
+
+// CanaryClaim routes users whose claim Name, nested claims separated by dots, equals Value to the canary targets
+type CanaryClaim struct {
+	Name  string
+	Value string
+}
+
+// ParseCanaryClaim parses a claim condition in the format "<claim>=<value>"
+func ParseCanaryClaim(s string) (*CanaryClaim, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, errors.New("PROXY_CANARY_CLAIM must have the format <claim>=<value>: " + s)
+	}
+	return &CanaryClaim{Name: parts[0], Value: parts[1]}, nil
+}
+
+// IsCanaryRequest checks if the request should be proxied to the canary targets: if the user has one of the canary roles,
+// satisfies the canary claim or falls into the canary percentage. The percentage is applied by hashing the UserID,
+// or the client IP address for unauthenticated requests, so that clients consistently see the same version.
+func IsCanaryRequest(r *http.Request) bool {
+	if GetApp().CanaryUpstreams == nil {
+		return false
+	}
+	key := GetClientIP(r)
+	if claims := GetClaimsFromContext(r); claims != nil {
+		if _ContainsAny(claims.Roles, GetConfig().ProxyCanaryRoles) {
+			return true
+		}
+		if claim := GetConfig().ProxyCanaryClaim; claim != nil && _MatchesClaimPredicate(_LookupClaim(_ClaimValues(claims), claim.Name), claim.Value) {
+			return true
+		}
+		if claims.Guest {
+			key = claims.GuestID
+		} else {
+			key = claims.UserID
+		}
+	}
+	if GetConfig().ProxyCanaryPercent == 0 {
+		return false
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32()%100) < GetConfig().ProxyCanaryPercent
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestParseCanaryClaim(t *testing.T) {
+	claim, err := ParseCanaryClaim("appMetadata.beta=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "appMetadata.beta", claim.Name)
+	checkTestString(t, "true", claim.Value)
+	if _, err := ParseCanaryClaim("beta"); err == nil {
+		t.Error("Expected error for missing value")
+	}
+}
+
+func TestProxyCanary(t *testing.T) {
+	os.Setenv("PROXY_CANARY_ROLES", "beta")
+	GetConfig().ReadConfig()
+	target, _ := url.Parse("http://127.0.0.1:8091")
+	GetApp().CanaryUpstreams = NewUpstreamPool([]*url.URL{target}, nil, LoadBalancingRoundRobin)
+	defer func() {
+		os.Unsetenv("PROXY_CANARY_ROLES")
+		GetConfig().ReadConfig()
+		GetApp().CanaryUpstreams = nil
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+	canaryHandler := &dummyProxyHandler{}
+	var canary *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8091",
+		Handler: canaryHandler,
+	}
+	go func() {
+		canary.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	res := executePublicTestRequest(newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	if handler.Headers == nil || canaryHandler.Headers != nil {
+		t.Error("Expected request without canary role to be proxied to the default target")
+	}
+
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	user.Roles = []string{"beta"}
+	GetUserRepository().Update(user)
+	loginResponse = loginUser("foo@bar.com", "12345678")
+	res = executePublicTestRequest(newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil))
+
+	proxy.Shutdown(context.TODO())
+	canary.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	if canaryHandler.Headers == nil {
+		t.Error("Expected request with canary role to be proxied to the canary target")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CaptchaProvider verifies CAPTCHA response tokens server-side
+type CaptchaProvider interface {
+	Verify(token, remoteIP string) (bool, error)
+}
+
+// SiteVerifyCaptchaProvider implements the siteverify API shared by reCAPTCHA, hCaptcha and Turnstile
+type SiteVerifyCaptchaProvider struct {
+	URL    string
+	Secret string
+}
+
+var captchaVerifyURLs = map[string]string{
+	"recaptcha": "https://www.google.com/recaptcha/api/siteverify",
+	"hcaptcha":  "https://api.hcaptcha.com/siteverify",
+	"turnstile": "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+}
+
+var (
+	captchaProvider = func() CaptchaProvider {
+		return &SiteVerifyCaptchaProvider{
+			URL:    captchaVerifyURLs[GetConfig().CaptchaProvider],
+			Secret: GetConfig().CaptchaSecret,
+		}
+	}
+)
+
+func (p *SiteVerifyCaptchaProvider) Verify(token, remoteIP string) (bool, error) {
+	client := &http.Client{Timeout: time.Second * 10}
+	form := url.Values{}
+	form.Set("secret", p.Secret)
+	form.Set("response", token)
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	res, err := client.PostForm(p.URL, form)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
+
+// IsCaptchaEnabled checks if a CAPTCHA provider is configured
+func IsCaptchaEnabled() bool {
+	return GetConfig().CaptchaProvider != ""
+}
+
+// VerifyCaptcha checks the CAPTCHA response token supplied with a request
+func VerifyCaptcha(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	ok, err := captchaProvider().Verify(token, GetClientIP(r))
+	if err != nil {
+		log.Println("Could not verify CAPTCHA:", err)
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestSiteVerifyCaptchaProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		success := r.FormValue("secret") == "secret" && r.FormValue("response") == "valid"
+		w.Write([]byte(`{"success": ` + map[bool]string{true: "true", false: "false"}[success] + `}`))
+	}))
+	defer server.Close()
+
+	provider := &SiteVerifyCaptchaProvider{URL: server.URL, Secret: "secret"}
+	if ok, err := provider.Verify("valid", "127.0.0.1"); !ok || err != nil {
+		t.Error("Expected valid CAPTCHA token to be accepted")
+	}
+	if ok, _ := provider.Verify("invalid", "127.0.0.1"); ok {
+		t.Error("Expected invalid CAPTCHA token to be rejected")
+	}
+}
+
+func TestCaptchaLoginAfterFailures(t *testing.T) {
+	os.Setenv("CAPTCHA_PROVIDER", "turnstile")
+	os.Setenv("CAPTCHA_LOGIN_FAILURES", "2")
+	GetConfig().ReadConfig()
+	oldProvider := captchaProvider
+	captchaProvider = func() CaptchaProvider {
+		return &captchaProviderMock{}
+	}
+	defer func() {
+		captchaProvider = oldProvider
+		os.Setenv("CAPTCHA_PROVIDER", "")
+		os.Setenv("CAPTCHA_LOGIN_FAILURES", "")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	createTestUser(true)
+
+	// First failure
+	payload := `{"email": "foo@bar.com", "password": "11111111"}`
+	req, _ := http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	// Second failure reaches threshold
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeCaptchaRequired, errorResponse.Error)
+
+	// Correct password without CAPTCHA is rejected
+	payload = `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	// Correct password with CAPTCHA succeeds and resets counter
+	payload = `{"email": "foo@bar.com", "password": "12345678", "captchaToken": "valid"}`
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	if GetUserRepository().GetByEmail("foo@bar.com").FailedLogins != 0 {
+		t.Error("Expected failed logins to be reset")
+	}
+}
+
+func TestCaptchaSignup(t *testing.T) {
+	os.Setenv("CAPTCHA_PROVIDER", "hcaptcha")
+	GetConfig().ReadConfig()
+	oldProvider := captchaProvider
+	captchaProvider = func() CaptchaProvider {
+		return &captchaProviderMock{}
+	}
+	defer func() {
+		captchaProvider = oldProvider
+		os.Setenv("CAPTCHA_PROVIDER", "")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+
+	payload := `{"email": "foo@bar.com", "password": "12345678", "captchaToken": "invalid"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	payload = `{"email": "foo@bar.com", "password": "12345678", "captchaToken": "valid"}`
+	req, _ = http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+}
+
+type captchaProviderMock struct {
+}
+
+func (p *captchaProviderMock) Verify(token, remoteIP string) (bool, error) {
+	return token == "valid", nil
+}
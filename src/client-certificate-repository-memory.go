@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryClientCertificateStore stores client certificates in memory, see STORAGE
+type MemoryClientCertificateStore struct {
+	certs *MemoryCollection[ClientCertificate]
+}
+
+func NewMemoryClientCertificateStore() *MemoryClientCertificateStore {
+	return &MemoryClientCertificateStore{certs: NewMemoryCollection[ClientCertificate]()}
+}
+
+func (r *MemoryClientCertificateStore) _ByID(id primitive.ObjectID) func(*ClientCertificate) bool {
+	return func(c *ClientCertificate) bool {
+		return c.ID == id
+	}
+}
+
+func (r *MemoryClientCertificateStore) Create(u *ClientCertificate) {
+	if r.GetByFingerprint(u.Fingerprint) != nil {
+		return
+	}
+	u.ID = primitive.NewObjectID()
+	r.certs.Insert(u)
+}
+
+func (r *MemoryClientCertificateStore) GetOne(id string) *ClientCertificate {
+	return r.certs.FindOne(func(c *ClientCertificate) bool {
+		return c.ID.Hex() == id
+	})
+}
+
+func (r *MemoryClientCertificateStore) GetByFingerprint(fingerprint string) *ClientCertificate {
+	return r.certs.FindOne(func(c *ClientCertificate) bool {
+		return c.Fingerprint == fingerprint
+	})
+}
+
+func (r *MemoryClientCertificateStore) GetAllForUser(userID string) []*ClientCertificate {
+	return r.certs.Find(func(c *ClientCertificate) bool {
+		return c.UserID == UserID(userID)
+	})
+}
+
+func (r *MemoryClientCertificateStore) UpdateLastUseDate(u *ClientCertificate) {
+	u.LastUseDate = time.Now()
+	r.certs.Update(r._ByID(u.ID), func(c *ClientCertificate) {
+		c.LastUseDate = u.LastUseDate
+	})
+}
+
+func (r *MemoryClientCertificateStore) Delete(u *ClientCertificate) {
+	r.certs.Delete(r._ByID(u.ID))
+}
+
+func (r *MemoryClientCertificateStore) DeleteAllForUser(userID string) {
+	r.certs.Delete(func(c *ClientCertificate) bool {
+		return c.UserID == UserID(userID)
+	})
+}
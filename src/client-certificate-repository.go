@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ClientCertificate struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      UserID             `json:"userId" bson:"userId"`
+	Name        string             `json:"name" bson:"name"`
+	Fingerprint string             `json:"fingerprint" bson:"fingerprint"`
+	CreateDate  time.Time          `json:"createDate" bson:"createDate"`
+	LastUseDate time.Time          `json:"lastUseDate" bson:"lastUseDate"`
+}
+
+// ClientCertificateStore persists client certificates, see STORAGE
+type ClientCertificateStore interface {
+	Create(u *ClientCertificate)
+	GetOne(id string) *ClientCertificate
+	GetByFingerprint(fingerprint string) *ClientCertificate
+	GetAllForUser(userID string) []*ClientCertificate
+	UpdateLastUseDate(u *ClientCertificate)
+	Delete(u *ClientCertificate)
+	DeleteAllForUser(userID string)
+}
+
+// ClientCertificateRepository manages client certificates, storing them in the ClientCertificateStore of the configured storage backend
+type ClientCertificateRepository struct {
+	ClientCertificateStore
+}
+
+var _clientCertificateRepositoryInstance *ClientCertificateRepository
+var _clientCertificateRepositoryOnce sync.Once
+
+func GetClientCertificateRepository() *ClientCertificateRepository {
+	_clientCertificateRepositoryOnce.Do(func() {
+		_clientCertificateRepositoryInstance = &ClientCertificateRepository{GetStorageDriver().NewClientCertificateStore()}
+	})
+	return _clientCertificateRepositoryInstance
+}
+
+type MongoClientCertificateStore struct {
+}
+
+func NewMongoClientCertificateStore() *MongoClientCertificateStore {
+	return &MongoClientCertificateStore{}
+}
+
+func (r *MongoClientCertificateStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("client_certificates")
+}
+
+func (r *MongoClientCertificateStore) Create(u *ClientCertificate) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+func (r *MongoClientCertificateStore) GetOne(id string) *ClientCertificate {
+	var cert ClientCertificate
+	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&cert)
+	if err != nil {
+		return nil
+	}
+	return &cert
+}
+
+// GetByFingerprint looks up a certificate by its fingerprint in any notation accepted by NormalizeFingerprint
+func (r *ClientCertificateRepository) GetByFingerprint(fingerprint string) *ClientCertificate {
+	return r.ClientCertificateStore.GetByFingerprint(r.NormalizeFingerprint(fingerprint))
+}
+
+func (r *MongoClientCertificateStore) GetByFingerprint(fingerprint string) *ClientCertificate {
+	var cert ClientCertificate
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"fingerprint": fingerprint}).Decode(&cert)
+	if err != nil {
+		return nil
+	}
+	return &cert
+}
+
+func (r *MongoClientCertificateStore) GetAllForUser(userID string) []*ClientCertificate {
+	results := make([]*ClientCertificate, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var cert ClientCertificate
+		err := cur.Decode(&cert)
+		if err != nil {
+			return results
+		}
+		results = append(results, &cert)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *MongoClientCertificateStore) UpdateLastUseDate(u *ClientCertificate) {
+	u.LastUseDate = time.Now()
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"lastUseDate": u.LastUseDate}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoClientCertificateStore) Delete(u *ClientCertificate) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoClientCertificateStore) DeleteAllForUser(userID string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// GetFingerprint returns the SHA-256 fingerprint of a certificate as lower case hex string
+func (r *ClientCertificateRepository) GetFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+// NormalizeFingerprint accepts fingerprints with colons and in upper case (i.e. as printed by openssl)
+func (r *ClientCertificateRepository) NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createTestClientCertificate(email string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(time.Now().UnixNano()),
+		Subject:        pkix.Name{CommonName: email},
+		EmailAddresses: []string{email},
+		NotBefore:      time.Now(),
+		NotAfter:       time.Now().Add(time.Hour),
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func newCertLoginRequest(cert *x509.Certificate) *http.Request {
+	req := newHTTPRequest("POST", "/auth/certlogin", "", nil)
+	req.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	return req
+}
+
+func TestClientCertificateFingerprint(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	cert := createTestClientCertificate("foo@bar.com")
+	fingerprint := GetClientCertificateRepository().GetFingerprint(cert)
+	GetClientCertificateRepository().Create(&ClientCertificate{
+		UserID:      user.ID,
+		Name:        "test",
+		Fingerprint: fingerprint,
+		CreateDate:  time.Now(),
+	})
+
+	// openssl prints fingerprints in upper case, separated by colons
+	var b strings.Builder
+	for i := 0; i < len(fingerprint); i += 2 {
+		if i > 0 {
+			b.WriteString(":")
+		}
+		b.WriteString(strings.ToUpper(fingerprint[i : i+2]))
+	}
+	if GetClientCertificateRepository().GetByFingerprint(b.String()) == nil {
+		t.Error("Expected client certificate to be found by openssl-style fingerprint")
+	}
+}
+
+func TestCertLoginFingerprint(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	cert := createTestClientCertificate("other@bar.com")
+	GetClientCertificateRepository().Create(&ClientCertificate{
+		UserID:      user.ID,
+		Name:        "test",
+		Fingerprint: GetClientCertificateRepository().GetFingerprint(cert),
+		CreateDate:  time.Now(),
+	})
+
+	res := executePublicTestRequest(newCertLoginRequest(cert))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	// Unregistered certificate
+	res = executePublicTestRequest(newCertLoginRequest(createTestClientCertificate("foo@bar.com")))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestCertLoginSAN(t *testing.T) {
+	os.Setenv("CLIENT_CERT_MAPPING", "san")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("CLIENT_CERT_MAPPING", "")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	createTestUser(true)
+
+	res := executePublicTestRequest(newCertLoginRequest(createTestClientCertificate("foo@bar.com")))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	res = executePublicTestRequest(newCertLoginRequest(createTestClientCertificate("unknown@bar.com")))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestCertLoginWithoutCertificate(t *testing.T) {
+	clearTestDB()
+	createTestUser(true)
+
+	req := newHTTPRequest("POST", "/auth/certlogin", "", nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// CompressionMiddleware compresses responses with brotli or gzip if the client accepts it
+// and the response is not compressed yet, of a configured content type and large enough
+func CompressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !GetConfig().EnableCompression || r.Method == "HEAD" || IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// NegotiateEncoding returns the preferred encoding accepted by the client, brotli taking precedence over gzip
+func NegotiateEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(entry, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		accepted[name] = true
+		for _, param := range parts[1:] {
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(param), "q="), 64); err == nil && q == 0 {
+				accepted[name] = false
+			}
+		}
+	}
+	if accepted[EncodingBrotli] {
+		return EncodingBrotli
+	}
+	if accepted[EncodingGzip] {
+		return EncodingGzip
+	}
+	return ""
+}
+
+// compressResponseWriter buffers the beginning of a response until it can decide whether to compress it
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buffer   bytes.Buffer
+	decided  bool
+	writer   io.WriteCloser
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w._Decide(false)
+		return
+	}
+	if contentLength, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil && contentLength < GetConfig().CompressionMinSize {
+		w._Decide(false)
+	}
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.writer != nil {
+			return w.writer.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buffer.Write(b)
+	if w.buffer.Len() >= GetConfig().CompressionMinSize {
+		if err := w._Decide(w._IsCompressible()); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.WriteHeader(http.StatusOK)
+		}
+		w._Decide(w._IsCompressible())
+	}
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes responses smaller than the minimum size uncompressed and finishes compressed ones
+func (w *compressResponseWriter) Close() {
+	if !w.decided {
+		if w.status == 0 && w.buffer.Len() == 0 {
+			return
+		}
+		if w.status == 0 {
+			w.WriteHeader(http.StatusOK)
+		}
+		w._Decide(false)
+	}
+	if w.writer != nil {
+		w.writer.Close()
+	}
+}
+
+func (w *compressResponseWriter) _IsCompressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, contentType := range GetConfig().CompressionContentTypes {
+		if mediaType == contentType || (strings.HasSuffix(contentType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(contentType, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// _Decide writes the response header and the buffered data, compressing from now on if requested
+func (w *compressResponseWriter) _Decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+	header.Add("Vary", "Accept-Encoding")
+	if compress {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		if w.encoding == EncodingBrotli {
+			w.writer = brotli.NewWriterLevel(w.ResponseWriter, brotli.DefaultCompression)
+		} else {
+			w.writer = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.writer != nil {
+		_, err = w.writer.Write(w.buffer.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer.Bytes())
+	}
+	w.buffer.Reset()
+	return err
+}
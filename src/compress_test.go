@@ -0,0 +1,69 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func executeCompressionTestRequest(acceptEncoding, contentType, body string) *httptest.ResponseRecorder {
+	handler := CompressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}))
+	req := newHTTPRequest("GET", "/test", "", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	checkTestString(t, "br", NegotiateEncoding("gzip, deflate, br"))
+	checkTestString(t, "gzip", NegotiateEncoding("gzip, br;q=0"))
+	checkTestString(t, "", NegotiateEncoding("deflate"))
+	checkTestString(t, "", NegotiateEncoding(""))
+}
+
+func TestCompression(t *testing.T) {
+	os.Setenv("COMPRESSION_ENABLE", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("COMPRESSION_ENABLE")
+		GetConfig().ReadConfig()
+	}()
+	body := strings.Repeat("compress me ", 200)
+
+	res := executeCompressionTestRequest("gzip", "text/html; charset=utf-8", body)
+	checkTestString(t, "gzip", res.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := io.ReadAll(reader)
+	checkTestString(t, body, string(decoded))
+
+	res = executeCompressionTestRequest("gzip, br", "application/json", body)
+	checkTestString(t, "br", res.Header().Get("Content-Encoding"))
+	decoded, _ = io.ReadAll(brotli.NewReader(res.Body))
+	checkTestString(t, body, string(decoded))
+
+	res = executeCompressionTestRequest("gzip", "text/html", "too small")
+	checkTestString(t, "", res.Header().Get("Content-Encoding"))
+	checkTestString(t, "too small", res.Body.String())
+
+	res = executeCompressionTestRequest("gzip", "image/png", body)
+	checkTestString(t, "", res.Header().Get("Content-Encoding"))
+	checkTestString(t, body, res.Body.String())
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	res := executeCompressionTestRequest("gzip", "text/html", strings.Repeat("compress me ", 200))
+	checkTestString(t, "", res.Header().Get("Content-Encoding"))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const ErrorCodeOverloaded = "overloaded"
+
+// ConcurrencyLimiter bounds the number of requests in flight. Requests exceeding the limit wait in a queue
+// of bounded size for a free slot, requests exceeding the queue or waiting too long are shed.
+type ConcurrencyLimiter struct {
+	slots     chan struct{}
+	queueSize int64
+	waiting   int64
+	timeout   time.Duration
+}
+
+func NewConcurrencyLimiter(max int, queueSize int, timeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		slots:     make(chan struct{}, max),
+		queueSize: int64(queueSize),
+		timeout:   timeout,
+	}
+}
+
+// Acquire takes a slot, waiting in the queue if none is free. It returns false if the request is to be shed,
+// otherwise the slot must be returned with Release.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt64(&l.waiting, 1) > l.queueSize {
+		atomic.AddInt64(&l.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt64(&l.waiting, -1)
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *ConcurrencyLimiter) Release() {
+	<-l.slots
+}
+
+// InFlight returns the number of slots taken
+func (l *ConcurrencyLimiter) InFlight() int {
+	return len(l.slots)
+}
+
+var _proxyConcurrencyLimiterInstance *ConcurrencyLimiter
+var _proxyConcurrencyLimiterOnce sync.Once
+
+// GetProxyConcurrencyLimiter returns the limiter for all proxied requests, or nil if PROXY_MAX_CONCURRENT is 0
+func GetProxyConcurrencyLimiter() *ConcurrencyLimiter {
+	_proxyConcurrencyLimiterOnce.Do(func() {
+		if GetConfig().ProxyMaxConcurrent > 0 {
+			_proxyConcurrencyLimiterInstance = NewConcurrencyLimiter(GetConfig().ProxyMaxConcurrent, GetConfig().ProxyQueueSize, time.Second*GetConfig().ProxyQueueTimeout)
+		}
+	})
+	return _proxyConcurrencyLimiterInstance
+}
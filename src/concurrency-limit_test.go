@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := NewConcurrencyLimiter(2, 1, 100*time.Millisecond)
+	if !limiter.Acquire(context.Background()) || !limiter.Acquire(context.Background()) {
+		t.Fatal("Expected 2 slots to be available")
+	}
+
+	// queued request gets the slot released while waiting
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		limiter.Release()
+	}()
+	if !limiter.Acquire(context.Background()) {
+		t.Fatal("Expected queued request to get a slot")
+	}
+
+	// queued request times out
+	start := time.Now()
+	if limiter.Acquire(context.Background()) {
+		t.Fatal("Expected queued request to time out")
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Error("Expected request to wait for the queue timeout")
+	}
+
+	// requests exceeding the queue are shed immediately
+	done := make(chan bool)
+	go func() {
+		done <- limiter.Acquire(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond)
+	start = time.Now()
+	if limiter.Acquire(context.Background()) {
+		t.Fatal("Expected request exceeding the queue to be shed")
+	}
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("Expected request exceeding the queue not to wait")
+	}
+	<-done
+
+	// canceled requests leave the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if limiter.Acquire(ctx) {
+		t.Fatal("Expected canceled request to be shed")
+	}
+	if limiter.InFlight() != 2 {
+		t.Errorf("Expected 2 requests in flight, got %d", limiter.InFlight())
+	}
+}
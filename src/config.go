@@ -1,53 +1,292 @@
 package main
 
 import (
+	"encoding/base64"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net"
+	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
 )
 
 type Config struct {
-	JwtSigningKey           string
-	PublicListenAddr        string
-	PublicAPIPath           string
-	BackendListenAddr       string
-	BackendCertDir          string
-	BackendCertHostnames    []string
-	BackendCertIPs          []net.IP
-	BackendGenerateCert     bool
-	TemplateSignup          string
-	TemplateChangeEmail     string
-	TemplateResetPassword   string
-	TemplateNewPassword     string
-	MongoDbURL              string
-	MongoDbName             string
-	EnableCors              bool
-	CorsOrigin              string
-	CorsHeaders             string
-	SMTPServer              string
-	SMTPSenderAddr          string
-	AllowSignup             bool
-	AllowChangePassword     bool
-	AllowChangeEmail        bool
-	AllowForgotPassword     bool
-	AllowDeleteAccount      bool
-	EnableTOTP              bool
-	TOTPIssuer              string
-	TOTPSecretEncryptionKey string
-	ProxyTarget             *url.URL
-	ProxyWhitelist          []string
-	ProxyBlacklist          []string
-	AccessTokenLifetime     time.Duration
-	RefreshTokenLifetime    time.Duration
-	PendingActionLifetime   time.Duration
+	JwtSigningKey                 string
+	PublicListenAddr              string
+	PublicAPIPath                 string
+	BackendListenAddr             string
+	BackendCertDir                string
+	BackendCertHostnames          []string
+	BackendCertIPs                []net.IP
+	BackendGenerateCert           bool
+	BackendClientPermissions      map[string][]string
+	BackendDefaultPermissions     []string
+	TemplateSignup                string
+	TemplateChangeEmail           string
+	TemplateResetPassword         string
+	TemplateNewPassword           string
+	TemplateInvitation            string
+	TemplateChangeEmailOld        string
+	TemplateAddEmail              string
+	TemplateEmailChanged          string
+	TemplateSignupHTML            string
+	TemplateChangeEmailHTML       string
+	TemplateResetPasswordHTML     string
+	TemplateNewPasswordHTML       string
+	TemplateInvitationHTML        string
+	TemplateChangeEmailOldHTML    string
+	TemplateAddEmailHTML          string
+	TemplateEmailChangedHTML      string
+	MailLogo                      string
+	TemplateLocalesDir            string
+	TemplateDefaultLocale         string
+	MailQueueEnable               bool
+	MailQueueMaxAttempts          int
+	MailQueueRetryBackoff         time.Duration
+	Storage                       string
+	MongoDbURL                    string
+	MongoDbName                   string
+	MongoDbCollectionPrefix       string
+	BackupEncryptionKey           string
+	TenantsFile                   string
+	TenantHeader                  string
+	MongoDbUsername               string
+	MongoDbPassword               string
+	MongoDbAuthSource             string
+	MongoDbAuthMechanism          string
+	MongoDbTLSEnable              bool
+	MongoDbTLSCA                  string
+	MongoDbTLSClientCert          string
+	MongoDbTLSClientKey           string
+	MongoDbTLSInsecure            bool
+	MongoDbReplicaSet             string
+	MongoDbReadPreference         string
+	MongoDbMinPoolSize            uint64
+	MongoDbMaxPoolSize            uint64
+	MongoDbConnectTimeout         time.Duration
+	MongoDbServerSelectionTimeout time.Duration
+	MongoDbEnsureIndexes          bool
+	MongoDbMigrate                bool
+	UserIDFormat                  string
+	EnableCors                    bool
+	CorsOrigin                    string
+	CorsHeaders                   string
+	SMTPServer                    string
+	SMTPSenderAddr                string
+	SMTPUsername                  string
+	SMTPPassword                  string
+	SMTPAuth                      string
+	SMTPTLS                       string
+	SMTPTLSCA                     string
+	SMTPTLSInsecure               bool
+	MailProvider                  string
+	MailAPIKey                    string
+	MailAPIURL                    string
+	MailgunDomain                 string
+	AWSRegion                     string
+	AWSAccessKeyID                string
+	AWSSecretAccessKey            string
+	AWSSessionToken               string
+	CaptchaProvider               string
+	CaptchaSecret                 string
+	SMSProvider                   string
+	SMSWebhookURL                 string
+	CaptchaSignup                 bool
+	CaptchaForgotPassword         bool
+	CaptchaLoginFailures          int
+	OIDCIssuer                    string
+	OIDCClientID                  string
+	OIDCClientSecret              string
+	OIDCRedirectURI               string
+	OIDCScopes                    []string
+	OIDCEmailClaim                string
+	OIDCRolesClaim                string
+	OIDCOrganizationClaim         string
+	OIDCRequireVerifiedEmail      bool
+	OIDCAllowSignup               bool
+	AllowSignup                   bool
+	AdminEmail                    string
+	AdminPassword                 string
+	AdminRole                     string
+	AllowInvitations              bool
+	AllowChangePassword           bool
+	AllowChangeEmail              bool
+	AllowForgotPassword           bool
+	AllowDeleteAccount            bool
+	EnableTOTP                    bool
+	EnableAPIKeys                 bool
+	EnableClientCertAuth          bool
+	ClientCertCA                  string
+	ClientCertMapping             string
+	PublicTLSCert                 string
+	PublicTLSKey                  string
+	PublicACMEDomains             []string
+	PublicACMEEmail               string
+	PublicACMECacheDir            string
+	PublicACMEDirectoryURL        string
+	MaintenanceMode               bool
+	MaintenanceMessage            string
+	ErrorPageTemplate             string
+	AccessLog                     string
+	AccessLogFile                 string
+	PublicHTTP2Enable             bool
+	PublicH2CEnable               bool
+	PublicHTTPRedirectAddr        string
+	PublicProxyProtocol           bool
+	PublicProxyProtocolSources    []*net.IPNet
+	EnableGuest                   bool
+	GuestTokenLifetime            time.Duration
+	GuestRoutes                   []string
+	MetadataMaxSize               int
+	TokenMetadataFields           []string
+	TokenAppMetadataFields        []string
+	DeletedUserRetention          time.Duration
+	PasswordMinLength             int
+	PasswordRequireLowercase      bool
+	PasswordRequireUppercase      bool
+	PasswordRequireDigit          bool
+	PasswordRequireSpecial        bool
+	PasswordBanCommon             bool
+	PasswordBannedList            []string
+	PasswordDisallowEmail         bool
+	PasswordMaxAge                time.Duration
+	HIBPEnable                    bool
+	HIBPAPIURL                    string
+	HIBPTimeout                   time.Duration
+	HIBPFailOpen                  bool
+	EmailChangeConfirmOld         bool
+	MaxAdditionalEmails           int
+	EmailNormalize                bool
+	EmailStripPlusTag             bool
+	EmailIDNToASCII               bool
+	EmailDomainAllowlist          []string
+	EmailDomainBlocklist          []string
+	DisposableEmailBlock          bool
+	DisposableEmailList           []string
+	DisposableEmailListURL        string
+	DisposableEmailListRefresh    time.Duration
+	PasswordHashAlgorithm         string
+	BcryptCost                    int
+	Argon2Memory                  uint32
+	Argon2Time                    uint32
+	Argon2Parallelism             uint8
+	AuditLogEnable                bool
+	AuditLogRetention             time.Duration
+	EnableDeviceFlow              bool
+	DeviceVerificationURI         string
+	DeviceCodeLifetime            time.Duration
+	DevicePollInterval            time.Duration
+	EnforceTOTP                   bool
+	TrustedDeviceLifetime         time.Duration
+	TOTPIssuer                    string
+	TOTPSecretEncryptionKey       string
+	ProxyTargets                  []*url.URL
+	ProxyTargetWeights            []int
+	ProxyLoadBalancing            string
+	ProxyRateLimitUser            *RateLimit
+	ProxyRateLimitAnonymous       *RateLimit
+	ProxyRateLimitStore           string
+	ProxyRateLimitRoutes          []*RateLimitRoute
+	ProxyCanaryTargets            []*url.URL
+	ProxyCanaryPercent            int
+	ProxyCanaryRoles              []string
+	ProxyCanaryClaim              *CanaryClaim
+	ProxyMirrorTarget             *url.URL
+	ProxyMirrorPercent            int
+	ProxyMirrorMaxBodySize        int64
+	ProxyMirrorTimeout            time.Duration
+	ProxyStickySessions           string
+	ProxyStickyCookie             string
+	ProxyHealthCheckPath          string
+	ProxyHealthCheckInterval      time.Duration
+	ProxyHealthCheckTimeout       time.Duration
+	ProxyNoUpstreamStatus         int
+	ProxyMaxConcurrent            int
+	ProxyMaxConcurrentPerUpstream int
+	ProxyQueueSize                int
+	ProxyQueueTimeout             time.Duration
+	ProxyRetries                  int
+	ProxyRetryBackoff             time.Duration
+	ProxyCircuitBreakerThreshold  int
+	ProxyCircuitBreakerCooldown   time.Duration
+	ProxyDialTimeout              time.Duration
+	ProxyKeepAlive                time.Duration
+	ProxyDisableKeepAlives        bool
+	ProxyMaxIdleConns             int
+	ProxyMaxIdleConnsPerHost      int
+	ProxyMaxConnsPerHost          int
+	ProxyIdleConnTimeout          time.Duration
+	ProxyTLSHandshakeTimeout      time.Duration
+	ProxyResponseHeaderTimeout    time.Duration
+	ProxyRequestTimeout           time.Duration
+	ProxyTLSClientCert            string
+	ProxyTLSClientKey             string
+	ProxyTLSCA                    string
+	ProxyTLSPins                  []string
+	ProxyTLSServerName            string
+	ProxyHostHeader               string
+	ProxyIdentityHeaders          map[string]string
+	ProxyUpstreamCredentials      string
+	ProxyUpstreamAuthHeader       string
+	ServerReadTimeout             time.Duration
+	ServerWriteTimeout            time.Duration
+	ServerIdleTimeout             time.Duration
+	UserCacheTTL                  time.Duration
+	ProxyRulesFile                string
+	ProxyTokenQueryRoutes         []*ProxyRule
+	ProxyTokenCookie              string
+	ProxyLoginURL                 string
+	ProxyLoginReturnParam         string
+	StaticDir                     string
+	StaticProxyRoutes             []*ProxyRule
+	SignedURLKey                  string
+	SignedURLMaxTTL               int
+	ProxyHeaderRules              []*HeaderRule
+	ProxyRulesDryRun              bool
+	ProxyResponseHeaderDenylist   []string
+	ProxyTrustedProxies           []*net.IPNet
+	ProxyForwardedHeaders         string
+	IPAllowlist                   []*net.IPNet
+	IPDenylist                    []*net.IPNet
+	IPAccessRules                 []*IPAccessRule
+	GeoIPDatabase                 string
+	GeoIPAllowCountries           []string
+	GeoIPDenyCountries            []string
+	GeoIPAccessRules              []*CountryAccessRule
+	GeoIPCountryHeader            string
+	IdentityHeaderSigningKey      string
+	ProxyForwardUserHeaders       bool
+	ProxyAuthzRulesFile           string
+	ProxyCache                    string
+	ProxyCacheRoutes              []string
+	ProxyCacheMaxEntries          int
+	ProxyCacheMaxBodySize         int
+	RedisURL                      string
+	EnableCompression             bool
+	CompressionMinSize            int
+	CompressionContentTypes       []string
+	EnableBasicAuth               bool
+	BasicAuthRealm                string
+	AccessTokenLifetime           time.Duration
+	RefreshTokenLifetime          time.Duration
+	PendingActionLifetime         time.Duration
+	InvitationLifetime            time.Duration
 }
 
+const (
+	ClientCertMappingFingerprint = "fingerprint"
+	ClientCertMappingSAN         = "san"
+)
+
 var _configInstance *Config
 var _configOnce sync.Once
 
@@ -91,44 +330,744 @@ func (c *Config) ReadConfig() {
 		c.BackendCertIPs[i] = net.ParseIP(ipAddr)
 	}
 	c.BackendGenerateCert = (c._GetEnv("BACKEND_GENERATE_CERT", "1") == "1")
+	c.BackendClientPermissions = make(map[string][]string)
+	for _, entry := range strings.Fields(c._GetEnv("BACKEND_CLIENT_PERMISSIONS", "")) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			log.Fatal("BACKEND_CLIENT_PERMISSIONS entries must have the format <common name>=<permission>[,<permission>]")
+		}
+		c.BackendClientPermissions[parts[0]] = strings.Split(parts[1], ",")
+	}
+	c.BackendDefaultPermissions = strings.Split(c._GetEnv("BACKEND_DEFAULT_PERMISSIONS", "admin"), ",")
 	c.TemplateSignup = c._GetEnv("TEMPLATE_SIGNUP", "res/signup.tpl")
 	c.TemplateChangeEmail = c._GetEnv("TEMPLATE_CHANGE_EMAIL", "res/changeemail.tpl")
 	c.TemplateResetPassword = c._GetEnv("TEMPLATE_RESET_PASSWORD", "res/resetpassword.tpl")
 	c.TemplateNewPassword = c._GetEnv("TEMPLATE_NEW_PASSWORD", "res/newpassword.tpl")
+	c.TemplateInvitation = c._GetEnv("TEMPLATE_INVITATION", "res/invitation.tpl")
+	c.TemplateChangeEmailOld = c._GetEnv("TEMPLATE_CHANGE_EMAIL_OLD", "res/changeemailold.tpl")
+	c.TemplateAddEmail = c._GetEnv("TEMPLATE_ADD_EMAIL", "res/addemail.tpl")
+	c.TemplateEmailChanged = c._GetEnv("TEMPLATE_EMAIL_CHANGED", "res/emailchanged.tpl")
+	c.TemplateSignupHTML = c._GetEnv("TEMPLATE_SIGNUP_HTML", "")
+	c.TemplateChangeEmailHTML = c._GetEnv("TEMPLATE_CHANGE_EMAIL_HTML", "")
+	c.TemplateResetPasswordHTML = c._GetEnv("TEMPLATE_RESET_PASSWORD_HTML", "")
+	c.TemplateNewPasswordHTML = c._GetEnv("TEMPLATE_NEW_PASSWORD_HTML", "")
+	c.TemplateInvitationHTML = c._GetEnv("TEMPLATE_INVITATION_HTML", "")
+	c.TemplateChangeEmailOldHTML = c._GetEnv("TEMPLATE_CHANGE_EMAIL_OLD_HTML", "")
+	c.TemplateAddEmailHTML = c._GetEnv("TEMPLATE_ADD_EMAIL_HTML", "")
+	c.TemplateEmailChangedHTML = c._GetEnv("TEMPLATE_EMAIL_CHANGED_HTML", "")
+	c.MailLogo = c._GetEnv("MAIL_LOGO", "")
+	c.TemplateLocalesDir = c._GetEnv("TEMPLATE_LOCALES_DIR", "")
+	c.TemplateDefaultLocale = c._GetEnv("TEMPLATE_DEFAULT_LOCALE", "en")
+	if _, err := language.Parse(c.TemplateDefaultLocale); err != nil {
+		log.Fatal("TEMPLATE_DEFAULT_LOCALE must be a language tag, e.g. en")
+	}
+	c.Storage = c._GetEnv("STORAGE", StorageMongoDB)
+	if _GetStorageDriver(c.Storage) == nil {
+		log.Fatal("STORAGE must be one of: " + strings.Join(GetStorageDrivers(), ", "))
+	}
 	c.MongoDbURL = c._GetEnv("MONGO_DB_URL", "mongodb://localhost:27017")
 	c.MongoDbName = c._GetEnv("MONGO_DB_NAME", "jwt_auth_proxy")
+	c.MongoDbCollectionPrefix = c._GetEnv("MONGO_DB_COLLECTION_PREFIX", "")
+	c.BackupEncryptionKey = c._GetEnv("BACKUP_ENCRYPT_KEY", "")
+	if l := len(c.BackupEncryptionKey); l != 0 && l != 16 && l != 24 && l != 32 {
+		log.Fatal("BACKUP_ENCRYPT_KEY must be 16, 24 or 32 bytes long")
+	}
+	c.TenantsFile = c._GetEnv("TENANTS_FILE", "")
+	c.TenantHeader = c._GetEnv("TENANT_HEADER", "")
+	c.MongoDbUsername = c._GetEnv("MONGO_DB_USERNAME", "")
+	c.MongoDbPassword = c._GetEnv("MONGO_DB_PASSWORD", "")
+	c.MongoDbAuthSource = c._GetEnv("MONGO_DB_AUTH_SOURCE", "")
+	c.MongoDbAuthMechanism = c._GetEnv("MONGO_DB_AUTH_MECHANISM", "")
+	c.MongoDbTLSEnable = (c._GetEnv("MONGO_DB_TLS_ENABLE", "0") == "1")
+	c.MongoDbTLSCA = c._GetEnv("MONGO_DB_TLS_CA", "")
+	c.MongoDbTLSClientCert = c._GetEnv("MONGO_DB_TLS_CLIENT_CERT", "")
+	c.MongoDbTLSClientKey = c._GetEnv("MONGO_DB_TLS_CLIENT_KEY", "")
+	if (c.MongoDbTLSClientCert == "") != (c.MongoDbTLSClientKey == "") {
+		log.Fatal("MONGO_DB_TLS_CLIENT_CERT and MONGO_DB_TLS_CLIENT_KEY must be set together")
+	}
+	c.MongoDbTLSInsecure = (c._GetEnv("MONGO_DB_TLS_INSECURE", "0") == "1")
+	c.MongoDbReplicaSet = c._GetEnv("MONGO_DB_REPLICA_SET", "")
+	c.MongoDbReadPreference = c._GetEnv("MONGO_DB_READ_PREFERENCE", "")
+	if _, err := readpref.ModeFromString(c.MongoDbReadPreference); c.MongoDbReadPreference != "" && err != nil {
+		log.Fatal("MONGO_DB_READ_PREFERENCE must be one of: primary, primaryPreferred, secondary, secondaryPreferred, nearest")
+	}
+	if i, err := strconv.Atoi(c._GetEnv("MONGO_DB_MIN_POOL_SIZE", "0")); err != nil || i < 0 {
+		log.Fatal("MONGO_DB_MIN_POOL_SIZE must be a non-negative integer")
+	} else {
+		c.MongoDbMinPoolSize = uint64(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("MONGO_DB_MAX_POOL_SIZE", "100")); err != nil || i < 0 {
+		log.Fatal("MONGO_DB_MAX_POOL_SIZE must be a non-negative integer")
+	} else {
+		c.MongoDbMaxPoolSize = uint64(i)
+	}
+	if c.MongoDbMaxPoolSize != 0 && c.MongoDbMinPoolSize > c.MongoDbMaxPoolSize {
+		log.Fatal("MONGO_DB_MIN_POOL_SIZE must not exceed MONGO_DB_MAX_POOL_SIZE")
+	}
+	if i, err := strconv.Atoi(c._GetEnv("MONGO_DB_CONNECT_TIMEOUT", "30")); err != nil || i < 1 {
+		log.Fatal("MONGO_DB_CONNECT_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.MongoDbConnectTimeout = time.Duration(i)
+	}
+	c.MongoDbEnsureIndexes = (c._GetEnv("MONGO_DB_ENSURE_INDEXES", "1") == "1")
+	c.MongoDbMigrate = (c._GetEnv("MONGO_DB_MIGRATE", "1") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("MONGO_DB_SERVER_SELECTION_TIMEOUT", "30")); err != nil || i < 1 {
+		log.Fatal("MONGO_DB_SERVER_SELECTION_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.MongoDbServerSelectionTimeout = time.Duration(i)
+	}
+	c.UserIDFormat = c._GetEnv("USER_ID_FORMAT", UserIDFormatObjectID)
+	if c.UserIDFormat != UserIDFormatObjectID && c.UserIDFormat != UserIDFormatUUIDv4 && c.UserIDFormat != UserIDFormatUUIDv7 {
+		log.Fatal("USER_ID_FORMAT must be one of: objectid, uuidv4, uuidv7")
+	}
 	c.EnableCors = (c._GetEnv("CORS_ENABLE", "0") == "1")
 	c.CorsOrigin = c._GetEnv("CORS_ORIGIN", "*")
 	c.CorsHeaders = c._GetEnv("CORS_HEADERS", "*")
 	c.SMTPServer = c._GetEnv("SMTP_SERVER", "127.0.0.1:25")
 	c.SMTPSenderAddr = c._GetEnv("SMTP_SENDER_ADDR", "no-reply@localhost")
+	c.SMTPUsername = c._GetEnv("SMTP_USERNAME", "")
+	c.SMTPPassword = c._GetEnv("SMTP_PASSWORD", "")
+	c.SMTPAuth = strings.ToLower(c._GetEnv("SMTP_AUTH", SMTPAuthPlain))
+	if c.SMTPAuth != SMTPAuthPlain && c.SMTPAuth != SMTPAuthLogin && c.SMTPAuth != SMTPAuthCRAMMD5 {
+		log.Fatal("SMTP_AUTH must be one of: plain, login, cram-md5")
+	}
+	c.SMTPTLS = strings.ToLower(c._GetEnv("SMTP_TLS", SMTPTLSNone))
+	if c.SMTPTLS != SMTPTLSNone && c.SMTPTLS != SMTPTLSStartTLS && c.SMTPTLS != SMTPTLSImplicit {
+		log.Fatal("SMTP_TLS must be one of: none, starttls, tls")
+	}
+	c.SMTPTLSCA = c._GetEnv("SMTP_TLS_CA", "")
+	c.SMTPTLSInsecure = (c._GetEnv("SMTP_TLS_INSECURE", "0") == "1")
+	c.MailProvider = strings.ToLower(c._GetEnv("MAIL_PROVIDER", MailProviderSMTP))
+	if c.MailProvider != MailProviderSMTP && c.MailProvider != MailProviderSES && mailProviderURLs[c.MailProvider] == "" {
+		log.Fatal("MAIL_PROVIDER must be one of: smtp, sendgrid, ses, mailgun, postmark")
+	}
+	c.MailAPIKey = c._GetEnv("MAIL_API_KEY", "")
+	if mailProviderURLs[c.MailProvider] != "" && c.MailAPIKey == "" {
+		log.Fatal("MAIL_PROVIDER=" + c.MailProvider + " requires MAIL_API_KEY")
+	}
+	c.MailAPIURL = strings.TrimSuffix(c._GetEnv("MAIL_API_URL", ""), "/")
+	c.MailgunDomain = c._GetEnv("MAILGUN_DOMAIN", "")
+	if c.MailProvider == MailProviderMailgun && c.MailgunDomain == "" {
+		log.Fatal("MAIL_PROVIDER=mailgun requires MAILGUN_DOMAIN")
+	}
+	c.AWSRegion = c._GetEnv("AWS_REGION", "")
+	c.AWSAccessKeyID = c._GetEnv("AWS_ACCESS_KEY_ID", "")
+	c.AWSSecretAccessKey = c._GetEnv("AWS_SECRET_ACCESS_KEY", "")
+	c.AWSSessionToken = c._GetEnv("AWS_SESSION_TOKEN", "")
+	if c.MailProvider == MailProviderSES && (c.AWSRegion == "" || c.AWSAccessKeyID == "" || c.AWSSecretAccessKey == "") {
+		log.Fatal("MAIL_PROVIDER=ses requires AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	c.MailQueueEnable = (c._GetEnv("MAIL_QUEUE_ENABLE", "0") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("MAIL_QUEUE_MAX_ATTEMPTS", "8")); err != nil || i < 1 {
+		log.Fatal("MAIL_QUEUE_MAX_ATTEMPTS must be a positive integer")
+	} else {
+		c.MailQueueMaxAttempts = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("MAIL_QUEUE_RETRY_BACKOFF", "30")); err != nil || i < 1 {
+		log.Fatal("MAIL_QUEUE_RETRY_BACKOFF must be a positive integer")
+	} else {
+		c.MailQueueRetryBackoff = time.Duration(i)
+	}
+	c.CaptchaProvider = c._GetEnv("CAPTCHA_PROVIDER", "")
+	if _, ok := captchaVerifyURLs[c.CaptchaProvider]; c.CaptchaProvider != "" && !ok {
+		log.Fatal("CAPTCHA_PROVIDER must be one of: recaptcha, hcaptcha, turnstile")
+	}
+	c.CaptchaSecret = c._GetEnv("CAPTCHA_SECRET", "")
+	c.SMSProvider = c._GetEnv("SMS_PROVIDER", "")
+	if c.SMSProvider != "" && c.SMSProvider != SMSProviderWebhook && c.SMSProvider != SMSProviderLog {
+		log.Fatal("SMS_PROVIDER must be one of: webhook, log")
+	}
+	c.SMSWebhookURL = c._GetEnv("SMS_WEBHOOK_URL", "")
+	if c.SMSProvider == SMSProviderWebhook && c.SMSWebhookURL == "" {
+		log.Fatal("SMS_PROVIDER=webhook requires SMS_WEBHOOK_URL")
+	}
+	c.CaptchaSignup = (c._GetEnv("CAPTCHA_SIGNUP", "1") == "1")
+	c.CaptchaForgotPassword = (c._GetEnv("CAPTCHA_FORGOT_PASSWORD", "0") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("CAPTCHA_LOGIN_FAILURES", "3")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.CaptchaLoginFailures = i
+	}
+	c.OIDCIssuer = c._GetEnv("OIDC_ISSUER", "")
+	c.OIDCClientID = c._GetEnv("OIDC_CLIENT_ID", "")
+	if c.OIDCIssuer != "" && c.OIDCClientID == "" {
+		log.Fatal("OIDC_ISSUER requires OIDC_CLIENT_ID")
+	}
+	c.OIDCClientSecret = c._GetEnv("OIDC_CLIENT_SECRET", "")
+	c.OIDCRedirectURI = c._GetEnv("OIDC_REDIRECT_URI", "http://localhost:8080/oidc/callback")
+	c.OIDCScopes = strings.Fields(c._GetEnv("OIDC_SCOPES", "openid email profile"))
+	c.OIDCEmailClaim = c._GetEnv("OIDC_EMAIL_CLAIM", "email")
+	c.OIDCRolesClaim = c._GetEnv("OIDC_ROLES_CLAIM", "")
+	c.OIDCOrganizationClaim = c._GetEnv("OIDC_ORGANIZATION_CLAIM", "")
+	c.OIDCRequireVerifiedEmail = (c._GetEnv("OIDC_REQUIRE_VERIFIED_EMAIL", "1") == "1")
+	c.OIDCAllowSignup = (c._GetEnv("OIDC_ALLOW_SIGNUP", "1") == "1")
 	c.AllowSignup = (c._GetEnv("ALLOW_SIGNUP", "1") == "1")
+	c.AdminEmail = c._GetEnv("ADMIN_EMAIL", "")
+	c.AdminPassword = c._GetEnv("ADMIN_PASSWORD", "")
+	c.AdminRole = c._GetEnv("ADMIN_ROLE", "admin")
+	c.AllowInvitations = (c._GetEnv("ALLOW_INVITATIONS", "0") == "1")
 	c.AllowChangePassword = (c._GetEnv("ALLOW_CHANGE_PASSWORD", "1") == "1")
 	c.AllowChangeEmail = (c._GetEnv("ALLOW_CHANGE_EMAIL", "1") == "1")
 	c.AllowForgotPassword = (c._GetEnv("ALLOW_FORGOT_PASSWORD", "1") == "1")
 	c.AllowDeleteAccount = (c._GetEnv("ALLOW_DELETE_ACCOUNT", "1") == "1")
+	c.EnableAPIKeys = (c._GetEnv("API_KEYS_ENABLE", "0") == "1")
+	c.EnableGuest = (c._GetEnv("GUEST_ENABLE", "0") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("GUEST_TOKEN_LIFETIME", "60")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.GuestTokenLifetime = time.Duration(i)
+	}
+	c.GuestRoutes = nil
+	if guestRoutes := strings.TrimSpace(c._GetEnv("GUEST_ROUTES", "")); guestRoutes != "" {
+		c.GuestRoutes = strings.Split(guestRoutes, ":")
+	}
+	if i, err := strconv.Atoi(c._GetEnv("METADATA_MAX_SIZE", "4096")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.MetadataMaxSize = i
+	}
+	c.TokenMetadataFields = strings.Fields(c._GetEnv("TOKEN_METADATA_FIELDS", ""))
+	c.TokenAppMetadataFields = strings.Fields(c._GetEnv("TOKEN_APP_METADATA_FIELDS", ""))
+	if i, err := strconv.Atoi(c._GetEnv("DELETED_USER_RETENTION", "30")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.DeletedUserRetention = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PASSWORD_MIN_LENGTH", "8")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.PasswordMinLength = i
+	}
+	c.PasswordRequireLowercase = (c._GetEnv("PASSWORD_REQUIRE_LOWERCASE", "0") == "1")
+	c.PasswordRequireUppercase = (c._GetEnv("PASSWORD_REQUIRE_UPPERCASE", "0") == "1")
+	c.PasswordRequireDigit = (c._GetEnv("PASSWORD_REQUIRE_DIGIT", "0") == "1")
+	c.PasswordRequireSpecial = (c._GetEnv("PASSWORD_REQUIRE_SPECIAL", "0") == "1")
+	c.PasswordBanCommon = (c._GetEnv("PASSWORD_BAN_COMMON", "0") == "1")
+	c.PasswordBannedList = nil
+	if bannedFile := c._GetEnv("PASSWORD_BANNED_FILE", ""); bannedFile != "" {
+		content, err := ioutil.ReadFile(bannedFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.PasswordBannedList = strings.Fields(string(content))
+	}
+	c.PasswordDisallowEmail = (c._GetEnv("PASSWORD_DISALLOW_EMAIL", "0") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("PASSWORD_MAX_AGE", "0")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.PasswordMaxAge = time.Duration(i)
+	}
+	c.HIBPEnable = (c._GetEnv("HIBP_ENABLE", "0") == "1")
+	c.HIBPAPIURL = c._GetEnv("HIBP_API_URL", "https://api.pwnedpasswords.com/range/")
+	if i, err := strconv.Atoi(c._GetEnv("HIBP_TIMEOUT", "3")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.HIBPTimeout = time.Duration(i)
+	}
+	c.HIBPFailOpen = (c._GetEnv("HIBP_FAIL_OPEN", "1") == "1")
+	c.EmailChangeConfirmOld = (c._GetEnv("EMAIL_CHANGE_CONFIRM_OLD", "0") == "1")
+	c.EmailNormalize = (c._GetEnv("EMAIL_NORMALIZE", "1") == "1")
+	c.EmailStripPlusTag = (c._GetEnv("EMAIL_STRIP_PLUS_TAG", "0") == "1")
+	c.EmailIDNToASCII = (c._GetEnv("EMAIL_IDN_TO_ASCII", "0") == "1")
+	c.EmailDomainAllowlist = strings.Fields(strings.ToLower(c._GetEnv("EMAIL_DOMAIN_ALLOWLIST", "")))
+	c.EmailDomainBlocklist = strings.Fields(strings.ToLower(c._GetEnv("EMAIL_DOMAIN_BLOCKLIST", "")))
+	c.DisposableEmailBlock = (c._GetEnv("DISPOSABLE_EMAIL_BLOCK", "0") == "1")
+	c.DisposableEmailList = nil
+	if disposableFile := c._GetEnv("DISPOSABLE_EMAIL_FILE", ""); disposableFile != "" {
+		content, err := ioutil.ReadFile(disposableFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.DisposableEmailList = strings.Fields(strings.ToLower(string(content)))
+	}
+	c.DisposableEmailListURL = c._GetEnv("DISPOSABLE_EMAIL_LIST_URL", "")
+	if i, err := strconv.Atoi(c._GetEnv("DISPOSABLE_EMAIL_LIST_REFRESH", "24")); err != nil || i < 1 {
+		log.Fatal("DISPOSABLE_EMAIL_LIST_REFRESH must be a positive number of hours")
+	} else {
+		c.DisposableEmailListRefresh = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("MAX_ADDITIONAL_EMAILS", "0")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.MaxAdditionalEmails = i
+	}
+	c.PasswordHashAlgorithm = c._GetEnv("PASSWORD_HASH_ALGORITHM", PasswordHashBcrypt)
+	if c.PasswordHashAlgorithm != PasswordHashBcrypt && c.PasswordHashAlgorithm != PasswordHashArgon2id {
+		log.Fatal("PASSWORD_HASH_ALGORITHM must be one of: bcrypt, argon2id")
+	}
+	if i, err := strconv.Atoi(c._GetEnv("BCRYPT_COST", "10")); err != nil || i < bcrypt.MinCost || i > bcrypt.MaxCost {
+		log.Fatal("BCRYPT_COST must be an integer between 4 and 31")
+	} else {
+		c.BcryptCost = i
+	}
+	if i, err := strconv.ParseUint(c._GetEnv("ARGON2_MEMORY", "65536"), 10, 32); err != nil {
+		log.Fatal(err)
+	} else {
+		c.Argon2Memory = uint32(i)
+	}
+	if i, err := strconv.ParseUint(c._GetEnv("ARGON2_TIME", "3"), 10, 32); err != nil || i == 0 {
+		log.Fatal("ARGON2_TIME must be a positive integer")
+	} else {
+		c.Argon2Time = uint32(i)
+	}
+	if i, err := strconv.ParseUint(c._GetEnv("ARGON2_PARALLELISM", "2"), 10, 8); err != nil || i == 0 {
+		log.Fatal("ARGON2_PARALLELISM must be an integer between 1 and 255")
+	} else {
+		c.Argon2Parallelism = uint8(i)
+	}
+	c.AuditLogEnable = (c._GetEnv("AUDIT_LOG_ENABLE", "0") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("AUDIT_LOG_RETENTION", "90")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.AuditLogRetention = time.Duration(i)
+	}
+	c.PublicTLSCert = c._GetEnv("PUBLIC_TLS_CERT", "")
+	c.PublicTLSKey = c._GetEnv("PUBLIC_TLS_KEY", "")
+	c.PublicACMEDomains = strings.Fields(c._GetEnv("PUBLIC_ACME_DOMAINS", ""))
+	c.PublicACMEEmail = c._GetEnv("PUBLIC_ACME_EMAIL", "")
+	c.PublicACMECacheDir = c._GetEnv("PUBLIC_ACME_CACHE_DIR", "./acme-cache")
+	c.PublicACMEDirectoryURL = c._GetEnv("PUBLIC_ACME_DIRECTORY_URL", "")
+	if c.TenantsFile != "" && len(c.PublicACMEDomains) > 0 {
+		log.Fatal("PUBLIC_ACME_DOMAINS is not supported with TENANTS_FILE, use PUBLIC_TLS_CERT")
+	}
+	if c.PublicTLSCert != "" && len(c.PublicACMEDomains) > 0 {
+		log.Fatal("PUBLIC_TLS_CERT and PUBLIC_ACME_DOMAINS must not be set together")
+	}
+	c.PublicHTTPRedirectAddr = c._GetEnv("PUBLIC_HTTP_REDIRECT_ADDR", "")
+	c.PublicProxyProtocol = (c._GetEnv("PUBLIC_PROXY_PROTOCOL", "0") == "1")
+	if sources, err := ParseIPRanges(c._GetEnv("PUBLIC_PROXY_PROTOCOL_SOURCES", "")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.PublicProxyProtocolSources = sources
+	}
+	c.MaintenanceMode = (c._GetEnv("MAINTENANCE_MODE", "0") == "1")
+	c.MaintenanceMessage = c._GetEnv("MAINTENANCE_MESSAGE", "")
+	c.ErrorPageTemplate = c._GetEnv("ERROR_PAGE_TEMPLATE", "")
+	c.AccessLog = c._GetEnv("ACCESS_LOG", "")
+	if c.AccessLog != "" && c.AccessLog != AccessLogCommon && c.AccessLog != AccessLogJSON {
+		log.Fatal("ACCESS_LOG must be empty or one of: common, json")
+	}
+	c.AccessLogFile = c._GetEnv("ACCESS_LOG_FILE", "")
+	c.PublicHTTP2Enable = (c._GetEnv("PUBLIC_HTTP2_ENABLE", "1") == "1")
+	c.PublicH2CEnable = (c._GetEnv("PUBLIC_H2C_ENABLE", "0") == "1")
+	if c.PublicH2CEnable && !c.PublicHTTP2Enable {
+		log.Fatal("PUBLIC_H2C_ENABLE requires PUBLIC_HTTP2_ENABLE")
+	}
+	c.EnableClientCertAuth = (c._GetEnv("CLIENT_CERT_AUTH_ENABLE", "0") == "1")
+	c.ClientCertCA = c._GetEnv("CLIENT_CERT_CA", "")
+	c.ClientCertMapping = c._GetEnv("CLIENT_CERT_MAPPING", ClientCertMappingFingerprint)
+	if c.ClientCertMapping != ClientCertMappingFingerprint && c.ClientCertMapping != ClientCertMappingSAN {
+		log.Fatal("CLIENT_CERT_MAPPING must be one of: fingerprint, san")
+	}
+	if c.EnableClientCertAuth && (c.ClientCertCA == "" || ((c.PublicTLSCert == "" || c.PublicTLSKey == "") && len(c.PublicACMEDomains) == 0)) {
+		log.Fatal("CLIENT_CERT_AUTH_ENABLE requires CLIENT_CERT_CA and PUBLIC_TLS_CERT and PUBLIC_TLS_KEY or PUBLIC_ACME_DOMAINS")
+	}
+	c.EnableDeviceFlow = (c._GetEnv("DEVICE_FLOW_ENABLE", "0") == "1")
+	c.DeviceVerificationURI = c._GetEnv("DEVICE_VERIFICATION_URI", "http://localhost:8080/device")
+	if i, err := strconv.Atoi(c._GetEnv("DEVICE_CODE_LIFETIME", "10")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.DeviceCodeLifetime = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("DEVICE_POLL_INTERVAL", "5")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.DevicePollInterval = time.Duration(i)
+	}
 	c.EnableTOTP = (c._GetEnv("TOTP_ENABLE", "0") == "1")
+	c.EnforceTOTP = (c._GetEnv("TOTP_ENFORCE", "0") == "1")
+	if c.EnforceTOTP && !c.EnableTOTP {
+		log.Fatal("TOTP_ENFORCE requires TOTP_ENABLE=1")
+	}
+	if i, err := strconv.Atoi(c._GetEnv("TOTP_TRUSTED_DEVICE_LIFETIME", "0")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.TrustedDeviceLifetime = time.Duration(i)
+	}
 	c.TOTPIssuer = c._GetEnv("TOTP_ISSUER", "JWT Auth Proxy")
 	c.TOTPSecretEncryptionKey = c._GetEnv("TOTP_ENCRYPT_KEY", "")
 	if c.EnableTOTP && len(c.TOTPSecretEncryptionKey) < 16 {
 		log.Fatal("TOTP_ENCRYPT_KEY with minimum length of 16 bytes required")
 	}
-	if proxyTaget, err := url.Parse(c._GetEnv("PROXY_TARGET", "http://127.0.0.1:80")); err != nil {
+	c.ProxyTargets = nil
+	for _, target := range strings.Fields(c._GetEnv("PROXY_TARGET", "http://127.0.0.1:80")) {
+		if proxyTarget, err := url.Parse(target); err != nil {
+			log.Fatal(err)
+		} else {
+			c.ProxyTargets = append(c.ProxyTargets, proxyTarget)
+		}
+	}
+	c.ProxyTargetWeights = nil
+	for _, weight := range strings.Fields(c._GetEnv("PROXY_TARGET_WEIGHTS", "")) {
+		if i, err := strconv.Atoi(weight); err != nil || i < 1 {
+			log.Fatal("PROXY_TARGET_WEIGHTS must be positive integers")
+		} else {
+			c.ProxyTargetWeights = append(c.ProxyTargetWeights, i)
+		}
+	}
+	if len(c.ProxyTargetWeights) != 0 && len(c.ProxyTargetWeights) != len(c.ProxyTargets) {
+		log.Fatal("PROXY_TARGET_WEIGHTS must contain one weight per PROXY_TARGET")
+	}
+	c.ProxyLoadBalancing = c._GetEnv("PROXY_LOAD_BALANCING", LoadBalancingRoundRobin)
+	if c.ProxyLoadBalancing != LoadBalancingRoundRobin && c.ProxyLoadBalancing != LoadBalancingLeastConnections && c.ProxyLoadBalancing != LoadBalancingWeighted {
+		log.Fatal("PROXY_LOAD_BALANCING must be one of: round-robin, least-connections, weighted")
+	}
+	c.ProxyCanaryTargets = nil
+	for _, target := range strings.Fields(c._GetEnv("PROXY_CANARY_TARGET", "")) {
+		if canaryTarget, err := url.Parse(target); err != nil {
+			log.Fatal(err)
+		} else {
+			c.ProxyCanaryTargets = append(c.ProxyCanaryTargets, canaryTarget)
+		}
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_CANARY_PERCENT", "0")); err != nil || i < 0 || i > 100 {
+		log.Fatal("PROXY_CANARY_PERCENT must be a number between 0 and 100")
+	} else {
+		c.ProxyCanaryPercent = i
+	}
+	c.ProxyCanaryRoles = strings.Fields(c._GetEnv("PROXY_CANARY_ROLES", ""))
+	if claim, err := ParseCanaryClaim(c._GetEnv("PROXY_CANARY_CLAIM", "")); err != nil {
 		log.Fatal(err)
 	} else {
-		c.ProxyTarget = proxyTaget
+		c.ProxyCanaryClaim = claim
 	}
-	c.ProxyWhitelist = strings.Split(strings.TrimSpace(c._GetEnv("PROXY_WHITELIST", "")), ":")
-	if len(c.ProxyWhitelist) == 1 && c.ProxyWhitelist[0] == "" {
-		c.ProxyWhitelist = make([]string, 0)
+	c.ProxyMirrorTarget = nil
+	if target := c._GetEnv("PROXY_MIRROR_TARGET", ""); target != "" {
+		if mirrorTarget, err := url.Parse(target); err != nil {
+			log.Fatal(err)
+		} else {
+			c.ProxyMirrorTarget = mirrorTarget
+		}
 	}
-	c.ProxyBlacklist = strings.Split(strings.TrimSpace(c._GetEnv("PROXY_BLACKLIST", "")), ":")
-	if len(c.ProxyBlacklist) == 1 && c.ProxyBlacklist[0] == "" {
-		c.ProxyBlacklist = make([]string, 0)
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MIRROR_PERCENT", "100")); err != nil || i < 0 || i > 100 {
+		log.Fatal("PROXY_MIRROR_PERCENT must be a number between 0 and 100")
+	} else {
+		c.ProxyMirrorPercent = i
 	}
-	if len(c.ProxyBlacklist) > 0 && len(c.ProxyWhitelist) > 0 {
-		log.Fatal("Can't set both PROXY_WHITELIST and PROXY_BLACKLIST")
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MIRROR_MAX_BODY_SIZE", "1048576")); err != nil || i < 0 {
+		log.Fatal("PROXY_MIRROR_MAX_BODY_SIZE must be a non-negative number of bytes")
+	} else {
+		c.ProxyMirrorMaxBodySize = int64(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MIRROR_TIMEOUT", "30")); err != nil || i < 1 {
+		log.Fatal("PROXY_MIRROR_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.ProxyMirrorTimeout = time.Duration(i)
+	}
+	c.ProxyStickySessions = c._GetEnv("PROXY_STICKY_SESSIONS", "")
+	if c.ProxyStickySessions != "" && c.ProxyStickySessions != StickySessionsUser && c.ProxyStickySessions != StickySessionsCookie {
+		log.Fatal("PROXY_STICKY_SESSIONS must be empty or one of: user, cookie")
+	}
+	c.ProxyStickyCookie = c._GetEnv("PROXY_STICKY_COOKIE", "jwt_auth_proxy_upstream")
+	c.ProxyHealthCheckPath = c._GetEnv("PROXY_HEALTH_CHECK_PATH", "")
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_HEALTH_CHECK_INTERVAL", "10")); err != nil || i < 1 {
+		log.Fatal("PROXY_HEALTH_CHECK_INTERVAL must be a positive number of seconds")
+	} else {
+		c.ProxyHealthCheckInterval = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_HEALTH_CHECK_TIMEOUT", "5")); err != nil || i < 1 {
+		log.Fatal("PROXY_HEALTH_CHECK_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.ProxyHealthCheckTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_NO_UPSTREAM_STATUS", "503")); err != nil || (i != 502 && i != 503) {
+		log.Fatal("PROXY_NO_UPSTREAM_STATUS must be 502 or 503")
+	} else {
+		c.ProxyNoUpstreamStatus = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MAX_CONCURRENT", "0")); err != nil || i < 0 {
+		log.Fatal("PROXY_MAX_CONCURRENT must be a non-negative number")
+	} else {
+		c.ProxyMaxConcurrent = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MAX_CONCURRENT_PER_UPSTREAM", "0")); err != nil || i < 0 {
+		log.Fatal("PROXY_MAX_CONCURRENT_PER_UPSTREAM must be a non-negative number")
+	} else {
+		c.ProxyMaxConcurrentPerUpstream = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_QUEUE_SIZE", "100")); err != nil || i < 0 {
+		log.Fatal("PROXY_QUEUE_SIZE must be a non-negative number")
+	} else {
+		c.ProxyQueueSize = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_QUEUE_TIMEOUT", "5")); err != nil || i < 1 {
+		log.Fatal("PROXY_QUEUE_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.ProxyQueueTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_RETRIES", "0")); err != nil || i < 0 {
+		log.Fatal("PROXY_RETRIES must be a non-negative number")
+	} else {
+		c.ProxyRetries = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_RETRY_BACKOFF", "100")); err != nil || i < 0 {
+		log.Fatal("PROXY_RETRY_BACKOFF must be a non-negative number of milliseconds")
+	} else {
+		c.ProxyRetryBackoff = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_CIRCUIT_BREAKER_THRESHOLD", "0")); err != nil || i < 0 {
+		log.Fatal("PROXY_CIRCUIT_BREAKER_THRESHOLD must be a non-negative number")
+	} else {
+		c.ProxyCircuitBreakerThreshold = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_CIRCUIT_BREAKER_COOLDOWN", "30")); err != nil || i < 1 {
+		log.Fatal("PROXY_CIRCUIT_BREAKER_COOLDOWN must be a positive number of seconds")
+	} else {
+		c.ProxyCircuitBreakerCooldown = time.Duration(i)
 	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_DIAL_TIMEOUT", "30")); err != nil || i < 1 {
+		log.Fatal("PROXY_DIAL_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.ProxyDialTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_TLS_HANDSHAKE_TIMEOUT", "10")); err != nil || i < 1 {
+		log.Fatal("PROXY_TLS_HANDSHAKE_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.ProxyTLSHandshakeTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_RESPONSE_HEADER_TIMEOUT", "0")); err != nil || i < 0 {
+		log.Fatal("PROXY_RESPONSE_HEADER_TIMEOUT must be a non-negative number of seconds")
+	} else {
+		c.ProxyResponseHeaderTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_REQUEST_TIMEOUT", "0")); err != nil || i < 0 {
+		log.Fatal("PROXY_REQUEST_TIMEOUT must be a non-negative number of seconds")
+	} else {
+		c.ProxyRequestTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_KEEP_ALIVE", "30")); err != nil || i < 0 {
+		log.Fatal("PROXY_KEEP_ALIVE must be a non-negative number of seconds")
+	} else {
+		c.ProxyKeepAlive = time.Duration(i)
+	}
+	c.ProxyDisableKeepAlives = (c._GetEnv("PROXY_DISABLE_KEEP_ALIVES", "0") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MAX_IDLE_CONNS", "100")); err != nil || i < 0 {
+		log.Fatal("PROXY_MAX_IDLE_CONNS must be a non-negative number")
+	} else {
+		c.ProxyMaxIdleConns = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MAX_IDLE_CONNS_PER_HOST", "100")); err != nil || i < 0 {
+		log.Fatal("PROXY_MAX_IDLE_CONNS_PER_HOST must be a non-negative number")
+	} else {
+		c.ProxyMaxIdleConnsPerHost = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_MAX_CONNS_PER_HOST", "0")); err != nil || i < 0 {
+		log.Fatal("PROXY_MAX_CONNS_PER_HOST must be a non-negative number")
+	} else {
+		c.ProxyMaxConnsPerHost = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_IDLE_CONN_TIMEOUT", "90")); err != nil || i < 0 {
+		log.Fatal("PROXY_IDLE_CONN_TIMEOUT must be a non-negative number of seconds")
+	} else {
+		c.ProxyIdleConnTimeout = time.Duration(i)
+	}
+	c.ProxyTLSClientCert = c._GetEnv("PROXY_TLS_CLIENT_CERT", "")
+	c.ProxyTLSClientKey = c._GetEnv("PROXY_TLS_CLIENT_KEY", "")
+	if (c.ProxyTLSClientCert == "") != (c.ProxyTLSClientKey == "") {
+		log.Fatal("PROXY_TLS_CLIENT_CERT and PROXY_TLS_CLIENT_KEY must be set together")
+	}
+	c.ProxyTLSCA = c._GetEnv("PROXY_TLS_CA", "")
+	c.ProxyTLSPins = strings.Fields(c._GetEnv("PROXY_TLS_PINS", ""))
+	c.ProxyTLSServerName = c._GetEnv("PROXY_TLS_SERVER_NAME", "")
+	c.ProxyHostHeader = c._GetEnv("PROXY_HOST_HEADER", "")
+	c.ProxyIdentityHeaders = make(map[string]string)
+	for _, entry := range strings.Fields(c._GetEnv("PROXY_IDENTITY_HEADERS", "")) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !_IsSignedIdentityHeader(parts[0]) {
+			log.Fatal("PROXY_IDENTITY_HEADERS entries must have the format <X-Auth-* header>=[<header>]")
+		}
+		c.ProxyIdentityHeaders[http.CanonicalHeaderKey(parts[0])] = parts[1]
+	}
+	basicAuth := c._GetEnv("PROXY_UPSTREAM_BASIC_AUTH", "")
+	bearerToken := c._GetEnv("PROXY_UPSTREAM_BEARER_TOKEN", "")
+	if basicAuth != "" && bearerToken != "" {
+		log.Fatal("PROXY_UPSTREAM_BASIC_AUTH and PROXY_UPSTREAM_BEARER_TOKEN must not be set together")
+	}
+	c.ProxyUpstreamCredentials = ""
+	if basicAuth != "" {
+		if !strings.Contains(basicAuth, ":") {
+			log.Fatal("PROXY_UPSTREAM_BASIC_AUTH must be in the format <user>:<password>")
+		}
+		c.ProxyUpstreamCredentials = "Basic " + base64.StdEncoding.EncodeToString([]byte(basicAuth))
+	} else if bearerToken != "" {
+		c.ProxyUpstreamCredentials = "Bearer " + bearerToken
+	}
+	c.ProxyUpstreamAuthHeader = http.CanonicalHeaderKey(c._GetEnv("PROXY_UPSTREAM_AUTH_HEADER", "Authorization"))
+	if i, err := strconv.Atoi(c._GetEnv("SERVER_READ_TIMEOUT", "15")); err != nil || i < 1 {
+		log.Fatal("SERVER_READ_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.ServerReadTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("SERVER_WRITE_TIMEOUT", "15")); err != nil || i < 0 {
+		log.Fatal("SERVER_WRITE_TIMEOUT must be a non-negative number of seconds")
+	} else {
+		c.ServerWriteTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("SERVER_IDLE_TIMEOUT", "60")); err != nil || i < 1 {
+		log.Fatal("SERVER_IDLE_TIMEOUT must be a positive number of seconds")
+	} else {
+		c.ServerIdleTimeout = time.Duration(i)
+	}
+	if i, err := strconv.Atoi(c._GetEnv("USER_CACHE_TTL", "0")); err != nil || i < 0 {
+		log.Fatal("USER_CACHE_TTL must be a non-negative number of seconds")
+	} else {
+		c.UserCacheTTL = time.Duration(i)
+	}
+	c.ProxyRulesFile = c._GetEnv("PROXY_RULES_FILE", "")
+	if rules, err := ParseProxyRules(c._GetEnv("PROXY_TOKEN_QUERY_ROUTES", "")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.ProxyTokenQueryRoutes = rules
+	}
+	c.ProxyTokenCookie = c._GetEnv("PROXY_TOKEN_COOKIE", "")
+	c.ProxyLoginURL = c._GetEnv("PROXY_LOGIN_URL", "")
+	if c.ProxyLoginURL != "" {
+		if _, err := url.Parse(c.ProxyLoginURL); err != nil {
+			log.Fatal(err)
+		}
+	}
+	c.ProxyLoginReturnParam = c._GetEnv("PROXY_LOGIN_RETURN_PARAM", "return_to")
+	c.StaticDir = c._GetEnv("STATIC_DIR", "")
+	if rules, err := ParseProxyRules(c._GetEnv("STATIC_PROXY_ROUTES", "")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.StaticProxyRoutes = rules
+	}
+	if c.StaticDir != "" && len(c.StaticProxyRoutes) == 0 {
+		log.Fatal("STATIC_DIR requires STATIC_PROXY_ROUTES")
+	}
+	c.SignedURLKey = c._GetEnv("SIGNED_URL_KEY", "")
+	if c.SignedURLKey != "" && len(c.SignedURLKey) < 32 {
+		log.Fatal("SIGNED_URL_KEY must be at least 32 characters long")
+	}
+	if i, err := strconv.Atoi(c._GetEnv("SIGNED_URL_MAX_TTL", "86400")); err != nil || i < 1 {
+		log.Fatal("SIGNED_URL_MAX_TTL must be a positive number of seconds")
+	} else {
+		c.SignedURLMaxTTL = i
+	}
+	if trustedProxies, err := ParseIPRanges(c._GetEnv("PROXY_TRUSTED_PROXIES", "")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.ProxyTrustedProxies = trustedProxies
+	}
+	c.ProxyForwardedHeaders = c._GetEnv("PROXY_FORWARDED_HEADERS", ForwardedHeadersTrusted)
+	if c.ProxyForwardedHeaders != ForwardedHeadersTrusted && c.ProxyForwardedHeaders != ForwardedHeadersPassthrough && c.ProxyForwardedHeaders != ForwardedHeadersSanitize {
+		log.Fatal("PROXY_FORWARDED_HEADERS must be one of: trusted, passthrough, sanitize")
+	}
+	if ranges, err := ParseIPRanges(c._GetEnv("IP_ALLOWLIST", "")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.IPAllowlist = ranges
+	}
+	if ranges, err := ParseIPRanges(c._GetEnv("IP_DENYLIST", "")); err != nil {
+		log.Fatal(err)
+	} else {
+		c.IPDenylist = ranges
+	}
+	c.IPAccessRules = make([]*IPAccessRule, 0)
+	for _, entry := range strings.Split(c._GetEnv("IP_ACCESS_RULES", ""), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		rule, err := ParseIPAccessRule(entry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.IPAccessRules = append(c.IPAccessRules, rule)
+	}
+	c.GeoIPDatabase = c._GetEnv("GEOIP_DATABASE", "")
+	c.GeoIPAllowCountries = ParseCountryCodes(c._GetEnv("GEOIP_ALLOW_COUNTRIES", ""))
+	c.GeoIPDenyCountries = ParseCountryCodes(c._GetEnv("GEOIP_DENY_COUNTRIES", ""))
+	c.GeoIPAccessRules = make([]*CountryAccessRule, 0)
+	for _, entry := range strings.Split(c._GetEnv("GEOIP_ACCESS_RULES", ""), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		rule, err := ParseCountryAccessRule(entry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.GeoIPAccessRules = append(c.GeoIPAccessRules, rule)
+	}
+	if c.GeoIPDatabase == "" && (len(c.GeoIPAllowCountries) > 0 || len(c.GeoIPDenyCountries) > 0 || len(c.GeoIPAccessRules) > 0) {
+		log.Fatal("GEOIP_ALLOW_COUNTRIES, GEOIP_DENY_COUNTRIES and GEOIP_ACCESS_RULES require GEOIP_DATABASE")
+	}
+	c.GeoIPCountryHeader = c._GetEnv("GEOIP_COUNTRY_HEADER", "X-Auth-Country")
+	c.IdentityHeaderSigningKey = c._GetEnv("IDENTITY_HEADER_SIGNING_KEY", "")
+	c.ProxyForwardUserHeaders = (c._GetEnv("PROXY_FORWARD_USER_HEADERS", "1") == "1")
+	c.ProxyAuthzRulesFile = c._GetEnv("PROXY_AUTHZ_RULES_FILE", "")
+	if rules, err := c.LoadRouteRules(); err != nil {
+		log.Fatal(err)
+	} else {
+		_SetRouteRules(rules)
+	}
+	c.ProxyRulesDryRun = (c._GetEnv("PROXY_RULES_DRY_RUN", "0") == "1")
+	c.ProxyHeaderRules = make([]*HeaderRule, 0)
+	for _, entry := range strings.Split(c._GetEnv("PROXY_HEADER_RULES", ""), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		rule, err := ParseHeaderRule(entry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.ProxyHeaderRules = append(c.ProxyHeaderRules, rule)
+	}
+	c.ProxyResponseHeaderDenylist = strings.Fields(c._GetEnv("PROXY_RESPONSE_HEADER_DENYLIST", "Server X-Powered-By X-AspNet-Version X-AspNetMvc-Version"))
+	for i, name := range c.ProxyResponseHeaderDenylist {
+		if strings.HasSuffix(name, "*") {
+			c.ProxyResponseHeaderDenylist[i] = http.CanonicalHeaderKey(strings.TrimSuffix(name, "*")) + "*"
+		} else {
+			c.ProxyResponseHeaderDenylist[i] = http.CanonicalHeaderKey(name)
+		}
+	}
+	var err error
+	if c.ProxyRateLimitUser, err = ParseRateLimit(c._GetEnv("PROXY_RATE_LIMIT_USER", "")); err != nil {
+		log.Fatal(err)
+	}
+	if c.ProxyRateLimitAnonymous, err = ParseRateLimit(c._GetEnv("PROXY_RATE_LIMIT_ANONYMOUS", "")); err != nil {
+		log.Fatal(err)
+	}
+	c.ProxyRateLimitStore = c._GetEnv("PROXY_RATE_LIMIT_STORE", RateLimitStoreMemory)
+	if c.ProxyRateLimitStore != RateLimitStoreMemory && c.ProxyRateLimitStore != RateLimitStoreRedis {
+		log.Fatal("PROXY_RATE_LIMIT_STORE must be one of: memory, redis")
+	}
+	c.ProxyRateLimitRoutes = make([]*RateLimitRoute, 0)
+	for _, entry := range strings.Split(c._GetEnv("PROXY_RATE_LIMIT_ROUTES", ""), ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		route, err := ParseRateLimitRoute(entry)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.ProxyRateLimitRoutes = append(c.ProxyRateLimitRoutes, route)
+	}
+	c.ProxyCache = c._GetEnv("PROXY_CACHE", "")
+	if c.ProxyCache != "" && c.ProxyCache != ProxyCacheMemory && c.ProxyCache != ProxyCacheRedis {
+		log.Fatal("PROXY_CACHE must be one of: memory, redis")
+	}
+	c.ProxyCacheRoutes = strings.Split(strings.TrimSpace(c._GetEnv("PROXY_CACHE_ROUTES", "/")), ":")
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_CACHE_MAX_ENTRIES", "1000")); err != nil || i < 1 {
+		log.Fatal("PROXY_CACHE_MAX_ENTRIES must be a positive number")
+	} else {
+		c.ProxyCacheMaxEntries = i
+	}
+	if i, err := strconv.Atoi(c._GetEnv("PROXY_CACHE_MAX_BODY_SIZE", "1048576")); err != nil || i < 0 {
+		log.Fatal("PROXY_CACHE_MAX_BODY_SIZE must be a non-negative number of bytes")
+	} else {
+		c.ProxyCacheMaxBodySize = i
+	}
+	c.RedisURL = c._GetEnv("REDIS_URL", "redis://localhost:6379/0")
+	c.EnableCompression = (c._GetEnv("COMPRESSION_ENABLE", "0") == "1")
+	if i, err := strconv.Atoi(c._GetEnv("COMPRESSION_MIN_SIZE", "1024")); err != nil || i < 0 {
+		log.Fatal("COMPRESSION_MIN_SIZE must be a non-negative number of bytes")
+	} else {
+		c.CompressionMinSize = i
+	}
+	c.CompressionContentTypes = strings.Fields(strings.ToLower(c._GetEnv("COMPRESSION_TYPES", "text/* application/json application/javascript application/xml image/svg+xml")))
+	c.EnableBasicAuth = (c._GetEnv("PROXY_BASIC_AUTH_ENABLE", "0") == "1")
+	c.BasicAuthRealm = c._GetEnv("PROXY_BASIC_AUTH_REALM", "JWT Auth Proxy")
 	if i, err := strconv.Atoi(c._GetEnv("ACCESS_TOKEN_LIFETIME", "5")); err != nil {
 		log.Fatal(err)
 	} else {
@@ -144,6 +1083,11 @@ func (c *Config) ReadConfig() {
 	} else {
 		c.PendingActionLifetime = time.Duration(i)
 	}
+	if i, err := strconv.Atoi(c._GetEnv("INVITATION_LIFETIME", strconv.Itoa(7*24*60))); err != nil {
+		log.Fatal(err)
+	} else {
+		c.InvitationLifetime = time.Duration(i)
+	}
 }
 
 func (c *Config) _GetEnv(key, defaultValue string) string {
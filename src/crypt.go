@@ -4,24 +4,18 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"io"
 )
 
 func Encrypt(passphrase, s string) (string, error) {
-	c, err := aes.NewCipher([]byte(passphrase))
-	if err != nil {
-		return "", err
-	}
-	gcm, err := cipher.NewGCM(c)
+	res, err := EncryptBytes(passphrase, []byte(s))
 	if err != nil {
 		return "", err
 	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
-	}
-	res := gcm.Seal(nonce, nonce, []byte(s), nil)
 	return base64.StdEncoding.EncodeToString(res), nil
 }
 
@@ -30,22 +24,50 @@ func Decrypt(passphrase, s string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	c, err := aes.NewCipher([]byte(passphrase))
+	res, err := DecryptBytes(passphrase, s2)
 	if err != nil {
 		return "", err
 	}
+	return string(res), nil
+}
+
+// EncryptBytes encrypts data with AES-GCM, prepending the random nonce. The passphrase must be 16, 24 or 32 bytes long.
+func EncryptBytes(passphrase string, data []byte) ([]byte, error) {
+	c, err := aes.NewCipher([]byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
 	gcm, err := cipher.NewGCM(c)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	nonceSize := gcm.NonceSize()
-	if len(s2) < nonceSize {
-		return "", err
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
 	}
-	nonce, s2 := s2[:nonceSize], s2[nonceSize:]
-	res, err := gcm.Open(nil, []byte(nonce), []byte(s2), nil)
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// DecryptBytes decrypts data encrypted by EncryptBytes
+func DecryptBytes(passphrase string, data []byte) ([]byte, error) {
+	c, err := aes.NewCipher([]byte(passphrase))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(res), nil
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("Encrypted data too short")
+	}
+	nonce, data := data[:nonceSize], data[nonceSize:]
+	return gcm.Open(nil, nonce, data, nil)
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a random token, which is stored instead of the token itself
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
@@ -47,3 +47,7 @@ func TestEncryptDecryptShortPassphrase(t *testing.T) {
 		t.Fatal("Expected minimum error due to short key length (15 bytes)")
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	checkTestString(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", HashToken("hello"))
+}
@@ -2,20 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type Database struct {
-	Client   *mongo.Client
-	Database *mongo.Database
+	Client           *mongo.Client
+	Database         *mongo.Database
+	CollectionPrefix string
 }
 
 var _databaseInstance *Database
@@ -30,7 +36,7 @@ func GetDatatabase() *Database {
 
 func (db *Database) connectMongoDb(url, dbName string) {
 	log.Println("Connecting to MongoDB at", url, "...")
-	clientOptions := options.Client().ApplyURI(url)
+	clientOptions := CreateMongoClientOptions(url)
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
 		log.Fatal(err)
@@ -44,6 +50,84 @@ func (db *Database) connectMongoDb(url, dbName string) {
 	log.Println("Connected to MongoDB!")
 	db.Client = client
 	db.Database = client.Database(dbName)
+	db.CollectionPrefix = GetConfig().MongoDbCollectionPrefix
+}
+
+// WithDatabase returns another database and collection prefix on the same connection, i.e. those of a tenant
+func (db *Database) WithDatabase(dbName, collectionPrefix string) *Database {
+	return &Database{
+		Client:           db.Client,
+		Database:         db.Client.Database(dbName),
+		CollectionPrefix: collectionPrefix,
+	}
+}
+
+// CreateMongoClientOptions applies the MONGO_DB_* settings to the options parsed from the connection URL.
+// Settings left empty keep the values from the URL.
+func CreateMongoClientOptions(url string) *options.ClientOptions {
+	c := GetConfig()
+	clientOptions := options.Client().ApplyURI(url)
+	if c.MongoDbUsername != "" || c.MongoDbAuthMechanism != "" {
+		clientOptions.SetAuth(options.Credential{
+			AuthMechanism: c.MongoDbAuthMechanism,
+			AuthSource:    c.MongoDbAuthSource,
+			Username:      c.MongoDbUsername,
+			Password:      c.MongoDbPassword,
+			PasswordSet:   c.MongoDbPassword != "",
+		})
+	}
+	if c.MongoDbTLSEnable {
+		clientOptions.SetTLSConfig(CreateMongoTLSConfig())
+	}
+	if c.MongoDbReplicaSet != "" {
+		clientOptions.SetReplicaSet(c.MongoDbReplicaSet)
+	}
+	if c.MongoDbReadPreference != "" {
+		mode, _ := readpref.ModeFromString(c.MongoDbReadPreference)
+		rp, err := readpref.New(mode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		clientOptions.SetReadPreference(rp)
+	}
+	clientOptions.SetMinPoolSize(c.MongoDbMinPoolSize)
+	clientOptions.SetMaxPoolSize(c.MongoDbMaxPoolSize)
+	clientOptions.SetConnectTimeout(c.MongoDbConnectTimeout * time.Second)
+	clientOptions.SetServerSelectionTimeout(c.MongoDbServerSelectionTimeout * time.Second)
+	return clientOptions
+}
+
+// CreateMongoTLSConfig builds the TLS configuration for connections to MongoDB:
+// a custom CA bundle and an optional client certificate, i.e. for MONGODB-X509 authentication
+func CreateMongoTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: GetConfig().MongoDbTLSInsecure,
+	}
+	if GetConfig().MongoDbTLSClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(GetConfig().MongoDbTLSClientCert, GetConfig().MongoDbTLSClientKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if GetConfig().MongoDbTLSCA != "" {
+		caCert, err := ioutil.ReadFile(GetConfig().MongoDbTLSCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			log.Fatal("No certificates found in MONGO_DB_TLS_CA")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig
+}
+
+// Collection returns a collection of the database, prefixed with MONGO_DB_COLLECTION_PREFIX
+func (db *Database) Collection(name string) *mongo.Collection {
+	return db.Database.Collection(db.CollectionPrefix + name)
 }
 
 func (db *Database) disconnect() {
@@ -61,3 +145,79 @@ func (db *Database) GetIDFilter(id string) primitive.M {
 	objID := db.GetObjectID(id)
 	return bson.M{"_id": objID}
 }
+
+func init() {
+	RegisterStorageDriver(StorageMongoDB, &MongoStorageDriver{})
+}
+
+// MongoStorageDriver stores data in the MongoDB database configured with MONGO_DB_URL and MONGO_DB_NAME
+type MongoStorageDriver struct {
+}
+
+func (d *MongoStorageDriver) Connect() {
+	GetDatatabase().connectMongoDb(GetConfig().MongoDbURL, GetConfig().MongoDbName)
+	ctx, cancel := context.WithTimeout(context.Background(), schemaMigrationLockTimeout)
+	defer cancel()
+	if err := MigrateSchema(ctx, GetDatatabase(), schemaMigrations, GetConfig().MongoDbMigrate); err != nil {
+		log.Fatal(err)
+	}
+	if GetConfig().MongoDbEnsureIndexes {
+		EnsureMongoIndexes(GetDatatabase())
+	}
+}
+
+func (d *MongoStorageDriver) Disconnect() {
+	GetDatatabase().disconnect()
+}
+
+func (d *MongoStorageDriver) NewUserStore() UserStore {
+	return NewMongoUserStore()
+}
+
+func (d *MongoStorageDriver) NewRefreshTokenStore() RefreshTokenStore {
+	return NewMongoRefreshTokenStore()
+}
+
+func (d *MongoStorageDriver) NewPendingActionStore() PendingActionStore {
+	return NewMongoPendingActionStore()
+}
+
+func (d *MongoStorageDriver) NewTrustedDeviceStore() TrustedDeviceStore {
+	return NewMongoTrustedDeviceStore()
+}
+
+func (d *MongoStorageDriver) NewAPIKeyStore() APIKeyStore {
+	return NewMongoAPIKeyStore()
+}
+
+func (d *MongoStorageDriver) NewDeviceCodeStore() DeviceCodeStore {
+	return NewMongoDeviceCodeStore()
+}
+
+func (d *MongoStorageDriver) NewInvitationStore() InvitationStore {
+	return NewMongoInvitationStore()
+}
+
+func (d *MongoStorageDriver) NewClientCertificateStore() ClientCertificateStore {
+	return NewMongoClientCertificateStore()
+}
+
+func (d *MongoStorageDriver) NewLinkedIdentityStore() LinkedIdentityStore {
+	return NewMongoLinkedIdentityStore()
+}
+
+func (d *MongoStorageDriver) NewOrganizationStore() OrganizationStore {
+	return NewMongoOrganizationStore()
+}
+
+func (d *MongoStorageDriver) NewAuditLogStore() AuditLogStore {
+	return NewMongoAuditLogStore()
+}
+
+func (d *MongoStorageDriver) NewLoginStatsStore() LoginStatsStore {
+	return NewMongoLoginStatsStore()
+}
+
+func (d *MongoStorageDriver) NewMailQueueStore() MailQueueStore {
+	return NewMongoMailQueueStore()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// DeviceAuthorize handles /device/code requests (RFC 8628, section 3.1)
+func (router *AuthRouter) DeviceAuthorize(w http.ResponseWriter, r *http.Request) {
+	dc := &DeviceCode{
+		DeviceCode: GetDeviceCodeRepository().FindUnusedDeviceCode(),
+		UserCode:   GetDeviceCodeRepository().FindUnusedUserCode(),
+		Status:     DeviceCodeStatusPending,
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * GetConfig().DeviceCodeLifetime),
+	}
+	if dc.DeviceCode == "" || dc.UserCode == "" {
+		log.Println("Could not generate device code")
+		SendInternalServerError(w)
+		return
+	}
+	GetDeviceCodeRepository().Create(dc)
+	SendJSON(w, &DeviceAuthorizationResponse{
+		DeviceCode:              dc.DeviceCode,
+		UserCode:                dc.UserCode,
+		VerificationURI:         GetConfig().DeviceVerificationURI,
+		VerificationURIComplete: GetConfig().DeviceVerificationURI + "?user_code=" + dc.UserCode,
+		ExpiresIn:               int(dc.ExpiryDate.Sub(dc.CreateDate).Seconds()),
+		Interval:                int(GetConfig().DevicePollInterval),
+	})
+}
+
+// DeviceVerify handles /device/verify requests, performed by a logged in user approving or denying a device
+func (router *AuthRouter) DeviceVerify(w http.ResponseWriter, r *http.Request) {
+	var data DeviceVerifyRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid device verification attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		log.Println("Invalid device verification attempt: invalid UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	dc := GetDeviceCodeRepository().GetByUserCode(data.UserCode)
+	if dc == nil || dc.Status != DeviceCodeStatusPending {
+		log.Println("Invalid device verification attempt: invalid user code for UserID", user.ID.String())
+		SendNotFound(w)
+		return
+	}
+	dc.UserID = user.ID
+	if data.Approve {
+		dc.Status = DeviceCodeStatusApproved
+	} else {
+		dc.Status = DeviceCodeStatusDenied
+	}
+	GetDeviceCodeRepository().Update(dc)
+	SendUpdated(w)
+}
+
+// DeviceToken handles /device/token requests polled by the device (RFC 8628, section 3.4)
+func (router *AuthRouter) DeviceToken(w http.ResponseWriter, r *http.Request) {
+	var data DeviceTokenRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid device token attempt: failed unmarshalling request")
+		SendError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	dc := GetDeviceCodeRepository().GetByDeviceCode(data.DeviceCode)
+	if dc == nil {
+		log.Println("Invalid device token attempt: invalid device code")
+		SendError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if dc.ExpiryDate.Before(time.Now()) {
+		GetDeviceCodeRepository().Delete(dc)
+		SendError(w, http.StatusBadRequest, "expired_token")
+		return
+	}
+	lastPollDate := dc.LastPollDate
+	dc.LastPollDate = time.Now()
+	GetDeviceCodeRepository().Update(dc)
+	if dc.LastPollDate.Before(lastPollDate.Add(time.Duration(time.Second) * GetConfig().DevicePollInterval)) {
+		SendError(w, http.StatusBadRequest, "slow_down")
+		return
+	}
+	switch dc.Status {
+	case DeviceCodeStatusPending:
+		SendError(w, http.StatusBadRequest, "authorization_pending")
+		return
+	case DeviceCodeStatusDenied:
+		GetDeviceCodeRepository().Delete(dc)
+		SendError(w, http.StatusBadRequest, "access_denied")
+		return
+	}
+	GetDeviceCodeRepository().Delete(dc)
+	user := GetUserRepository().GetOne(dc.UserID.String())
+	if user == nil || !user.Confirmed || !user.Enabled || user.Deleted {
+		log.Println("Invalid device token attempt: invalid or disabled UserID", dc.UserID.String())
+		SendError(w, http.StatusBadRequest, "access_denied")
+		return
+	}
+	log.Println("Successful device authorization for UserID", user.ID.String())
+	refreshToken := router._CreateRefreshToken(r, user)
+	accessToken := router._CreateAccessToken(user)
+	SendJSON(w, &LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken.Token,
+	})
+}
+
+// DeviceAuthorizationResponse holds the response payload for device authorization requests
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceVerifyRequest holds the POST payload for device verification requests
+type DeviceVerifyRequest struct {
+	UserCode string `json:"user_code" validate:"required"`
+	Approve  bool   `json:"approve"`
+}
+
+// DeviceTokenRequest holds the POST payload for device token requests
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code" validate:"required"`
+}
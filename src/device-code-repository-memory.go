@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryDeviceCodeStore stores device codes in memory, see STORAGE
+type MemoryDeviceCodeStore struct {
+	codes *MemoryCollection[DeviceCode]
+}
+
+func NewMemoryDeviceCodeStore() *MemoryDeviceCodeStore {
+	return &MemoryDeviceCodeStore{codes: NewMemoryCollection[DeviceCode]()}
+}
+
+func (r *MemoryDeviceCodeStore) _ByID(id primitive.ObjectID) func(*DeviceCode) bool {
+	return func(c *DeviceCode) bool {
+		return c.ID == id
+	}
+}
+
+func (r *MemoryDeviceCodeStore) Create(u *DeviceCode) {
+	u.ID = primitive.NewObjectID()
+	r.codes.Insert(u)
+}
+
+func (r *MemoryDeviceCodeStore) GetByDeviceCode(deviceCode string) *DeviceCode {
+	return r.codes.FindOne(func(c *DeviceCode) bool {
+		return c.DeviceCode == deviceCode
+	})
+}
+
+func (r *MemoryDeviceCodeStore) GetByUserCode(userCode string) *DeviceCode {
+	result := r.codes.FindOne(func(c *DeviceCode) bool {
+		return c.UserCode == userCode
+	})
+	if result == nil {
+		return nil
+	}
+	if result.ExpiryDate.Before(time.Now()) {
+		r.Delete(result)
+		return nil
+	}
+	return result
+}
+
+func (r *MemoryDeviceCodeStore) Update(u *DeviceCode) {
+	r.codes.Replace(r._ByID(u.ID), u)
+}
+
+func (r *MemoryDeviceCodeStore) Delete(u *DeviceCode) {
+	r.codes.Delete(r._ByID(u.ID))
+}
+
+func (r *MemoryDeviceCodeStore) DeleteAllForUser(userID string) {
+	r.codes.Delete(func(c *DeviceCode) bool {
+		return c.UserID == UserID(userID)
+	})
+}
+
+func (r *MemoryDeviceCodeStore) CleanUp() {
+	now := time.Now()
+	r.codes.Delete(func(c *DeviceCode) bool {
+		return !c.ExpiryDate.After(now)
+	})
+}
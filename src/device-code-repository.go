@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	guuid "github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const DeviceCodeStatusPending = 1
+const DeviceCodeStatusApproved = 2
+const DeviceCodeStatusDenied = 3
+
+// userCodeChars excludes vowels and ambiguous characters (RFC 8628, section 6.1)
+const userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+
+type DeviceCode struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID       UserID             `json:"userId" bson:"userId,omitempty"`
+	DeviceCode   string             `json:"deviceCode" bson:"deviceCode"`
+	UserCode     string             `json:"userCode" bson:"userCode"`
+	Status       int                `json:"status" bson:"status"`
+	CreateDate   time.Time          `json:"createDate" bson:"createDate"`
+	ExpiryDate   time.Time          `json:"expiryDate" bson:"expiryDate"`
+	LastPollDate time.Time          `json:"lastPollDate" bson:"lastPollDate"`
+}
+
+// DeviceCodeStore persists device codes, see STORAGE
+type DeviceCodeStore interface {
+	Create(u *DeviceCode)
+	GetByDeviceCode(deviceCode string) *DeviceCode
+	GetByUserCode(userCode string) *DeviceCode
+	Update(u *DeviceCode)
+	Delete(u *DeviceCode)
+	DeleteAllForUser(userID string)
+	CleanUp()
+}
+
+// DeviceCodeRepository manages device codes, storing them in the DeviceCodeStore of the configured storage backend
+type DeviceCodeRepository struct {
+	DeviceCodeStore
+}
+
+var _deviceCodeRepositoryInstance *DeviceCodeRepository
+var _deviceCodeRepositoryOnce sync.Once
+
+func GetDeviceCodeRepository() *DeviceCodeRepository {
+	_deviceCodeRepositoryOnce.Do(func() {
+		_deviceCodeRepositoryInstance = &DeviceCodeRepository{GetStorageDriver().NewDeviceCodeStore()}
+	})
+	return _deviceCodeRepositoryInstance
+}
+
+type MongoDeviceCodeStore struct {
+}
+
+func NewMongoDeviceCodeStore() *MongoDeviceCodeStore {
+	return &MongoDeviceCodeStore{}
+}
+
+func (r *MongoDeviceCodeStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("device_codes")
+}
+
+func (r *MongoDeviceCodeStore) Create(u *DeviceCode) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+// GetByDeviceCode returns expired device codes, too, so pollers can be told about the expiry
+func (r *MongoDeviceCodeStore) GetByDeviceCode(deviceCode string) *DeviceCode {
+	var result DeviceCode
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"deviceCode": deviceCode}).Decode(&result)
+	if err != nil {
+		return nil
+	}
+	return &result
+}
+
+// GetByUserCode looks up an unexpired device code by the user code as typed by the user
+func (r *DeviceCodeRepository) GetByUserCode(userCode string) *DeviceCode {
+	return r.DeviceCodeStore.GetByUserCode(r.NormalizeUserCode(userCode))
+}
+
+func (r *MongoDeviceCodeStore) GetByUserCode(userCode string) *DeviceCode {
+	var result DeviceCode
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"userCode": userCode}).Decode(&result)
+	if err != nil {
+		return nil
+	}
+	if result.ExpiryDate.Before(time.Now()) {
+		r.Delete(&result)
+		return nil
+	}
+	return &result
+}
+
+func (r *MongoDeviceCodeStore) Update(u *DeviceCode) {
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": u})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoDeviceCodeStore) Delete(u *DeviceCode) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoDeviceCodeStore) DeleteAllForUser(userID string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *DeviceCodeRepository) FindUnusedDeviceCode() string {
+	var token string = ""
+	for i := 1; i <= 20 && token == ""; i++ {
+		token = guuid.New().String()
+		if r.GetByDeviceCode(token) != nil {
+			token = ""
+		}
+	}
+	return token
+}
+
+// FindUnusedUserCode returns a short code in the format XXXX-XXXX for users to type in
+func (r *DeviceCodeRepository) FindUnusedUserCode() string {
+	var code string = ""
+	for i := 1; i <= 20 && code == ""; i++ {
+		var b strings.Builder
+		for j := 0; j < 8; j++ {
+			if j == 4 {
+				b.WriteRune('-')
+			}
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeChars))))
+			if err != nil {
+				log.Println(err)
+				return ""
+			}
+			b.WriteByte(userCodeChars[n.Int64()])
+		}
+		code = b.String()
+		if r.GetByUserCode(code) != nil {
+			code = ""
+		}
+	}
+	return code
+}
+
+// NormalizeUserCode makes user input case insensitive and tolerant of missing dashes
+func (r *DeviceCodeRepository) NormalizeUserCode(userCode string) string {
+	s := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(userCode), "-", ""))
+	if len(s) != 8 {
+		return s
+	}
+	return s[:4] + "-" + s[4:]
+}
+
+func (r *MongoDeviceCodeStore) CleanUp() {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"expiryDate": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceCodeUserCodeFormat(t *testing.T) {
+	clearTestDB()
+
+	userCode := GetDeviceCodeRepository().FindUnusedUserCode()
+	if len(userCode) != 9 || userCode[4] != '-' {
+		t.Fatalf("Expected user code in format XXXX-XXXX, got '%s'", userCode)
+	}
+	checkTestString(t, "BCDF-GHJK", GetDeviceCodeRepository().NormalizeUserCode(" bcdfghjk "))
+	checkTestString(t, "BCDF-GHJK", GetDeviceCodeRepository().NormalizeUserCode("bcdf-ghjk"))
+}
+
+func TestDeviceCodeGetByUserCodeExpired(t *testing.T) {
+	clearTestDB()
+
+	dc := &DeviceCode{
+		DeviceCode: GetDeviceCodeRepository().FindUnusedDeviceCode(),
+		UserCode:   GetDeviceCodeRepository().FindUnusedUserCode(),
+		Status:     DeviceCodeStatusPending,
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
+	}
+	GetDeviceCodeRepository().Create(dc)
+
+	if GetDeviceCodeRepository().GetByUserCode(dc.UserCode) != nil {
+		t.Error("Expected expired device code to be nil")
+	}
+}
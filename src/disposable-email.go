@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultDisposableEmailDomains is a short list of well-known throwaway email providers
+var defaultDisposableEmailDomains = []string{
+	"10minutemail.com", "20minutemail.com", "33mail.com", "burnermail.io", "discard.email", "dispostable.com",
+	"emailondeck.com", "fakeinbox.com", "getairmail.com", "getnada.com", "guerrillamail.biz", "guerrillamail.com",
+	"guerrillamail.de", "guerrillamail.net", "guerrillamail.org", "guerrillamailblock.com", "harakirimail.com",
+	"incognitomail.org", "jetable.org", "mailcatch.com", "maildrop.cc", "mailinator.com", "mailinator.net",
+	"mailnesia.com", "mintemail.com", "mohmal.com", "mytemp.email", "mytrashmail.com", "sharklasers.com",
+	"spam4.me", "spamgourmet.com", "temp-mail.org", "tempail.com", "tempinbox.com", "tempmail.com",
+	"tempmailo.com", "tempr.email", "throwawaymail.com", "trashmail.com", "trashmail.de", "wegwerfmail.de",
+	"yopmail.com", "yopmail.fr", "yopmail.net",
+}
+
+var (
+	_disposableEmailFetchedDomains []string
+	_disposableEmailMutex          sync.RWMutex
+)
+
+// IsDisposableEmail checks if an email address belongs to a disposable email provider (or one of its subdomains)
+func IsDisposableEmail(email string) bool {
+	if !GetConfig().DisposableEmailBlock {
+		return false
+	}
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	if _EmailDomainMatchesList(domain, defaultDisposableEmailDomains) ||
+		_EmailDomainMatchesList(domain, GetConfig().DisposableEmailList) {
+		return true
+	}
+	_disposableEmailMutex.RLock()
+	defer _disposableEmailMutex.RUnlock()
+	return _EmailDomainMatchesList(domain, _disposableEmailFetchedDomains)
+}
+
+// UpdateDisposableEmailDomains fetches the list of disposable email domains from the configured URL,
+// keeping the previous list if the download fails
+func UpdateDisposableEmailDomains() {
+	if !GetConfig().DisposableEmailBlock || GetConfig().DisposableEmailListURL == "" {
+		return
+	}
+	domains, err := _FetchDisposableEmailDomains(GetConfig().DisposableEmailListURL)
+	if err != nil {
+		log.Println("Could not fetch disposable email domains:", err)
+		return
+	}
+	_disposableEmailMutex.Lock()
+	_disposableEmailFetchedDomains = domains
+	_disposableEmailMutex.Unlock()
+	log.Println("Fetched", len(domains), "disposable email domains")
+}
+
+func _FetchDisposableEmailDomains(url string) ([]string, error) {
+	client := &http.Client{Timeout: time.Second * 30}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("Unexpected status code " + strconv.Itoa(res.StatusCode))
+	}
+	domains := make([]string, 0)
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains, scanner.Err()
+}
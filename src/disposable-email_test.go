@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestDisposableEmail(t *testing.T) {
+	if IsDisposableEmail("foo@mailinator.com") {
+		t.Error("Expected disposable email check to be disabled by default")
+	}
+	os.Setenv("DISPOSABLE_EMAIL_BLOCK", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("DISPOSABLE_EMAIL_BLOCK")
+		GetConfig().ReadConfig()
+	}()
+	if !IsDisposableEmail("foo@Mailinator.com") || !IsDisposableEmail("foo@x.yopmail.com") {
+		t.Error("Expected built-in disposable domains to be detected")
+	}
+	if IsDisposableEmail("foo@bar.com") {
+		t.Error("Expected regular domain not to be detected")
+	}
+
+	clearTestDB()
+	payload := `{"email": "foo@mailinator.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestDisposableEmailListURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "# disposable domains")
+		fmt.Fprintln(w, "Throwaway.test")
+		fmt.Fprintln(w, "")
+	}))
+	defer server.Close()
+	os.Setenv("DISPOSABLE_EMAIL_BLOCK", "1")
+	os.Setenv("DISPOSABLE_EMAIL_LIST_URL", server.URL)
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("DISPOSABLE_EMAIL_BLOCK")
+		os.Unsetenv("DISPOSABLE_EMAIL_LIST_URL")
+		GetConfig().ReadConfig()
+		_disposableEmailFetchedDomains = nil
+	}()
+
+	if IsDisposableEmail("foo@throwaway.test") {
+		t.Error("Expected domain not to be detected before fetching the list")
+	}
+	UpdateDisposableEmailDomains()
+	if !IsDisposableEmail("foo@throwaway.test") {
+		t.Error("Expected fetched domain to be detected")
+	}
+
+	// A failed download keeps the previous list
+	server.Close()
+	UpdateDisposableEmailDomains()
+	if !IsDisposableEmail("foo@throwaway.test") {
+		t.Error("Expected fetched domain to be kept")
+	}
+}
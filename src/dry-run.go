@@ -0,0 +1,18 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// IsRulesDryRun checks if the route rules are only evaluated for the request, logging rejections instead of enforcing them.
+// Requests to the public API are never affected.
+func IsRulesDryRun(r *http.Request) bool {
+	return GetConfig().ProxyRulesDryRun && !strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath)
+}
+
+// LogDryRunRejection logs the decision that would have rejected the request if PROXY_RULES_DRY_RUN wasn't set
+func LogDryRunRejection(r *http.Request, status int, reason string) {
+	log.Println("Dry run: would reject", r.Method, r.URL.EscapedPath(), "from", GetClientIP(r), "with status", status, "-", reason)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestProxyRulesDryRun(t *testing.T) {
+	os.Setenv("PROXY_AUTHZ_RULES_FILE", writeTestAuthzRules(t, testAuthzRules))
+	os.Setenv("PROXY_RULES_DRY_RUN", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_AUTHZ_RULES_FILE")
+		os.Unsetenv("PROXY_RULES_DRY_RUN")
+		GetConfig().ReadConfig()
+	}()
+
+	clearTestDB()
+	res := executePublicTestRequest(newHTTPRequest("GET", "/admin/users", "", nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+
+	res = executePublicTestRequest(newHTTPRequest("GET", "/blacklist", "invalid", nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+
+	loginResponse := createLoginTestUser()
+	res = executePublicTestRequest(newHTTPRequest("GET", "/admin/users", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+
+	res = executePublicTestRequest(newHTTPRequest("GET", "/auth/me", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
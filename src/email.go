@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeEmail brings an email address into its canonical form so equivalent spellings map to the same account:
+// Unicode NFC, lowercase and, if configured, without plus tag and with an ASCII (punycode) domain
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	if !GetConfig().EmailNormalize {
+		return email
+	}
+	email = strings.ToLower(norm.NFC.String(email))
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return email
+	}
+	local, domain := email[:i], email[i+1:]
+	if GetConfig().EmailStripPlusTag {
+		if j := strings.Index(local, "+"); j > 0 {
+			local = local[:j]
+		}
+	}
+	if GetConfig().EmailIDNToASCII {
+		if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+			domain = ascii
+		}
+	}
+	return local + "@" + domain
+}
+
+// IsEmailDomainAllowed checks the domain of an email address against the configured allowlist and blocklist
+// as well as the disposable email providers, entries match the domain itself and all of its subdomains
+func IsEmailDomainAllowed(email string) bool {
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	if len(GetConfig().EmailDomainAllowlist) != 0 && !_EmailDomainMatchesList(domain, GetConfig().EmailDomainAllowlist) {
+		return false
+	}
+	if _EmailDomainMatchesList(domain, GetConfig().EmailDomainBlocklist) {
+		return false
+	}
+	return !IsDisposableEmail(email)
+}
+
+func _EmailDomainMatchesList(domain string, list []string) bool {
+	for _, entry := range list {
+		if domain == entry || strings.HasSuffix(domain, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	checkTestString(t, "foo@bar.com", NormalizeEmail(" Foo@BAR.com "))
+	checkTestString(t, "foo+tag@bar.com", NormalizeEmail("Foo+Tag@bar.com"))
+	checkTestString(t, "josé@bar.com", NormalizeEmail("José@bar.com"))
+	checkTestString(t, "foo@bücher.de", NormalizeEmail("foo@BÜCHER.de"))
+
+	os.Setenv("EMAIL_STRIP_PLUS_TAG", "1")
+	os.Setenv("EMAIL_IDN_TO_ASCII", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("EMAIL_STRIP_PLUS_TAG")
+		os.Unsetenv("EMAIL_IDN_TO_ASCII")
+		GetConfig().ReadConfig()
+	}()
+	checkTestString(t, "foo@bar.com", NormalizeEmail("Foo+Tag@bar.com"))
+	checkTestString(t, "+tag@bar.com", NormalizeEmail("+tag@bar.com"))
+	checkTestString(t, "foo@xn--bcher-kva.de", NormalizeEmail("foo@BÜCHER.de"))
+}
+
+func TestNormalizeEmailDisabled(t *testing.T) {
+	os.Setenv("EMAIL_NORMALIZE", "0")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("EMAIL_NORMALIZE")
+		GetConfig().ReadConfig()
+	}()
+	checkTestString(t, "Foo@BAR.com", NormalizeEmail(" Foo@BAR.com "))
+}
+
+func TestLoginNormalizedEmail(t *testing.T) {
+	clearTestDB()
+
+	payload := `{"email": " Foo@Bar.COM", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	if user == nil {
+		t.Fatal("Expected user to be stored with normalized email")
+	}
+	checkTestString(t, "foo@bar.com", user.Email)
+	user.Confirmed = true
+	GetUserRepository().Update(user)
+
+	loginResponse := loginUser("FOO@bar.com", "12345678")
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+}
+
+func TestEmailDomainAllowlist(t *testing.T) {
+	os.Setenv("EMAIL_DOMAIN_ALLOWLIST", "bar.com example.org")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("EMAIL_DOMAIN_ALLOWLIST")
+		GetConfig().ReadConfig()
+	}()
+	if !IsEmailDomainAllowed("foo@bar.com") || !IsEmailDomainAllowed("foo@mail.bar.com") {
+		t.Error("Expected allowlisted domain and subdomain to be allowed")
+	}
+	if IsEmailDomainAllowed("foo@foobar.com") || IsEmailDomainAllowed("foo@other.com") {
+		t.Error("Expected other domains to be rejected")
+	}
+
+	clearTestDB()
+	payload := `{"email": "foo@other.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeEmailDomainNotAllowed, errorResponse.Error)
+
+	payload = `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ = http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+}
+
+func TestEmailDomainBlocklist(t *testing.T) {
+	os.Setenv("EMAIL_DOMAIN_BLOCKLIST", "blocked.com")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("EMAIL_DOMAIN_BLOCKLIST")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"email": "foo@sub.blocked.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	payload = `{"email": "foo@blocked.com", "password": "12345678"}`
+	req = newHTTPRequest("POST", "/auth/changeemail", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
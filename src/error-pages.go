@@ -0,0 +1,79 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrorPageVars are passed to the ERROR_PAGE_TEMPLATE
+type ErrorPageVars struct {
+	Status     int
+	StatusText string
+	Error      string
+	Message    string
+	RequestID  string
+}
+
+var _errorPageTemplateInstance *template.Template
+var _errorPageTemplateOnce sync.Once
+
+// GetErrorPageTemplate returns the parsed ERROR_PAGE_TEMPLATE, or nil if none is configured
+func GetErrorPageTemplate() *template.Template {
+	_errorPageTemplateOnce.Do(func() {
+		if GetConfig().ErrorPageTemplate == "" {
+			return
+		}
+		tpl, err := template.ParseFiles(GetConfig().ErrorPageTemplate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_errorPageTemplateInstance = tpl
+	})
+	return _errorPageTemplateInstance
+}
+
+// SendErrorPage sends an HTML error page rendered from ERROR_PAGE_TEMPLATE to browsers
+// and a JSON error response to all other clients
+func SendErrorPage(w http.ResponseWriter, r *http.Request, status int, code string, message string) {
+	tpl := GetErrorPageTemplate()
+	if tpl == nil || !_AcceptsHTML(r) {
+		SendJSONWithStatus(w, status, &ErrorResponse{Error: code, Message: message})
+		return
+	}
+	vars := &ErrorPageVars{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Error:      code,
+		Message:    message,
+	}
+	if entry := GetAccessLogEntryFromContext(r); entry != nil {
+		vars.RequestID = entry.RequestID
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := tpl.Execute(w, vars); err != nil {
+		log.Println(err)
+	}
+}
+
+// _AcceptsHTML checks if the client prefers HTML over JSON, as browsers navigating to a page do
+func _AcceptsHTML(r *http.Request) bool {
+	for _, entry := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+		if mediaType == "text/html" {
+			return true
+		}
+		if mediaType == "application/json" {
+			return false
+		}
+	}
+	return false
+}
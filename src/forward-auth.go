@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// IsForwardAuthRequest checks if the request is a /verify subrequest of a reverse proxy delegating authentication to us
+func IsForwardAuthRequest(r *http.Request) bool {
+	return r.URL.EscapedPath() == GetConfig().PublicAPIPath+"verify"
+}
+
+// GetForwardAuthToken returns the JWT from PROXY_TOKEN_COOKIE of the original request, as forwarded by the reverse proxy
+func GetForwardAuthToken(r *http.Request) string {
+	if GetConfig().ProxyTokenCookie == "" {
+		return ""
+	}
+	if cookie, err := r.Cookie(GetConfig().ProxyTokenCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// GetForwardAuthTarget returns the method and escaped path of the original request, as passed by Traefik
+// in X-Forwarded-Method and X-Forwarded-Uri, or by nginx in X-Original-Method and X-Original-URI.
+// The path is empty if the reverse proxy didn't pass the original URI.
+func GetForwardAuthTarget(r *http.Request) (string, string) {
+	method := r.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = r.Header.Get("X-Original-Method")
+	}
+	if method == "" {
+		method = "GET"
+	}
+	uri := r.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = r.Header.Get("X-Original-URI")
+	}
+	target, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return method, ""
+	}
+	return method, target.EscapedPath()
+}
+
+// Verify handles /verify requests, answering 200 with the identity headers for authenticated users,
+// so nginx' auth_request or Traefik's forwardAuth can protect services not proxied by JWT Auth Proxy.
+// The token has already been verified by VerifyJwtMiddleware, which answers 401 otherwise.
+func (router *AuthRouter) Verify(w http.ResponseWriter, r *http.Request) {
+	claims := GetClaimsFromContext(r)
+	if claims == nil || claims.Guest {
+		AddBearerChallenge(w, "", "")
+		SendUnauthorized(w)
+		return
+	}
+	if claims.IsRestricted() {
+		AddBearerChallenge(w, BearerErrorInsufficientScope, "Restricted token")
+		SendForbidden(w)
+		return
+	}
+	if method, path := GetForwardAuthTarget(r); path != "" {
+		for _, rule := range GetRouteRules().AuthzRules {
+			if rule.Matches(method, path) && !rule.Authorize(claims) {
+				log.Println("Rejecting forward auth request", method, path, "of UserID", claims.UserID, "not satisfying authorization rule", rule.Route)
+				AddBearerChallenge(w, BearerErrorInsufficientScope, "Insufficient permissions")
+				SendError(w, http.StatusForbidden, ErrorCodeInsufficientPermissions)
+				return
+			}
+		}
+	}
+	SetIdentityHeaders(w.Header(), r)
+	if GetConfig().IdentityHeaderSigningKey != "" {
+		SignIdentityHeaders(w.Header(), GetConfig().IdentityHeaderSigningKey, time.Now())
+	}
+	w.WriteHeader(http.StatusOK)
+}
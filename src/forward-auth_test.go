@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestForwardAuthVerify(t *testing.T) {
+	os.Setenv("PROXY_AUTHZ_RULES_FILE", writeTestAuthzRules(t, testAuthzRules))
+	os.Setenv("PROXY_TOKEN_COOKIE", "token")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_AUTHZ_RULES_FILE")
+		os.Unsetenv("PROXY_TOKEN_COOKIE")
+		GetConfig().ReadConfig()
+	}()
+
+	clearTestDB()
+	res := executePublicTestRequest(newHTTPRequest("GET", "/auth/verify", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	user := createTestUser(true)
+	loginResponse := loginUser("foo@bar.com", "12345678")
+	res = executePublicTestRequest(newHTTPRequest("GET", "/auth/verify", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, user.ID.String(), res.Header().Get("X-Auth-UserID"))
+	checkTestString(t, "foo@bar.com", res.Header().Get("X-Auth-Email"))
+
+	req := newHTTPRequest("GET", "/auth/verify", "", nil)
+	req.AddCookie(&http.Cookie{Name: "token", Value: loginResponse.AccessToken})
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	req = newHTTPRequest("GET", "/auth/verify", loginResponse.AccessToken, nil)
+	req.Header.Set("X-Forwarded-Method", "GET")
+	req.Header.Set("X-Forwarded-Uri", "/admin/users?page=2")
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
+
+func TestGetForwardAuthTarget(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/auth/verify", nil)
+	req.Header.Set("X-Original-Method", "POST")
+	req.Header.Set("X-Original-URI", "/some%2Fpath?x=1")
+	method, path := GetForwardAuthTarget(req)
+	checkTestString(t, "POST", method)
+	checkTestString(t, "/some%2Fpath", path)
+
+	req.Header.Del("X-Original-URI")
+	if _, path := GetForwardAuthTarget(req); path != "" {
+		t.Error("Expected empty path without original URI")
+	}
+}
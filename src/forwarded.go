@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	ForwardedHeadersTrusted     = "trusted"
+	ForwardedHeadersPassthrough = "passthrough"
+	ForwardedHeadersSanitize    = "sanitize"
+)
+
+// KeepsForwardedHeaders checks if the X-Forwarded-* and Forwarded headers sent with the request are passed on to the upstream:
+// always in passthrough mode, never in sanitize mode and only if received from a trusted proxy otherwise
+func KeepsForwardedHeaders(r *http.Request) bool {
+	switch GetConfig().ProxyForwardedHeaders {
+	case ForwardedHeadersPassthrough:
+		return true
+	case ForwardedHeadersSanitize:
+		return false
+	default:
+		return IsTrustedProxy(r)
+	}
+}
+
+// AppendForwardedHeaders appends the connection the request was received on to the Forwarded and X-Forwarded-For chains
+// and sets X-Forwarded-Host, X-Forwarded-Proto and X-Real-IP. Must be called after StripClientIdentityHeaders.
+func AppendForwardedHeaders(r *http.Request, proto string) {
+	forwarded := "for=" + FormatForwardedNode(r.RemoteAddr) + ";host=" + _QuoteForwardedValue(r.Host) + ";proto=" + _QuoteForwardedValue(proto)
+	if prior := strings.Join(r.Header.Values("Forwarded"), ", "); prior != "" {
+		r.Header.Set("Forwarded", prior+", "+forwarded)
+	} else {
+		r.Header.Set("Forwarded", forwarded)
+	}
+	if prior := strings.Join(r.Header.Values("X-Forwarded-For"), ", "); prior != "" {
+		r.Header.Set("X-Forwarded-For", prior+", "+GetPeerIP(r))
+	} else {
+		r.Header.Set("X-Forwarded-For", GetPeerIP(r))
+	}
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	if r.Header.Get("X-Forwarded-Proto") == "" {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+	r.Header.Set("X-Real-IP", GetClientIP(r))
+}
+
+// FormatForwardedNode formats an address as node of a Forwarded header (RFC 7239, section 6):
+// IPv6 addresses are enclosed in brackets, values containing a port or brackets are quoted
+func FormatForwardedNode(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + ip.String() + "]"
+	}
+	if port != "" {
+		host = host + ":" + port
+	}
+	return _QuoteForwardedValue(host)
+}
+
+// _QuoteForwardedValue returns the value as token if possible, otherwise as quoted-string
+func _QuoteForwardedValue(s string) string {
+	if s != "" && strings.IndexFunc(s, func(c rune) bool { return !_IsTokenChar(c) }) == -1 {
+		return s
+	}
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
+
+func _IsTokenChar(c rune) bool {
+	return c < 127 && c > 32 && !strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c)
+}
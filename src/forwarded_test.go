@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestFormatForwardedNode(t *testing.T) {
+	checkTestString(t, "192.0.2.60", FormatForwardedNode("192.0.2.60"))
+	checkTestString(t, `"192.0.2.60:4711"`, FormatForwardedNode("192.0.2.60:4711"))
+	checkTestString(t, `"[2001:db8:cafe::17]"`, FormatForwardedNode("2001:db8:cafe::17"))
+	checkTestString(t, `"[2001:db8:cafe::17]:4711"`, FormatForwardedNode("[2001:db8:cafe::17]:4711"))
+	checkTestString(t, `"a\"b"`, _QuoteForwardedValue(`a"b`))
+}
+
+func TestAppendForwardedHeaders(t *testing.T) {
+	os.Setenv("PROXY_TRUSTED_PROXIES", "10.0.0.0/8")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_TRUSTED_PROXIES")
+		os.Unsetenv("PROXY_FORWARDED_HEADERS")
+		GetConfig().ReadConfig()
+	}()
+	newRequest := func(remoteAddr string) *http.Request {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Host = "example.com"
+		req.RemoteAddr = remoteAddr
+		req.Header.Add("Forwarded", "for=192.0.2.43")
+		req.Header.Add("Forwarded", `for="[2001:db8::1]"`)
+		req.Header.Set("X-Forwarded-For", "192.0.2.43, 2001:db8::1")
+		return req
+	}
+
+	req := newRequest("10.1.2.3:1234")
+	StripClientIdentityHeaders(req)
+	AppendForwardedHeaders(req, "https")
+	checkTestString(t, `for=192.0.2.43, for="[2001:db8::1]", for="10.1.2.3:1234";host=example.com;proto=https`, req.Header.Get("Forwarded"))
+	checkTestString(t, "192.0.2.43, 2001:db8::1, 10.1.2.3", req.Header.Get("X-Forwarded-For"))
+	checkTestString(t, "2001:db8::1", req.Header.Get("X-Real-IP"))
+
+	req = newRequest("[2001:db8::2]:1234")
+	StripClientIdentityHeaders(req)
+	AppendForwardedHeaders(req, "http")
+	checkTestString(t, `for="[2001:db8::2]:1234";host=example.com;proto=http`, req.Header.Get("Forwarded"))
+	checkTestString(t, "2001:db8::2", req.Header.Get("X-Forwarded-For"))
+
+	os.Setenv("PROXY_FORWARDED_HEADERS", "passthrough")
+	GetConfig().ReadConfig()
+	req = newRequest("[2001:db8::2]:1234")
+	StripClientIdentityHeaders(req)
+	AppendForwardedHeaders(req, "http")
+	checkTestString(t, "192.0.2.43, 2001:db8::1, 2001:db8::2", req.Header.Get("X-Forwarded-For"))
+
+	os.Setenv("PROXY_FORWARDED_HEADERS", "sanitize")
+	GetConfig().ReadConfig()
+	req = newRequest("10.1.2.3:1234")
+	StripClientIdentityHeaders(req)
+	AppendForwardedHeaders(req, "http")
+	checkTestString(t, "10.1.2.3", req.Header.Get("X-Forwarded-For"))
+}
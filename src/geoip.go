@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+const ErrorCodeCountryNotAllowed = "country_not_allowed"
+
+var _geoIPReaderInstance *geoip2.Reader
+var _geoIPReaderOnce sync.Once
+
+// GetGeoIPReader returns the reader of the MaxMind database configured in GEOIP_DATABASE
+func GetGeoIPReader() *geoip2.Reader {
+	_geoIPReaderOnce.Do(func() {
+		reader, err := geoip2.Open(GetConfig().GeoIPDatabase)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_geoIPReaderInstance = reader
+	})
+	return _geoIPReaderInstance
+}
+
+// LookupCountry returns the ISO 3166-1 alpha-2 code of the IP address' country, or an empty string if it's unknown
+func LookupCountry(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil || GetConfig().GeoIPDatabase == "" {
+		return ""
+	}
+	country, err := GetGeoIPReader().Country(ip)
+	if err != nil {
+		log.Println("Could not look up country of", address, err)
+		return ""
+	}
+	return country.Country.IsoCode
+}
+
+// CountryAccessRule allows only or denies the given countries access to routes matching Route,
+// which uses the syntax of PROXY_WHITELIST entries
+type CountryAccessRule struct {
+	Route     string
+	Action    string
+	Countries []string
+	rule      *ProxyRule
+}
+
+// ParseCountryAccessRule parses a rule in the format "<route> <allow|deny> <country codes>"
+func ParseCountryAccessRule(s string) (*CountryAccessRule, error) {
+	route, action, values := _SplitAccessRule(s)
+	if route == "" {
+		return nil, errors.New("Country access rule must have the format <route> <allow|deny> <country codes>: " + s)
+	}
+	rule := &CountryAccessRule{Route: route, Action: action, Countries: ParseCountryCodes(values)}
+	var err error
+	if rule.rule, err = ParseProxyRule(rule.Route); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// ParseCountryCodes parses a space-separated list of country codes
+func ParseCountryCodes(s string) []string {
+	return strings.Fields(strings.ToUpper(s))
+}
+
+// Permits checks if the rule doesn't reject the country, unknown countries are only permitted by deny rules
+func (rule *CountryAccessRule) Permits(country string) bool {
+	if rule.Action == IPAccessAllow {
+		return _ContainsAny(rule.Countries, []string{country})
+	}
+	return !_ContainsAny(rule.Countries, []string{country})
+}
+
+// IsCountryAllowed checks the country against the global deny and allow lists and the rules matching the request
+func IsCountryAllowed(country, method, path string) bool {
+	if _ContainsAny(GetConfig().GeoIPDenyCountries, []string{country}) {
+		return false
+	}
+	if len(GetConfig().GeoIPAllowCountries) > 0 && !_ContainsAny(GetConfig().GeoIPAllowCountries, []string{country}) {
+		return false
+	}
+	for _, rule := range GetConfig().GeoIPAccessRules {
+		if rule.rule.Matches(method, path) && !rule.Permits(country) {
+			return false
+		}
+	}
+	return true
+}
+
+// GeoIPMiddleware looks up the client's country and rejects requests from countries not allowed to access the route
+func GeoIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetConfig().GeoIPDatabase == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		country := LookupCountry(GetClientIP(r))
+		path := r.URL.EscapedPath()
+		if !IsCountryAllowed(country, r.Method, path) {
+			log.Println("Rejecting request", r.Method, path, "from country", country)
+			SendError(w, http.StatusForbidden, ErrorCodeCountryNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyCountry, country)))
+	})
+}
+
+// GetCountryFromContext returns the client's country code looked up by GeoIPMiddleware
+func GetCountryFromContext(r *http.Request) string {
+	country, _ := r.Context().Value(contextKeyCountry).(string)
+	return country
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseCountryAccessRule(t *testing.T) {
+	rule, err := ParseCountryAccessRule("/payments allow de at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "/payments", rule.Route)
+	if !rule.Permits("DE") || !rule.Permits("AT") || rule.Permits("US") || rule.Permits("") {
+		t.Error("Expected only listed countries to be permitted")
+	}
+	if _, err := ParseCountryAccessRule("/payments DE"); err == nil {
+		t.Error("Expected error for missing action")
+	}
+}
+
+func TestIsCountryAllowed(t *testing.T) {
+	os.Setenv("GEOIP_DATABASE", "GeoLite2-Country.mmdb")
+	os.Setenv("GEOIP_DENY_COUNTRIES", "KP")
+	os.Setenv("GEOIP_ACCESS_RULES", "POST /payments allow DE AT")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("GEOIP_DATABASE")
+		os.Unsetenv("GEOIP_DENY_COUNTRIES")
+		os.Unsetenv("GEOIP_ACCESS_RULES")
+		GetConfig().ReadConfig()
+	}()
+	if IsCountryAllowed("KP", "GET", "/some/route") {
+		t.Error("Expected denied country to be rejected")
+	}
+	if !IsCountryAllowed("US", "GET", "/payments") || !IsCountryAllowed("", "GET", "/some/route") {
+		t.Error("Expected request not matching rules to be allowed")
+	}
+	if IsCountryAllowed("US", "POST", "/payments") || !IsCountryAllowed("DE", "POST", "/payments") {
+		t.Error("Expected route rule to be applied")
+	}
+}
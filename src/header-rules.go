@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	HeaderRuleRequest  = "request"
+	HeaderRuleResponse = "response"
+)
+
+const (
+	HeaderRuleSet     = "set"
+	HeaderRuleAdd     = "add"
+	HeaderRuleRemove  = "remove"
+	HeaderRuleRewrite = "rewrite"
+)
+
+// HeaderRule modifies a header of proxied requests or their responses for paths starting with Path
+type HeaderRule struct {
+	Direction string
+	Path      string
+	Action    string
+	Header    string
+	Value     string
+	Pattern   *regexp.Regexp
+}
+
+// ParseHeaderRule parses a rule in the format "<direction> <path> <action> <header> [<value>]".
+// Rewrite rules take a regular expression and its replacement as value, separated by a space.
+func ParseHeaderRule(s string) (*HeaderRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return nil, errors.New("Header rule must have the format <direction> <path> <action> <header> [<value>]: " + s)
+	}
+	rule := &HeaderRule{
+		Direction: strings.ToLower(fields[0]),
+		Path:      strings.TrimSuffix(fields[1], "/"),
+		Action:    strings.ToLower(fields[2]),
+		Header:    http.CanonicalHeaderKey(fields[3]),
+		Value:     strings.Join(fields[4:], " "),
+	}
+	if rule.Direction != HeaderRuleRequest && rule.Direction != HeaderRuleResponse {
+		return nil, errors.New("Header rule direction must be request or response: " + s)
+	}
+	switch rule.Action {
+	case HeaderRuleSet, HeaderRuleAdd:
+		if rule.Value == "" {
+			return nil, errors.New("Header rule requires a value: " + s)
+		}
+	case HeaderRuleRemove:
+	case HeaderRuleRewrite:
+		if len(fields) < 5 {
+			return nil, errors.New("Header rewrite rule requires a regular expression: " + s)
+		}
+		pattern, err := regexp.Compile(fields[4])
+		if err != nil {
+			return nil, errors.New("Header rewrite rule has an invalid regular expression: " + s)
+		}
+		rule.Pattern = pattern
+		rule.Value = strings.Join(fields[5:], " ")
+	default:
+		return nil, errors.New("Header rule action must be one of set, add, remove, rewrite: " + s)
+	}
+	return rule, nil
+}
+
+// Matches checks if the rule applies to the given request path
+func (rule *HeaderRule) Matches(path string) bool {
+	return rule.Path == "" || path == rule.Path || strings.HasPrefix(path, rule.Path+"/")
+}
+
+func (rule *HeaderRule) Apply(header http.Header) {
+	switch rule.Action {
+	case HeaderRuleSet:
+		header.Set(rule.Header, rule.Value)
+	case HeaderRuleAdd:
+		header.Add(rule.Header, rule.Value)
+	case HeaderRuleRemove:
+		header.Del(rule.Header)
+	case HeaderRuleRewrite:
+		values := header.Values(rule.Header)
+		for i, value := range values {
+			values[i] = rule.Pattern.ReplaceAllString(value, rule.Value)
+		}
+	}
+}
+
+// ApplyHeaderRules applies all configured rules of the given direction matching the path in order of definition
+func ApplyHeaderRules(direction, path string, header http.Header) {
+	for _, rule := range GetConfig().ProxyHeaderRules {
+		if rule.Direction == direction && rule.Matches(path) {
+			rule.Apply(header)
+		}
+	}
+}
+
+// ScrubResponseHeaders removes the headers listed in PROXY_RESPONSE_HEADER_DENYLIST from upstream responses,
+// so they don't reveal details of the target servers. Entries ending with an asterisk remove all headers with that prefix.
+func ScrubResponseHeaders(header http.Header) {
+	for _, name := range GetConfig().ProxyResponseHeaderDenylist {
+		if !strings.HasSuffix(name, "*") {
+			delete(header, name)
+			continue
+		}
+		prefix := strings.TrimSuffix(name, "*")
+		for key := range header {
+			if _HasPrefixFold(key, prefix) {
+				delete(header, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestParseHeaderRule(t *testing.T) {
+	rule, err := ParseHeaderRule("response /internal/ remove set-cookie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, HeaderRuleResponse, rule.Direction)
+	checkTestString(t, "/internal", rule.Path)
+	checkTestString(t, "Set-Cookie", rule.Header)
+
+	rule, err = ParseHeaderRule("request / set X-Static some value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "some value", rule.Value)
+
+	for _, invalid := range []string{"request / set X-Static", "both / remove X-Foo", "request / drop X-Foo", "response / rewrite Location", "response / rewrite Location ( x"} {
+		if _, err := ParseHeaderRule(invalid); err == nil {
+			t.Errorf("Expected error for invalid rule %s", invalid)
+		}
+	}
+}
+
+func TestApplyHeaderRule(t *testing.T) {
+	header := http.Header{}
+	header.Add("Set-Cookie", "a=b")
+	header.Set("Location", "http://backend:8080/foo")
+	remove, _ := ParseHeaderRule("response /internal remove Set-Cookie")
+	rewrite, _ := ParseHeaderRule("response / rewrite Location ^http://backend:8080 https://example.com")
+	if remove.Matches("/internalfoo") || !remove.Matches("/internal") || !remove.Matches("/internal/foo") {
+		t.Error("Expected rule to match path prefix only")
+	}
+	remove.Apply(header)
+	rewrite.Apply(header)
+	checkTestString(t, "", header.Get("Set-Cookie"))
+	checkTestString(t, "https://example.com/foo", header.Get("Location"))
+}
+
+func TestProxyHeaderRules(t *testing.T) {
+	os.Setenv("PROXY_HEADER_RULES", "request /some set X-Static static value; request /other set X-Other 1; request / remove X-Internal")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_HEADER_RULES")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	req := newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil)
+	req.Header.Set("X-Internal", "1")
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "static value", handler.Headers.Get("X-Static"))
+	checkTestString(t, "", handler.Headers.Get("X-Other"))
+	checkTestString(t, "", handler.Headers.Get("X-Internal"))
+}
+
+func TestScrubResponseHeaders(t *testing.T) {
+	os.Setenv("PROXY_RESPONSE_HEADER_DENYLIST", "Server x-debug-*")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_RESPONSE_HEADER_DENYLIST")
+		GetConfig().ReadConfig()
+	}()
+	header := http.Header{}
+	header.Set("Server", "nginx/1.2.3")
+	header.Set("X-Debug-Query-Time", "12ms")
+	header.Set("X-Powered-By", "PHP")
+	header.Set("Content-Type", "text/html")
+	ScrubResponseHeaders(header)
+	checkTestString(t, "", header.Get("Server"))
+	checkTestString(t, "", header.Get("X-Debug-Query-Time"))
+	checkTestString(t, "PHP", header.Get("X-Powered-By"))
+	checkTestString(t, "text/html", header.Get("Content-Type"))
+}
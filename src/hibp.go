@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsBreachedPassword checks a password against the Have I Been Pwned range API.
+// Only the first five characters of the password's SHA-1 hash are sent (k-anonymity).
+// The second return value is false if the check could not be performed.
+func IsBreachedPassword(password string) (bool, bool) {
+	if !GetConfig().HIBPEnable {
+		return false, true
+	}
+	breached, err := _QueryHIBPRange(password)
+	if err != nil {
+		log.Println("Could not check password against HIBP:", err)
+		return false, false
+	}
+	return breached, true
+}
+
+func _QueryHIBPRange(password string) (bool, error) {
+	hash := sha1.Sum([]byte(password))
+	hashHex := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := hashHex[:5], hashHex[5:]
+	client := &http.Client{Timeout: time.Second * GetConfig().HIBPTimeout}
+	req, err := http.NewRequest("GET", GetConfig().HIBPAPIURL+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Add-Padding", "true")
+	res, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return false, errors.New("Unexpected status code " + strconv.Itoa(res.StatusCode))
+	}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], suffix) {
+			continue
+		}
+		// Padding entries have a count of 0
+		count, _ := strconv.Atoi(parts[1])
+		return count > 0, nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func startHIBPMock(breached ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+		w.Write([]byte("0000000000000000000000000000000000A:0\r\n"))
+		for _, password := range breached {
+			hash := sha1.Sum([]byte(password))
+			hashHex := strings.ToUpper(hex.EncodeToString(hash[:]))
+			if hashHex[:5] == prefix {
+				w.Write([]byte(hashHex[5:] + ":42\r\n"))
+			}
+		}
+	}))
+}
+
+func setHIBPTestConfig(url, failOpen string) func() {
+	os.Setenv("HIBP_ENABLE", "1")
+	os.Setenv("HIBP_API_URL", url)
+	os.Setenv("HIBP_FAIL_OPEN", failOpen)
+	GetConfig().ReadConfig()
+	return func() {
+		os.Unsetenv("HIBP_ENABLE")
+		os.Unsetenv("HIBP_API_URL")
+		os.Unsetenv("HIBP_FAIL_OPEN")
+		GetConfig().ReadConfig()
+	}
+}
+
+func TestSignupBreachedPassword(t *testing.T) {
+	server := startHIBPMock("breached123")
+	defer server.Close()
+	defer setHIBPTestConfig(server.URL+"/range/", "1")()
+	clearTestDB()
+
+	payload := `{"email": "foo@bar.com", "password": "breached123"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	var errorResponse PasswordPolicyErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkPasswordViolations(t, []string{PasswordViolationBreached}, errorResponse.Violations)
+
+	payload = `{"email": "foo@bar.com", "password": "not-breached-123"}`
+	req, _ = http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+}
+
+func TestBreachedPasswordCheckUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	restore := setHIBPTestConfig(server.URL+"/range/", "1")
+	checkPasswordViolations(t, []string{}, CheckPasswordPolicy("not-breached-123", "foo@bar.com"))
+	restore()
+
+	defer setHIBPTestConfig(server.URL+"/range/", "0")()
+	checkPasswordViolations(t, []string{PasswordViolationBreachCheckError}, CheckPasswordPolicy("not-breached-123", "foo@bar.com"))
+}
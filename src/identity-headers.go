@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IdentityHeaderName returns the name of the header passing the identity header name to the target servers,
+// as renamed by PROXY_IDENTITY_HEADERS. Empty if the header shouldn't be set.
+func IdentityHeaderName(name string) string {
+	if renamed, ok := GetConfig().ProxyIdentityHeaders[http.CanonicalHeaderKey(name)]; ok {
+		return renamed
+	}
+	return name
+}
+
+// SetIdentityHeader sets the identity header name, or the header it's renamed to, to value
+func SetIdentityHeader(header http.Header, name, value string) {
+	if name = IdentityHeaderName(name); name != "" {
+		header.Set(name, value)
+	}
+}
+
+// IsIdentityHeader checks if name is one of the identity headers set by the proxy, either by its default or renamed name
+func IsIdentityHeader(name string) bool {
+	if _HasPrefixFold(name, "x-auth-") {
+		return true
+	}
+	for _, renamed := range GetConfig().ProxyIdentityHeaders {
+		if strings.EqualFold(name, renamed) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetIdentityHeaders sets the identity headers describing the authenticated user of r, and the client's country if GeoIP is enabled
+func SetIdentityHeaders(header http.Header, r *http.Request) {
+	SetIdentityHeader(header, "X-Auth-UserID", GetUserIDFromContext(r))
+	SetIdentityHeader(header, "X-Auth-Scopes", strings.Join(GetScopesFromContext(r), " "))
+	organization, organizationRole := GetOrganizationFromContext(r)
+	SetIdentityHeader(header, "X-Auth-Organization", organization)
+	SetIdentityHeader(header, "X-Auth-Organization-Role", organizationRole)
+	SetIdentityHeader(header, "X-Auth-Phone", GetPhoneFromContext(r))
+	if IsGuestFromContext(r) {
+		SetIdentityHeader(header, "X-Auth-Guest", "1")
+	}
+	SetIdentityHeader(header, "X-Auth-GuestID", GetGuestIDFromContext(r))
+	if claims := GetClaimsFromContext(r); GetConfig().ProxyForwardUserHeaders && claims != nil {
+		SetIdentityHeader(header, "X-Auth-Email", claims.Email)
+		SetIdentityHeader(header, "X-Auth-Roles", strings.Join(claims.Roles, " "))
+		if claims.Confirmed {
+			SetIdentityHeader(header, "X-Auth-Confirmed", "1")
+		}
+	}
+	if GetConfig().GeoIPCountryHeader != "" {
+		header.Set(GetConfig().GeoIPCountryHeader, GetCountryFromContext(r))
+	}
+}
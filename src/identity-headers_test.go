@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestProxyRenamedIdentityHeaders(t *testing.T) {
+	os.Setenv("PROXY_IDENTITY_HEADERS", "X-Auth-UserID=X-Remote-User X-Auth-Phone=")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_IDENTITY_HEADERS")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	user := createTestUser(true)
+	loginResponse := loginUser("foo@bar.com", "12345678")
+
+	req := newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil)
+	req.Header.Set("X-Remote-User", "spoofed")
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, user.ID.String(), handler.Headers.Get("X-Remote-User"))
+	checkTestString(t, "", handler.Headers.Get("X-Auth-UserID"))
+	if _, ok := handler.Headers["X-Auth-Phone"]; ok {
+		t.Error("Expected disabled X-Auth-Phone header not to be set")
+	}
+}
+
+func TestIsIdentityHeader(t *testing.T) {
+	os.Setenv("PROXY_IDENTITY_HEADERS", "X-Auth-UserID=X-Consumer-ID")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_IDENTITY_HEADERS")
+		GetConfig().ReadConfig()
+	}()
+	if !IsIdentityHeader("x-consumer-id") || !IsIdentityHeader("X-Auth-Custom") || IsIdentityHeader("X-Consumer-Name") {
+		t.Error("Expected renamed and X-Auth-* headers to be identity headers")
+	}
+	checkTestString(t, "X-Consumer-ID", IdentityHeaderName("X-Auth-UserID"))
+	checkTestString(t, "X-Auth-Scopes", IdentityHeaderName("X-Auth-Scopes"))
+}
+
+func TestProxyForwardUserHeaders(t *testing.T) {
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+	defer proxy.Shutdown(context.TODO())
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	res := executePublicTestRequest(newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "foo@bar.com", handler.Headers.Get("X-Auth-Email"))
+	checkTestString(t, "1", handler.Headers.Get("X-Auth-Confirmed"))
+
+	os.Setenv("PROXY_FORWARD_USER_HEADERS", "0")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_FORWARD_USER_HEADERS")
+		GetConfig().ReadConfig()
+	}()
+	res = executePublicTestRequest(newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "", handler.Headers.Get("X-Auth-Email"))
+	checkTestString(t, "", handler.Headers.Get("X-Auth-Confirmed"))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signedIdentityHeaders are the headers covered by X-Auth-Signature, in the order they are signed
+var signedIdentityHeaders = []string{
+	"X-Auth-UserID",
+	"X-Auth-Scopes",
+	"X-Auth-Organization",
+	"X-Auth-Organization-Role",
+	"X-Auth-Phone",
+	"X-Auth-Guest",
+	"X-Auth-GuestID",
+	"X-Auth-Email",
+	"X-Auth-Roles",
+	"X-Auth-Confirmed",
+}
+
+// SignIdentityHeaders sets X-Auth-Timestamp and X-Auth-Signature, an HMAC-SHA256 over the timestamp
+// and the identity headers, so the upstream can verify the headers were set by the proxy
+func SignIdentityHeaders(header http.Header, key string, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	header.Set("X-Auth-Timestamp", timestamp)
+	header.Set("X-Auth-Signature", _ComputeIdentitySignature(header, key, timestamp))
+}
+
+// VerifyIdentityHeaders checks the signature of the identity headers and that it isn't older than maxAge
+func VerifyIdentityHeaders(header http.Header, key string, maxAge time.Duration, now time.Time) bool {
+	timestamp := header.Get("X-Auth-Timestamp")
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > maxAge || age < -maxAge {
+		return false
+	}
+	expected := _ComputeIdentitySignature(header, key, timestamp)
+	return hmac.Equal([]byte(expected), []byte(header.Get("X-Auth-Signature")))
+}
+
+// _ComputeIdentitySignature signs the timestamp followed by one "name:value" line per identity header,
+// using the names set by PROXY_IDENTITY_HEADERS and skipping disabled headers
+func _ComputeIdentitySignature(header http.Header, key, timestamp string) string {
+	var sb strings.Builder
+	sb.WriteString(timestamp)
+	sb.WriteString("\n")
+	for _, name := range signedIdentityHeaders {
+		if name = IdentityHeaderName(name); name == "" {
+			continue
+		}
+		sb.WriteString(strings.ToLower(name))
+		sb.WriteString(":")
+		sb.WriteString(header.Get(name))
+		sb.WriteString("\n")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(sb.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func _IsSignedIdentityHeader(name string) bool {
+	for _, identityHeader := range signedIdentityHeaders {
+		if strings.EqualFold(name, identityHeader) {
+			return true
+		}
+	}
+	return false
+}
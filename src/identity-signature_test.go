@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIdentityHeaderSignature(t *testing.T) {
+	now := time.Now()
+	header := http.Header{}
+	header.Set("X-Auth-UserID", "123")
+	SignIdentityHeaders(header, "secret", now)
+	checkStringNotEmpty(t, header.Get("X-Auth-Signature"))
+	if !VerifyIdentityHeaders(header, "secret", time.Minute, now) {
+		t.Error("Expected signature to be valid")
+	}
+	if VerifyIdentityHeaders(header, "other", time.Minute, now) {
+		t.Error("Expected signature with other key to be invalid")
+	}
+	if VerifyIdentityHeaders(header, "secret", time.Minute, now.Add(2*time.Minute)) {
+		t.Error("Expected expired signature to be invalid")
+	}
+	header.Set("X-Auth-UserID", "456")
+	if VerifyIdentityHeaders(header, "secret", time.Minute, now) {
+		t.Error("Expected signature of modified header to be invalid")
+	}
+}
+
+func TestProxySignedIdentityHeaders(t *testing.T) {
+	os.Setenv("IDENTITY_HEADER_SIGNING_KEY", "secret")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("IDENTITY_HEADER_SIGNING_KEY")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	req := newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil)
+	req.Header.Set("X-Auth-Signature", "forged")
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	if !VerifyIdentityHeaders(handler.Headers, "secret", time.Minute, time.Now()) {
+		t.Error("Expected valid identity header signature")
+	}
+}
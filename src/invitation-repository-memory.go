@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryInvitationStore stores invitations in memory, see STORAGE
+type MemoryInvitationStore struct {
+	invitations *MemoryCollection[Invitation]
+}
+
+func NewMemoryInvitationStore() *MemoryInvitationStore {
+	return &MemoryInvitationStore{invitations: NewMemoryCollection[Invitation]()}
+}
+
+func (r *MemoryInvitationStore) Create(u *Invitation) {
+	u.ID = primitive.NewObjectID()
+	r.invitations.Insert(u)
+}
+
+func (r *MemoryInvitationStore) GetOne(id string) *Invitation {
+	return r._Unexpired(r.invitations.FindOne(func(i *Invitation) bool {
+		return i.ID.Hex() == id
+	}))
+}
+
+func (r *MemoryInvitationStore) GetByToken(token string) *Invitation {
+	return r._Unexpired(r.invitations.FindOne(func(i *Invitation) bool {
+		return i.Token == token
+	}))
+}
+
+func (r *MemoryInvitationStore) _Unexpired(invitation *Invitation) *Invitation {
+	if invitation == nil {
+		return nil
+	}
+	if invitation.ExpiryDate.Before(time.Now()) {
+		r.Delete(invitation)
+		return nil
+	}
+	return invitation
+}
+
+func (r *MemoryInvitationStore) GetAll() []*Invitation {
+	now := time.Now()
+	return r.invitations.Find(func(i *Invitation) bool {
+		return !i.ExpiryDate.Before(now)
+	})
+}
+
+func (r *MemoryInvitationStore) Delete(u *Invitation) {
+	r.invitations.Delete(func(i *Invitation) bool {
+		return i.ID == u.ID
+	})
+}
+
+func (r *MemoryInvitationStore) CleanUp() {
+	now := time.Now()
+	r.invitations.Delete(func(i *Invitation) bool {
+		return !i.ExpiryDate.After(now)
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	guuid "github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Invitation struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Email        string             `json:"email" bson:"email"`
+	Role         string             `json:"role,omitempty" bson:"role,omitempty"`
+	Organization string             `json:"organization,omitempty" bson:"organization,omitempty"`
+	Token        string             `json:"-" bson:"token"`
+	CreateDate   time.Time          `json:"createDate" bson:"createDate"`
+	ExpiryDate   time.Time          `json:"expiryDate" bson:"expiryDate"`
+}
+
+// InvitationStore persists invitations, see STORAGE
+type InvitationStore interface {
+	Create(u *Invitation)
+	GetOne(id string) *Invitation
+	GetByToken(token string) *Invitation
+	GetAll() []*Invitation
+	Delete(u *Invitation)
+	CleanUp()
+}
+
+// InvitationRepository manages invitations, storing them in the InvitationStore of the configured storage backend
+type InvitationRepository struct {
+	InvitationStore
+}
+
+var _invitationRepositoryInstance *InvitationRepository
+var _invitationRepositoryOnce sync.Once
+
+func GetInvitationRepository() *InvitationRepository {
+	_invitationRepositoryOnce.Do(func() {
+		_invitationRepositoryInstance = &InvitationRepository{GetStorageDriver().NewInvitationStore()}
+	})
+	return _invitationRepositoryInstance
+}
+
+type MongoInvitationStore struct {
+}
+
+func NewMongoInvitationStore() *MongoInvitationStore {
+	return &MongoInvitationStore{}
+}
+
+func (r *MongoInvitationStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("invitations")
+}
+
+func (r *MongoInvitationStore) Create(u *Invitation) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+func (r *MongoInvitationStore) GetOne(id string) *Invitation {
+	var invitation Invitation
+	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&invitation)
+	if err != nil {
+		return nil
+	}
+	if invitation.ExpiryDate.Before(time.Now()) {
+		r.Delete(&invitation)
+		return nil
+	}
+	return &invitation
+}
+
+func (r *MongoInvitationStore) GetByToken(token string) *Invitation {
+	var invitation Invitation
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"token": token}).Decode(&invitation)
+	if err != nil {
+		return nil
+	}
+	if invitation.ExpiryDate.Before(time.Now()) {
+		r.Delete(&invitation)
+		return nil
+	}
+	return &invitation
+}
+
+func (r *MongoInvitationStore) GetAll() []*Invitation {
+	results := make([]*Invitation, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{"expiryDate": bson.M{"$gte": time.Now()}})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var invitation Invitation
+		err := cur.Decode(&invitation)
+		if err != nil {
+			return results
+		}
+		results = append(results, &invitation)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *MongoInvitationStore) Delete(u *Invitation) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *InvitationRepository) FindUnusedToken() string {
+	var token string = ""
+	for i := 1; i <= 20 && token == ""; i++ {
+		token = guuid.New().String()
+		if r.GetByToken(token) != nil {
+			token = ""
+		}
+	}
+	return token
+}
+
+func (r *MongoInvitationStore) CleanUp() {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"expiryDate": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type InvitationRouter struct {
+}
+
+func (router *InvitationRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/{id}", router.getOne).Methods("GET")
+	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
+	s.HandleFunc("/", router.Create).Methods("POST")
+	s.HandleFunc("/", router.getAll).Methods("GET")
+}
+
+func (router *InvitationRouter) Create(w http.ResponseWriter, r *http.Request) {
+	var data CreateInvitationRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Received invalid create invitation request")
+		SendBadRequest(w)
+		return
+	}
+	data.Email = NormalizeEmail(data.Email)
+	if GetUserRepository().GetByEmail(data.Email) != nil {
+		SendAleadyExists(w)
+		return
+	}
+	if len(GetPendingActionRepository().GetByPayload(data.Email)) != 0 {
+		SendAleadyExists(w)
+		return
+	}
+	if data.Organization != "" && GetOrganizationRepository().GetOne(data.Organization) == nil {
+		log.Println("Received create invitation request for unknown organization")
+		SendBadRequest(w)
+		return
+	}
+	invitation := &Invitation{
+		Email:        data.Email,
+		Role:         data.Role,
+		Organization: data.Organization,
+		Token:        GetInvitationRepository().FindUnusedToken(),
+		CreateDate:   time.Now(),
+		ExpiryDate:   time.Now().Add(time.Duration(time.Minute) * GetConfig().InvitationLifetime),
+	}
+	GetInvitationRepository().Create(invitation)
+	router.sendInvitationMail(invitation)
+	SendCreated(w, invitation.ID.Hex())
+}
+
+func (router *InvitationRouter) getOne(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	invitation := GetInvitationRepository().GetOne(vars["id"])
+	if invitation == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, invitation)
+}
+
+func (router *InvitationRouter) delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	invitation := GetInvitationRepository().GetOne(vars["id"])
+	if invitation == nil {
+		SendNotFound(w)
+		return
+	}
+	GetInvitationRepository().Delete(invitation)
+	SendUpdated(w)
+}
+
+func (router *InvitationRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, GetInvitationRepository().GetAll())
+}
+
+func (router *InvitationRouter) sendInvitationMail(invitation *Invitation) {
+	organizationName := ""
+	if invitation.Organization != "" {
+		if organization := GetOrganizationRepository().GetOne(invitation.Organization); organization != nil {
+			organizationName = organization.Name
+		}
+	}
+	var buf bytes.Buffer
+	TemplateInvitation.Execute(&buf, InvitationMailVars{
+		From:         GetConfig().SMTPSenderAddr,
+		To:           invitation.Email,
+		InvitationID: invitation.Token,
+		Organization: organizationName,
+	})
+	SendMail(invitation.Email, buf.String())
+}
+
+type CreateInvitationRequest struct {
+	Email        string `json:"email" validate:"required,email"`
+	Role         string `json:"role,omitempty"`
+	Organization string `json:"organization,omitempty"`
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestInvitationSignup(t *testing.T) {
+	clearTestDB()
+
+	organization := &Organization{Name: "acme", CreateDate: time.Now()}
+	GetOrganizationRepository().Create(organization)
+
+	// Create invitation for unknown organization
+	payload := `{"email": "foo@bar.com", "organization": "` + primitive.NewObjectID().Hex() + `"}`
+	req, _ := http.NewRequest("POST", "/invitations/", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	// Create invitation
+	payload = `{"email": "foo@bar.com", "role": "beta", "organization": "` + organization.ID.Hex() + `"}`
+	req, _ = http.NewRequest("POST", "/invitations/", bytes.NewBufferString(payload))
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	// Check mail content
+	checkTestString(t, "foo@bar.com", smtpMockContent.RcptValue)
+	invitationToken := smtpMockContent.Buffer.DataValue
+	checkStringNotEmpty(t, invitationToken)
+
+	// Sign up with invitation for different email address
+	payload = `{"email": "foo2@bar.com", "password": "12345678", "invitationToken": "` + invitationToken + `"}`
+	req, _ = http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	// Sign up with invitation
+	payload = `{"email": "foo@bar.com", "password": "12345678", "invitationToken": "` + invitationToken + `"}`
+	req, _ = http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	if user == nil {
+		t.Fatal("Expected user not to be nil")
+	}
+	if !user.Confirmed {
+		t.Error("Expected invited user to be confirmed")
+	}
+	checkTestString(t, organization.ID.Hex(), user.Organization)
+	checkTestString(t, OrganizationRoleMember, user.OrganizationRole)
+	if len(user.Roles) != 1 || user.Roles[0] != "beta" {
+		t.Error("Expected invited user to have role 'beta'")
+	}
+	if GetInvitationRepository().GetByToken(invitationToken) != nil {
+		t.Error("Expected invitation to be consumed")
+	}
+}
+
+func TestSignupWithoutInvitationWhenSignupDisabled(t *testing.T) {
+	os.Setenv("ALLOW_SIGNUP", "0")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("ALLOW_SIGNUP", "1")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeInvitationRequired, errorResponse.Error)
+}
+
+func TestDeleteInvitation(t *testing.T) {
+	clearTestDB()
+
+	payload := `{"email": "foo@bar.com"}`
+	req, _ := http.NewRequest("POST", "/invitations/", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	invitationID := res.Header().Get("X-Object-Id")
+
+	req, _ = http.NewRequest("DELETE", "/invitations/"+invitationID, nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req, _ = http.NewRequest("GET", "/invitations/"+invitationID, nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
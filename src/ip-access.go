@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const ErrorCodeIPNotAllowed = "ip_not_allowed"
+
+const (
+	IPAccessAllow = "allow"
+	IPAccessDeny  = "deny"
+)
+
+// IPAccessRule allows only or denies the given IP ranges access to routes matching Route,
+// which uses the syntax of PROXY_WHITELIST entries
+type IPAccessRule struct {
+	Route  string
+	Action string
+	Ranges []*net.IPNet
+	rule   *ProxyRule
+}
+
+// ParseIPAccessRule parses a rule in the format "<route> <allow|deny> <ranges>", ranges being IP addresses or CIDR ranges
+func ParseIPAccessRule(s string) (*IPAccessRule, error) {
+	route, action, values := _SplitAccessRule(s)
+	if route == "" {
+		return nil, errors.New("IP access rule must have the format <route> <allow|deny> <ranges>: " + s)
+	}
+	rule := &IPAccessRule{Route: route, Action: action}
+	var err error
+	if rule.Ranges, err = ParseIPRanges(values); err != nil {
+		return nil, err
+	}
+	if rule.rule, err = ParseProxyRule(rule.Route); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// _SplitAccessRule splits a rule in the format "<route> <allow|deny> <values>", returning an empty route if it's malformed
+func _SplitAccessRule(s string) (string, string, string) {
+	fields := strings.Fields(s)
+	for i, field := range fields {
+		action := strings.ToLower(field)
+		if action != IPAccessAllow && action != IPAccessDeny {
+			continue
+		}
+		if i == 0 || i == len(fields)-1 {
+			break
+		}
+		return strings.Join(fields[:i], " "), action, strings.Join(fields[i+1:], " ")
+	}
+	return "", "", ""
+}
+
+// Permits checks if the rule doesn't reject the client IP address
+func (rule *IPAccessRule) Permits(ip string) bool {
+	if rule.Action == IPAccessAllow {
+		return IPRangesContain(rule.Ranges, ip)
+	}
+	return !IPRangesContain(rule.Ranges, ip)
+}
+
+// IsIPAllowed checks the client IP address against the global deny and allow lists and the rules matching the request
+func IsIPAllowed(ip, method, path string) bool {
+	if IPRangesContain(GetConfig().IPDenylist, ip) {
+		return false
+	}
+	if len(GetConfig().IPAllowlist) > 0 && !IPRangesContain(GetConfig().IPAllowlist, ip) {
+		return false
+	}
+	for _, rule := range GetConfig().IPAccessRules {
+		if rule.rule.Matches(method, path) && !rule.Permits(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// IPAccessMiddleware rejects requests from IP addresses not allowed to access the route before authentication
+func IPAccessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := GetClientIP(r)
+		path := r.URL.EscapedPath()
+		if !IsIPAllowed(ip, r.Method, path) {
+			log.Println("Rejecting request", r.Method, path, "from IP address", ip)
+			SendError(w, http.StatusForbidden, ErrorCodeIPNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
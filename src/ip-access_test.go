@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestParseIPAccessRule(t *testing.T) {
+	rule, err := ParseIPAccessRule("GET,POST /admin allow 10.8.0.0/16 192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "GET,POST /admin", rule.Route)
+	checkTestString(t, IPAccessAllow, rule.Action)
+	if !rule.Permits("10.8.1.2") || !rule.Permits("192.0.2.10") || rule.Permits("192.0.2.11") {
+		t.Error("Expected only listed addresses to be permitted")
+	}
+	for _, s := range []string{"/admin allow", "allow 10.0.0.0/8", "/admin block 10.0.0.0/8", "/admin deny 10.0.0.0/33"} {
+		if _, err := ParseIPAccessRule(s); err == nil {
+			t.Errorf("Expected error for %s", s)
+		}
+	}
+}
+
+func TestIPAccess(t *testing.T) {
+	os.Setenv("IP_DENYLIST", "10.0.0.66")
+	os.Setenv("IP_ACCESS_RULES", "/admin allow 10.0.0.0/8; /admin/logs deny 10.0.0.5")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("IP_DENYLIST")
+		os.Unsetenv("IP_ACCESS_RULES")
+		GetConfig().ReadConfig()
+	}()
+	req := newHTTPRequest("GET", "/admin/users", "", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/admin/users", "", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+
+	req = newHTTPRequest("GET", "/admin/logs", "", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	req = newHTTPRequest("GET", "/some/route", "", nil)
+	req.RemoteAddr = "10.0.0.66:1234"
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+}
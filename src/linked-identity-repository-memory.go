@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryLinkedIdentityStore stores linked identities in memory, see STORAGE
+type MemoryLinkedIdentityStore struct {
+	identities *MemoryCollection[LinkedIdentity]
+}
+
+func NewMemoryLinkedIdentityStore() *MemoryLinkedIdentityStore {
+	return &MemoryLinkedIdentityStore{identities: NewMemoryCollection[LinkedIdentity]()}
+}
+
+func (r *MemoryLinkedIdentityStore) Create(u *LinkedIdentity) {
+	// Enforce the unique index on 'provider' and 'subject' like MongoDB does
+	if r.GetByProviderSubject(u.Provider, u.Subject) != nil {
+		log.Println("Duplicate linked identity", u.Provider, u.Subject)
+		return
+	}
+	u.ID = primitive.NewObjectID()
+	r.identities.Insert(u)
+}
+
+func (r *MemoryLinkedIdentityStore) GetOne(id string) *LinkedIdentity {
+	return r.identities.FindOne(func(i *LinkedIdentity) bool {
+		return i.ID.Hex() == id
+	})
+}
+
+func (r *MemoryLinkedIdentityStore) GetByProviderSubject(provider, subject string) *LinkedIdentity {
+	return r.identities.FindOne(func(i *LinkedIdentity) bool {
+		return i.Provider == provider && i.Subject == subject
+	})
+}
+
+func (r *MemoryLinkedIdentityStore) GetAllForUser(userID string) []*LinkedIdentity {
+	return r.identities.Find(func(i *LinkedIdentity) bool {
+		return i.UserID == UserID(userID)
+	})
+}
+
+func (r *MemoryLinkedIdentityStore) Delete(u *LinkedIdentity) {
+	r.identities.Delete(func(i *LinkedIdentity) bool {
+		return i.ID == u.ID
+	})
+}
+
+func (r *MemoryLinkedIdentityStore) DeleteAllForUser(userID string) {
+	r.identities.Delete(func(i *LinkedIdentity) bool {
+		return i.UserID == UserID(userID)
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const LinkedIdentityProviderOIDC = "oidc"
+
+// LinkedIdentity maps an identity at an external provider to a local user
+type LinkedIdentity struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     UserID             `json:"userId" bson:"userId"`
+	Provider   string             `json:"provider" bson:"provider"`
+	Subject    string             `json:"subject" bson:"subject"`
+	Email      string             `json:"email" bson:"email"`
+	CreateDate time.Time          `json:"createDate" bson:"createDate"`
+}
+
+// LinkedIdentityStore persists linked identities, see STORAGE
+type LinkedIdentityStore interface {
+	Create(u *LinkedIdentity)
+	GetOne(id string) *LinkedIdentity
+	GetByProviderSubject(provider, subject string) *LinkedIdentity
+	GetAllForUser(userID string) []*LinkedIdentity
+	Delete(u *LinkedIdentity)
+	DeleteAllForUser(userID string)
+}
+
+// LinkedIdentityRepository manages linked identities, storing them in the LinkedIdentityStore of the configured storage backend
+type LinkedIdentityRepository struct {
+	LinkedIdentityStore
+}
+
+var _linkedIdentityRepositoryInstance *LinkedIdentityRepository
+var _linkedIdentityRepositoryOnce sync.Once
+
+func GetLinkedIdentityRepository() *LinkedIdentityRepository {
+	_linkedIdentityRepositoryOnce.Do(func() {
+		_linkedIdentityRepositoryInstance = &LinkedIdentityRepository{GetStorageDriver().NewLinkedIdentityStore()}
+	})
+	return _linkedIdentityRepositoryInstance
+}
+
+type MongoLinkedIdentityStore struct {
+}
+
+func NewMongoLinkedIdentityStore() *MongoLinkedIdentityStore {
+	return &MongoLinkedIdentityStore{}
+}
+
+func (r *MongoLinkedIdentityStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("linked_identities")
+}
+
+func (r *MongoLinkedIdentityStore) Create(u *LinkedIdentity) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+func (r *MongoLinkedIdentityStore) GetOne(id string) *LinkedIdentity {
+	var identity LinkedIdentity
+	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&identity)
+	if err != nil {
+		return nil
+	}
+	return &identity
+}
+
+func (r *MongoLinkedIdentityStore) GetByProviderSubject(provider, subject string) *LinkedIdentity {
+	var identity LinkedIdentity
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"provider": provider, "subject": subject}).Decode(&identity)
+	if err != nil {
+		return nil
+	}
+	return &identity
+}
+
+func (r *MongoLinkedIdentityStore) GetAllForUser(userID string) []*LinkedIdentity {
+	results := make([]*LinkedIdentity, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var identity LinkedIdentity
+		err := cur.Decode(&identity)
+		if err != nil {
+			return results
+		}
+		results = append(results, &identity)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *MongoLinkedIdentityStore) Delete(u *LinkedIdentity) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoLinkedIdentityStore) DeleteAllForUser(userID string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// IsLoginRedirectRequest checks if an unauthenticated request should be redirected to PROXY_LOGIN_URL:
+// GET and HEAD requests to proxied paths from browsers, i.e. a user following a link to a protected page
+func IsLoginRedirectRequest(r *http.Request) bool {
+	if GetConfig().ProxyLoginURL == "" || (r.Method != "GET" && r.Method != "HEAD") || IsWebSocketUpgrade(r) {
+		return false
+	}
+	if strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+		return false
+	}
+	return _AcceptsHTML(r)
+}
+
+// RedirectToLogin redirects to PROXY_LOGIN_URL, passing the requested URL in PROXY_LOGIN_RETURN_PARAM
+func RedirectToLogin(w http.ResponseWriter, r *http.Request) {
+	target, _ := url.Parse(GetConfig().ProxyLoginURL)
+	query := target.Query()
+	query.Set(GetConfig().ProxyLoginReturnParam, GetRequestOrigin(r)+r.URL.RequestURI())
+	target.RawQuery = query.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// GetRequestOrigin returns the scheme and host the client requested, taking X-Forwarded-Proto and X-Forwarded-Host
+// into account if the request was received from a trusted proxy
+func GetRequestOrigin(r *http.Request) string {
+	scheme, host := "http", r.Host
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if IsTrustedProxy(r) {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestProxyRedirectsBrowserToLogin(t *testing.T) {
+	setTestBlacklist(t)
+	os.Setenv("PROXY_LOGIN_URL", "https://app.example.com/login?lang=en")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_LOGIN_URL")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	req := newHTTPRequest("GET", "/blacklist/page?id=1", "", nil)
+	req.Host = "proxy.example.com"
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,*/*;q=0.8")
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusFound, res.Code)
+	checkTestString(t, "https://app.example.com/login?lang=en&return_to=http%3A%2F%2Fproxy.example.com%2Fblacklist%2Fpage%3Fid%3D1", res.Header().Get("Location"))
+
+	req = newHTTPRequest("GET", "/blacklist/page", "", nil)
+	req.Header.Set("Accept", "application/json")
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	req = newHTTPRequest("POST", "/blacklist/page", "", nil)
+	req.Header.Set("Accept", "text/html")
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
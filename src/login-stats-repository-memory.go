@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+)
+
+// MemoryLoginStatsStore stores daily login counters in memory, see STORAGE
+type MemoryLoginStatsStore struct {
+	stats *MemoryCollection[LoginStats]
+}
+
+func NewMemoryLoginStatsStore() *MemoryLoginStatsStore {
+	return &MemoryLoginStatsStore{stats: NewMemoryCollection[LoginStats]()}
+}
+
+func (r *MemoryLoginStatsStore) CountLogin(success bool) {
+	date := time.Now().UTC().Format(statsDateFormat)
+	count := func(stats *LoginStats) {
+		if success {
+			stats.Successful++
+		} else {
+			stats.Failed++
+		}
+	}
+	if r.stats.Update(func(stats *LoginStats) bool { return stats.Date == date }, count) == 0 {
+		stats := &LoginStats{Date: date}
+		count(stats)
+		r.stats.Insert(stats)
+	}
+}
+
+func (r *MemoryLoginStatsStore) GetPerDay(from time.Time) map[string]*LoginStats {
+	results := make(map[string]*LoginStats)
+	fromDate := from.UTC().Format(statsDateFormat)
+	for _, stats := range r.stats.Find(func(stats *LoginStats) bool { return stats.Date >= fromDate }) {
+		results[stats.Date] = stats
+	}
+	return results
+}
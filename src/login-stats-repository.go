@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginStats holds the number of successful and failed logins of one day (UTC)
+type LoginStats struct {
+	Date       string `json:"date" bson:"_id"`
+	Successful int64  `json:"successful" bson:"successful"`
+	Failed     int64  `json:"failed" bson:"failed"`
+}
+
+// LoginStatsStore persists daily login counters, see STORAGE
+type LoginStatsStore interface {
+	CountLogin(success bool)
+	GetPerDay(from time.Time) map[string]*LoginStats
+}
+
+// LoginStatsRepository manages daily login counters, storing them in the LoginStatsStore of the configured storage backend
+type LoginStatsRepository struct {
+	LoginStatsStore
+}
+
+var _loginStatsRepositoryInstance *LoginStatsRepository
+var _loginStatsRepositoryOnce sync.Once
+
+func GetLoginStatsRepository() *LoginStatsRepository {
+	_loginStatsRepositoryOnce.Do(func() {
+		_loginStatsRepositoryInstance = &LoginStatsRepository{GetStorageDriver().NewLoginStatsStore()}
+	})
+	return _loginStatsRepositoryInstance
+}
+
+type MongoLoginStatsStore struct {
+}
+
+func NewMongoLoginStatsStore() *MongoLoginStatsStore {
+	return &MongoLoginStatsStore{}
+}
+
+func (r *MongoLoginStatsStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("login_stats")
+}
+
+// CountLogin increments today's counter of successful or failed logins
+func (r *MongoLoginStatsStore) CountLogin(success bool) {
+	field := "failed"
+	if success {
+		field = "successful"
+	}
+	date := time.Now().UTC().Format(statsDateFormat)
+	opts := options.Update().SetUpsert(true)
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": date}, bson.M{"$inc": bson.M{field: 1}}, opts)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// GetPerDay returns the login counters of all days since the given date, keyed by date
+func (r *MongoLoginStatsStore) GetPerDay(from time.Time) map[string]*LoginStats {
+	results := make(map[string]*LoginStats)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{"_id": bson.M{"$gte": from.UTC().Format(statsDateFormat)}})
+	if err != nil {
+		log.Println(err)
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var stats LoginStats
+		if err := cur.Decode(&stats); err != nil {
+			log.Println(err)
+			break
+		}
+		results[stats.Date] = &stats
+	}
+	cur.Close(context.TODO())
+	return results
+}
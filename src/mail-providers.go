@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MailProviderSMTP     = "smtp"
+	MailProviderSendGrid = "sendgrid"
+	MailProviderSES      = "ses"
+	MailProviderMailgun  = "mailgun"
+	MailProviderPostmark = "postmark"
+)
+
+// mailProviderURLs are the default API base URLs, overridden by MAIL_API_URL
+var mailProviderURLs = map[string]string{
+	MailProviderSendGrid: "https://api.sendgrid.com",
+	MailProviderMailgun:  "https://api.mailgun.net",
+	MailProviderPostmark: "https://api.postmarkapp.com",
+}
+
+// mailAPITimeout limits requests to the mail providers' HTTP APIs
+const mailAPITimeout = 30 * time.Second
+
+var (
+	mailSender = func() MailSender {
+		url := GetConfig().MailAPIURL
+		if url == "" {
+			url = mailProviderURLs[GetConfig().MailProvider]
+		}
+		switch GetConfig().MailProvider {
+		case MailProviderSendGrid:
+			return &SendGridMailSender{URL: url, APIKey: GetConfig().MailAPIKey}
+		case MailProviderSES:
+			if url == "" {
+				url = "https://email." + GetConfig().AWSRegion + ".amazonaws.com"
+			}
+			return &SESMailSender{
+				URL:             url,
+				Region:          GetConfig().AWSRegion,
+				AccessKeyID:     GetConfig().AWSAccessKeyID,
+				SecretAccessKey: GetConfig().AWSSecretAccessKey,
+				SessionToken:    GetConfig().AWSSessionToken,
+			}
+		case MailProviderMailgun:
+			return &MailgunMailSender{URL: url, APIKey: GetConfig().MailAPIKey, Domain: GetConfig().MailgunDomain}
+		case MailProviderPostmark:
+			return &PostmarkMailSender{URL: url, ServerToken: GetConfig().MailAPIKey}
+		default:
+			return &SMTPMailSender{}
+		}
+	}
+)
+
+// SendGridMailSender delivers messages via SendGrid's v3 mail send API
+type SendGridMailSender struct {
+	URL    string
+	APIKey string
+}
+
+// SESMailSender delivers raw messages via the AWS SES v2 API
+type SESMailSender struct {
+	URL             string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// MailgunMailSender delivers raw messages via Mailgun's MIME messages API
+type MailgunMailSender struct {
+	URL    string
+	APIKey string
+	Domain string
+}
+
+// PostmarkMailSender delivers messages via Postmark's email API
+type PostmarkMailSender struct {
+	URL         string
+	ServerToken string
+}
+
+// _ParsedMail is a message rendered from a template, split up for APIs that don't accept raw messages
+type _ParsedMail struct {
+	From     *mail.Address
+	Subject  string
+	Body     string
+	HTMLBody string
+	Inline   []*MailAttachment
+}
+
+func _ParseMail(message string) (*_ParsedMail, error) {
+	msg, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		from = &mail.Address{Address: GetConfig().SMTPSenderAddr}
+	}
+	m := &_ParsedMail{
+		From:    from,
+		Subject: msg.Header.Get("Subject"),
+	}
+	contentType := msg.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	if err := m._ParsePart(textproto.MIMEHeader{"Content-Type": {contentType}}, msg.Body); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// _ParsePart collects the plain text and HTML bodies and inline attachments of a (multipart) message
+func (m *_ParsedMail) _ParsePart(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := m._ParsePart(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+	// Quoted-printable parts are decoded by the multipart reader already
+	if strings.EqualFold(header.Get("Content-Transfer-Encoding"), "base64") {
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	switch {
+	case mediaType == "text/plain" && m.Body == "":
+		m.Body = string(data)
+	case mediaType == "text/html" && m.HTMLBody == "":
+		m.HTMLBody = string(data)
+	case header.Get("Content-Id") != "":
+		_, dispositionParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+		m.Inline = append(m.Inline, &MailAttachment{
+			ContentID:   strings.Trim(header.Get("Content-Id"), "<>"),
+			ContentType: mediaType,
+			Filename:    dispositionParams["filename"],
+			Data:        data,
+		})
+	}
+	return nil
+}
+
+// _DoMailAPIRequest sends a request to a mail provider, treating any status code but 2xx as failure
+func _DoMailAPIRequest(req *http.Request) error {
+	client := &http.Client{Timeout: mailAPITimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		details, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return errors.New("Unexpected status code " + strconv.Itoa(res.StatusCode) + ": " + strings.TrimSpace(string(details)))
+	}
+	return nil
+}
+
+func (s *SendGridMailSender) Send(recv, message string) error {
+	m, err := _ParseMail(message)
+	if err != nil {
+		return err
+	}
+	content := []interface{}{map[string]string{"type": "text/plain", "value": m.Body}}
+	if m.HTMLBody != "" {
+		content = append(content, map[string]string{"type": "text/html", "value": m.HTMLBody})
+	}
+	email := map[string]interface{}{
+		"personalizations": []interface{}{
+			map[string]interface{}{
+				"to": []interface{}{map[string]string{"email": recv}},
+			},
+		},
+		"from":    map[string]string{"email": m.From.Address, "name": m.From.Name},
+		"subject": m.Subject,
+		"content": content,
+	}
+	if len(m.Inline) > 0 {
+		attachments := make([]interface{}, 0, len(m.Inline))
+		for _, a := range m.Inline {
+			attachments = append(attachments, map[string]string{
+				"content":     base64.StdEncoding.EncodeToString(a.Data),
+				"type":        a.ContentType,
+				"filename":    a.Filename,
+				"disposition": "inline",
+				"content_id":  a.ContentID,
+			})
+		}
+		email["attachments"] = attachments
+	}
+	payload, err := json.Marshal(email)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.URL+"/v3/mail/send", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	return _DoMailAPIRequest(req)
+}
+
+func (s *SESMailSender) Send(recv, message string) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"FromEmailAddress": GetConfig().SMTPSenderAddr,
+		"Destination": map[string]interface{}{
+			"ToAddresses": []string{recv},
+		},
+		"Content": map[string]interface{}{
+			"Raw": map[string]string{"Data": base64.StdEncoding.EncodeToString([]byte(message))},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.URL+"/v2/email/outbound-emails", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	SignAWSRequest(req, payload, s.Region, "ses", s.AccessKeyID, s.SecretAccessKey, time.Now())
+	return _DoMailAPIRequest(req)
+}
+
+func (s *MailgunMailSender) Send(recv, message string) error {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("to", recv); err != nil {
+		return err
+	}
+	fw, err := mw.CreateFormFile("message", "message.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(fw, message); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.URL+"/v3/"+s.Domain+"/messages.mime", &buf)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", s.APIKey)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return _DoMailAPIRequest(req)
+}
+
+func (s *PostmarkMailSender) Send(recv, message string) error {
+	m, err := _ParseMail(message)
+	if err != nil {
+		return err
+	}
+	email := map[string]interface{}{
+		"From":     m.From.String(),
+		"To":       recv,
+		"Subject":  m.Subject,
+		"TextBody": m.Body,
+	}
+	if m.HTMLBody != "" {
+		email["HtmlBody"] = m.HTMLBody
+	}
+	if len(m.Inline) > 0 {
+		attachments := make([]interface{}, 0, len(m.Inline))
+		for _, a := range m.Inline {
+			attachments = append(attachments, map[string]string{
+				"Name":        a.Filename,
+				"Content":     base64.StdEncoding.EncodeToString(a.Data),
+				"ContentType": a.ContentType,
+				"ContentID":   "cid:" + a.ContentID,
+			})
+		}
+		email["Attachments"] = attachments
+	}
+	payload, err := json.Marshal(email)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.URL+"/email", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Postmark-Server-Token", s.ServerToken)
+	return _DoMailAPIRequest(req)
+}
+
+// SignAWSRequest adds an AWS Signature Version 4 authorization header to the request, signing the host,
+// the Content-Type and X-Amz-* headers as well as the body
+func SignAWSRequest(req *http.Request, body []byte, region, service, accessKeyID, secretAccessKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+hex.EncodeToString(key))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMailMessage = "From: Service <no-reply@localhost>\r\nTo: foo@bar.com\r\nSubject: Confirm your account\r\n\r\nHello World!"
+
+type mailAPIRequestMock struct {
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+func runMailAPIMock(t *testing.T, status int) (*httptest.Server, *mailAPIRequestMock) {
+	received := &mailAPIRequestMock{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Path = r.URL.Path
+		received.Header = r.Header
+		received.Body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func TestSendGridMailSender(t *testing.T) {
+	server, received := runMailAPIMock(t, http.StatusAccepted)
+	sender := &SendGridMailSender{URL: server.URL, APIKey: "key"}
+	if err := sender.Send("foo@bar.com", testMailMessage); err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "/v3/mail/send", received.Path)
+	checkTestString(t, "Bearer key", received.Header.Get("Authorization"))
+	var payload struct {
+		Personalizations []struct {
+			To []struct {
+				Email string `json:"email"`
+			} `json:"to"`
+		} `json:"personalizations"`
+		From struct {
+			Email string `json:"email"`
+			Name  string `json:"name"`
+		} `json:"from"`
+		Subject string `json:"subject"`
+		Content []struct {
+			Value string `json:"value"`
+		} `json:"content"`
+	}
+	json.Unmarshal(received.Body, &payload)
+	checkTestString(t, "foo@bar.com", payload.Personalizations[0].To[0].Email)
+	checkTestString(t, "no-reply@localhost", payload.From.Email)
+	checkTestString(t, "Service", payload.From.Name)
+	checkTestString(t, "Confirm your account", payload.Subject)
+	checkTestString(t, "Hello World!", payload.Content[0].Value)
+}
+
+func TestPostmarkMailSender(t *testing.T) {
+	server, received := runMailAPIMock(t, http.StatusOK)
+	sender := &PostmarkMailSender{URL: server.URL, ServerToken: "token"}
+	if err := sender.Send("foo@bar.com", testMailMessage); err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "/email", received.Path)
+	checkTestString(t, "token", received.Header.Get("X-Postmark-Server-Token"))
+	var payload map[string]string
+	json.Unmarshal(received.Body, &payload)
+	checkTestString(t, "foo@bar.com", payload["To"])
+	checkTestString(t, "Confirm your account", payload["Subject"])
+	checkTestString(t, "Hello World!", payload["TextBody"])
+}
+
+func TestMailgunMailSender(t *testing.T) {
+	server, received := runMailAPIMock(t, http.StatusOK)
+	sender := &MailgunMailSender{URL: server.URL, APIKey: "key", Domain: "mg.example.com"}
+	if err := sender.Send("foo@bar.com", testMailMessage); err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "/v3/mg.example.com/messages.mime", received.Path)
+	if !strings.HasPrefix(received.Header.Get("Authorization"), "Basic ") {
+		t.Error("Expected basic authentication")
+	}
+	if !strings.Contains(string(received.Body), testMailMessage) {
+		t.Error("Expected raw message to be uploaded")
+	}
+}
+
+func TestSESMailSender(t *testing.T) {
+	server, received := runMailAPIMock(t, http.StatusOK)
+	sender := &SESMailSender{URL: server.URL, Region: "eu-central-1", AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	if err := sender.Send("foo@bar.com", testMailMessage); err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "/v2/email/outbound-emails", received.Path)
+	if !strings.HasPrefix(received.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+		t.Error("Expected request to be signed")
+	}
+	var payload struct {
+		Destination struct {
+			ToAddresses []string
+		}
+		Content struct {
+			Raw struct {
+				Data []byte
+			}
+		}
+	}
+	json.Unmarshal(received.Body, &payload)
+	checkTestString(t, "foo@bar.com", payload.Destination.ToAddresses[0])
+	checkTestString(t, testMailMessage, string(payload.Content.Raw.Data))
+}
+
+func TestMailSenderErrorStatus(t *testing.T) {
+	server, _ := runMailAPIMock(t, http.StatusUnauthorized)
+	sender := &PostmarkMailSender{URL: server.URL, ServerToken: "wrong"}
+	if err := sender.Send("foo@bar.com", testMailMessage); err == nil {
+		t.Error("Expected error for unauthorized request")
+	}
+}
+
+func TestSignAWSRequest(t *testing.T) {
+	// Test vector "get-vanilla" of the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	SignAWSRequest(req, nil, "us-east-1", "service", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", now)
+	checkTestString(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryMailQueueStore stores queued mails in memory, see STORAGE
+type MemoryMailQueueStore struct {
+	mails *MemoryCollection[QueuedMail]
+}
+
+func NewMemoryMailQueueStore() *MemoryMailQueueStore {
+	return &MemoryMailQueueStore{mails: NewMemoryCollection[QueuedMail]()}
+}
+
+func (r *MemoryMailQueueStore) _ByID(id primitive.ObjectID) func(*QueuedMail) bool {
+	return func(m *QueuedMail) bool {
+		return m.ID == id
+	}
+}
+
+func (r *MemoryMailQueueStore) Create(m *QueuedMail) error {
+	m.ID = primitive.NewObjectID()
+	r.mails.Insert(m)
+	return nil
+}
+
+func (r *MemoryMailQueueStore) GetOne(id string) *QueuedMail {
+	return r.mails.FindOne(func(m *QueuedMail) bool {
+		return m.ID.Hex() == id
+	})
+}
+
+func (r *MemoryMailQueueStore) Find(status string, limit int64) []*QueuedMail {
+	results := r.mails.Find(func(m *QueuedMail) bool {
+		return status == "" || m.Status == status
+	})
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].CreateDate.Before(results[j].CreateDate)
+	})
+	if limit > 0 && int64(len(results)) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (r *MemoryMailQueueStore) ClaimDue(now, lockedUntil time.Time) *QueuedMail {
+	due := r.mails.Find(func(m *QueuedMail) bool {
+		return m.Status == QueuedMailStatusPending && !m.NextAttemptDate.After(now) && !m.LockedUntil.After(now)
+	})
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttemptDate.Before(due[j].NextAttemptDate)
+	})
+	for _, candidate := range due {
+		var claimed *QueuedMail
+		// Check again while holding the lock, the mail may have been claimed since
+		r.mails.Update(func(m *QueuedMail) bool {
+			return m.ID == candidate.ID && m.Status == QueuedMailStatusPending && !m.LockedUntil.After(now)
+		}, func(m *QueuedMail) {
+			m.LockedUntil = lockedUntil
+			claimed = _CopyMemoryDocument(m)
+		})
+		if claimed != nil {
+			return claimed
+		}
+	}
+	return nil
+}
+
+func (r *MemoryMailQueueStore) Update(m *QueuedMail) {
+	r.mails.Replace(r._ByID(m.ID), m)
+}
+
+func (r *MemoryMailQueueStore) Delete(m *QueuedMail) {
+	r.mails.Delete(r._ByID(m.ID))
+}
+
+func (r *MemoryMailQueueStore) CleanUp() {
+	now := time.Now()
+	r.mails.Delete(func(m *QueuedMail) bool {
+		return m.Status == QueuedMailStatusDead && !m.ExpiryDate.After(now)
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	QueuedMailStatusPending = "pending"
+	QueuedMailStatusDead    = "dead"
+)
+
+// QueuedMail is a mail waiting to be sent, or given up on after MAIL_QUEUE_MAX_ATTEMPTS
+type QueuedMail struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Recipient string             `json:"recipient" bson:"recipient"`
+	Subject   string             `json:"subject" bson:"subject"`
+	// Message contains confirmation tokens or passwords, so it's never returned by the backend API
+	Message         string    `json:"-" bson:"message"`
+	Status          string    `json:"status" bson:"status"`
+	Attempts        int       `json:"attempts" bson:"attempts"`
+	LastError       string    `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreateDate      time.Time `json:"createDate" bson:"createDate"`
+	NextAttemptDate time.Time `json:"nextAttemptDate" bson:"nextAttemptDate"`
+	LockedUntil     time.Time `json:"-" bson:"lockedUntil"`
+	// ExpiryDate is set once a mail is dead, it's purged afterwards
+	ExpiryDate time.Time `json:"expiryDate,omitempty" bson:"expiryDate,omitempty"`
+}
+
+// MailQueueStore persists queued mails, see STORAGE
+type MailQueueStore interface {
+	Create(m *QueuedMail) error
+	GetOne(id string) *QueuedMail
+	Find(status string, limit int64) []*QueuedMail
+	// ClaimDue locks the pending mail due the longest until lockedUntil, so that no other instance sends it meanwhile
+	ClaimDue(now, lockedUntil time.Time) *QueuedMail
+	Update(m *QueuedMail)
+	Delete(m *QueuedMail)
+	CleanUp()
+}
+
+// MailQueueRepository manages queued mails, storing them in the MailQueueStore of the configured storage backend
+type MailQueueRepository struct {
+	MailQueueStore
+}
+
+var _mailQueueRepositoryInstance *MailQueueRepository
+var _mailQueueRepositoryOnce sync.Once
+
+func GetMailQueueRepository() *MailQueueRepository {
+	_mailQueueRepositoryOnce.Do(func() {
+		_mailQueueRepositoryInstance = &MailQueueRepository{GetStorageDriver().NewMailQueueStore()}
+	})
+	return _mailQueueRepositoryInstance
+}
+
+type MongoMailQueueStore struct {
+}
+
+func NewMongoMailQueueStore() *MongoMailQueueStore {
+	return &MongoMailQueueStore{}
+}
+
+func (r *MongoMailQueueStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("mail_queue")
+}
+
+func (r *MongoMailQueueStore) Create(m *QueuedMail) error {
+	res, err := r.GetCollection().InsertOne(context.TODO(), m)
+	if err != nil {
+		return err
+	}
+	id, ok := res.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return errors.New("Unexpected ID of queued mail")
+	}
+	m.ID = id
+	return nil
+}
+
+func (r *MongoMailQueueStore) GetOne(id string) *QueuedMail {
+	var m QueuedMail
+	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&m)
+	if err != nil {
+		return nil
+	}
+	return &m
+}
+
+// Find returns the queued mails with the status, or all if it's empty, oldest first
+func (r *MongoMailQueueStore) Find(status string, limit int64) []*QueuedMail {
+	results := make([]*QueuedMail, 0)
+	query := bson.M{}
+	if status != "" {
+		query["status"] = status
+	}
+	opts := options.Find().SetSort(bson.M{"createDate": 1})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cur, err := r.GetCollection().Find(context.TODO(), query, opts)
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var m QueuedMail
+		err := cur.Decode(&m)
+		if err != nil {
+			return results
+		}
+		results = append(results, &m)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *MongoMailQueueStore) ClaimDue(now, lockedUntil time.Time) *QueuedMail {
+	var m QueuedMail
+	filter := bson.M{
+		"status":          QueuedMailStatusPending,
+		"nextAttemptDate": bson.M{"$lte": now},
+		"lockedUntil":     bson.M{"$lte": now},
+	}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"nextAttemptDate": 1}).SetReturnDocument(options.After)
+	err := r.GetCollection().FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": bson.M{"lockedUntil": lockedUntil}}, opts).Decode(&m)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println(err)
+		}
+		return nil
+	}
+	return &m
+}
+
+func (r *MongoMailQueueStore) Update(m *QueuedMail) {
+	_, err := r.GetCollection().ReplaceOne(context.TODO(), bson.M{"_id": m.ID}, m)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoMailQueueStore) Delete(m *QueuedMail) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": m.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// CleanUp purges dead mails once they have expired
+func (r *MongoMailQueueStore) CleanUp() {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"status": QueuedMailStatusDead, "expiryDate": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		log.Println(err)
+	}
+}
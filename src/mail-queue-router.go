@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type MailQueueRouter struct {
+}
+
+func (router *MailQueueRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/{id}/retry", router.retry).Methods("POST")
+	s.HandleFunc("/{id}", router.getOne).Methods("GET")
+	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
+	s.HandleFunc("/", router.getAll).Methods("GET")
+}
+
+func (router *MailQueueRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != QueuedMailStatusPending && status != QueuedMailStatusDead {
+		SendBadRequest(w)
+		return
+	}
+	var limit int64 = 100
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.ParseInt(s, 10, 64); err != nil || limit < 1 {
+			SendBadRequest(w)
+			return
+		}
+	}
+	SendJSON(w, GetMailQueueRepository().Find(status, limit))
+}
+
+func (router *MailQueueRouter) getOne(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m := GetMailQueueRepository().GetOne(vars["id"])
+	if m == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, m)
+}
+
+func (router *MailQueueRouter) retry(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m := GetMailQueueRepository().GetOne(vars["id"])
+	if m == nil {
+		SendNotFound(w)
+		return
+	}
+	RetryQueuedMail(m)
+	SendUpdated(w)
+}
+
+func (router *MailQueueRouter) delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	m := GetMailQueueRepository().GetOne(vars["id"])
+	if m == nil {
+		SendNotFound(w)
+		return
+	}
+	GetMailQueueRepository().Delete(m)
+	SendUpdated(w)
+}
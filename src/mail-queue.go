@@ -0,0 +1,112 @@
+package main
+
+import (
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// mailQueuePollInterval is how often the queue is checked for mails due for a retry
+const mailQueuePollInterval = 10 * time.Second
+
+// mailQueueLockDuration must exceed the time needed to send a mail, otherwise it may be sent twice
+const mailQueueLockDuration = 5 * time.Minute
+
+// mailQueueMaxBackoff limits the delay between retries
+const mailQueueMaxBackoff = time.Hour
+
+// mailQueueDeadRetention is how long dead mails are kept for inspection via the backend API
+const mailQueueDeadRetention = 7 * 24 * time.Hour
+
+// mailQueueWakeup starts processing the queue right after a mail has been queued instead of on the next tick
+var mailQueueWakeup = make(chan struct{}, 1)
+
+// EnqueueMail stores a mail in the queue to be sent in the background, an error means it hasn't been queued
+func EnqueueMail(recv, message string) error {
+	now := time.Now()
+	m := &QueuedMail{
+		Recipient:       recv,
+		Subject:         _GetMailSubject(message),
+		Message:         message,
+		Status:          QueuedMailStatusPending,
+		CreateDate:      now,
+		NextAttemptDate: now,
+	}
+	if err := GetMailQueueRepository().Create(m); err != nil {
+		return err
+	}
+	select {
+	case mailQueueWakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func _GetMailSubject(message string) string {
+	msg, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		return ""
+	}
+	return msg.Header.Get("Subject")
+}
+
+// ProcessMailQueue sends all mails due, until none is left
+func ProcessMailQueue() {
+	for {
+		now := time.Now()
+		m := GetMailQueueRepository().ClaimDue(now, now.Add(mailQueueLockDuration))
+		if m == nil {
+			return
+		}
+		_DeliverQueuedMail(m)
+	}
+}
+
+// _DeliverQueuedMail sends a claimed mail, removing it from the queue on success. Failed mails are retried
+// with exponential backoff, after MAIL_QUEUE_MAX_ATTEMPTS they are marked dead.
+func _DeliverQueuedMail(m *QueuedMail) {
+	err := mailSender().Send(m.Recipient, m.Message)
+	if err == nil {
+		GetMailQueueRepository().Delete(m)
+		return
+	}
+	m.Attempts++
+	m.LastError = err.Error()
+	m.LockedUntil = time.Time{}
+	if m.Attempts >= GetConfig().MailQueueMaxAttempts {
+		log.Println("Giving up sending mail", m.ID.Hex(), "to", m.Recipient, "after", m.Attempts, "attempts:", err)
+		m.Status = QueuedMailStatusDead
+		m.ExpiryDate = time.Now().Add(mailQueueDeadRetention)
+	} else {
+		backoff := GetMailQueueBackoff(m.Attempts)
+		log.Println("Could not send mail", m.ID.Hex(), "to", m.Recipient+", retrying in", backoff.String()+":", err)
+		m.NextAttemptDate = time.Now().Add(backoff)
+	}
+	GetMailQueueRepository().Update(m)
+}
+
+// GetMailQueueBackoff returns the delay before the next attempt, doubling MAIL_QUEUE_RETRY_BACKOFF with each failed one
+func GetMailQueueBackoff(attempts int) time.Duration {
+	backoff := time.Second * GetConfig().MailQueueRetryBackoff
+	for i := 1; i < attempts && backoff < mailQueueMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > mailQueueMaxBackoff {
+		return mailQueueMaxBackoff
+	}
+	return backoff
+}
+
+// RetryQueuedMail makes a dead or pending mail due immediately with all attempts available again
+func RetryQueuedMail(m *QueuedMail) {
+	m.Status = QueuedMailStatusPending
+	m.Attempts = 0
+	m.NextAttemptDate = time.Now()
+	m.ExpiryDate = time.Time{}
+	GetMailQueueRepository().Update(m)
+	select {
+	case mailQueueWakeup <- struct{}{}:
+	default:
+	}
+}
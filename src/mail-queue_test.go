@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type mailSenderMock struct {
+	Err  error
+	Sent []string
+}
+
+func (s *mailSenderMock) Send(recv, message string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	s.Sent = append(s.Sent, recv)
+	return nil
+}
+
+// enableTestMailQueue queues mails and delivers them with the returned mock when ProcessMailQueue is called
+func enableTestMailQueue(t *testing.T) *mailSenderMock {
+	sender := &mailSenderMock{}
+	prevSender := mailSender
+	mailSender = func() MailSender {
+		return sender
+	}
+	GetConfig().MailQueueEnable = true
+	GetApp().InitializeBackendRouter()
+	t.Cleanup(func() {
+		mailSender = prevSender
+		GetConfig().MailQueueEnable = false
+		GetApp().InitializeBackendRouter()
+	})
+	return sender
+}
+
+func TestMailQueueSignupWithFailingProvider(t *testing.T) {
+	clearTestDB()
+	sender := enableTestMailQueue(t)
+	sender.Err = errors.New("connection refused")
+
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	ProcessMailQueue()
+	mails := GetMailQueueRepository().Find(QueuedMailStatusPending, 0)
+	if len(mails) != 1 {
+		t.Fatalf("Expected 1 pending mail, got %d", len(mails))
+	}
+	checkTestString(t, "foo@bar.com", mails[0].Recipient)
+	checkTestString(t, "connection refused", mails[0].LastError)
+	if mails[0].Attempts != 1 || !mails[0].NextAttemptDate.After(time.Now()) {
+		t.Error("Expected retry to be scheduled after the failed attempt")
+	}
+
+	// Not due yet
+	sender.Err = nil
+	ProcessMailQueue()
+	if len(sender.Sent) != 0 {
+		t.Error("Expected mail not to be retried before the backoff")
+	}
+
+	mails[0].NextAttemptDate = time.Now()
+	GetMailQueueRepository().Update(mails[0])
+	ProcessMailQueue()
+	if len(sender.Sent) != 1 || sender.Sent[0] != "foo@bar.com" {
+		t.Error("Expected mail to be sent on retry")
+	}
+	if len(GetMailQueueRepository().Find("", 0)) != 0 {
+		t.Error("Expected sent mail to be removed from the queue")
+	}
+}
+
+func TestMailQueueDeadLetter(t *testing.T) {
+	clearTestDB()
+	sender := enableTestMailQueue(t)
+	sender.Err = errors.New("mailbox unavailable")
+	prevMaxAttempts := GetConfig().MailQueueMaxAttempts
+	GetConfig().MailQueueMaxAttempts = 2
+	defer func() { GetConfig().MailQueueMaxAttempts = prevMaxAttempts }()
+
+	if err := SendMail("foo@bar.com", "Subject: Hello\n\nHello World!"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		for _, m := range GetMailQueueRepository().Find(QueuedMailStatusPending, 0) {
+			m.NextAttemptDate = time.Now()
+			GetMailQueueRepository().Update(m)
+		}
+		ProcessMailQueue()
+	}
+
+	req, _ := http.NewRequest("GET", "/mail-queue/?status=dead", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var mails []map[string]interface{}
+	json.Unmarshal(res.Body.Bytes(), &mails)
+	if len(mails) != 1 {
+		t.Fatalf("Expected 1 dead mail, got %d", len(mails))
+	}
+	checkTestString(t, "Hello", mails[0]["subject"].(string))
+	checkTestString(t, "mailbox unavailable", mails[0]["lastError"].(string))
+	if _, ok := mails[0]["message"]; ok {
+		t.Error("Expected message not to be returned")
+	}
+
+	sender.Err = nil
+	req, _ = http.NewRequest("POST", "/mail-queue/"+mails[0]["id"].(string)+"/retry", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	ProcessMailQueue()
+	if len(sender.Sent) != 1 {
+		t.Error("Expected dead mail to be sent after retry")
+	}
+
+	req, _ = http.NewRequest("GET", "/mail-queue/?status=unknown", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
+
+func TestMailQueueBackoff(t *testing.T) {
+	prevBackoff := GetConfig().MailQueueRetryBackoff
+	GetConfig().MailQueueRetryBackoff = 30
+	defer func() { GetConfig().MailQueueRetryBackoff = prevBackoff }()
+
+	if GetMailQueueBackoff(1) != 30*time.Second || GetMailQueueBackoff(3) != 2*time.Minute {
+		t.Error("Expected backoff to double with each attempt")
+	}
+	if GetMailQueueBackoff(20) != mailQueueMaxBackoff {
+		t.Error("Expected backoff to be limited")
+	}
+}
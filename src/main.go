@@ -6,14 +6,29 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backup":
+			RunBackup(os.Args[2:])
+			os.Exit(0)
+		case "restore":
+			RunRestore(os.Args[2:])
+			os.Exit(0)
+		}
+	}
 	log.Println("Starting server...")
+	if GetConfig().TenantsFile != "" {
+		RunTenants()
+		os.Exit(0)
+	}
 	a := GetApp()
-	GetDatatabase().connectMongoDb(GetConfig().MongoDbURL, GetConfig().MongoDbName)
+	GetStorageDriver().Connect()
+	BootstrapAdminUser()
 	a.InitializePublicRouter()
 	a.InitializeBackendRouter()
 	a.InitializeTimers()
 	readMailTemplatesFromFile()
 	a.Run(GetConfig().PublicListenAddr, GetConfig().BackendListenAddr)
-	GetDatatabase().disconnect()
+	GetStorageDriver().Disconnect()
 	os.Exit(0)
 }
@@ -19,26 +19,50 @@ import (
 
 func TestMain(m *testing.M) {
 	os.Setenv("PROXY_TARGET", "http://127.0.0.1:8090")
+	os.Setenv("MONGO_DB_URL", "mongodb://localhost:27017")
+	os.Setenv("MONGO_DB_NAME", "jwt_auth_proxy_test")
 	os.Setenv("PROXY_BLACKLIST", "/blacklist")
 	os.Setenv("TEMPLATE_SIGNUP", "../test/res/signup.tpl")
 	os.Setenv("TEMPLATE_CHANGE_EMAIL", "../test/res/changeemail.tpl")
 	os.Setenv("TEMPLATE_RESET_PASSWORD", "../test/res/resetpassword.tpl")
 	os.Setenv("TEMPLATE_NEW_PASSWORD", "../test/res/newpassword.tpl")
+	os.Setenv("TEMPLATE_INVITATION", "../test/res/invitation.tpl")
+	os.Setenv("TEMPLATE_CHANGE_EMAIL_OLD", "../test/res/changeemailold.tpl")
+	os.Setenv("TEMPLATE_EMAIL_CHANGED", "../test/res/emailchanged.tpl")
+	os.Setenv("TEMPLATE_ADD_EMAIL", "../test/res/addemail.tpl")
+	os.Setenv("MAX_ADDITIONAL_EMAILS", "2")
+	os.Setenv("ALLOW_INVITATIONS", "1")
 	os.Setenv("CORS_ENABLE", "1")
 	os.Setenv("TOTP_ENABLE", "1")
 	os.Setenv("TOTP_ENCRYPT_KEY", "w66iO0l3Kru7Qgpx")
+	os.Setenv("TOTP_TRUSTED_DEVICE_LIFETIME", "30")
+	os.Setenv("API_KEYS_ENABLE", "1")
+	os.Setenv("DEVICE_FLOW_ENABLE", "1")
+	os.Setenv("GUEST_ENABLE", "1")
+	os.Setenv("SIGNED_URL_KEY", "ohzeeg0aiv5Ohth4eiPh9aiNgoh3Ahx7")
+	os.Setenv("SMS_PROVIDER", "log")
+	os.Setenv("PUBLIC_TLS_CERT", "../certs/server.crt")
+	os.Setenv("PUBLIC_TLS_KEY", "../certs/server.key")
+	os.Setenv("CLIENT_CERT_AUTH_ENABLE", "1")
+	os.Setenv("CLIENT_CERT_CA", "../certs/ca.crt")
+	os.Setenv("DEVICE_POLL_INTERVAL", "0")
+	oidcTestIssuer = newOIDCIssuerMock()
+	os.Setenv("OIDC_ISSUER", oidcTestIssuer.server.URL)
+	os.Setenv("OIDC_CLIENT_ID", "test-client")
+	os.Setenv("OIDC_ROLES_CLAIM", "roles")
 	GetConfig().ReadConfig()
 	smtpClient = func(addr string) (dialer, error) {
 		client := &smtpDialerMock{}
 		return client, nil
 	}
 	a := GetApp()
-	GetDatatabase().connectMongoDb("mongodb://localhost:27017", "jwt_auth_proxy_test")
+	GetStorageDriver().Connect()
 	a.InitializePublicRouter()
 	a.InitializeBackendRouter()
 	readMailTemplatesFromFile()
 	code := m.Run()
-	GetDatatabase().disconnect()
+	GetStorageDriver().Disconnect()
+	oidcTestIssuer.server.Close()
 	os.Exit(code)
 }
 
@@ -96,6 +120,15 @@ func createLoginTestUser() *LoginResponse {
 	return loginUser("foo@bar.com", "12345678")
 }
 
+// setTestBlacklist protects /blacklist during the test, regardless of the route rules other tests left behind
+func setTestBlacklist(t *testing.T) {
+	t.Cleanup(func() {
+		ReloadRouteRules()
+	})
+	t.Setenv("PROXY_BLACKLIST", "/blacklist")
+	ReloadRouteRules()
+}
+
 func newHTTPRequest(method, url, accessToken string, body io.Reader) *http.Request {
 	req, _ := http.NewRequest(method, url, body)
 	if accessToken != "" {
@@ -105,9 +138,14 @@ func newHTTPRequest(method, url, accessToken string, body io.Reader) *http.Reque
 }
 
 func clearTestDB() {
-	GetPendingActionRepository().GetCollection().DeleteMany(context.TODO(), bson.D{})
-	GetRefreshTokenRepository().GetCollection().DeleteMany(context.TODO(), bson.D{})
-	GetUserRepository().GetCollection().DeleteMany(context.TODO(), bson.D{})
+	if GetConfig().Storage == StorageMemory {
+		ClearMemoryStorage()
+		return
+	}
+	for _, collection := range []string{"pending_actions", "refresh_tokens", "users", "trusted_devices", "api_keys", "device_codes",
+		"invitations", "client_certificates", "linked_identities", "organizations", "audit_events", "login_stats", "mail_queue"} {
+		GetDatatabase().Collection(collection).DeleteMany(context.TODO(), bson.D{})
+	}
 }
 
 func executePublicTestRequest(req *http.Request) *httptest.ResponseRecorder {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const ErrorCodeMaintenance = "maintenance"
+
+// Maintenance holds whether maintenance mode is active. It is initialized from MAINTENANCE_MODE
+// and can be toggled at runtime via the backend API, which only affects the instance called.
+type Maintenance struct {
+	mutex   sync.RWMutex
+	enabled bool
+	message string
+}
+
+var _maintenanceInstance *Maintenance
+var _maintenanceOnce sync.Once
+
+func GetMaintenance() *Maintenance {
+	_maintenanceOnce.Do(func() {
+		_maintenanceInstance = &Maintenance{
+			enabled: GetConfig().MaintenanceMode,
+			message: GetConfig().MaintenanceMessage,
+		}
+	})
+	return _maintenanceInstance
+}
+
+func (m *Maintenance) Get() (bool, string) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.enabled, m.message
+}
+
+func (m *Maintenance) Set(enabled bool, message string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.enabled = enabled
+	m.message = message
+}
+
+// MaintenanceMiddleware answers proxied requests with 503 while maintenance mode is active.
+// The public API stays available.
+func MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enabled, message := GetMaintenance().Get()
+		if !enabled || strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		SendErrorPage(w, r, http.StatusServiceUnavailable, ErrorCodeMaintenance, message)
+	})
+}
+
+type MaintenanceRouter struct {
+}
+
+func (router *MaintenanceRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/", router.getMaintenance).Methods("GET")
+	s.HandleFunc("/", router.setMaintenance).Methods("PUT")
+}
+
+func (router *MaintenanceRouter) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	enabled, message := GetMaintenance().Get()
+	SendJSON(w, &MaintenanceRequest{Enabled: enabled, Message: message})
+}
+
+func (router *MaintenanceRouter) setMaintenance(w http.ResponseWriter, r *http.Request) {
+	var data MaintenanceRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		SendBadRequest(w)
+		return
+	}
+	GetMaintenance().Set(data.Enabled, data.Message)
+	SendUpdated(w)
+}
+
+// MaintenanceRequest holds the maintenance mode state of PUT and GET /maintenance/
+type MaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	clearTestDB()
+	payload := `{"enabled": true, "message": "Back soon"}`
+	req, _ := http.NewRequest("PUT", "/maintenance/", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	defer GetMaintenance().Set(false, "")
+
+	res = executePublicTestRequest(newHTTPRequest("GET", "/some/route", "", nil))
+	checkTestResponseCode(t, http.StatusServiceUnavailable, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeMaintenance, errorResponse.Error)
+	checkTestString(t, "Back soon", errorResponse.Message)
+
+	createLoginTestUser()
+
+	req, _ = http.NewRequest("GET", "/maintenance/", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var maintenance MaintenanceRequest
+	json.Unmarshal(res.Body.Bytes(), &maintenance)
+	if !maintenance.Enabled {
+		t.Error("Expected maintenance mode to be enabled")
+	}
+}
+
+func TestErrorPage(t *testing.T) {
+	GetErrorPageTemplate()
+	_errorPageTemplateInstance = template.Must(template.New("error").Parse("<h1>{{.Status}} {{.StatusText}}</h1><p>{{.Message}}</p>"))
+	defer func() {
+		_errorPageTemplateInstance = nil
+	}()
+
+	req := httptest.NewRequest("GET", "/some/route", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	res := httptest.NewRecorder()
+	SendErrorPage(res, req, http.StatusBadGateway, ErrorCodeUpstreamUnavailable, "<Try again>")
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+	if !strings.HasPrefix(res.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Expected HTML error page, got %s", res.Header().Get("Content-Type"))
+	}
+	checkTestString(t, "<h1>502 Bad Gateway</h1><p>&lt;Try again&gt;</p>", res.Body.String())
+
+	req = httptest.NewRequest("GET", "/some/route", nil)
+	req.Header.Set("Accept", "application/json")
+	res = httptest.NewRecorder()
+	SendErrorPage(res, req, http.StatusBadGateway, ErrorCodeUpstreamUnavailable, "")
+	checkTestString(t, "application/json", res.Header().Get("Content-Type"))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func init() {
+	RegisterStorageDriver(StorageMemory, &MemoryStorageDriver{})
+}
+
+// MemoryStorageDriver keeps all data in memory, it's lost when the process exits
+type MemoryStorageDriver struct {
+}
+
+func (d *MemoryStorageDriver) Connect() {
+	log.Println("Using in-memory storage, all data will be lost on exit")
+}
+
+func (d *MemoryStorageDriver) Disconnect() {
+}
+
+func (d *MemoryStorageDriver) NewUserStore() UserStore {
+	return NewMemoryUserStore()
+}
+
+func (d *MemoryStorageDriver) NewRefreshTokenStore() RefreshTokenStore {
+	return NewMemoryRefreshTokenStore()
+}
+
+func (d *MemoryStorageDriver) NewPendingActionStore() PendingActionStore {
+	return NewMemoryPendingActionStore()
+}
+
+func (d *MemoryStorageDriver) NewTrustedDeviceStore() TrustedDeviceStore {
+	return NewMemoryTrustedDeviceStore()
+}
+
+func (d *MemoryStorageDriver) NewAPIKeyStore() APIKeyStore {
+	return NewMemoryAPIKeyStore()
+}
+
+func (d *MemoryStorageDriver) NewDeviceCodeStore() DeviceCodeStore {
+	return NewMemoryDeviceCodeStore()
+}
+
+func (d *MemoryStorageDriver) NewInvitationStore() InvitationStore {
+	return NewMemoryInvitationStore()
+}
+
+func (d *MemoryStorageDriver) NewClientCertificateStore() ClientCertificateStore {
+	return NewMemoryClientCertificateStore()
+}
+
+func (d *MemoryStorageDriver) NewLinkedIdentityStore() LinkedIdentityStore {
+	return NewMemoryLinkedIdentityStore()
+}
+
+func (d *MemoryStorageDriver) NewOrganizationStore() OrganizationStore {
+	return NewMemoryOrganizationStore()
+}
+
+func (d *MemoryStorageDriver) NewAuditLogStore() AuditLogStore {
+	return NewMemoryAuditLogStore()
+}
+
+func (d *MemoryStorageDriver) NewLoginStatsStore() LoginStatsStore {
+	return NewMemoryLoginStatsStore()
+}
+
+func (d *MemoryStorageDriver) NewMailQueueStore() MailQueueStore {
+	return NewMemoryMailQueueStore()
+}
+
+// MemoryCollection holds documents in memory for STORAGE=memory. Documents are copied
+// via BSON when stored and loaded, so callers can't modify stored documents by accident,
+// just as with MongoDB.
+type MemoryCollection[T any] struct {
+	mutex sync.RWMutex
+	docs  []*T
+}
+
+var _memoryCollections []interface{ Clear() }
+var _memoryCollectionsMutex sync.Mutex
+
+// NewMemoryCollection creates an empty collection, which is emptied by ClearMemoryStorage as well
+func NewMemoryCollection[T any]() *MemoryCollection[T] {
+	c := &MemoryCollection[T]{docs: make([]*T, 0)}
+	_memoryCollectionsMutex.Lock()
+	defer _memoryCollectionsMutex.Unlock()
+	_memoryCollections = append(_memoryCollections, c)
+	return c
+}
+
+// ClearMemoryStorage removes all documents from all memory collections
+func ClearMemoryStorage() {
+	_memoryCollectionsMutex.Lock()
+	defer _memoryCollectionsMutex.Unlock()
+	for _, c := range _memoryCollections {
+		c.Clear()
+	}
+}
+
+func (c *MemoryCollection[T]) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.docs = make([]*T, 0)
+}
+
+// Insert stores a copy of the document
+func (c *MemoryCollection[T]) Insert(doc *T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.docs = append(c.docs, _CopyMemoryDocument(doc))
+}
+
+// FindOne returns a copy of the first document matching, or nil
+func (c *MemoryCollection[T]) FindOne(match func(*T) bool) *T {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, doc := range c.docs {
+		if match(doc) {
+			return _CopyMemoryDocument(doc)
+		}
+	}
+	return nil
+}
+
+// Find returns copies of all documents matching in insertion order
+func (c *MemoryCollection[T]) Find(match func(*T) bool) []*T {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	results := make([]*T, 0)
+	for _, doc := range c.docs {
+		if match(doc) {
+			results = append(results, _CopyMemoryDocument(doc))
+		}
+	}
+	return results
+}
+
+// Count returns the number of documents matching
+func (c *MemoryCollection[T]) Count(match func(*T) bool) int64 {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var count int64
+	for _, doc := range c.docs {
+		if match(doc) {
+			count++
+		}
+	}
+	return count
+}
+
+// Update applies update to all documents matching and returns their number
+func (c *MemoryCollection[T]) Update(match func(*T) bool, update func(*T)) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	count := 0
+	for i, doc := range c.docs {
+		if match(doc) {
+			update(doc)
+			// Copy again, so values assigned by update aren't shared with the caller
+			c.docs[i] = _CopyMemoryDocument(doc)
+			count++
+		}
+	}
+	return count
+}
+
+// Replace stores a copy of doc in place of the first document matching
+func (c *MemoryCollection[T]) Replace(match func(*T) bool, doc *T) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, existing := range c.docs {
+		if match(existing) {
+			c.docs[i] = _CopyMemoryDocument(doc)
+			return
+		}
+	}
+}
+
+// Delete removes all documents matching
+func (c *MemoryCollection[T]) Delete(match func(*T) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	docs := make([]*T, 0, len(c.docs))
+	for _, doc := range c.docs {
+		if !match(doc) {
+			docs = append(docs, doc)
+		}
+	}
+	c.docs = docs
+}
+
+func _CopyMemoryDocument[T any](doc *T) *T {
+	var res T
+	data, err := bson.Marshal(doc)
+	if err == nil {
+		err = bson.Unmarshal(data, &res)
+	}
+	if err != nil {
+		log.Println("Could not copy document:", err)
+	}
+	return &res
+}
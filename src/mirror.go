@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// mirrorMaxConcurrent limits the mirrored requests in flight, so a slow mirror target can't exhaust the proxy's resources
+const mirrorMaxConcurrent = 100
+
+var _mirrorClientInstance *http.Client
+var _mirrorClientOnce sync.Once
+var _mirrorSlots = make(chan struct{}, mirrorMaxConcurrent)
+
+// GetMirrorClient returns the client sending mirrored requests to PROXY_MIRROR_TARGET
+func GetMirrorClient() *http.Client {
+	_mirrorClientOnce.Do(func() {
+		_mirrorClientInstance = &http.Client{
+			Transport: NewUpstreamTransport(),
+			Timeout:   time.Second * GetConfig().ProxyMirrorTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	})
+	return _mirrorClientInstance
+}
+
+// IsMirroredRequest checks if a copy of the request should be sent to the mirror target.
+// The percentage is applied by hashing the UserID or client IP address, mirroring all requests of the selected clients.
+func IsMirroredRequest(r *http.Request) bool {
+	if GetConfig().ProxyMirrorTarget == nil || IsWebSocketUpgrade(r) {
+		return false
+	}
+	if GetConfig().ProxyMirrorPercent == 100 {
+		return true
+	}
+	key := GetUserIDFromContext(r)
+	if key == "" {
+		key = GetClientIP(r)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32()%100) < GetConfig().ProxyMirrorPercent
+}
+
+// MirrorRequest sends a copy of the request to the mirror target in the background, discarding its response.
+// The request body is buffered to be sent twice, requests with bodies larger than PROXY_MIRROR_MAX_BODY_SIZE aren't mirrored.
+// Must be called before the request is proxied.
+func MirrorRequest(r *http.Request) {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, GetConfig().ProxyMirrorMaxBodySize+1))
+		r.Body = &mirroredBody{Reader: io.MultiReader(bytes.NewReader(body), r.Body), Closer: r.Body}
+		if err != nil || int64(len(body)) > GetConfig().ProxyMirrorMaxBodySize {
+			return
+		}
+	}
+	select {
+	case _mirrorSlots <- struct{}{}:
+	default:
+		log.Println("Dropping mirrored request", r.Method, r.URL.Path, "as too many are in flight")
+		return
+	}
+	upstream := &Upstream{URL: GetConfig().ProxyMirrorTarget}
+	target := *upstream.RequestURL()
+	target.Path = GetApp()._SingleJoiningSlash(target.Path, r.URL.Path)
+	target.RawPath = ""
+	target.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequestWithContext(context.WithValue(context.Background(), contextKeyUpstream, upstream), r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		<-_mirrorSlots
+		log.Println("Could not create mirrored request:", err)
+		return
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set("X-Mirrored-Request", "1")
+	go func() {
+		defer func() { <-_mirrorSlots }()
+		res, err := GetMirrorClient().Do(req)
+		if err != nil {
+			log.Println("Mirroring", req.Method, req.URL.Path, "failed:", err)
+			return
+		}
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}()
+}
+
+// mirroredBody replays the buffered beginning of a request body followed by the rest, closing the original body
+type mirroredBody struct {
+	io.Reader
+	io.Closer
+}
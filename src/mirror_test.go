@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+type mirrorTestHandler struct {
+	Requests chan *http.Request
+	Bodies   chan string
+}
+
+func (h *mirrorTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	h.Requests <- r
+	h.Bodies <- string(body)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func TestProxyMirror(t *testing.T) {
+	os.Setenv("PROXY_MIRROR_TARGET", "http://127.0.0.1:8092/shadow")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_MIRROR_TARGET")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+	mirrorHandler := &mirrorTestHandler{Requests: make(chan *http.Request, 1), Bodies: make(chan string, 1)}
+	var mirror *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8092",
+		Handler: mirrorHandler,
+	}
+	go func() {
+		mirror.ListenAndServe()
+	}()
+	defer mirror.Shutdown(context.TODO())
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	req := newHTTPRequest("POST", "/some/route/test.html?a=b", loginResponse.AccessToken, bytes.NewBufferString(`{"foo": "bar"}`))
+	res := executePublicTestRequest(req)
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+
+	select {
+	case mirrored := <-mirrorHandler.Requests:
+		checkTestString(t, "POST", mirrored.Method)
+		checkTestString(t, "/shadow/some/route/test.html", mirrored.URL.Path)
+		checkTestString(t, "a=b", mirrored.URL.RawQuery)
+		checkTestString(t, "1", mirrored.Header.Get("X-Mirrored-Request"))
+		checkStringNotEmpty(t, mirrored.Header.Get("X-Auth-UserID"))
+		checkTestString(t, `{"foo": "bar"}`, <-mirrorHandler.Bodies)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected request to be mirrored")
+	}
+	checkStringNotEmpty(t, handler.Headers.Get("X-Auth-UserID"))
+}
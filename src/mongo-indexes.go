@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// caseInsensitiveCollation compares strings ignoring case and diacritics, queries must specify it to use the index
+var caseInsensitiveCollation = &options.Collation{
+	Strength: 1,
+	Locale:   "en",
+}
+
+// GetMongoIndexes returns the indexes to ensure per collection. TTL indexes with expireAfterSeconds 0 let
+// MongoDB purge documents once their expiryDate has passed, in addition to the periodic clean up.
+func GetMongoIndexes() map[string][]mongo.IndexModel {
+	return map[string][]mongo.IndexModel{
+		"users": {
+			{Keys: bson.M{"email": 1}, Options: options.Index().SetUnique(true).SetCollation(caseInsensitiveCollation)},
+			{Keys: bson.M{"additionalEmails": 1}, Options: options.Index().SetUnique(true).SetSparse(true).SetCollation(caseInsensitiveCollation)},
+			{Keys: bson.M{"organization": 1}, Options: options.Index().SetSparse(true)},
+		},
+		"refresh_tokens": {
+			{Keys: bson.M{"hashedToken": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"userId": 1}},
+			{Keys: bson.M{"expiryDate": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"pending_actions": {
+			{Keys: bson.M{"token": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"payload": 1}, Options: options.Index().SetUnique(false).SetCollation(caseInsensitiveCollation)},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "actionType", Value: 1}}},
+			{Keys: bson.M{"expiryDate": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"trusted_devices": {
+			{Keys: bson.M{"hashedToken": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"userId": 1}},
+			{Keys: bson.M{"expiryDate": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"invitations": {
+			{Keys: bson.M{"token": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"expiryDate": 1}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"api_keys": {
+			{Keys: bson.M{"hashedKey": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"userId": 1}},
+		},
+		"device_codes": {
+			// No TTL index, expired device codes are kept until the clean up so pollers can be told about the expiry
+			{Keys: bson.M{"deviceCode": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"userCode": 1}, Options: options.Index().SetUnique(true)},
+		},
+		"client_certificates": {
+			{Keys: bson.M{"fingerprint": 1}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"userId": 1}},
+		},
+		"linked_identities": {
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.M{"userId": 1}},
+		},
+		"organizations": {
+			{Keys: bson.M{"name": 1}, Options: options.Index().SetUnique(true).SetCollation(caseInsensitiveCollation)},
+		},
+		"mail_queue": {
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptDate", Value: 1}}},
+		},
+		"audit_events": {
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createDate", Value: -1}}},
+			{Keys: bson.M{"createDate": -1}},
+		},
+	}
+}
+
+// EnsureMongoIndexes creates missing indexes on startup. Existing indexes are left untouched,
+// an index conflicting with an existing one of the same name stops the server.
+func EnsureMongoIndexes(db *Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	indexes := GetMongoIndexes()
+	collections := make([]string, 0, len(indexes))
+	for collection := range indexes {
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	for _, collection := range collections {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, indexes[collection]); err != nil {
+			log.Fatal("Could not create indexes on collection ", collection, ": ", err)
+		}
+	}
+	log.Println("Ensured MongoDB indexes")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMongoTTLIndexes(t *testing.T) {
+	indexes := GetMongoIndexes()
+	for _, collection := range []string{"refresh_tokens", "pending_actions"} {
+		found := false
+		for _, index := range indexes[collection] {
+			keys, ok := index.Keys.(bson.M)
+			if ok && keys["expiryDate"] == 1 && index.Options != nil && index.Options.ExpireAfterSeconds != nil {
+				found = *index.Options.ExpireAfterSeconds == 0
+			}
+		}
+		if !found {
+			t.Errorf("Expected TTL index on expiryDate of %s", collection)
+		}
+	}
+	if _, ok := indexes["device_codes"]; !ok {
+		t.Error("Expected indexes on device_codes")
+	}
+}
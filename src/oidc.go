@@ -0,0 +1,514 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/li6in9muyou/jwt-auth-proxy/pkg/jwtauthproxy"
+)
+
+const OIDCStateCookieName = "oidc_state"
+
+// OIDCProvider talks to a generic OpenID Connect identity provider
+type OIDCProvider struct {
+	client   *http.Client
+	mutex    sync.Mutex
+	issuer   string
+	metadata *OIDCProviderMetadata
+	keys     map[string]*rsa.PublicKey
+}
+
+// OIDCProviderMetadata holds the relevant parts of the provider's discovery document
+type OIDCProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+var _oidcProviderInstance *OIDCProvider
+var _oidcProviderOnce sync.Once
+
+func GetOIDCProvider() *OIDCProvider {
+	_oidcProviderOnce.Do(func() {
+		_oidcProviderInstance = &OIDCProvider{
+			client: &http.Client{Timeout: time.Second * 10},
+		}
+	})
+	return _oidcProviderInstance
+}
+
+// GetMetadata returns the discovery document of the configured issuer, fetching it on first use
+func (p *OIDCProvider) GetMetadata() (*OIDCProviderMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	issuer := strings.TrimSuffix(GetConfig().OIDCIssuer, "/")
+	if p.metadata != nil && p.issuer == issuer {
+		return p.metadata, nil
+	}
+	res, err := p.client.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery request failed with status %d", res.StatusCode)
+	}
+	metadata := &OIDCProviderMetadata{}
+	if err := json.NewDecoder(res.Body).Decode(metadata); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return nil, errors.New("issuer in discovery document does not match OIDC_ISSUER")
+	}
+	p.issuer = issuer
+	p.metadata = metadata
+	p.keys = nil
+	return metadata, nil
+}
+
+// GetAuthorizationURL builds the URL to redirect the user agent to
+func (p *OIDCProvider) GetAuthorizationURL(state, nonce string) (string, error) {
+	metadata, err := p.GetMetadata()
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", GetConfig().OIDCClientID)
+	params.Set("redirect_uri", GetConfig().OIDCRedirectURI)
+	params.Set("scope", strings.Join(GetConfig().OIDCScopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return metadata.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token claims
+func (p *OIDCProvider) Exchange(code, nonce string) (jwt.MapClaims, error) {
+	metadata, err := p.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", GetConfig().OIDCRedirectURI)
+	req, err := http.NewRequest("POST", metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(GetConfig().OIDCClientID), url.QueryEscape(GetConfig().OIDCClientSecret))
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with status %d", res.StatusCode)
+	}
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenResponse); err != nil {
+		return nil, err
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("token response did not contain an ID token")
+	}
+	return p.VerifyIDToken(tokenResponse.IDToken, nonce)
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce of an ID token
+func (p *OIDCProvider) VerifyIDToken(idToken, nonce string) (jwt.MapClaims, error) {
+	metadata, err := p.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p._GetKey(metadata, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(metadata.Issuer, true) {
+		return nil, errors.New("invalid issuer")
+	}
+	if !p._HasAudience(claims, GetConfig().OIDCClientID) {
+		return nil, errors.New("invalid audience")
+	}
+	if tokenNonce, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid nonce")
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) _HasAudience(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *OIDCProvider) _GetKey(metadata *OIDCProviderMetadata, kid string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	// Unknown key ID: the provider might have rotated its keys, so fetch them again
+	res, err := p.client.Get(metadata.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("no matching key found for kid " + kid)
+}
+
+// OIDCLogin handles /oidc/login requests by redirecting to the identity provider
+func (router *AuthRouter) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL := router._StartOIDCAuthorization(w, "")
+	if authURL == "" {
+		SendInternalServerError(w)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCLink handles /oidc/link requests of logged in users linking their identity at the identity provider
+func (router *AuthRouter) OIDCLink(w http.ResponseWriter, r *http.Request) {
+	user := GetUserRepository().GetOne(GetUserIDFromContext(r))
+	if user == nil {
+		log.Println("Invalid OIDC link attempt: invalid UserID", GetUserIDFromContext(r))
+		SendUnauthorized(w)
+		return
+	}
+	authURL := router._StartOIDCAuthorization(w, user.ID.String())
+	if authURL == "" {
+		SendInternalServerError(w)
+		return
+	}
+	SendJSON(w, &OIDCLinkResponse{URL: authURL})
+}
+
+// OIDCCallback handles /oidc/callback requests carrying the authorization code
+func (router *AuthRouter) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var data OIDCCallbackRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Invalid OIDC login attempt: failed unmarshalling request")
+		SendBadRequest(w)
+		return
+	}
+	cookie, err := r.Cookie(OIDCStateCookieName)
+	if err != nil {
+		log.Println("Invalid OIDC login attempt: missing state cookie")
+		SendBadRequest(w)
+		return
+	}
+	router._SetOIDCStateCookie(w, "", -time.Second)
+	stateClaims := &OIDCStateClaims{}
+	token, err := jwt.ParseWithClaims(cookie.Value, stateClaims, jwtauthproxy.KeyFunc(router._GetOIDCStateKey()))
+	if err != nil || !token.Valid || subtle.ConstantTimeCompare([]byte(stateClaims.State), []byte(data.State)) != 1 {
+		log.Println("Invalid OIDC login attempt: state mismatch")
+		SendBadRequest(w)
+		return
+	}
+	claims, err := GetOIDCProvider().Exchange(data.Code, stateClaims.Nonce)
+	if err != nil {
+		log.Println("Invalid OIDC login attempt:", err)
+		SendUnauthorized(w)
+		return
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		log.Println("Invalid OIDC login attempt: missing subject claim")
+		SendUnauthorized(w)
+		return
+	}
+	email, _ := claims[GetConfig().OIDCEmailClaim].(string)
+	email = NormalizeEmail(email)
+	if stateClaims.LinkUserID != "" {
+		router._LinkOIDCIdentity(w, stateClaims.LinkUserID, subject, email)
+		return
+	}
+	var user *User
+	identity := GetLinkedIdentityRepository().GetByProviderSubject(LinkedIdentityProviderOIDC, subject)
+	if identity != nil {
+		user = GetUserRepository().GetOne(identity.UserID.String())
+		if user == nil {
+			GetLinkedIdentityRepository().Delete(identity)
+		}
+	}
+	if user == nil {
+		if email == "" {
+			log.Println("Invalid OIDC login attempt: missing email claim for subject", subject)
+			SendUnauthorized(w)
+			return
+		}
+		if GetConfig().OIDCRequireVerifiedEmail && !router._IsOIDCClaimTrue(claims["email_verified"]) {
+			log.Println("Invalid OIDC login attempt: unverified email", email)
+			SendUnauthorized(w)
+			return
+		}
+		if GetUserRepository().GetByEmail(email) != nil {
+			// Never merge accounts implicitly, the user has to log in and link the identity first
+			log.Println("Invalid OIDC login attempt: unlinked identity for existing account", email)
+			SendError(w, http.StatusConflict, ErrorCodeAccountExists)
+			return
+		}
+		if !GetConfig().OIDCAllowSignup {
+			log.Println("Invalid OIDC login attempt: unknown user", email)
+			SendUnauthorized(w)
+			return
+		}
+		if !IsEmailDomainAllowed(email) {
+			log.Println("Invalid OIDC login attempt: email domain not allowed for", email)
+			SendError(w, http.StatusForbidden, ErrorCodeEmailDomainNotAllowed)
+			return
+		}
+		user = &User{
+			Email:          email,
+			HashedPassword: GetUserRepository().GetHashedPassword(GetConfig().GenerateRandomPassword(32)),
+			Confirmed:      true,
+			Enabled:        true,
+			CreateDate:     time.Now(),
+		}
+		router._MapOIDCClaims(user, claims)
+		GetUserRepository().Create(user)
+		router._CreateOIDCIdentity(user, subject, email)
+		log.Println("Created user from OIDC login for UserID", user.ID.String())
+	} else {
+		if user.Confirmed == false {
+			log.Println("Invalid OIDC login attempt: unconfirmed account", user.ID.String())
+			SendUnauthorized(w)
+			return
+		}
+		if user.Enabled == false {
+			log.Println("Invalid OIDC login attempt: disabled account", user.ID.String())
+			SendUnauthorized(w)
+			return
+		}
+		if user.Deleted {
+			log.Println("Invalid OIDC login attempt: deleted account", user.ID.String())
+			SendUnauthorized(w)
+			return
+		}
+		router._MapOIDCClaims(user, claims)
+		GetUserRepository().Update(user)
+	}
+	log.Println("Successful OIDC login for UserID", user.ID.String())
+	RecordAuditEvent(r, AuditEventLoginSuccess, AuditActorUser, user.ID.String(), "oidc")
+	refreshToken := router._CreateRefreshToken(r, user)
+	accessToken := router._CreateAccessToken(user)
+	SendJSON(w, &LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken.Token,
+	})
+}
+
+func (router *AuthRouter) _LinkOIDCIdentity(w http.ResponseWriter, userID, subject, email string) {
+	user := GetUserRepository().GetOne(userID)
+	if user == nil {
+		log.Println("Invalid OIDC link attempt: invalid UserID", userID)
+		SendUnauthorized(w)
+		return
+	}
+	if identity := GetLinkedIdentityRepository().GetByProviderSubject(LinkedIdentityProviderOIDC, subject); identity != nil {
+		if identity.UserID == user.ID {
+			SendUpdated(w)
+			return
+		}
+		log.Println("Invalid OIDC link attempt: identity already linked to another account for UserID", user.ID.String())
+		SendError(w, http.StatusConflict, ErrorCodeIdentityAlreadyLinked)
+		return
+	}
+	router._CreateOIDCIdentity(user, subject, email)
+	log.Println("Linked OIDC identity for UserID", user.ID.String())
+	SendUpdated(w)
+}
+
+func (router *AuthRouter) _CreateOIDCIdentity(user *User, subject, email string) {
+	GetLinkedIdentityRepository().Create(&LinkedIdentity{
+		UserID:     user.ID,
+		Provider:   LinkedIdentityProviderOIDC,
+		Subject:    subject,
+		Email:      email,
+		CreateDate: time.Now(),
+	})
+}
+
+// _StartOIDCAuthorization sets the state cookie and returns the URL to redirect the user agent to
+func (router *AuthRouter) _StartOIDCAuthorization(w http.ResponseWriter, linkUserID string) string {
+	state := router._GenerateOIDCNonce()
+	nonce := router._GenerateOIDCNonce()
+	if state == "" || nonce == "" {
+		return ""
+	}
+	authURL, err := GetOIDCProvider().GetAuthorizationURL(state, nonce)
+	if err != nil {
+		log.Println("Could not build OIDC authorization URL:", err)
+		return ""
+	}
+	claims := &OIDCStateClaims{
+		State:      state,
+		Nonce:      nonce,
+		LinkUserID: linkUserID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(10 * time.Minute).Unix(),
+		},
+	}
+	stateToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString(router._GetOIDCStateKey())
+	if err != nil {
+		return ""
+	}
+	router._SetOIDCStateCookie(w, stateToken, 10*time.Minute)
+	return authURL
+}
+
+// _GetOIDCStateKey derives the key signing state cookies from JWT_SIGNING_KEY, so they can't pass as access tokens
+func (router *AuthRouter) _GetOIDCStateKey() []byte {
+	mac := hmac.New(sha256.New, []byte(GetConfig().JwtSigningKey))
+	mac.Write([]byte(OIDCStateCookieName))
+	return mac.Sum(nil)
+}
+
+// _MapOIDCClaims copies the configured ID token claims to the user
+func (router *AuthRouter) _MapOIDCClaims(user *User, claims jwt.MapClaims) {
+	if GetConfig().OIDCRolesClaim != "" {
+		switch roles := claims[GetConfig().OIDCRolesClaim].(type) {
+		case string:
+			user.Roles = []string{roles}
+		case []interface{}:
+			user.Roles = make([]string, 0, len(roles))
+			for _, role := range roles {
+				if s, ok := role.(string); ok {
+					user.Roles = append(user.Roles, s)
+				}
+			}
+		}
+	}
+	if GetConfig().OIDCOrganizationClaim != "" {
+		// The claim carries the organization name, unknown organizations are ignored
+		if name, ok := claims[GetConfig().OIDCOrganizationClaim].(string); ok {
+			organization := GetOrganizationRepository().GetByName(name)
+			if organization != nil && organization.ID.Hex() != user.Organization {
+				user.Organization = organization.ID.Hex()
+				user.OrganizationRole = OrganizationRoleMember
+			}
+		}
+	}
+}
+
+func (router *AuthRouter) _IsOIDCClaimTrue(claim interface{}) bool {
+	switch v := claim.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func (router *AuthRouter) _GenerateOIDCNonce() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+func (router *AuthRouter) _SetOIDCStateCookie(w http.ResponseWriter, stateToken string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    stateToken,
+		Path:     GetConfig().PublicAPIPath + "oidc/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// OIDCStateClaims holds the payload of the state cookie set during OIDC logins
+type OIDCStateClaims struct {
+	State      string `json:"state"`
+	Nonce      string `json:"nonce"`
+	LinkUserID string `json:"linkUserID,omitempty"`
+	jwt.StandardClaims
+}
+
+// OIDCCallbackRequest holds the POST payload for OIDC callback requests
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" validate:"required"`
+	State string `json:"state" validate:"required"`
+}
+
+// OIDCLinkResponse holds the response payload for OIDC link requests
+type OIDCLinkResponse struct {
+	URL string `json:"url"`
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type oidcIssuerMock struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	codes  map[string]jwt.MapClaims
+}
+
+var oidcTestIssuer *oidcIssuerMock
+
+func newOIDCIssuerMock() *oidcIssuerMock {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	mock := &oidcIssuerMock{
+		key:   key,
+		codes: make(map[string]jwt.MapClaims),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&OIDCProviderMetadata{
+			Issuer:                mock.server.URL,
+			AuthorizationEndpoint: mock.server.URL + "/authorize",
+			TokenEndpoint:         mock.server.URL + "/token",
+			JwksURI:               mock.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		clientID, _, _ := r.BasicAuth()
+		claims, ok := mock.codes[r.FormValue("code")]
+		if !ok || clientID != "test-client" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+	mock.server = httptest.NewServer(mux)
+	return mock
+}
+
+// startOIDCLogin performs /oidc/login and returns state cookie, state and nonce
+func startOIDCLogin(t *testing.T) (*http.Cookie, string, string) {
+	req, _ := http.NewRequest("GET", "/auth/oidc/login", nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusFound, res.Code)
+	location, _ := url.Parse(res.Header().Get("Location"))
+	checkTestString(t, "test-client", location.Query().Get("client_id"))
+	cookies := (&http.Response{Header: res.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != OIDCStateCookieName {
+		t.Fatal("Expected OIDC state cookie to be set")
+	}
+	return cookies[0], location.Query().Get("state"), location.Query().Get("nonce")
+}
+
+func finishOIDCLogin(cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	payload := `{"code": "` + code + `", "state": "` + state + `"}`
+	req, _ := http.NewRequest("POST", "/auth/oidc/callback", bytes.NewBufferString(payload))
+	req.AddCookie(cookie)
+	return executePublicTestRequest(req)
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	clearTestDB()
+	cookie, state, nonce := startOIDCLogin(t)
+	oidcTestIssuer.codes["code1"] = jwt.MapClaims{
+		"iss":            oidcTestIssuer.server.URL,
+		"aud":            "test-client",
+		"sub":            "subject1",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "oidc@bar.com",
+		"email_verified": true,
+		"roles":          []string{"admin"},
+	}
+	res := finishOIDCLogin(cookie, "code1", state)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var loginResponse LoginResponse
+	json.Unmarshal(res.Body.Bytes(), &loginResponse)
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+	checkStringNotEmpty(t, loginResponse.RefreshToken)
+
+	user := GetUserRepository().GetByEmail("oidc@bar.com")
+	if user == nil || !user.Confirmed {
+		t.Fatal("Expected confirmed user to be created")
+	}
+	if len(user.Roles) != 1 || user.Roles[0] != "admin" {
+		t.Error("Expected roles claim to be mapped")
+	}
+}
+
+func TestOIDCLoginInvalidState(t *testing.T) {
+	clearTestDB()
+	cookie, _, nonce := startOIDCLogin(t)
+	oidcTestIssuer.codes["code2"] = jwt.MapClaims{
+		"iss":            oidcTestIssuer.server.URL,
+		"aud":            "test-client",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "oidc@bar.com",
+		"email_verified": true,
+	}
+	res := finishOIDCLogin(cookie, "code2", "invalid")
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
+
+func TestOIDCStateNotAcceptedAsAccessToken(t *testing.T) {
+	setTestBlacklist(t)
+	clearTestDB()
+	cookie, _, _ := startOIDCLogin(t)
+	res := executePublicTestRequest(newHTTPRequest("GET", "/blacklist/test.html", cookie.Value, nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", "/auth/verify", cookie.Value, nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestOIDCLoginInvalidNonce(t *testing.T) {
+	clearTestDB()
+	cookie, state, _ := startOIDCLogin(t)
+	oidcTestIssuer.codes["code3"] = jwt.MapClaims{
+		"iss":            oidcTestIssuer.server.URL,
+		"aud":            "test-client",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          "invalid",
+		"email":          "oidc@bar.com",
+		"email_verified": true,
+	}
+	res := finishOIDCLogin(cookie, "code3", state)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestOIDCLoginUnverifiedEmail(t *testing.T) {
+	clearTestDB()
+	createTestUser(true)
+	cookie, state, nonce := startOIDCLogin(t)
+	oidcTestIssuer.codes["code4"] = jwt.MapClaims{
+		"iss":            oidcTestIssuer.server.URL,
+		"aud":            "test-client",
+		"sub":            "subject4",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "foo@bar.com",
+		"email_verified": false,
+	}
+	res := finishOIDCLogin(cookie, "code4", state)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestOIDCLoginExistingAccountNotLinked(t *testing.T) {
+	clearTestDB()
+	createTestUser(true)
+	cookie, state, nonce := startOIDCLogin(t)
+	oidcTestIssuer.codes["code5"] = jwt.MapClaims{
+		"iss":            oidcTestIssuer.server.URL,
+		"aud":            "test-client",
+		"sub":            "subject5",
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "foo@bar.com",
+		"email_verified": true,
+	}
+	res := finishOIDCLogin(cookie, "code5", state)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeAccountExists, errorResponse.Error)
+}
+
+func TestOIDCLinkUnlinkIdentity(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	loginResponse := loginUser("foo@bar.com", "12345678")
+
+	// Start linking as logged in user
+	req := newHTTPRequest("POST", "/auth/oidc/link", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var linkResponse OIDCLinkResponse
+	json.Unmarshal(res.Body.Bytes(), &linkResponse)
+	location, _ := url.Parse(linkResponse.URL)
+	cookie := (&http.Response{Header: res.Header()}).Cookies()[0]
+	oidcTestIssuer.codes["code6"] = jwt.MapClaims{
+		"iss":   oidcTestIssuer.server.URL,
+		"aud":   "test-client",
+		"sub":   "subject6",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": location.Query().Get("nonce"),
+		"email": "other@bar.com",
+	}
+	res = finishOIDCLogin(cookie, "code6", location.Query().Get("state"))
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Log in with the linked identity despite different email address
+	cookie, state, nonce := startOIDCLogin(t)
+	oidcTestIssuer.codes["code7"] = jwt.MapClaims{
+		"iss":   oidcTestIssuer.server.URL,
+		"aud":   "test-client",
+		"sub":   "subject6",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": nonce,
+		"email": "other@bar.com",
+	}
+	res = finishOIDCLogin(cookie, "code7", state)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	if GetUserRepository().GetByEmail("other@bar.com") != nil {
+		t.Error("Expected no new user to be created for linked identity")
+	}
+
+	// List and unlink
+	req = newHTTPRequest("GET", "/auth/identities", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var identities []LinkedIdentity
+	json.Unmarshal(res.Body.Bytes(), &identities)
+	if len(identities) != 1 || identities[0].UserID != user.ID {
+		t.Fatal("Expected exactly one linked identity")
+	}
+	req = newHTTPRequest("DELETE", "/auth/identities/"+identities[0].ID.Hex(), loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if GetLinkedIdentityRepository().GetByProviderSubject(LinkedIdentityProviderOIDC, "subject6") != nil {
+		t.Error("Expected identity to be unlinked")
+	}
+}
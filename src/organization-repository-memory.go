@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryOrganizationStore stores organizations in memory, see STORAGE
+type MemoryOrganizationStore struct {
+	organizations *MemoryCollection[Organization]
+}
+
+func NewMemoryOrganizationStore() *MemoryOrganizationStore {
+	return &MemoryOrganizationStore{organizations: NewMemoryCollection[Organization]()}
+}
+
+func (r *MemoryOrganizationStore) _ByID(id primitive.ObjectID) func(*Organization) bool {
+	return func(o *Organization) bool {
+		return o.ID == id
+	}
+}
+
+func (r *MemoryOrganizationStore) Create(u *Organization) {
+	// Enforce the unique index on 'name' like MongoDB does
+	if r.GetByName(u.Name) != nil {
+		log.Println("Duplicate organization name", u.Name)
+		return
+	}
+	u.ID = primitive.NewObjectID()
+	r.organizations.Insert(u)
+}
+
+func (r *MemoryOrganizationStore) GetOne(id string) *Organization {
+	return r.organizations.FindOne(func(o *Organization) bool {
+		return o.ID.Hex() == id
+	})
+}
+
+func (r *MemoryOrganizationStore) GetByName(name string) *Organization {
+	return r.organizations.FindOne(func(o *Organization) bool {
+		return strings.EqualFold(o.Name, name)
+	})
+}
+
+func (r *MemoryOrganizationStore) GetAll() []*Organization {
+	return r.organizations.Find(func(o *Organization) bool {
+		return true
+	})
+}
+
+func (r *MemoryOrganizationStore) Update(u *Organization) {
+	r.organizations.Replace(r._ByID(u.ID), u)
+}
+
+func (r *MemoryOrganizationStore) Delete(u *Organization) {
+	r.organizations.Delete(r._ByID(u.ID))
+}
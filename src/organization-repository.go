@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	OrganizationRoleMember = "member"
+	OrganizationRoleAdmin  = "admin"
+)
+
+type Organization struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	CreateDate time.Time          `json:"createDate" bson:"createDate"`
+}
+
+// OrganizationStore persists organizations, see STORAGE
+type OrganizationStore interface {
+	Create(u *Organization)
+	GetOne(id string) *Organization
+	GetByName(name string) *Organization
+	GetAll() []*Organization
+	Update(u *Organization)
+	Delete(u *Organization)
+}
+
+// OrganizationRepository manages organizations, storing them in the OrganizationStore of the configured storage backend
+type OrganizationRepository struct {
+	OrganizationStore
+}
+
+var _organizationRepositoryInstance *OrganizationRepository
+var _organizationRepositoryOnce sync.Once
+
+func GetOrganizationRepository() *OrganizationRepository {
+	_organizationRepositoryOnce.Do(func() {
+		_organizationRepositoryInstance = &OrganizationRepository{GetStorageDriver().NewOrganizationStore()}
+	})
+	return _organizationRepositoryInstance
+}
+
+type MongoOrganizationStore struct {
+}
+
+func NewMongoOrganizationStore() *MongoOrganizationStore {
+	return &MongoOrganizationStore{}
+}
+
+func (r *MongoOrganizationStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("organizations")
+}
+
+func (r *MongoOrganizationStore) Create(u *Organization) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+func (r *MongoOrganizationStore) GetOne(id string) *Organization {
+	var organization Organization
+	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&organization)
+	if err != nil {
+		return nil
+	}
+	return &organization
+}
+
+func (r *MongoOrganizationStore) GetByName(name string) *Organization {
+	var organization Organization
+	col := &options.Collation{
+		Strength: 1,
+		Locale:   "en",
+	}
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"name": name}, options.FindOne().SetCollation(col)).Decode(&organization)
+	if err != nil {
+		return nil
+	}
+	return &organization
+}
+
+func (r *MongoOrganizationStore) GetAll() []*Organization {
+	results := make([]*Organization, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var organization Organization
+		err := cur.Decode(&organization)
+		if err != nil {
+			return results
+		}
+		results = append(results, &organization)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *MongoOrganizationStore) Update(u *Organization) {
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": u})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Delete removes the organization and all memberships in it
+func (r *OrganizationRepository) Delete(u *Organization) {
+	GetUserRepository().RemoveAllFromOrganization(u.ID.Hex())
+	r.OrganizationStore.Delete(u)
+}
+
+func (r *MongoOrganizationStore) Delete(u *Organization) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
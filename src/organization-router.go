@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type OrganizationRouter struct {
+}
+
+func (router *OrganizationRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/{id}", router.getOne).Methods("GET")
+	s.HandleFunc("/{id}", router.update).Methods("PUT")
+	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
+	s.HandleFunc("/{id}/members", router.getMembers).Methods("GET")
+	s.HandleFunc("/{id}/members/{userId}", router.setMember).Methods("PUT")
+	s.HandleFunc("/{id}/members/{userId}", router.removeMember).Methods("DELETE")
+	s.HandleFunc("/", router.Create).Methods("POST")
+	s.HandleFunc("/", router.getAll).Methods("GET")
+}
+
+func (router *OrganizationRouter) Create(w http.ResponseWriter, r *http.Request) {
+	var data OrganizationRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		log.Println("Received invalid create organization request")
+		SendBadRequest(w)
+		return
+	}
+	if GetOrganizationRepository().GetByName(data.Name) != nil {
+		SendAleadyExists(w)
+		return
+	}
+	organization := &Organization{
+		Name:       data.Name,
+		CreateDate: time.Now(),
+	}
+	GetOrganizationRepository().Create(organization)
+	SendCreated(w, organization.ID.Hex())
+}
+
+func (router *OrganizationRouter) getOne(w http.ResponseWriter, r *http.Request) {
+	organization := router.getOrganizationFromMuxVars(r)
+	if organization == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, organization)
+}
+
+func (router *OrganizationRouter) update(w http.ResponseWriter, r *http.Request) {
+	organization := router.getOrganizationFromMuxVars(r)
+	if organization == nil {
+		SendNotFound(w)
+		return
+	}
+	var data OrganizationRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		SendBadRequest(w)
+		return
+	}
+	if other := GetOrganizationRepository().GetByName(data.Name); other != nil && other.ID != organization.ID {
+		SendAleadyExists(w)
+		return
+	}
+	organization.Name = data.Name
+	GetOrganizationRepository().Update(organization)
+	SendUpdated(w)
+}
+
+func (router *OrganizationRouter) delete(w http.ResponseWriter, r *http.Request) {
+	organization := router.getOrganizationFromMuxVars(r)
+	if organization == nil {
+		SendNotFound(w)
+		return
+	}
+	GetOrganizationRepository().Delete(organization)
+	SendUpdated(w)
+}
+
+func (router *OrganizationRouter) getAll(w http.ResponseWriter, r *http.Request) {
+	SendJSON(w, GetOrganizationRepository().GetAll())
+}
+
+func (router *OrganizationRouter) getMembers(w http.ResponseWriter, r *http.Request) {
+	organization := router.getOrganizationFromMuxVars(r)
+	if organization == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, GetOrganizationMembers(organization.ID.Hex()))
+}
+
+func (router *OrganizationRouter) setMember(w http.ResponseWriter, r *http.Request) {
+	organization := router.getOrganizationFromMuxVars(r)
+	if organization == nil {
+		SendNotFound(w)
+		return
+	}
+	vars := mux.Vars(r)
+	user := GetUserRepository().GetOne(vars["userId"])
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	var data OrganizationMemberRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		SendBadRequest(w)
+		return
+	}
+	GetUserRepository().SetOrganization(user, organization.ID.Hex(), data.Role)
+	SendUpdated(w)
+}
+
+func (router *OrganizationRouter) removeMember(w http.ResponseWriter, r *http.Request) {
+	organization := router.getOrganizationFromMuxVars(r)
+	if organization == nil {
+		SendNotFound(w)
+		return
+	}
+	vars := mux.Vars(r)
+	user := GetUserRepository().GetOne(vars["userId"])
+	if user == nil || user.Organization != organization.ID.Hex() {
+		SendNotFound(w)
+		return
+	}
+	GetUserRepository().SetOrganization(user, "", "")
+	SendUpdated(w)
+}
+
+func (router *OrganizationRouter) getOrganizationFromMuxVars(r *http.Request) *Organization {
+	vars := mux.Vars(r)
+	return GetOrganizationRepository().GetOne(vars["id"])
+}
+
+// GetOrganizationMembers lists the members of an organization with their organization roles
+func GetOrganizationMembers(organizationID string) []*OrganizationMember {
+	results := make([]*OrganizationMember, 0)
+	for _, user := range GetUserRepository().GetAllForOrganization(organizationID) {
+		results = append(results, &OrganizationMember{
+			UserID: user.ID.String(),
+			Email:  user.Email,
+			Role:   user.OrganizationRole,
+		})
+	}
+	return results
+}
+
+type OrganizationRequest struct {
+	Name string `json:"name" validate:"required,max=128"`
+}
+
+type OrganizationMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=member admin"`
+}
+
+type OrganizationMember struct {
+	UserID string `json:"userId"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestCreateOrganizationAndAddMember(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+
+	payload := `{"name": "Acme"}`
+	req, _ := http.NewRequest("POST", "/organizations/", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	organizationID := res.Header().Get("X-Object-Id")
+
+	// Names are unique regardless of case
+	payload = `{"name": "acme"}`
+	req, _ = http.NewRequest("POST", "/organizations/", bytes.NewBufferString(payload))
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	payload = `{"role": "admin"}`
+	req, _ = http.NewRequest("PUT", "/organizations/"+organizationID+"/members/"+user.ID.String(), bytes.NewBufferString(payload))
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req, _ = http.NewRequest("GET", "/organizations/"+organizationID+"/members", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var members []OrganizationMember
+	json.Unmarshal(res.Body.Bytes(), &members)
+	if len(members) != 1 {
+		t.Fatal("Expected exactly one member")
+	}
+	checkTestString(t, user.ID.String(), members[0].UserID)
+	checkTestString(t, OrganizationRoleAdmin, members[0].Role)
+
+	// Organization is part of the access token
+	loginResponse := loginUser("foo@bar.com", "12345678")
+	claims := &Claims{}
+	jwt.ParseWithClaims(loginResponse.AccessToken, claims, JwtKeyFunc)
+	checkTestString(t, organizationID, claims.Organization)
+	checkTestString(t, OrganizationRoleAdmin, claims.OrganizationRole)
+
+	// Deleting the organization removes the membership
+	req, _ = http.NewRequest("DELETE", "/organizations/"+organizationID, nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	user = GetUserRepository().GetOne(user.ID.String())
+	checkTestString(t, "", user.Organization)
+	checkTestString(t, "", user.OrganizationRole)
+}
+
+func TestOrganizationAdminManagesMembers(t *testing.T) {
+	clearTestDB()
+	organization := &Organization{Name: "Acme", CreateDate: time.Now()}
+	GetOrganizationRepository().Create(organization)
+	admin := createTestUser(true)
+	GetUserRepository().SetOrganization(admin, organization.ID.Hex(), OrganizationRoleMember)
+	member := &User{
+		Email:          "member@bar.com",
+		CreateDate:     time.Now(),
+		HashedPassword: GetUserRepository().GetHashedPassword("12345678"),
+		Confirmed:      true,
+		Enabled:        true,
+	}
+	GetUserRepository().Create(member)
+	GetUserRepository().SetOrganization(member, organization.ID.Hex(), OrganizationRoleMember)
+	loginResponse := loginUser("foo@bar.com", "12345678")
+
+	// Plain members may not manage the organization
+	req := newHTTPRequest("GET", "/auth/organization/members", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	GetUserRepository().SetOrganization(admin, organization.ID.Hex(), OrganizationRoleAdmin)
+	req = newHTTPRequest("GET", "/auth/organization/members", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var members []OrganizationMember
+	json.Unmarshal(res.Body.Bytes(), &members)
+	if len(members) != 2 {
+		t.Fatal("Expected two members")
+	}
+
+	req = newHTTPRequest("DELETE", "/auth/organization/members/"+member.ID.String(), loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestString(t, "", GetUserRepository().GetOne(member.ID.String()).Organization)
+
+	// Users outside the organization are not found
+	req = newHTTPRequest("DELETE", "/auth/organization/members/"+member.ID.String(), loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// argon2Params holds the tunable parameters of an Argon2id hash
+type argon2Params struct {
+	Memory      uint32
+	Time        uint32
+	Parallelism uint8
+}
+
+// HashPassword hashes a password using the configured algorithm
+func HashPassword(password string) string {
+	if GetConfig().PasswordHashAlgorithm == PasswordHashArgon2id {
+		return _HashPasswordArgon2id(password, &argon2Params{
+			Memory:      GetConfig().Argon2Memory,
+			Time:        GetConfig().Argon2Time,
+			Parallelism: GetConfig().Argon2Parallelism,
+		})
+	}
+	pwHash, _ := bcrypt.GenerateFromPassword([]byte(password), GetConfig().BcryptCost)
+	return string(pwHash)
+}
+
+// PasswordNeedsRehash checks if a hash was created with another algorithm or other parameters than currently configured
+func PasswordNeedsRehash(hashedPassword string) bool {
+	if GetConfig().PasswordHashAlgorithm == PasswordHashArgon2id {
+		params, _, _, err := _DecodeArgon2idHash(hashedPassword)
+		if err != nil {
+			return true
+		}
+		return params.Memory != GetConfig().Argon2Memory ||
+			params.Time != GetConfig().Argon2Time ||
+			params.Parallelism != GetConfig().Argon2Parallelism
+	}
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	if err != nil {
+		return true
+	}
+	return cost != GetConfig().BcryptCost
+}
+
+// VerifyPassword checks a password against a hash created by any of the supported algorithms
+func VerifyPassword(hashedPassword, password string) bool {
+	if strings.HasPrefix(hashedPassword, "$"+PasswordHashArgon2id+"$") {
+		return _VerifyPasswordArgon2id(hashedPassword, password)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	return err == nil
+}
+
+// _HashPasswordArgon2id encodes the hash in the PHC string format: $argon2id$v=19$m=<memory>,t=<time>,p=<parallelism>$<salt>$<key>
+func _HashPasswordArgon2id(password string, params *argon2Params) string {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return ""
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", PasswordHashArgon2id, argon2.Version,
+		params.Memory, params.Time, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func _VerifyPasswordArgon2id(hashedPassword, password string) bool {
+	params, salt, key, err := _DecodeArgon2idHash(hashedPassword)
+	if err != nil {
+		return false
+	}
+	otherKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, otherKey) == 1
+}
+
+func _DecodeArgon2idHash(hashedPassword string) (*argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != PasswordHashArgon2id {
+		return nil, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return nil, nil, nil, err
+	}
+	if version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	params := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Parallelism); err != nil {
+		return nil, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func setArgon2TestConfig() func() {
+	os.Setenv("PASSWORD_HASH_ALGORITHM", "argon2id")
+	os.Setenv("ARGON2_MEMORY", "1024")
+	os.Setenv("ARGON2_TIME", "1")
+	os.Setenv("ARGON2_PARALLELISM", "1")
+	GetConfig().ReadConfig()
+	return func() {
+		os.Unsetenv("PASSWORD_HASH_ALGORITHM")
+		os.Unsetenv("ARGON2_MEMORY")
+		os.Unsetenv("ARGON2_TIME")
+		os.Unsetenv("ARGON2_PARALLELISM")
+		GetConfig().ReadConfig()
+	}
+}
+
+func TestHashPasswordBcrypt(t *testing.T) {
+	hash := HashPassword("12345678")
+	if !strings.HasPrefix(hash, "$2a$") {
+		t.Fatalf("Expected bcrypt hash, got %s", hash)
+	}
+	if !VerifyPassword(hash, "12345678") {
+		t.Error("Expected password to match")
+	}
+	if VerifyPassword(hash, "87654321") {
+		t.Error("Expected password not to match")
+	}
+}
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	bcryptHash := HashPassword("12345678")
+	defer setArgon2TestConfig()()
+
+	hash := HashPassword("12345678")
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Expected argon2id hash, got %s", hash)
+	}
+	if !VerifyPassword(hash, "12345678") {
+		t.Error("Expected password to match")
+	}
+	if VerifyPassword(hash, "87654321") {
+		t.Error("Expected password not to match")
+	}
+	if !VerifyPassword(bcryptHash, "12345678") {
+		t.Error("Expected existing bcrypt hash to still match")
+	}
+	if VerifyPassword("$argon2id$v=19$m=1024,t=1,p=1$invalid", "12345678") {
+		t.Error("Expected malformed hash not to match")
+	}
+}
+
+func TestLoginWithArgon2idHash(t *testing.T) {
+	defer setArgon2TestConfig()()
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	bcryptHash := HashPassword("12345678")
+	if PasswordNeedsRehash(bcryptHash) {
+		t.Error("Expected bcrypt hash with configured cost not to need rehash")
+	}
+	os.Setenv("BCRYPT_COST", "11")
+	GetConfig().ReadConfig()
+	if !PasswordNeedsRehash(bcryptHash) {
+		t.Error("Expected bcrypt hash with other cost to need rehash")
+	}
+	os.Unsetenv("BCRYPT_COST")
+	GetConfig().ReadConfig()
+
+	defer setArgon2TestConfig()()
+	if !PasswordNeedsRehash(bcryptHash) {
+		t.Error("Expected bcrypt hash to need rehash")
+	}
+	argon2Hash := HashPassword("12345678")
+	if PasswordNeedsRehash(argon2Hash) {
+		t.Error("Expected argon2id hash with configured parameters not to need rehash")
+	}
+	os.Setenv("ARGON2_TIME", "2")
+	GetConfig().ReadConfig()
+	if !PasswordNeedsRehash(argon2Hash) {
+		t.Error("Expected argon2id hash with other parameters to need rehash")
+	}
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	defer setArgon2TestConfig()()
+
+	loginResponse := loginUser("foo@bar.com", "12345678")
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+	user = GetUserRepository().GetOne(user.ID.String())
+	if !strings.HasPrefix(user.HashedPassword, "$argon2id$") {
+		t.Fatalf("Expected password hash to be upgraded to argon2id, got %s", user.HashedPassword)
+	}
+	loginResponse = loginUser("foo@bar.com", "12345678")
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+const ErrorCodePasswordPolicy = "password_policy"
+
+const (
+	PasswordViolationTooShort         = "too_short"
+	PasswordViolationMissingLowercase = "missing_lowercase"
+	PasswordViolationMissingUppercase = "missing_uppercase"
+	PasswordViolationMissingDigit     = "missing_digit"
+	PasswordViolationMissingSpecial   = "missing_special"
+	PasswordViolationCommon           = "common_password"
+	PasswordViolationContainsEmail    = "contains_email"
+	PasswordViolationBreached         = "breached_password"
+	PasswordViolationBreachCheckError = "breach_check_unavailable"
+)
+
+const passwordSpecialChars = "!#$%&*+-=?@^_~"
+
+// commonPasswords is a short list of frequently used passwords within the allowed length
+var commonPasswords = []string{
+	"password", "password1", "password123", "passw0rd", "12345678", "123456789", "1234567890",
+	"11111111", "00000000", "87654321", "11223344", "12341234", "abcd1234", "abc12345", "aa123456",
+	"qwertyui", "qwertyuiop", "qwerty123", "1q2w3e4r", "1qaz2wsx", "zaq12wsx", "qazwsxedc", "asdfghjk",
+	"iloveyou", "sunshine", "princess", "football", "baseball", "superman", "starwars", "whatever",
+	"welcome1", "letmein1", "trustno1", "computer", "internet", "michael1", "monkey123", "dragon12",
+}
+
+// PasswordPolicyErrorResponse lists the policy rules a rejected password violates
+type PasswordPolicyErrorResponse struct {
+	Error      string   `json:"error"`
+	Violations []string `json:"violations"`
+}
+
+// CheckPasswordPolicy returns the configured password policy rules the password violates,
+// including a breach check against Have I Been Pwned if enabled
+func CheckPasswordPolicy(password, email string) []string {
+	violations := _CheckLocalPasswordPolicy(password, email)
+	if len(violations) == 0 {
+		violations = append(violations, _CheckBreachedPassword(password)...)
+	}
+	return violations
+}
+
+func _CheckBreachedPassword(password string) []string {
+	breached, checked := IsBreachedPassword(password)
+	if breached {
+		return []string{PasswordViolationBreached}
+	}
+	if !checked && !GetConfig().HIBPFailOpen {
+		return []string{PasswordViolationBreachCheckError}
+	}
+	return nil
+}
+
+func _CheckLocalPasswordPolicy(password, email string) []string {
+	violations := make([]string, 0)
+	if len([]rune(password)) < GetConfig().PasswordMinLength {
+		violations = append(violations, PasswordViolationTooShort)
+	}
+	var hasLower, hasUpper, hasDigit, hasSpecial bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		default:
+			hasSpecial = true
+		}
+	}
+	if GetConfig().PasswordRequireLowercase && !hasLower {
+		violations = append(violations, PasswordViolationMissingLowercase)
+	}
+	if GetConfig().PasswordRequireUppercase && !hasUpper {
+		violations = append(violations, PasswordViolationMissingUppercase)
+	}
+	if GetConfig().PasswordRequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolationMissingDigit)
+	}
+	if GetConfig().PasswordRequireSpecial && !hasSpecial {
+		violations = append(violations, PasswordViolationMissingSpecial)
+	}
+	if _IsBannedPassword(password) {
+		violations = append(violations, PasswordViolationCommon)
+	}
+	if GetConfig().PasswordDisallowEmail && _ContainsEmail(password, email) {
+		violations = append(violations, PasswordViolationContainsEmail)
+	}
+	return violations
+}
+
+// GeneratePolicyPassword generates a random password satisfying the password policy, i.e. for password resets
+func GeneratePolicyPassword(email string) string {
+	length := GetConfig().PasswordMinLength
+	if length < 8 {
+		length = 8
+	}
+	for attempts := 0; ; attempts++ {
+		password := []rune(GetConfig().GenerateRandomPassword(length))
+		if GetConfig().PasswordRequireSpecial {
+			password[rand.Intn(len(password))] = rune(passwordSpecialChars[rand.Intn(len(passwordSpecialChars))])
+		}
+		if len(_CheckLocalPasswordPolicy(string(password), email)) != 0 {
+			continue
+		}
+		// Random passwords are practically never breached, so don't retry forever if the check fails closed
+		if attempts >= 10 || len(_CheckBreachedPassword(string(password))) == 0 {
+			return string(password)
+		}
+	}
+}
+
+// SendPasswordPolicyError sends a structured error response listing the violated rules
+func SendPasswordPolicyError(w http.ResponseWriter, violations []string) {
+	SendJSONWithStatus(w, http.StatusBadRequest, &PasswordPolicyErrorResponse{
+		Error:      ErrorCodePasswordPolicy,
+		Violations: violations,
+	})
+}
+
+func _IsBannedPassword(password string) bool {
+	password = strings.ToLower(password)
+	if GetConfig().PasswordBanCommon {
+		for _, banned := range commonPasswords {
+			if password == banned {
+				return true
+			}
+		}
+	}
+	for _, banned := range GetConfig().PasswordBannedList {
+		if password == strings.ToLower(banned) {
+			return true
+		}
+	}
+	return false
+}
+
+func _ContainsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if strings.Contains(password, email) {
+		return true
+	}
+	// Also reject the local part unless it is too short to be meaningful
+	localPart := email
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		localPart = email[:i]
+	}
+	return len(localPart) >= 3 && strings.Contains(password, localPart)
+}
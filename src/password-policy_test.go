@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func setPasswordPolicyTestConfig() func() {
+	vars := []string{"PASSWORD_MIN_LENGTH", "PASSWORD_REQUIRE_UPPERCASE", "PASSWORD_REQUIRE_DIGIT", "PASSWORD_REQUIRE_SPECIAL", "PASSWORD_BAN_COMMON", "PASSWORD_DISALLOW_EMAIL"}
+	os.Setenv("PASSWORD_MIN_LENGTH", "10")
+	os.Setenv("PASSWORD_REQUIRE_UPPERCASE", "1")
+	os.Setenv("PASSWORD_REQUIRE_DIGIT", "1")
+	os.Setenv("PASSWORD_REQUIRE_SPECIAL", "1")
+	os.Setenv("PASSWORD_BAN_COMMON", "1")
+	os.Setenv("PASSWORD_DISALLOW_EMAIL", "1")
+	GetConfig().ReadConfig()
+	return func() {
+		for _, v := range vars {
+			os.Unsetenv(v)
+		}
+		GetConfig().ReadConfig()
+	}
+}
+
+func checkPasswordViolations(t *testing.T, expected, actual []string) {
+	if len(expected) != len(actual) {
+		t.Fatalf("Expected violations %v, got %v", expected, actual)
+	}
+	for i := range expected {
+		checkTestString(t, expected[i], actual[i])
+	}
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	defer setPasswordPolicyTestConfig()()
+
+	checkPasswordViolations(t, []string{}, CheckPasswordPolicy("Secret-2024x", "foo@bar.com"))
+	checkPasswordViolations(t, []string{PasswordViolationTooShort}, CheckPasswordPolicy("Secret-24", "foo@bar.com"))
+	checkPasswordViolations(t, []string{PasswordViolationMissingUppercase, PasswordViolationMissingDigit, PasswordViolationMissingSpecial}, CheckPasswordPolicy("secretsecret", "foo@bar.com"))
+	checkPasswordViolations(t, []string{PasswordViolationTooShort, PasswordViolationMissingUppercase, PasswordViolationMissingSpecial, PasswordViolationCommon}, CheckPasswordPolicy("password1", "foo@bar.com"))
+	checkPasswordViolations(t, []string{PasswordViolationContainsEmail}, CheckPasswordPolicy("My-Foo-2024x", "foo@bar.com"))
+}
+
+func TestGeneratePolicyPassword(t *testing.T) {
+	defer setPasswordPolicyTestConfig()()
+
+	for i := 0; i < 10; i++ {
+		checkPasswordViolations(t, []string{}, CheckPasswordPolicy(GeneratePolicyPassword("foo@bar.com"), "foo@bar.com"))
+	}
+}
+
+func TestSignupPasswordPolicyViolated(t *testing.T) {
+	defer setPasswordPolicyTestConfig()()
+	clearTestDB()
+
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	var errorResponse PasswordPolicyErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodePasswordPolicy, errorResponse.Error)
+	checkPasswordViolations(t, []string{PasswordViolationTooShort, PasswordViolationMissingUppercase, PasswordViolationMissingSpecial, PasswordViolationCommon}, errorResponse.Violations)
+	if GetUserRepository().GetByEmail("foo@bar.com") != nil {
+		t.Error("Expected user not to be created")
+	}
+}
+
+func TestChangePasswordPolicyViolated(t *testing.T) {
+	defer setPasswordPolicyTestConfig()()
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"oldPassword": "12345678", "newPassword": "foobar-Secret-1"}`
+	req := newHTTPRequest("POST", "/auth/setpw", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	payload = `{"oldPassword": "12345678", "newPassword": "Secret-2024x"}`
+	req = newHTTPRequest("POST", "/auth/setpw", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+}
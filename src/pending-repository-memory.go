@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryPendingActionStore stores pending actions in memory, see STORAGE
+type MemoryPendingActionStore struct {
+	actions *MemoryCollection[PendingAction]
+}
+
+func NewMemoryPendingActionStore() *MemoryPendingActionStore {
+	return &MemoryPendingActionStore{actions: NewMemoryCollection[PendingAction]()}
+}
+
+func (r *MemoryPendingActionStore) _ByID(id primitive.ObjectID) func(*PendingAction) bool {
+	return func(a *PendingAction) bool {
+		return a.ID == id
+	}
+}
+
+func (r *MemoryPendingActionStore) Create(u *PendingAction) {
+	u.ID = primitive.NewObjectID()
+	r.actions.Insert(u)
+}
+
+func (r *MemoryPendingActionStore) GetOne(id string) *PendingAction {
+	return r._Unexpired(r.actions.FindOne(func(a *PendingAction) bool {
+		return a.ID.Hex() == id
+	}))
+}
+
+func (r *MemoryPendingActionStore) GetByToken(token string) *PendingAction {
+	return r._Unexpired(r.actions.FindOne(func(a *PendingAction) bool {
+		return a.Token == token
+	}))
+}
+
+func (r *MemoryPendingActionStore) _Unexpired(pendingAction *PendingAction) *PendingAction {
+	if pendingAction == nil {
+		return nil
+	}
+	if pendingAction.ExpiryDate.Before(time.Now()) {
+		r.Delete(pendingAction)
+		return nil
+	}
+	return pendingAction
+}
+
+func (r *MemoryPendingActionStore) GetByPayload(payload string) []*PendingAction {
+	now := time.Now()
+	return r.actions.Find(func(a *PendingAction) bool {
+		return strings.EqualFold(a.Payload, payload) && !a.ExpiryDate.Before(now)
+	})
+}
+
+func (r *MemoryPendingActionStore) GetAllForUserByType(userID string, actionType int) []*PendingAction {
+	now := time.Now()
+	return r.actions.Find(func(a *PendingAction) bool {
+		return a.UserID == UserID(userID) && a.ActionType == actionType && !a.ExpiryDate.Before(now)
+	})
+}
+
+func (r *MemoryPendingActionStore) IncrementAttempts(u *PendingAction) {
+	u.Attempts++
+	r.actions.Update(r._ByID(u.ID), func(a *PendingAction) {
+		a.Attempts++
+	})
+}
+
+func (r *MemoryPendingActionStore) Delete(u *PendingAction) {
+	r.actions.Delete(r._ByID(u.ID))
+}
+
+func (r *MemoryPendingActionStore) DeleteAllForUser(userID string) {
+	r.actions.Delete(func(a *PendingAction) bool {
+		return a.UserID == UserID(userID)
+	})
+}
+
+func (r *MemoryPendingActionStore) CleanUp() {
+	now := time.Now()
+	r.actions.Delete(func(a *PendingAction) bool {
+		return !a.ExpiryDate.After(now)
+	})
+}
@@ -16,18 +16,38 @@ import (
 const PendingActionTypeConfirmAccount = 1
 const PendingActionTypeChangeEmail = 2
 const PendingActionTypeInitPasswordReset = 3
+const PendingActionTypeConfirmEmailChangeOld = 4
+const PendingActionTypeVerifyPhone = 5
+const PendingActionTypeAddEmail = 6
 
 type PendingAction struct {
 	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserID     primitive.ObjectID `json:"userId" bson:"userId"`
+	UserID     UserID             `json:"userId" bson:"userId"`
 	Token      string             `json:"token" bson:"token"`
 	ActionType int                `json:"actionType" bson:"actionType"`
 	Payload    string             `json:"payload" bson:"payload"`
 	CreateDate time.Time          `json:"createDate" bson:"createDate"`
 	ExpiryDate time.Time          `json:"expiryDate" bson:"expiryDate"`
+	Code       string             `json:"-" bson:"code,omitempty"`
+	Attempts   int                `json:"-" bson:"attempts,omitempty"`
 }
 
+// PendingActionStore persists pending actions, see STORAGE
+type PendingActionStore interface {
+	Create(u *PendingAction)
+	GetOne(id string) *PendingAction
+	GetByToken(token string) *PendingAction
+	GetByPayload(payload string) []*PendingAction
+	GetAllForUserByType(userID string, actionType int) []*PendingAction
+	IncrementAttempts(u *PendingAction)
+	Delete(u *PendingAction)
+	DeleteAllForUser(userID string)
+	CleanUp()
+}
+
+// PendingActionRepository manages pending actions, storing them in the PendingActionStore of the configured storage backend
 type PendingActionRepository struct {
+	PendingActionStore
 }
 
 var _pendingActionRepositoryInstance *PendingActionRepository
@@ -35,43 +55,23 @@ var _pendingActionRepositoryOnce sync.Once
 
 func GetPendingActionRepository() *PendingActionRepository {
 	_pendingActionRepositoryOnce.Do(func() {
-		_pendingActionRepositoryInstance = &PendingActionRepository{}
-		ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
-		// Create unique index on 'token'
-		mod := mongo.IndexModel{
-			Keys: bson.M{
-				"token": 1,
-			},
-			Options: options.Index().SetUnique(true),
-		}
-		_, err := _pendingActionRepositoryInstance.GetCollection().Indexes().CreateOne(ctx, mod)
-		if err != nil {
-			log.Fatal(err)
-		}
-		// Create non-unique index on 'payload'
-		col := &options.Collation{
-			Strength: 1,
-			Locale:   "en",
-		}
-		mod = mongo.IndexModel{
-			Keys: bson.M{
-				"payload": 1,
-			},
-			Options: options.Index().SetUnique(false).SetCollation(col),
-		}
-		_, err = _pendingActionRepositoryInstance.GetCollection().Indexes().CreateOne(ctx, mod)
-		if err != nil {
-			log.Fatal(err)
-		}
+		_pendingActionRepositoryInstance = &PendingActionRepository{GetStorageDriver().NewPendingActionStore()}
 	})
 	return _pendingActionRepositoryInstance
 }
 
-func (r *PendingActionRepository) GetCollection() *mongo.Collection {
-	return GetDatatabase().Database.Collection("pending_actions")
+type MongoPendingActionStore struct {
 }
 
-func (r *PendingActionRepository) Create(u *PendingAction) {
+func NewMongoPendingActionStore() *MongoPendingActionStore {
+	return &MongoPendingActionStore{}
+}
+
+func (r *MongoPendingActionStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("pending_actions")
+}
+
+func (r *MongoPendingActionStore) Create(u *PendingAction) {
 	res, err := r.GetCollection().InsertOne(context.TODO(), u)
 	if err != nil {
 		log.Println(err)
@@ -79,7 +79,7 @@ func (r *PendingActionRepository) Create(u *PendingAction) {
 	u.ID = res.InsertedID.(primitive.ObjectID)
 }
 
-func (r *PendingActionRepository) GetOne(id string) *PendingAction {
+func (r *MongoPendingActionStore) GetOne(id string) *PendingAction {
 	var pendingAction PendingAction
 	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&pendingAction)
 	if err != nil {
@@ -92,7 +92,7 @@ func (r *PendingActionRepository) GetOne(id string) *PendingAction {
 	return &pendingAction
 }
 
-func (r *PendingActionRepository) GetByToken(token string) *PendingAction {
+func (r *MongoPendingActionStore) GetByToken(token string) *PendingAction {
 	var pendingAction PendingAction
 	err := r.GetCollection().FindOne(context.TODO(), bson.M{"token": token}).Decode(&pendingAction)
 	if err != nil {
@@ -105,7 +105,7 @@ func (r *PendingActionRepository) GetByToken(token string) *PendingAction {
 	return &pendingAction
 }
 
-func (r *PendingActionRepository) GetByPayload(payload string) []*PendingAction {
+func (r *MongoPendingActionStore) GetByPayload(payload string) []*PendingAction {
 	var results []*PendingAction
 	col := &options.Collation{
 		Strength: 1,
@@ -130,15 +130,46 @@ func (r *PendingActionRepository) GetByPayload(payload string) []*PendingAction
 	return results
 }
 
-func (r *PendingActionRepository) Delete(u *PendingAction) {
+func (r *MongoPendingActionStore) GetAllForUserByType(userID string, actionType int) []*PendingAction {
+	results := make([]*PendingAction, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{
+		"userId":     UserID(userID),
+		"actionType": actionType,
+		"expiryDate": bson.M{"$gte": time.Now()},
+	})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var pendingAction PendingAction
+		err := cur.Decode(&pendingAction)
+		if err != nil {
+			return results
+		}
+		results = append(results, &pendingAction)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+// IncrementAttempts counts a failed attempt to confirm the pending action by code
+func (r *MongoPendingActionStore) IncrementAttempts(u *PendingAction) {
+	u.Attempts++
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$inc": bson.M{"attempts": 1}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoPendingActionStore) Delete(u *PendingAction) {
 	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (r *PendingActionRepository) DeleteAllForUser(userID string) {
-	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": GetDatatabase().GetObjectID(userID)})
+func (r *MongoPendingActionStore) DeleteAllForUser(userID string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID)})
 	if err != nil {
 		log.Println(err)
 	}
@@ -155,7 +186,7 @@ func (r *PendingActionRepository) FindUnusedToken() string {
 	return token
 }
 
-func (r *PendingActionRepository) CleanUp() {
+func (r *MongoPendingActionStore) CleanUp() {
 	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"expiryDate": bson.M{"$lte": time.Now()}})
 	if err != nil {
 		log.Println(err)
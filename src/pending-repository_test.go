@@ -3,8 +3,6 @@ package main
 import (
 	"testing"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestPendingActionCleanUp(t *testing.T) {
@@ -14,7 +12,7 @@ func TestPendingActionCleanUp(t *testing.T) {
 		ActionType: 1,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
@@ -23,7 +21,7 @@ func TestPendingActionCleanUp(t *testing.T) {
 		ActionType: 1,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
@@ -49,7 +47,7 @@ func TestPendingActionGetOne(t *testing.T) {
 		ActionType: 1,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
@@ -68,7 +66,7 @@ func TestPendingActionGetOneExpired(t *testing.T) {
 		ActionType: 1,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
@@ -88,7 +86,7 @@ func TestPendingActionGetByToken(t *testing.T) {
 		ActionType: 1,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "",
 		Token:      token,
 	}
@@ -108,7 +106,7 @@ func TestPendingActionGetByTokenExpired(t *testing.T) {
 		ActionType: 1,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "",
 		Token:      token,
 	}
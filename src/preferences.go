@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+const (
+	PreferenceLocale   = "locale"
+	PreferenceTimezone = "timezone"
+)
+
+const preferencesMaxKeys = 32
+const preferenceMaxValueLength = 256
+
+var preferenceKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// MergePreferences applies a set of changes to the user's preferences, an empty value removes the key
+func MergePreferences(preferences, changes map[string]string) (map[string]string, error) {
+	res := make(map[string]string)
+	for key, value := range preferences {
+		res[key] = value
+	}
+	for key, value := range changes {
+		if value == "" {
+			delete(res, key)
+			continue
+		}
+		if err := ValidatePreference(key, value); err != nil {
+			return nil, err
+		}
+		res[key] = value
+	}
+	if len(res) > preferencesMaxKeys {
+		return nil, errors.New("Too many preferences")
+	}
+	return res, nil
+}
+
+// ValidatePreference checks the format of a preference key and, for well-known keys, its value
+func ValidatePreference(key, value string) error {
+	if !preferenceKeyRegexp.MatchString(key) {
+		return errors.New("Invalid preference key: " + key)
+	}
+	if len(value) > preferenceMaxValueLength {
+		return errors.New("Preference value too long: " + key)
+	}
+	switch key {
+	case PreferenceLocale:
+		if _, err := language.Parse(value); err != nil {
+			return errors.New("Invalid locale: " + value)
+		}
+	case PreferenceTimezone:
+		if _, err := time.LoadLocation(value); err != nil {
+			return errors.New("Invalid timezone: " + value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http/httputil"
+	"sync"
+)
+
+// proxyBufferSize matches the buffer size io.Copy would allocate for each proxied response body
+const proxyBufferSize = 32 * 1024
+
+// ProxyBufferPool reuses the buffers for copying response bodies, instead of allocating one per proxied request
+type ProxyBufferPool struct {
+	pool sync.Pool
+}
+
+var _ httputil.BufferPool = &ProxyBufferPool{}
+
+var _proxyBufferPoolInstance *ProxyBufferPool
+var _proxyBufferPoolOnce sync.Once
+
+func GetProxyBufferPool() *ProxyBufferPool {
+	_proxyBufferPoolOnce.Do(func() {
+		_proxyBufferPoolInstance = &ProxyBufferPool{
+			pool: sync.Pool{
+				New: func() interface{} {
+					buf := make([]byte, proxyBufferSize)
+					return &buf
+				},
+			},
+		}
+	})
+	return _proxyBufferPoolInstance
+}
+
+func (p *ProxyBufferPool) Get() []byte {
+	return *p.pool.Get().(*[]byte)
+}
+
+func (p *ProxyBufferPool) Put(buf []byte) {
+	if cap(buf) != proxyBufferSize {
+		return
+	}
+	buf = buf[:proxyBufferSize]
+	p.pool.Put(&buf)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+// proxyProtocolHeaderTimeout limits how long a new connection may take to send its PROXY protocol header
+const proxyProtocolHeaderTimeout = 10 * time.Second
+
+// ListenPublic opens the listener of a user-facing server.
+// If PUBLIC_PROXY_PROTOCOL is enabled, connections may start with a PROXY protocol v1 or v2 header
+// and report the client address passed in it as their remote address.
+func ListenPublic(addr string) net.Listener {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !GetConfig().PublicProxyProtocol {
+		return listener
+	}
+	return &proxyproto.Listener{
+		Listener:          listener,
+		Policy:            ProxyProtocolPolicy,
+		ReadHeaderTimeout: proxyProtocolHeaderTimeout,
+	}
+}
+
+// ProxyProtocolPolicy accepts PROXY protocol headers from the addresses in PUBLIC_PROXY_PROTOCOL_SOURCES, or from everyone if none are set,
+// and rejects connections from other addresses sending one
+func ProxyProtocolPolicy(upstream net.Addr) (proxyproto.Policy, error) {
+	sources := GetConfig().PublicProxyProtocolSources
+	if len(sources) == 0 {
+		return proxyproto.USE, nil
+	}
+	host, _, err := net.SplitHostPort(upstream.String())
+	if err != nil {
+		return proxyproto.REJECT, nil
+	}
+	if IPRangesContain(sources, host) {
+		return proxyproto.USE, nil
+	}
+	return proxyproto.REJECT, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+func sendProxyProtocolTestRequest(t *testing.T, addr string, header *proxyproto.Header) (*http.Response, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := header.WriteTo(conn); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(conn), req)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	os.Setenv("PUBLIC_PROXY_PROTOCOL", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PUBLIC_PROXY_PROTOCOL")
+		os.Unsetenv("PUBLIC_PROXY_PROTOCOL_SOURCES")
+		GetConfig().ReadConfig()
+	}()
+	clientIPs := make(chan string, 1)
+	listener := ListenPublic("127.0.0.1:0")
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIPs <- GetClientIP(r)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+	addr := listener.Addr().String()
+
+	for _, version := range []byte{1, 2} {
+		header := proxyproto.HeaderProxyFromAddrs(version, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 12345}, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80})
+		res, err := sendProxyProtocolTestRequest(t, addr, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		checkTestResponseCode(t, http.StatusOK, res.StatusCode)
+		checkTestString(t, "203.0.113.7", <-clientIPs)
+	}
+
+	os.Setenv("PUBLIC_PROXY_PROTOCOL_SOURCES", "10.0.0.0/8")
+	GetConfig().ReadConfig()
+	header := proxyproto.HeaderProxyFromAddrs(1, &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 12345}, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80})
+	if res, err := sendProxyProtocolTestRequest(t, addr, header); err == nil {
+		res.Body.Close()
+		checkTestResponseCode(t, http.StatusBadRequest, res.StatusCode)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ProxyRule is an entry of PROXY_WHITELIST or PROXY_BLACKLIST, optionally restricted to a set of HTTP methods.
+// The path is either a URL prefix ("/articles"), a glob ("/api/*/public") or a regular expression ("^/files/[0-9]+$").
+type ProxyRule struct {
+	Methods []string
+	Path    string
+	Pattern *regexp.Regexp
+}
+
+func ParseProxyRule(s string) (*ProxyRule, error) {
+	rule := &ProxyRule{}
+	fields := strings.Fields(s)
+	if len(fields) > 1 {
+		rule.Methods = strings.Split(strings.ToUpper(fields[0]), ",")
+		fields = fields[1:]
+	}
+	rule.Path = strings.Join(fields, " ")
+	if strings.HasPrefix(rule.Path, "^") {
+		pattern, err := regexp.Compile(rule.Path)
+		if err != nil {
+			return nil, errors.New("Invalid regular expression in proxy rule: " + s)
+		}
+		rule.Pattern = pattern
+	} else if strings.Contains(rule.Path, "*") {
+		rule.Pattern = regexp.MustCompile(_GlobToRegexp(strings.TrimSuffix(rule.Path, "/")))
+	} else {
+		rule.Path = strings.TrimSuffix(rule.Path, "/")
+	}
+	return rule, nil
+}
+
+// ParseProxyRules parses a colon-separated list of rules, skipping empty entries
+func ParseProxyRules(s string) ([]*ProxyRule, error) {
+	res := make([]*ProxyRule, 0)
+	for _, entry := range strings.Split(s, ":") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		rule, err := ParseProxyRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rule)
+	}
+	return res, nil
+}
+
+// Matches checks if the request's method and escaped path are covered by the rule
+func (rule *ProxyRule) Matches(method, path string) bool {
+	if len(rule.Methods) > 0 && !rule._MatchesMethod(method) {
+		return false
+	}
+	if rule.Pattern != nil {
+		return rule.Pattern.MatchString(path)
+	}
+	return rule.Path != "" && (path == rule.Path || strings.HasPrefix(path, rule.Path+"/"))
+}
+
+// _GlobToRegexp converts a glob to a regular expression matching the glob as a path prefix.
+// A single * matches within one path segment, ** across segments.
+func _GlobToRegexp(glob string) string {
+	parts := strings.Split(glob, "**")
+	for i, part := range parts {
+		segments := strings.Split(part, "*")
+		for j, segment := range segments {
+			segments[j] = regexp.QuoteMeta(segment)
+		}
+		parts[i] = strings.Join(segments, "[^/]*")
+	}
+	return "^" + strings.Join(parts, ".*") + "(/.*)?$"
+}
+
+func (rule *ProxyRule) _MatchesMethod(method string) bool {
+	for _, m := range rule.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
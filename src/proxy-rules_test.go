@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestParseProxyRules(t *testing.T) {
+	rules, err := ParseProxyRules("/public/:GET,head /articles::")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+	checkTestString(t, "/public", rules[0].Path)
+	if len(rules[0].Methods) != 0 {
+		t.Error("Expected rule without methods")
+	}
+	checkTestString(t, "/articles", rules[1].Path)
+	if len(rules[1].Methods) != 2 || rules[1].Methods[1] != "HEAD" {
+		t.Errorf("Expected methods GET and HEAD, got %v", rules[1].Methods)
+	}
+}
+
+func TestProxyRuleMatches(t *testing.T) {
+	rule, _ := ParseProxyRule("GET /articles")
+	if !rule.Matches("GET", "/articles") || !rule.Matches("GET", "/articles/1") {
+		t.Error("Expected GET requests to match")
+	}
+	if rule.Matches("POST", "/articles") {
+		t.Error("Expected POST request not to match")
+	}
+	if rule.Matches("GET", "/articlesfoo") {
+		t.Error("Expected other path not to match")
+	}
+	rule, _ = ParseProxyRule("/articles")
+	if !rule.Matches("DELETE", "/articles/1") {
+		t.Error("Expected rule without methods to match all methods")
+	}
+}
+
+func TestProxyRuleGlob(t *testing.T) {
+	rule, _ := ParseProxyRule("/api/*/public")
+	if !rule.Matches("GET", "/api/v1/public") || !rule.Matches("GET", "/api/v2/public/file.txt") {
+		t.Error("Expected glob to match")
+	}
+	if rule.Matches("GET", "/api/v1/private") || rule.Matches("GET", "/api/v1/x/public") || rule.Matches("GET", "/api/v1/publicfoo") {
+		t.Error("Expected glob not to match")
+	}
+	rule, _ = ParseProxyRule("/static/**.css")
+	if !rule.Matches("GET", "/static/a/b/c.css") || rule.Matches("GET", "/static/a/b/c.js") {
+		t.Error("Expected ** to match across segments")
+	}
+}
+
+func TestProxyRuleRegexp(t *testing.T) {
+	rule, err := ParseProxyRule("GET ^/files/[0-9]+/download$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rule.Matches("GET", "/files/42/download") {
+		t.Error("Expected regular expression to match")
+	}
+	if rule.Matches("GET", "/files/abc/download") || rule.Matches("GET", "/files/42/download/more") {
+		t.Error("Expected regular expression not to match")
+	}
+	if _, err := ParseProxyRule("^/files/[0-9+$"); err == nil {
+		t.Error("Expected error for invalid regular expression")
+	}
+}
+
+func TestProxyMethodBlacklist(t *testing.T) {
+	os.Setenv("PROXY_BLACKLIST", "POST,PUT /articles")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("PROXY_BLACKLIST", "/blacklist")
+		GetConfig().ReadConfig()
+	}()
+
+	res := executePublicTestRequest(newHTTPRequest("POST", "/articles/1", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", "/articles/1", "", nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+}
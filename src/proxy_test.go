@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestProxyUnauthorizedNoMatch(t *testing.T) {
@@ -34,6 +39,26 @@ func TestProxyTargetDown(t *testing.T) {
 	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
 }
 
+func TestProxyNoHealthyUpstream(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	for _, upstream := range GetApp().Upstreams.Upstreams {
+		upstream.SetHealthy(false)
+	}
+	defer func() {
+		for _, upstream := range GetApp().Upstreams.Upstreams {
+			upstream.SetHealthy(true)
+		}
+	}()
+
+	req := newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusServiceUnavailable, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeUpstreamUnavailable, errorResponse.Error)
+}
+
 func TestProxySuccessWithAuth(t *testing.T) {
 	handler := &dummyProxyHandler{}
 	var proxy *http.Server = &http.Server{
@@ -53,7 +78,7 @@ func TestProxySuccessWithAuth(t *testing.T) {
 
 	proxy.Shutdown(context.TODO())
 	checkTestResponseCode(t, http.StatusOK, res.Code)
-	if handler.Headers.Get("X-Auth-UserID") != user.ID.Hex() {
+	if handler.Headers.Get("X-Auth-UserID") != user.ID.String() {
 		t.Error("Expected X-Auth-UserID header to match actual User ID")
 	}
 	if !strings.HasPrefix(handler.Headers.Get("Authorization"), "Bearer ") {
@@ -83,8 +108,8 @@ func TestProxySuccessWhitelistWithAuth(t *testing.T) {
 	if !strings.HasPrefix(handler.Headers.Get("Authorization"), "Bearer ") {
 		t.Error("Expected Authorization: Bearer [...] header")
 	}
-	if handler.Headers.Get("X-Auth-UserID") != user.ID.Hex() {
-		t.Error("Expected X-Auth-UserID header to match actual User ID '" + user.ID.Hex() + "' but got '" + handler.Headers.Get("X-Auth-UserID") + "'")
+	if handler.Headers.Get("X-Auth-UserID") != user.ID.String() {
+		t.Error("Expected X-Auth-UserID header to match actual User ID '" + user.ID.String() + "' but got '" + handler.Headers.Get("X-Auth-UserID") + "'")
 	}
 }
 
@@ -209,3 +234,271 @@ type dummyProxyHandler struct {
 func (h *dummyProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.Headers = r.Header
 }
+
+func TestProxySuccessWithAPIKey(t *testing.T) {
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	user := createTestUser(true)
+	_, key := GetAPIKeyRepository().CreateForUser(user, "test", []string{"read", "write"})
+
+	req := newHTTPRequest("GET", "/some/route/test.html", "", nil)
+	req.Header.Set("X-Api-Key", key)
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, user.ID.String(), handler.Headers.Get("X-Auth-UserID"))
+	checkTestString(t, "read write", handler.Headers.Get("X-Auth-Scopes"))
+	if handler.Headers.Get("X-Api-Key") != "" {
+		t.Error("Expected X-Api-Key header not to be forwarded")
+	}
+}
+
+func TestProxyUnauthorizedInvalidAPIKey(t *testing.T) {
+	clearTestDB()
+
+	req := newHTTPRequest("GET", "/blacklist/test.html", "", nil)
+	req.Header.Set("X-Api-Key", "invalid")
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestProxySuccessWithBasicAuth(t *testing.T) {
+	os.Setenv("PROXY_BASIC_AUTH_ENABLE", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("PROXY_BASIC_AUTH_ENABLE", "")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	user := createTestUser(true)
+
+	req := newHTTPRequest("GET", "/some/route/test.html", "", nil)
+	req.SetBasicAuth("foo@bar.com", "12345678")
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, user.ID.String(), handler.Headers.Get("X-Auth-UserID"))
+	if !strings.HasPrefix(handler.Headers.Get("Authorization"), "Bearer ") {
+		t.Error("Expected Authorization: Bearer [...] header")
+	}
+}
+
+func TestProxyUnauthorizedBasicAuth(t *testing.T) {
+	os.Setenv("PROXY_BASIC_AUTH_ENABLE", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("PROXY_BASIC_AUTH_ENABLE", "")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	user := createTestUser(true)
+
+	req := newHTTPRequest("GET", "/blacklist/test.html", "", nil)
+	req.SetBasicAuth("foo@bar.com", "11111111")
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	if !strings.HasPrefix(res.Header().Get("WWW-Authenticate"), "Basic ") {
+		t.Error("Expected WWW-Authenticate: Basic [...] header")
+	}
+	user = GetUserRepository().GetOne(user.ID.String())
+	if user.FailedLogins != 1 {
+		t.Error("Expected failed basic auth attempt to be counted")
+	}
+}
+
+func TestProxyUnauthorizedBasicAuthOTP(t *testing.T) {
+	os.Setenv("PROXY_BASIC_AUTH_ENABLE", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("PROXY_BASIC_AUTH_ENABLE", "")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	user, _ := createOTPTestUser(true)
+
+	req := newHTTPRequest("GET", "/blacklist/test.html", "", nil)
+	req.SetBasicAuth("foo@bar.com", "11111111")
+	_, _, errWrongPassword := ExtractClaimsFromBasicAuth(req)
+	req.SetBasicAuth("foo@bar.com", "12345678")
+	_, _, errOTPRequired := ExtractClaimsFromBasicAuth(req)
+	if errWrongPassword != ErrBasicAuthInvalidCredentials || errOTPRequired != ErrBasicAuthInvalidCredentials {
+		t.Error("Expected wrong password and required TOTP to fail alike")
+	}
+	user = GetUserRepository().GetOne(user.ID.String())
+	if user.FailedLogins != 1 {
+		t.Error("Expected password to be checked before TOTP")
+	}
+}
+
+func TestProxyUnauthorizedBasicAuthDisabled(t *testing.T) {
+	clearTestDB()
+	createTestUser(true)
+
+	req := newHTTPRequest("GET", "/blacklist/test.html", "", nil)
+	req.SetBasicAuth("foo@bar.com", "12345678")
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestProxySuccessWithGuestToken(t *testing.T) {
+	handler := &dummyProxyHandler{}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	t.Cleanup(func() {
+		GetConfig().ReadConfig()
+		GetApp().InitializeProxy()
+	})
+	t.Setenv("PROXY_TARGET", server.URL)
+	t.Setenv("GUEST_ROUTES", "/blacklist/guests")
+	GetConfig().ReadConfig()
+	GetApp().InitializeProxy()
+
+	clearTestDB()
+	req := newHTTPRequest("POST", "/auth/guest", "", nil)
+	res := executePublicTestRequest(req)
+	var guestResponse LoginResponse
+	json.Unmarshal(res.Body.Bytes(), &guestResponse)
+
+	req = newHTTPRequest("GET", "/blacklist/guests/test.html", guestResponse.AccessToken, nil)
+	req.Header.Set("X-Auth-UserID", "fake")
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "1", handler.Headers.Get("X-Auth-Guest"))
+	checkStringNotEmpty(t, handler.Headers.Get("X-Auth-GuestID"))
+	checkTestString(t, "", handler.Headers.Get("X-Auth-UserID"))
+
+	// Guests are rejected on protected routes not listed in GUEST_ROUTES
+	req = newHTTPRequest("GET", "/blacklist/test.html", guestResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestProxyBlockedUntilExpiredPasswordChanged(t *testing.T) {
+	os.Setenv("PASSWORD_MAX_AGE", "90")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("PASSWORD_MAX_AGE", "0")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+	defer proxy.Shutdown(context.TODO())
+
+	clearTestDB()
+	user := createTestUser(true)
+	user.CreateDate = time.Now().Add(-91 * 24 * time.Hour)
+	GetUserRepository().Update(user)
+
+	// Login succeeds, but proxied requests are blocked
+	loginResponse := loginUser("foo@bar.com", "12345678")
+	if !loginResponse.RequirePasswordChange {
+		t.Fatal("Expected password change to be required")
+	}
+	checkTestString(t, ErrorCodePasswordChangeRequired, loginResponse.Error)
+	req := newHTTPRequest("GET", "/blacklist/test.html", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusForbidden, res.Code)
+
+	payload := `{"oldPassword": "12345678", "newPassword": "87654321", "refreshToken": "` + loginResponse.RefreshToken + `"}`
+	req = newHTTPRequest("POST", "/auth/setpw", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	// Refreshed access token is accepted again
+	payload = `{"refreshToken": "` + loginResponse.RefreshToken + `"}`
+	req = newHTTPRequest("POST", "/auth/refresh", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	json.Unmarshal(res.Body.Bytes(), loginResponse)
+	req = newHTTPRequest("GET", "/blacklist/test.html", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+}
+
+func TestProxyRequestTimeout(t *testing.T) {
+	os.Setenv("PROXY_REQUEST_TIMEOUT", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_REQUEST_TIMEOUT")
+		GetConfig().ReadConfig()
+	}()
+	var proxy *http.Server = &http.Server{
+		Addr: "0.0.0.0:8090",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(2 * time.Second)
+		}),
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	req := newHTTPRequest("GET", "/some/route/test.html", loginResponse.AccessToken, nil)
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusGatewayTimeout, res.Code)
+	var errorResponse ErrorResponse
+	json.Unmarshal(res.Body.Bytes(), &errorResponse)
+	checkTestString(t, ErrorCodeUpstreamTimeout, errorResponse.Error)
+}
+
+type benchmarkProxyHandler struct {
+}
+
+func (h *benchmarkProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status": "ok"}`))
+}
+
+func BenchmarkProxyHandler(b *testing.B) {
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: &benchmarkProxyHandler{},
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+	defer proxy.Shutdown(context.TODO())
+	time.Sleep(100 * time.Millisecond)
+	accessToken := SignAccessToken(&Claims{UserID: "benchmark"})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := newHTTPRequest("GET", "/blacklist/route?page=1", accessToken, nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Forwarded-For", "192.0.2.1")
+		res := executePublicTestRequest(req)
+		if res.Code != http.StatusOK {
+			b.Fatalf("Expected HTTP Status %d, but got %d", http.StatusOK, res.Code)
+		}
+	}
+}
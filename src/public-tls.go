@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// CreateACMEManager creates a manager obtaining and renewing certificates for PUBLIC_ACME_DOMAINS
+// via the TLS-ALPN-01 challenge, or HTTP-01 if the redirect server is enabled
+func CreateACMEManager() *autocert.Manager {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(GetConfig().PublicACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(GetConfig().PublicACMEDomains...),
+		Email:      GetConfig().PublicACMEEmail,
+	}
+	if GetConfig().PublicACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: GetConfig().PublicACMEDirectoryURL}
+	}
+	return manager
+}
+
+// ConfigurePublicHTTP2 enables or disables HTTP/2 on the user-facing server. Over TLS, HTTP/2 is negotiated
+// via ALPN, which requires the TLS config to be set up before. Without TLS, h2c is accepted if enabled.
+func ConfigurePublicHTTP2(server *http.Server, useTLS bool) {
+	if !GetConfig().PublicHTTP2Enable {
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return
+	}
+	h2 := &http2.Server{IdleTimeout: server.IdleTimeout}
+	if useTLS {
+		if err := http2.ConfigureServer(server, h2); err != nil {
+			log.Fatal(err)
+		}
+	} else if GetConfig().PublicH2CEnable {
+		server.Handler = h2c.NewHandler(server.Handler, h2)
+	}
+}
+
+// HTTPSRedirectHandler redirects requests to the same URL on the HTTPS user-facing server
+func HTTPSRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		host = strings.Trim(host, "[]")
+	}
+	if _, port, err := net.SplitHostPort(GetConfig().PublicListenAddr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	target := "https://" + host + r.URL.RequestURI()
+	if r.Method == "GET" || r.Method == "HEAD" {
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	} else {
+		http.Redirect(w, r, target, http.StatusPermanentRedirect)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/some/route?a=b", nil)
+	res := httptest.NewRecorder()
+	HTTPSRedirectHandler(res, req)
+	checkTestResponseCode(t, http.StatusMovedPermanently, res.Code)
+	checkTestString(t, "https://example.com:8080/some/route?a=b", res.Header().Get("Location"))
+
+	req = httptest.NewRequest("POST", "http://example.com:80/some/route", nil)
+	res = httptest.NewRecorder()
+	HTTPSRedirectHandler(res, req)
+	checkTestResponseCode(t, http.StatusPermanentRedirect, res.Code)
+	checkTestString(t, "https://example.com:8080/some/route", res.Header().Get("Location"))
+}
+
+func TestPublicH2C(t *testing.T) {
+	os.Setenv("PUBLIC_H2C_ENABLE", "1")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PUBLIC_H2C_ENABLE")
+		GetConfig().ReadConfig()
+	}()
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	})}
+	ConfigurePublicHTTP2(server, false)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	defer server.Shutdown(context.TODO())
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	res, err := client.Get("http://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	checkTestString(t, "HTTP/2.0", string(body))
+}
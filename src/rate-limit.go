@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const ErrorCodeRateLimited = "rate_limited"
+
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreRedis  = "redis"
+)
+
+const redisRateLimitPrefix = "jwt-auth-proxy:ratelimit:"
+
+// RateLimit allows Limit requests per Period, in bursts of up to Limit requests
+type RateLimit struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParseRateLimit parses a limit in the format "<requests>/<s|m|h>", e.g. "100/m". "-" means no limit and returns nil.
+func ParseRateLimit(s string) (*RateLimit, error) {
+	if s == "" || s == "-" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return nil, errors.New("Rate limit must have the format <requests>/<s|m|h>: " + s)
+	}
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 1 {
+		return nil, errors.New("Rate limit must allow a positive number of requests: " + s)
+	}
+	periods := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	period, ok := periods[parts[1]]
+	if !ok {
+		return nil, errors.New("Rate limit period must be one of s, m, h: " + s)
+	}
+	return &RateLimit{Limit: limit, Period: period}, nil
+}
+
+// RateLimitRoute applies separate limits to the requests matching Route, which uses the syntax of PROXY_WHITELIST entries
+type RateLimitRoute struct {
+	Route     string
+	User      *RateLimit
+	Anonymous *RateLimit
+	rule      *ProxyRule
+}
+
+// ParseRateLimitRoute parses a route group in the format "<route> <user limit> <anonymous limit>"
+func ParseRateLimitRoute(s string) (*RateLimitRoute, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return nil, errors.New("Rate limit route must have the format <route> <user limit> <anonymous limit>: " + s)
+	}
+	route := &RateLimitRoute{Route: strings.Join(fields[:len(fields)-2], " ")}
+	var err error
+	if route.User, err = ParseRateLimit(fields[len(fields)-2]); err != nil {
+		return nil, err
+	}
+	if route.Anonymous, err = ParseRateLimit(fields[len(fields)-1]); err != nil {
+		return nil, err
+	}
+	if route.rule, err = ParseProxyRule(route.Route); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+// RateLimiter counts the requests per key, each key having its own token bucket
+type RateLimiter interface {
+	// Allow takes a token from the key's bucket and returns false and the time until the next token is available if it's empty
+	Allow(key string, limit *RateLimit) (bool, time.Duration)
+}
+
+var _rateLimiterInstance RateLimiter
+var _rateLimiterOnce sync.Once
+
+func GetRateLimiter() RateLimiter {
+	_rateLimiterOnce.Do(func() {
+		if GetConfig().ProxyRateLimitStore == RateLimitStoreRedis {
+			_rateLimiterInstance = &RedisRateLimiter{}
+		} else {
+			_rateLimiterInstance = &MemoryRateLimiter{buckets: make(map[string]*rateLimitBucket)}
+		}
+	})
+	return _rateLimiterInstance
+}
+
+type rateLimitBucket struct {
+	Tokens  float64
+	Updated time.Time
+	Period  time.Duration
+}
+
+// MemoryRateLimiter keeps the token buckets in memory, limits apply per proxy instance
+type MemoryRateLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*rateLimitBucket
+	lastSweep time.Time
+}
+
+func (l *MemoryRateLimiter) Allow(key string, limit *RateLimit) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastSweep) > time.Minute {
+		l._Sweep(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &rateLimitBucket{Tokens: float64(limit.Limit), Updated: now, Period: limit.Period}
+		l.buckets[key] = bucket
+	}
+	rate := float64(limit.Limit) / limit.Period.Seconds()
+	bucket.Tokens = math.Min(float64(limit.Limit), bucket.Tokens+now.Sub(bucket.Updated).Seconds()*rate)
+	bucket.Updated = now
+	if bucket.Tokens < 1 {
+		return false, time.Duration((1 - bucket.Tokens) / rate * float64(time.Second))
+	}
+	bucket.Tokens--
+	return true, 0
+}
+
+// _Sweep removes the buckets which have been refilled completely, as they are equal to new ones
+func (l *MemoryRateLimiter) _Sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.Updated) > bucket.Period {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// redisRateLimitScript refills and takes a token from the bucket atomically, using the Redis server's clock so that
+// all proxy instances agree on the time. It returns whether the request is allowed and otherwise the wait time in ms.
+var redisRateLimitScript = redis.NewScript(`
+redis.replicate_commands()
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or limit
+local updated = tonumber(bucket[2]) or now
+local rate = limit / period
+tokens = math.min(limit, tokens + math.max(0, now - updated) * rate)
+local allowed, wait = 1, 0
+if tokens < 1 then
+	allowed, wait = 0, math.ceil((1 - tokens) / rate)
+else
+	tokens = tokens - 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], period)
+return {allowed, wait}
+`)
+
+// RedisRateLimiter keeps the token buckets in Redis so that limits hold across multiple proxy instances.
+// If Redis is unavailable, requests are allowed rather than failing the proxy.
+type RedisRateLimiter struct {
+}
+
+func (l *RedisRateLimiter) Allow(key string, limit *RateLimit) (bool, time.Duration) {
+	res, err := redisRateLimitScript.Run(context.TODO(), GetRedisClient(), []string{redisRateLimitPrefix + key}, limit.Limit, limit.Period.Milliseconds()).Int64Slice()
+	if err != nil {
+		log.Println("Could not check rate limit:", err)
+		return true, 0
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond
+}
+
+// RateLimitMiddleware rejects proxied requests exceeding the rate limit of their route group with 429.
+// Authenticated requests are limited per UserID (or GuestID), anonymous requests per client IP address.
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.EscapedPath()
+		if r.Method == "OPTIONS" || strings.HasPrefix(path, GetConfig().PublicAPIPath) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		group, userLimit, anonymousLimit := "", GetConfig().ProxyRateLimitUser, GetConfig().ProxyRateLimitAnonymous
+		for _, route := range GetConfig().ProxyRateLimitRoutes {
+			if route.rule.Matches(r.Method, path) {
+				group, userLimit, anonymousLimit = route.Route, route.User, route.Anonymous
+				break
+			}
+		}
+		var key string
+		var limit *RateLimit
+		if claims := GetClaimsFromContext(r); claims == nil {
+			key, limit = "ip:"+GetClientIP(r), anonymousLimit
+		} else if claims.Guest {
+			key, limit = "guest:"+claims.GuestID, userLimit
+		} else {
+			key, limit = "user:"+claims.UserID, userLimit
+		}
+		if limit == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retryAfter := GetRateLimiter().Allow(group+"|"+key, limit); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			SendError(w, http.StatusTooManyRequests, ErrorCodeRateLimited)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("100/m")
+	if err != nil || limit.Limit != 100 || limit.Period != time.Minute {
+		t.Error("Expected 100 requests per minute")
+	}
+	if limit, err := ParseRateLimit("-"); err != nil || limit != nil {
+		t.Error("Expected no limit")
+	}
+	for _, s := range []string{"100", "0/s", "abc/s", "10/d"} {
+		if _, err := ParseRateLimit(s); err == nil {
+			t.Errorf("Expected error for %s", s)
+		}
+	}
+	route, err := ParseRateLimitRoute("POST,PUT /upload 10/m -")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "POST,PUT /upload", route.Route)
+	if route.User.Limit != 10 || route.Anonymous != nil || !route.rule.Matches("PUT", "/upload/file") || route.rule.Matches("GET", "/upload") {
+		t.Error("Expected route group to be parsed")
+	}
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	limiter := &MemoryRateLimiter{buckets: make(map[string]*rateLimitBucket)}
+	limit := &RateLimit{Limit: 3, Period: time.Minute}
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("a", limit); !ok {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+	ok, retryAfter := limiter.Allow("a", limit)
+	if ok {
+		t.Error("Expected request exceeding the limit to be rejected")
+	}
+	if retryAfter <= 0 || retryAfter > 20*time.Second {
+		t.Errorf("Expected to retry within 20 seconds, got %s", retryAfter)
+	}
+	if ok, _ := limiter.Allow("b", limit); !ok {
+		t.Error("Expected other key to have its own bucket")
+	}
+	limiter.buckets["a"].Updated = time.Now().Add(-20 * time.Second)
+	if ok, _ := limiter.Allow("a", limit); !ok {
+		t.Error("Expected bucket to be refilled")
+	}
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	os.Setenv("PROXY_RATE_LIMIT_ROUTES", "/ratelimited 1/m 2/m")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_RATE_LIMIT_ROUTES")
+		GetConfig().ReadConfig()
+	}()
+	for i := 0; i < 2; i++ {
+		res := executePublicTestRequest(newHTTPRequest("GET", "/ratelimited", "", nil))
+		checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+	}
+	res := executePublicTestRequest(newHTTPRequest("GET", "/ratelimited", "", nil))
+	checkTestResponseCode(t, http.StatusTooManyRequests, res.Code)
+	checkStringNotEmpty(t, res.Header().Get("Retry-After"))
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	res = executePublicTestRequest(newHTTPRequest("GET", "/ratelimited", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", "/ratelimited", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusTooManyRequests, res.Code)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var _redisClientInstance *redis.Client
+var _redisClientOnce sync.Once
+
+// GetRedisClient returns the client for the Redis server configured in REDIS_URL, shared by all Redis-backed features
+func GetRedisClient() *redis.Client {
+	_redisClientOnce.Do(func() {
+		options, err := redis.ParseURL(GetConfig().RedisURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		_redisClientInstance = redis.NewClient(options)
+	})
+	return _redisClientInstance
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRefreshTokenStore stores refresh tokens in memory, see STORAGE
+type MemoryRefreshTokenStore struct {
+	tokens *MemoryCollection[RefreshToken]
+}
+
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{tokens: NewMemoryCollection[RefreshToken]()}
+}
+
+func (r *MemoryRefreshTokenStore) _ByID(id primitive.ObjectID) func(*RefreshToken) bool {
+	return func(t *RefreshToken) bool {
+		return t.ID == id
+	}
+}
+
+func (r *MemoryRefreshTokenStore) Create(u *RefreshToken) {
+	u.ID = primitive.NewObjectID()
+	r.tokens.Insert(u)
+}
+
+func (r *MemoryRefreshTokenStore) GetOne(id string) *RefreshToken {
+	return r._Unexpired(r.tokens.FindOne(func(t *RefreshToken) bool {
+		return t.ID.Hex() == id
+	}))
+}
+
+func (r *MemoryRefreshTokenStore) GetByHashedToken(hashedToken string) *RefreshToken {
+	return r._Unexpired(r.tokens.FindOne(func(t *RefreshToken) bool {
+		return t.HashedToken == hashedToken
+	}))
+}
+
+func (r *MemoryRefreshTokenStore) _Unexpired(refreshToken *RefreshToken) *RefreshToken {
+	if refreshToken == nil {
+		return nil
+	}
+	if refreshToken.ExpiryDate.Before(time.Now()) {
+		r.Delete(refreshToken)
+		return nil
+	}
+	return refreshToken
+}
+
+func (r *MemoryRefreshTokenStore) GetAllForUser(userID string) []*RefreshToken {
+	now := time.Now()
+	return r.tokens.Find(func(t *RefreshToken) bool {
+		return t.UserID == UserID(userID) && t.ExpiryDate.After(now)
+	})
+}
+
+func (r *MemoryRefreshTokenStore) UpdateLastUse(u *RefreshToken, ip, userAgent string) {
+	u.LastUseDate = time.Now()
+	u.IP = ip
+	u.UserAgent = userAgent
+	r.tokens.Update(r._ByID(u.ID), func(t *RefreshToken) {
+		t.LastUseDate = u.LastUseDate
+		t.IP = u.IP
+		t.UserAgent = u.UserAgent
+	})
+}
+
+func (r *MemoryRefreshTokenStore) CountActive() int64 {
+	now := time.Now()
+	return r.tokens.Count(func(t *RefreshToken) bool {
+		return t.ExpiryDate.After(now)
+	})
+}
+
+func (r *MemoryRefreshTokenStore) DeleteAllForUser(userID string) {
+	r.tokens.Delete(func(t *RefreshToken) bool {
+		return t.UserID == UserID(userID)
+	})
+}
+
+func (r *MemoryRefreshTokenStore) DeleteAllForUserExcept(userID string, hashedToken string) {
+	r.tokens.Delete(func(t *RefreshToken) bool {
+		return t.UserID == UserID(userID) && t.HashedToken != hashedToken
+	})
+}
+
+func (r *MemoryRefreshTokenStore) Delete(u *RefreshToken) {
+	r.tokens.Delete(r._ByID(u.ID))
+}
+
+func (r *MemoryRefreshTokenStore) CleanUp() {
+	now := time.Now()
+	r.tokens.Delete(func(t *RefreshToken) bool {
+		return !t.ExpiryDate.After(now)
+	})
+}
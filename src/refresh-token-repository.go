@@ -9,20 +9,41 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 
 	guuid "github.com/google/uuid"
 )
 
+// RefreshToken is a session of a user. Only a hash of the token is stored, the plain text Token is set on
+// tokens created or looked up by token only.
 type RefreshToken struct {
-	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	UserID     primitive.ObjectID `json:"userId" bson:"userId"`
-	Token      string             `json:"token" bson:"token"`
-	CreateDate time.Time          `json:"createDate" bson:"createDate"`
-	ExpiryDate time.Time          `json:"expiryDate" bson:"expiryDate"`
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      UserID             `json:"userId" bson:"userId"`
+	Token       string             `json:"-" bson:"-"`
+	HashedToken string             `json:"-" bson:"hashedToken"`
+	CreateDate  time.Time          `json:"createDate" bson:"createDate"`
+	ExpiryDate  time.Time          `json:"expiryDate" bson:"expiryDate"`
+	LastUseDate time.Time          `json:"lastUseDate" bson:"lastUseDate"`
+	IP          string             `json:"ip" bson:"ip"`
+	UserAgent   string             `json:"userAgent" bson:"userAgent"`
 }
 
+// RefreshTokenStore persists refresh tokens, see STORAGE
+type RefreshTokenStore interface {
+	Create(u *RefreshToken)
+	GetOne(id string) *RefreshToken
+	GetByHashedToken(hashedToken string) *RefreshToken
+	GetAllForUser(userID string) []*RefreshToken
+	UpdateLastUse(u *RefreshToken, ip, userAgent string)
+	CountActive() int64
+	DeleteAllForUser(userID string)
+	DeleteAllForUserExcept(userID string, hashedToken string)
+	Delete(u *RefreshToken)
+	CleanUp()
+}
+
+// RefreshTokenRepository manages refresh tokens, storing them in the RefreshTokenStore of the configured storage backend
 type RefreshTokenRepository struct {
+	RefreshTokenStore
 }
 
 var _refreshTokenRepositoryInstance *RefreshTokenRepository
@@ -30,28 +51,23 @@ var _refreshTokenRepositoryOnce sync.Once
 
 func GetRefreshTokenRepository() *RefreshTokenRepository {
 	_refreshTokenRepositoryOnce.Do(func() {
-		_refreshTokenRepositoryInstance = &RefreshTokenRepository{}
-		ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
-		// Create unique index on 'token'
-		mod := mongo.IndexModel{
-			Keys: bson.M{
-				"token": 1,
-			},
-			Options: options.Index().SetUnique(true),
-		}
-		_, err := _refreshTokenRepositoryInstance.GetCollection().Indexes().CreateOne(ctx, mod)
-		if err != nil {
-			log.Fatal(err)
-		}
+		_refreshTokenRepositoryInstance = &RefreshTokenRepository{GetStorageDriver().NewRefreshTokenStore()}
 	})
 	return _refreshTokenRepositoryInstance
 }
 
-func (r *RefreshTokenRepository) GetCollection() *mongo.Collection {
-	return GetDatatabase().Database.Collection("refresh_tokens")
+type MongoRefreshTokenStore struct {
 }
 
-func (r *RefreshTokenRepository) Create(u *RefreshToken) {
+func NewMongoRefreshTokenStore() *MongoRefreshTokenStore {
+	return &MongoRefreshTokenStore{}
+}
+
+func (r *MongoRefreshTokenStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("refresh_tokens")
+}
+
+func (r *MongoRefreshTokenStore) Create(u *RefreshToken) {
 	res, err := r.GetCollection().InsertOne(context.TODO(), u)
 	if err != nil {
 		log.Println(err)
@@ -59,7 +75,7 @@ func (r *RefreshTokenRepository) Create(u *RefreshToken) {
 	u.ID = res.InsertedID.(primitive.ObjectID)
 }
 
-func (r *RefreshTokenRepository) GetOne(id string) *RefreshToken {
+func (r *MongoRefreshTokenStore) GetOne(id string) *RefreshToken {
 	var refreshToken RefreshToken
 	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&refreshToken)
 	if err != nil {
@@ -72,9 +88,23 @@ func (r *RefreshTokenRepository) GetOne(id string) *RefreshToken {
 	return &refreshToken
 }
 
+// Create stores the refresh token with the hash of its plain text Token
+func (r *RefreshTokenRepository) Create(u *RefreshToken) {
+	u.HashedToken = HashToken(u.Token)
+	r.RefreshTokenStore.Create(u)
+}
+
 func (r *RefreshTokenRepository) GetByToken(token string) *RefreshToken {
+	refreshToken := r.GetByHashedToken(HashToken(token))
+	if refreshToken != nil {
+		refreshToken.Token = token
+	}
+	return refreshToken
+}
+
+func (r *MongoRefreshTokenStore) GetByHashedToken(hashedToken string) *RefreshToken {
 	var refreshToken RefreshToken
-	err := r.GetCollection().FindOne(context.TODO(), bson.M{"token": token}).Decode(&refreshToken)
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"hashedToken": hashedToken}).Decode(&refreshToken)
 	if err != nil {
 		return nil
 	}
@@ -85,14 +115,71 @@ func (r *RefreshTokenRepository) GetByToken(token string) *RefreshToken {
 	return &refreshToken
 }
 
-func (r *RefreshTokenRepository) DeleteAllForUser(userID string) {
-	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": GetDatatabase().GetObjectID(userID)})
+func (r *MongoRefreshTokenStore) GetAllForUser(userID string) []*RefreshToken {
+	results := make([]*RefreshToken, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{
+		"userId":     UserID(userID),
+		"expiryDate": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var refreshToken RefreshToken
+		err := cur.Decode(&refreshToken)
+		if err != nil {
+			return results
+		}
+		results = append(results, &refreshToken)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+// UpdateLastUse records the time and client of the latest token refresh
+func (r *MongoRefreshTokenStore) UpdateLastUse(u *RefreshToken, ip, userAgent string) {
+	u.LastUseDate = time.Now()
+	u.IP = ip
+	u.UserAgent = userAgent
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{
+		"lastUseDate": u.LastUseDate,
+		"ip":          u.IP,
+		"userAgent":   u.UserAgent,
+	}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// CountActive returns the number of refresh tokens which have not expired yet
+func (r *MongoRefreshTokenStore) CountActive() int64 {
+	count, err := r.GetCollection().CountDocuments(context.TODO(), bson.M{"expiryDate": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		log.Println(err)
+	}
+	return count
+}
+
+func (r *MongoRefreshTokenStore) DeleteAllForUser(userID string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// DeleteAllForUserExcept deletes all of the user's refresh tokens but the given one
+func (r *RefreshTokenRepository) DeleteAllForUserExcept(userID string, token string) {
+	r.RefreshTokenStore.DeleteAllForUserExcept(userID, HashToken(token))
+}
+
+func (r *MongoRefreshTokenStore) DeleteAllForUserExcept(userID string, hashedToken string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID), "hashedToken": bson.M{"$ne": hashedToken}})
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func (r *RefreshTokenRepository) Delete(u *RefreshToken) {
+func (r *MongoRefreshTokenStore) Delete(u *RefreshToken) {
 	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
 	if err != nil {
 		log.Println(err)
@@ -110,7 +197,7 @@ func (r *RefreshTokenRepository) FindUnusedToken() string {
 	return token
 }
 
-func (r *RefreshTokenRepository) CleanUp() {
+func (r *MongoRefreshTokenStore) CleanUp() {
 	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"expiryDate": bson.M{"$lte": time.Now()}})
 	if err != nil {
 		log.Println(err)
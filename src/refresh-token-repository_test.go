@@ -3,8 +3,6 @@ package main
 import (
 	"testing"
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func TestRefreshTokenCleanUp(t *testing.T) {
@@ -13,14 +11,14 @@ func TestRefreshTokenCleanUp(t *testing.T) {
 	t1 := &RefreshToken{
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Token:      GetRefreshTokenRepository().FindUnusedToken(),
 	}
 	GetRefreshTokenRepository().Create(t1)
 	t2 := &RefreshToken{
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Token:      GetRefreshTokenRepository().FindUnusedToken(),
 	}
 	GetRefreshTokenRepository().Create(t2)
@@ -44,7 +42,7 @@ func TestRefreshTokenGetOne(t *testing.T) {
 	t1 := &RefreshToken{
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Token:      GetRefreshTokenRepository().FindUnusedToken(),
 	}
 	GetRefreshTokenRepository().Create(t1)
@@ -61,7 +59,7 @@ func TestRefreshTokenGetOneExpired(t *testing.T) {
 	t1 := &RefreshToken{
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Token:      GetRefreshTokenRepository().FindUnusedToken(),
 	}
 	GetRefreshTokenRepository().Create(t1)
@@ -79,7 +77,7 @@ func TestRefreshTokenGetByToken(t *testing.T) {
 	t1 := &RefreshToken{
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Token:      token,
 	}
 	GetRefreshTokenRepository().Create(t1)
@@ -97,7 +95,7 @@ func TestRefreshTokenGetByTokenExpired(t *testing.T) {
 	t1 := &RefreshToken{
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Token:      token,
 	}
 	GetRefreshTokenRepository().Create(t1)
@@ -107,3 +105,29 @@ func TestRefreshTokenGetByTokenExpired(t *testing.T) {
 		t.Error("Expected t1 to be nil")
 	}
 }
+
+func TestRefreshTokenStoredHashed(t *testing.T) {
+	clearTestDB()
+
+	token := GetRefreshTokenRepository().FindUnusedToken()
+	t1 := &RefreshToken{
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
+		UserID:     NewUserID(),
+		Token:      token,
+	}
+	GetRefreshTokenRepository().Create(t1)
+
+	stored := GetRefreshTokenRepository().GetOne(t1.ID.Hex())
+	if stored == nil {
+		t.Fatal("Expected stored token not to be nil")
+	}
+	checkTestString(t, "", stored.Token)
+	if stored.HashedToken == "" || stored.HashedToken == token {
+		t.Error("Expected only the hash of the token to be stored")
+	}
+	if GetRefreshTokenRepository().GetByHashedToken(token) != nil {
+		t.Error("Expected token not to be found by its plain text")
+	}
+	checkTestString(t, token, GetRefreshTokenRepository().GetByToken(token).Token)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProxyCacheMemory = "memory"
+	ProxyCacheRedis  = "redis"
+)
+
+const redisResponseCachePrefix = "jwt-auth-proxy:cache:"
+
+// CachedResponse is a proxied response stored in the cache.
+// For responses with a Vary header, an entry with only Vary set is stored under the request's key,
+// pointing to the variants stored under keys including the values of the listed request headers.
+type CachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	Vary   []string    `json:"vary"`
+	Date   time.Time   `json:"date"`
+}
+
+type ResponseCache interface {
+	Get(key string) *CachedResponse
+	Set(key string, res *CachedResponse, ttl time.Duration)
+}
+
+var _responseCacheInstance ResponseCache
+var _responseCacheOnce sync.Once
+
+func GetResponseCache() ResponseCache {
+	_responseCacheOnce.Do(func() {
+		if GetConfig().ProxyCache == ProxyCacheRedis {
+			_responseCacheInstance = &RedisResponseCache{}
+		} else {
+			_responseCacheInstance = &MemoryResponseCache{entries: make(map[string]*memoryResponseCacheEntry)}
+		}
+	})
+	return _responseCacheInstance
+}
+
+type memoryResponseCacheEntry struct {
+	Response *CachedResponse
+	Expiry   time.Time
+}
+
+// MemoryResponseCache keeps up to PROXY_CACHE_MAX_ENTRIES responses in memory
+type MemoryResponseCache struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryResponseCacheEntry
+}
+
+func (c *MemoryResponseCache) Get(key string) *CachedResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if entry.Expiry.Before(time.Now()) {
+		delete(c.entries, key)
+		return nil
+	}
+	return entry.Response
+}
+
+func (c *MemoryResponseCache) Set(key string, res *CachedResponse, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= GetConfig().ProxyCacheMaxEntries {
+		c._Evict()
+	}
+	c.entries[key] = &memoryResponseCacheEntry{Response: res, Expiry: time.Now().Add(ttl)}
+}
+
+// _Evict removes all expired entries or, if there are none, an arbitrary one
+func (c *MemoryResponseCache) _Evict() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if entry.Expiry.Before(now) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) < GetConfig().ProxyCacheMaxEntries {
+		return
+	}
+	for key := range c.entries {
+		delete(c.entries, key)
+		return
+	}
+}
+
+// RedisResponseCache stores responses in Redis so that multiple proxy instances share the cache
+type RedisResponseCache struct {
+}
+
+func (c *RedisResponseCache) Get(key string) *CachedResponse {
+	data, err := GetRedisClient().Get(context.TODO(), redisResponseCachePrefix+key).Bytes()
+	if err != nil {
+		return nil
+	}
+	var res CachedResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		log.Println(err)
+		return nil
+	}
+	return &res
+}
+
+func (c *RedisResponseCache) Set(key string, res *CachedResponse, ttl time.Duration) {
+	data, err := json.Marshal(res)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := GetRedisClient().Set(context.TODO(), redisResponseCachePrefix+key, data, ttl).Err(); err != nil {
+		log.Println(err)
+	}
+}
+
+// IsCacheableRequest checks if the response to a proxied request may be served from or stored in the cache
+func IsCacheableRequest(r *http.Request) bool {
+	if GetConfig().ProxyCache == "" || r.Method != "GET" || IsWebSocketUpgrade(r) {
+		return false
+	}
+	if _HasCacheControlDirective(r.Header, "no-store") {
+		return false
+	}
+	path := r.URL.Path
+	for _, route := range GetConfig().ProxyCacheRoutes {
+		route = strings.TrimSuffix(route, "/")
+		if route == "" || path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeCachedResponse writes the cached response for the request, if any, and returns true in this case
+func ServeCachedResponse(w http.ResponseWriter, r *http.Request) bool {
+	if _HasCacheControlDirective(r.Header, "no-cache") {
+		return false
+	}
+	key := _GetResponseCacheKey(r)
+	res := GetResponseCache().Get(key)
+	if res != nil && len(res.Vary) > 0 {
+		res = GetResponseCache().Get(_GetResponseCacheVariantKey(key, res.Vary, r.Header))
+	}
+	if res == nil {
+		return false
+	}
+	for name, values := range res.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(res.Date).Seconds())))
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(res.Status)
+	w.Write(res.Body)
+	return true
+}
+
+// cacheResponseWriter records a proxied response while passing it on to the client
+type cacheResponseWriter struct {
+	http.ResponseWriter
+	key           string
+	requestHeader http.Header
+	claims        *Claims
+	status        int
+	body          bytes.Buffer
+	overflow      bool
+}
+
+// NewCacheResponseWriter creates a writer recording the response to the request for the cache
+func NewCacheResponseWriter(w http.ResponseWriter, r *http.Request) *cacheResponseWriter {
+	return &cacheResponseWriter{
+		ResponseWriter: w,
+		key:            _GetResponseCacheKey(r),
+		requestHeader:  r.Header,
+		claims:         GetClaimsFromContext(r),
+	}
+}
+
+func (w *cacheResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.Header().Set("X-Cache", "MISS")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.overflow {
+		if w.body.Len()+len(b) > GetConfig().ProxyCacheMaxBodySize {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Store puts the recorded response into the cache if its Cache-Control header allows it.
+// As a shared cache, responses to authenticated requests, no matter if by access token, API key or signed URL,
+// are only stored if they are explicitly public or vary by X-Auth-UserID, so that they are cached per user.
+// Guests have no UserID, so responses to them must be public.
+func (w *cacheResponseWriter) Store() {
+	header := w.Header()
+	if w.status != http.StatusOK || w.overflow || header.Get("Set-Cookie") != "" {
+		return
+	}
+	if _HasCacheControlDirective(header, "no-store") || _HasCacheControlDirective(header, "no-cache") || _HasCacheControlDirective(header, "private") {
+		return
+	}
+	ttl := _GetCacheControlMaxAge(header)
+	if ttl <= 0 {
+		return
+	}
+	vary := make([]string, 0)
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name == "*" {
+				return
+			} else if name != "" {
+				vary = append(vary, name)
+			}
+		}
+	}
+	sort.Strings(vary)
+	if w.claims != nil && !_HasCacheControlDirective(header, "public") && !_HasCacheControlDirective(header, "s-maxage") && (w.claims.UserID == "" || !_VariesByUser(vary)) {
+		return
+	}
+	res := &CachedResponse{
+		Status: w.status,
+		Header: header.Clone(),
+		Body:   w.body.Bytes(),
+		Date:   time.Now(),
+	}
+	res.Header.Del("X-Cache")
+	key := w.key
+	if len(vary) > 0 {
+		GetResponseCache().Set(key, &CachedResponse{Vary: vary}, ttl)
+		key = _GetResponseCacheVariantKey(key, vary, w.requestHeader)
+	}
+	GetResponseCache().Set(key, res, ttl)
+}
+
+func _VariesByUser(vary []string) bool {
+	for _, name := range vary {
+		if name == http.CanonicalHeaderKey(IdentityHeaderName("X-Auth-UserID")) {
+			return true
+		}
+	}
+	return false
+}
+
+func _GetResponseCacheKey(r *http.Request) string {
+	return r.Method + " " + r.Host + r.URL.RequestURI()
+}
+
+func _GetResponseCacheVariantKey(key string, vary []string, header http.Header) string {
+	for _, name := range vary {
+		key += "\n" + name + ": " + strings.Join(header.Values(name), ", ")
+	}
+	return key
+}
+
+func _HasCacheControlDirective(header http.Header, directive string) bool {
+	for _, value := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(value, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if d == directive || strings.HasPrefix(d, directive+"=") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// _GetCacheControlMaxAge returns the lifetime of a response from s-maxage or, if not set, max-age
+func _GetCacheControlMaxAge(header http.Header) time.Duration {
+	maxAge := -1
+	for _, value := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(value, ",") {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if strings.HasPrefix(d, "s-maxage=") {
+				if i, err := strconv.Atoi(strings.TrimPrefix(d, "s-maxage=")); err == nil {
+					return time.Duration(i) * time.Second
+				}
+			} else if strings.HasPrefix(d, "max-age=") {
+				if i, err := strconv.Atoi(strings.TrimPrefix(d, "max-age=")); err == nil {
+					maxAge = i
+				}
+			}
+		}
+	}
+	return time.Duration(maxAge) * time.Second
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type countingProxyHandler struct {
+	Calls        int
+	CacheControl string
+	Vary         string
+}
+
+func (h *countingProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Calls++
+	w.Header().Set("Cache-Control", h.CacheControl)
+	w.Header().Set("Vary", h.Vary)
+	w.Write([]byte(strconv.Itoa(h.Calls) + " " + r.Header.Get("X-Auth-UserID")))
+}
+
+func TestGetCacheControlMaxAge(t *testing.T) {
+	header := http.Header{}
+	header.Set("Cache-Control", "public, max-age=60")
+	if _GetCacheControlMaxAge(header) != 60*time.Second {
+		t.Error("Expected max-age of 60 seconds")
+	}
+	header.Set("Cache-Control", "max-age=60, s-maxage=10")
+	if _GetCacheControlMaxAge(header) != 10*time.Second {
+		t.Error("Expected s-maxage to take precedence")
+	}
+	header.Set("Cache-Control", "no-cache")
+	if _GetCacheControlMaxAge(header) > 0 {
+		t.Error("Expected no max-age")
+	}
+}
+
+func TestMemoryResponseCache(t *testing.T) {
+	cache := &MemoryResponseCache{entries: make(map[string]*memoryResponseCacheEntry)}
+	cache.Set("a", &CachedResponse{Status: http.StatusOK}, time.Minute)
+	cache.Set("b", &CachedResponse{Status: http.StatusOK}, -time.Minute)
+	if cache.Get("a") == nil {
+		t.Error("Expected cached response")
+	}
+	if cache.Get("b") != nil {
+		t.Error("Expected expired response to be dropped")
+	}
+}
+
+func TestProxyCache(t *testing.T) {
+	os.Setenv("PROXY_CACHE", "memory")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_CACHE")
+		GetConfig().ReadConfig()
+	}()
+	handler := &countingProxyHandler{CacheControl: "public, max-age=60"}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	res1 := executePublicTestRequest(newHTTPRequest("GET", "/some/route/cached.html", loginResponse.AccessToken, nil))
+	res2 := executePublicTestRequest(newHTTPRequest("GET", "/some/route/cached.html", loginResponse.AccessToken, nil))
+	req := newHTTPRequest("GET", "/some/route/cached.html", loginResponse.AccessToken, nil)
+	req.Header.Set("Cache-Control", "no-cache")
+	res3 := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res2.Code)
+	checkTestString(t, "MISS", res1.Header().Get("X-Cache"))
+	checkTestString(t, "HIT", res2.Header().Get("X-Cache"))
+	checkTestString(t, res1.Body.String(), res2.Body.String())
+	checkTestString(t, "MISS", res3.Header().Get("X-Cache"))
+	if handler.Calls != 2 {
+		t.Errorf("Expected 2 upstream calls, got %d", handler.Calls)
+	}
+}
+
+func TestProxyCachePerUser(t *testing.T) {
+	os.Setenv("PROXY_CACHE", "memory")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_CACHE")
+		GetConfig().ReadConfig()
+	}()
+	handler := &countingProxyHandler{CacheControl: "max-age=60", Vary: "X-Auth-UserID"}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	user1 := createTestUser(true)
+	login1 := loginUser("foo@bar.com", "12345678")
+	user2 := &User{
+		Email:          "foo2@bar.com",
+		CreateDate:     time.Now(),
+		HashedPassword: GetUserRepository().GetHashedPassword("12345678"),
+		Confirmed:      true,
+		Enabled:        true,
+	}
+	GetUserRepository().Create(user2)
+	login2 := loginUser("foo2@bar.com", "12345678")
+
+	res1 := executePublicTestRequest(newHTTPRequest("GET", "/some/route/per-user.html", login1.AccessToken, nil))
+	res2 := executePublicTestRequest(newHTTPRequest("GET", "/some/route/per-user.html", login2.AccessToken, nil))
+	res3 := executePublicTestRequest(newHTTPRequest("GET", "/some/route/per-user.html", login1.AccessToken, nil))
+
+	proxy.Shutdown(context.TODO())
+	checkTestString(t, "1 "+user1.ID.String(), res1.Body.String())
+	checkTestString(t, "2 "+user2.ID.String(), res2.Body.String())
+	checkTestString(t, "1 "+user1.ID.String(), res3.Body.String())
+	checkTestString(t, "HIT", res3.Header().Get("X-Cache"))
+}
+
+func TestProxyCacheNotPublic(t *testing.T) {
+	os.Setenv("PROXY_CACHE", "memory")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_CACHE")
+		GetConfig().ReadConfig()
+	}()
+	handler := &countingProxyHandler{CacheControl: "max-age=60"}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	executePublicTestRequest(newHTTPRequest("GET", "/some/route/private.html", loginResponse.AccessToken, nil))
+	res := executePublicTestRequest(newHTTPRequest("GET", "/some/route/private.html", loginResponse.AccessToken, nil))
+
+	proxy.Shutdown(context.TODO())
+	checkTestString(t, "MISS", res.Header().Get("X-Cache"))
+	if handler.Calls != 2 {
+		t.Errorf("Expected 2 upstream calls, got %d", handler.Calls)
+	}
+}
+
+func TestProxyCacheNotPublicAPIKeyAndSignedURL(t *testing.T) {
+	setTestBlacklist(t)
+	handler := &countingProxyHandler{CacheControl: "max-age=60"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	t.Cleanup(func() {
+		GetConfig().ReadConfig()
+		GetApp().InitializeProxy()
+	})
+	t.Setenv("PROXY_CACHE", "memory")
+	t.Setenv("PROXY_TARGET", server.URL)
+	GetConfig().ReadConfig()
+	GetApp().InitializeProxy()
+
+	clearTestDB()
+	user1 := createTestUser(true)
+	_, key := GetAPIKeyRepository().CreateForUser(user1, "test", []string{"read"})
+	user2 := &User{
+		Email:          "foo2@bar.com",
+		CreateDate:     time.Now(),
+		HashedPassword: GetUserRepository().GetHashedPassword("12345678"),
+		Confirmed:      true,
+		Enabled:        true,
+	}
+	GetUserRepository().Create(user2)
+	login2 := loginUser("foo2@bar.com", "12345678")
+
+	req := newHTTPRequest("GET", "/blacklist/me.json", "", nil)
+	req.Header.Set("X-Api-Key", key)
+	res := executePublicTestRequest(req)
+	checkTestString(t, "1 "+user1.ID.String(), res.Body.String())
+	res = executePublicTestRequest(newHTTPRequest("GET", "/blacklist/me.json", login2.AccessToken, nil))
+	checkTestString(t, "MISS", res.Header().Get("X-Cache"))
+	checkTestString(t, "2 "+user2.ID.String(), res.Body.String())
+
+	signed, err := SignURL("/blacklist/signed.json", user1.ID.String(), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = executePublicTestRequest(newHTTPRequest("GET", signed, "", nil))
+	checkTestString(t, "3 "+user1.ID.String(), res.Body.String())
+	res = executePublicTestRequest(newHTTPRequest("GET", "/blacklist/signed.json", login2.AccessToken, nil))
+	checkTestString(t, "MISS", res.Header().Get("X-Cache"))
+	checkTestString(t, "4 "+user2.ID.String(), res.Body.String())
+}
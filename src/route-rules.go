@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const ErrorCodeInvalidRouteRules = "invalid_route_rules"
+
+// RouteRules decide which proxied requests require authentication and authorization.
+// They are loaded with the config and can be reloaded at runtime on SIGHUP or via the backend API.
+type RouteRules struct {
+	PublicRoutes []string
+	Whitelist    []*ProxyRule
+	Blacklist    []*ProxyRule
+	AuthzRules   []*AuthzRule
+}
+
+// RouteRulesFile holds the content of PROXY_RULES_FILE, each entry using the syntax of PROXY_WHITELIST entries
+type RouteRulesFile struct {
+	Whitelist []string `json:"whitelist"`
+	Blacklist []string `json:"blacklist"`
+}
+
+var _routeRulesInstance *RouteRules
+var _routeRulesMutex sync.RWMutex
+
+// GetRouteRules returns the currently active route rules
+func GetRouteRules() *RouteRules {
+	GetConfig()
+	_routeRulesMutex.RLock()
+	defer _routeRulesMutex.RUnlock()
+	return _routeRulesInstance
+}
+
+func _SetRouteRules(rules *RouteRules) {
+	_routeRulesMutex.Lock()
+	defer _routeRulesMutex.Unlock()
+	_routeRulesInstance = rules
+}
+
+// ReloadRouteRules replaces the active route rules with freshly loaded ones.
+// If loading fails, the active rules are kept.
+func ReloadRouteRules() error {
+	rules, err := GetConfig().LoadRouteRules()
+	if err != nil {
+		log.Println("Could not reload route rules:", err)
+		return err
+	}
+	_SetRouteRules(rules)
+	log.Println("Reloaded route rules")
+	return nil
+}
+
+// LoadRouteRules reads PROXY_WHITELIST and PROXY_BLACKLIST, or PROXY_RULES_FILE if set, and PROXY_AUTHZ_RULES_FILE
+func (c *Config) LoadRouteRules() (*RouteRules, error) {
+	rules := &RouteRules{
+		PublicRoutes: []string{
+			c.PublicAPIPath + "login",
+			c.PublicAPIPath + "signup",
+			c.PublicAPIPath + "confirm",
+			c.PublicAPIPath + "initpwreset",
+			c.PublicAPIPath + "device/code",
+			c.PublicAPIPath + "device/token",
+			c.PublicAPIPath + "oidc",
+			c.PublicAPIPath + "certlogin",
+			c.PublicAPIPath + "guest",
+		},
+		AuthzRules: make([]*AuthzRule, 0),
+	}
+	var err error
+	if c.ProxyRulesFile != "" {
+		if rules.Whitelist, rules.Blacklist, err = _LoadRouteRulesFile(c.ProxyRulesFile); err != nil {
+			return nil, err
+		}
+	} else {
+		if rules.Whitelist, err = ParseProxyRules(c._GetEnv("PROXY_WHITELIST", "")); err != nil {
+			return nil, err
+		}
+		if rules.Blacklist, err = ParseProxyRules(c._GetEnv("PROXY_BLACKLIST", "")); err != nil {
+			return nil, err
+		}
+	}
+	if len(rules.Whitelist) > 0 && len(rules.Blacklist) > 0 {
+		return nil, errors.New("Can't set both PROXY_WHITELIST and PROXY_BLACKLIST")
+	}
+	if c.ProxyAuthzRulesFile != "" {
+		if rules.AuthzRules, err = LoadAuthzRules(c.ProxyAuthzRulesFile); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+func _LoadRouteRulesFile(file string) ([]*ProxyRule, []*ProxyRule, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var data RouteRulesFile
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, nil, errors.New("Invalid route rules file: " + err.Error())
+	}
+	var lists [2][]*ProxyRule
+	for i, entries := range [][]string{data.Whitelist, data.Blacklist} {
+		lists[i] = make([]*ProxyRule, 0)
+		for _, entry := range entries {
+			rule, err := ParseProxyRule(entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			lists[i] = append(lists[i], rule)
+		}
+	}
+	return lists[0], lists[1], nil
+}
+
+type RouteRulesRouter struct {
+}
+
+func (router *RouteRulesRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/reload", router.reload).Methods("POST")
+}
+
+func (router *RouteRulesRouter) reload(w http.ResponseWriter, r *http.Request) {
+	if err := ReloadRouteRules(); err != nil {
+		SendJSONWithStatus(w, http.StatusBadRequest, &ErrorResponse{Error: ErrorCodeInvalidRouteRules, Message: err.Error()})
+		return
+	}
+	SendUpdated(w)
+}
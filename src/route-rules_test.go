@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadRouteRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.json")
+	os.WriteFile(file, []byte(`{"blacklist": ["/private"]}`), 0600)
+	os.Setenv("PROXY_RULES_FILE", file)
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_RULES_FILE")
+		GetConfig().ReadConfig()
+	}()
+
+	res := executePublicTestRequest(newHTTPRequest("GET", "/private/test", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", "/other", "", nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+
+	os.WriteFile(file, []byte(`{"blacklist": ["/other"]}`), 0600)
+	res = executeBackendTestRequest(newHTTPRequest("POST", "/rules/reload", "", nil))
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", "/private/test", "", nil))
+	checkTestResponseCode(t, http.StatusBadGateway, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", "/other", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	os.WriteFile(file, []byte(`{"whitelist": ["/public"], "blacklist": ["/other"]}`), 0600)
+	res = executeBackendTestRequest(newHTTPRequest("POST", "/rules/reload", "", nil))
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", "/other", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
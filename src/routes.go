@@ -6,14 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/li6in9muyou/jwt-auth-proxy/pkg/jwtauthproxy"
 )
 
 type Route interface {
@@ -29,6 +32,18 @@ func (c contextKey) String() string {
 var (
 	contextKeyUserID     = contextKey("UserID")
 	contextKeyAuthHeader = contextKey("AuthHeader")
+	contextKeyScopes     = contextKey("Scopes")
+	contextKeyGuestID    = contextKey("GuestID")
+	contextKeyGuest      = contextKey("Guest")
+	contextKeyOrg        = contextKey("Organization")
+	contextKeyOrgRole    = contextKey("OrganizationRole")
+	contextKeyPhone      = contextKey("Phone")
+	contextKeyExpiry     = contextKey("Expiry")
+	contextKeyClaims     = contextKey("Claims")
+	contextKeyUpstream   = contextKey("Upstream")
+	contextKeyProxyPath  = contextKey("ProxyPath")
+	contextKeyCountry    = contextKey("Country")
+	contextKeyAccessLog  = contextKey("AccessLog")
 )
 
 func SendNotFound(w http.ResponseWriter) {
@@ -43,12 +58,16 @@ func SendUnauthorized(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusUnauthorized)
 }
 
+func SendForbidden(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+}
+
 func SendAleadyExists(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusConflict)
 }
 
-func SendCreated(w http.ResponseWriter, id primitive.ObjectID) {
-	w.Header().Set("X-Object-ID", id.Hex())
+func SendCreated(w http.ResponseWriter, id string) {
+	w.Header().Set("X-Object-ID", id)
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -61,6 +80,10 @@ func SendInternalServerError(w http.ResponseWriter) {
 }
 
 func SendJSON(w http.ResponseWriter, v interface{}) {
+	SendJSONWithStatus(w, http.StatusOK, v)
+}
+
+func SendJSONWithStatus(w http.ResponseWriter, status int, v interface{}) {
 	json, err := json.Marshal(v)
 	if err != nil {
 		log.Println(err)
@@ -68,9 +91,15 @@ func SendJSON(w http.ResponseWriter, v interface{}) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(json)
 }
 
+// SendError sends a structured error response clients can act on
+func SendError(w http.ResponseWriter, status int, code string) {
+	SendJSONWithStatus(w, status, &ErrorResponse{Error: code})
+}
+
 func UnmarshalBody(r *http.Request, o interface{}) error {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -82,11 +111,38 @@ func UnmarshalBody(r *http.Request, o interface{}) error {
 	return nil
 }
 
+// UnmarshalMetadataBody reads a metadata JSON object not exceeding METADATA_MAX_SIZE bytes
+func UnmarshalMetadataBody(r *http.Request) (map[string]interface{}, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(GetConfig().MetadataMaxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > GetConfig().MetadataMaxSize {
+		return nil, errors.New("Metadata exceeds maximum size")
+	}
+	var metadata map[string]interface{}
+	if err = json.Unmarshal(body, &metadata); err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		return nil, errors.New("Metadata must be a JSON object")
+	}
+	return metadata, nil
+}
+
+// RequestNormalizer is implemented by payloads whose fields need to be brought into canonical form before validation
+type RequestNormalizer interface {
+	Normalize()
+}
+
 func UnmarshalValidateBody(r *http.Request, o interface{}) error {
 	err := UnmarshalBody(r, &o)
 	if err != nil {
 		return err
 	}
+	if n, ok := o.(RequestNormalizer); ok {
+		n.Normalize()
+	}
 	v := validator.New()
 	err = v.Struct(o)
 	if err != nil {
@@ -111,6 +167,98 @@ func GetAuthHeaderFromContext(r *http.Request) string {
 	return authHeader.(string)
 }
 
+func GetScopesFromContext(r *http.Request) []string {
+	scopes := r.Context().Value(contextKeyScopes)
+	if scopes == nil {
+		return nil
+	}
+	return scopes.([]string)
+}
+
+func GetGuestIDFromContext(r *http.Request) string {
+	guestID := r.Context().Value(contextKeyGuestID)
+	if guestID == nil {
+		return ""
+	}
+	return guestID.(string)
+}
+
+func IsGuestFromContext(r *http.Request) bool {
+	guest := r.Context().Value(contextKeyGuest)
+	if guest == nil {
+		return false
+	}
+	return guest.(bool)
+}
+
+func GetOrganizationFromContext(r *http.Request) (string, string) {
+	organization := r.Context().Value(contextKeyOrg)
+	role := r.Context().Value(contextKeyOrgRole)
+	if organization == nil || role == nil {
+		return "", ""
+	}
+	return organization.(string), role.(string)
+}
+
+func GetPhoneFromContext(r *http.Request) string {
+	phone := r.Context().Value(contextKeyPhone)
+	if phone == nil {
+		return ""
+	}
+	return phone.(string)
+}
+
+// GetClaimsFromContext returns the verified claims of an authenticated request, or nil
+func GetClaimsFromContext(r *http.Request) *Claims {
+	claims, _ := r.Context().Value(contextKeyClaims).(*Claims)
+	return claims
+}
+
+// GetUpstreamFromContext returns the upstream a proxied request has been assigned to
+func GetUpstreamFromContext(r *http.Request) *Upstream {
+	upstream, _ := r.Context().Value(contextKeyUpstream).(*Upstream)
+	return upstream
+}
+
+// GetTokenExpiryFromContext returns the expiry date of the access token, zero if the credentials don't expire
+func GetTokenExpiryFromContext(r *http.Request) time.Time {
+	expiry, _ := r.Context().Value(contextKeyExpiry).(int64)
+	if expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(expiry, 0)
+}
+
+// GetClientIP returns the IP address of the client that sent the request.
+// Requests received from trusted proxies are attributed to the rightmost address in X-Forwarded-For not belonging to a trusted proxy.
+func GetClientIP(r *http.Request) string {
+	ip := GetPeerIP(r)
+	if !IPRangesContain(GetConfig().ProxyTrustedProxies, ip) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := ParseForwardedIP(hops[i])
+		if hop == "" {
+			break
+		}
+		ip = hop
+		if !IPRangesContain(GetConfig().ProxyTrustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// GetPeerIP returns the IP address of the immediate peer of the connection, which may be a proxy in front of us
+func GetPeerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
 func SetCorsHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", GetConfig().CorsOrigin)
 	w.Header().Set("Access-Control-Allow-Headers", GetConfig().CorsHeaders)
@@ -123,32 +271,175 @@ func CorsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// JwtKeyFunc verifies the signing method of a JWT and returns the signing key
+func JwtKeyFunc(token *jwt.Token) (interface{}, error) {
+	return jwtauthproxy.KeyFunc([]byte(GetConfig().JwtSigningKey))(token)
+}
+
+// NewUserClaims builds the claims identifying a user in issued access tokens
+func NewUserClaims(user *User) *Claims {
+	phone := ""
+	if user.PhoneVerified {
+		phone = user.Phone
+	}
+	return &Claims{
+		Email:                  user.Email,
+		UserID:                 user.ID.String(),
+		GuestID:                user.GuestID,
+		Organization:           user.Organization,
+		OrganizationRole:       user.OrganizationRole,
+		Phone:                  phone,
+		Confirmed:              user.Confirmed,
+		Roles:                  user.Roles,
+		PasswordChangeRequired: user.PasswordChangeRequired,
+		Metadata:               SelectMetadataFields(user.Metadata, GetConfig().TokenMetadataFields),
+		AppMetadata:            SelectMetadataFields(user.AppMetadata, GetConfig().TokenAppMetadataFields),
+	}
+}
+
+// SelectMetadataFields returns the given top-level fields of a metadata document, or nil if none are present
+func SelectMetadataFields(metadata map[string]interface{}, fields []string) map[string]interface{} {
+	var res map[string]interface{}
+	for _, field := range fields {
+		if value, ok := metadata[field]; ok {
+			if res == nil {
+				res = make(map[string]interface{})
+			}
+			res[field] = value
+		}
+	}
+	return res
+}
+
+// SignAccessToken sets the expiry date of the claims unless already set and signs them
+func SignAccessToken(claims *Claims) string {
+	jwtString, err := jwtauthproxy.SignToken(claims, []byte(GetConfig().JwtSigningKey), GetConfig().AccessTokenLifetime*time.Minute)
+	if err != nil {
+		return ""
+	}
+	return jwtString
+}
+
 func ExtractClaimsFromRequest(r *http.Request) (*Claims, string, error) {
 	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" && IsWebSocketUpgrade(r) {
+		if token := GetWebSocketToken(r); token != "" {
+			authHeader = "Bearer " + token
+		}
+	}
+	if authHeader == "" && IsAlternateTokenRoute(r) {
+		if token := GetAlternateToken(r); token != "" {
+			authHeader = "Bearer " + token
+		}
+	}
+	if authHeader == "" && IsForwardAuthRequest(r) {
+		if token := GetForwardAuthToken(r); token != "" {
+			authHeader = "Bearer " + token
+		}
+	}
+	if authHeader == "" && IsSignedURL(r) {
+		claims, err := ExtractClaimsFromSignedURL(r)
+		return claims, "", err
+	}
+	if authHeader == "" && r.Header.Get("X-Api-Key") != "" {
+		claims, err := ExtractClaimsFromAPIKey(r)
+		return claims, "", err
+	}
 	if authHeader == "" {
-		return nil, "", errors.New("JWT header verification failed: missing auth header")
+		return nil, "", ErrMissingCredentials
+	}
+	if strings.HasPrefix(authHeader, "Basic ") {
+		return ExtractClaimsFromBasicAuth(r)
 	}
 	if !strings.HasPrefix(authHeader, "Bearer ") {
 		return nil, "", errors.New("JWT header verification failed: invalid auth header")
 	}
 	authHeader = strings.TrimPrefix(authHeader, "Bearer ")
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(authHeader, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(GetConfig().JwtSigningKey), nil
-	})
+	claims, err := jwtauthproxy.ParseToken(authHeader, []byte(GetConfig().JwtSigningKey))
 	if err != nil {
-		return nil, "", errors.New("JWT header verification failed: parsing JWT failed with: " + err.Error())
-	}
-	if !token.Valid {
-		return nil, "", errors.New("JWT header verification failed: invalid JWT")
+		return nil, "", fmt.Errorf("JWT header verification failed: parsing JWT failed with: %w", err)
 	}
 	log.Println("Successfully verified JWT header for UserID", claims.UserID)
 	return claims, authHeader, nil
 }
 
+// ExtractClaimsFromAPIKey authenticates a request by its X-Api-Key header.
+// API keys are accepted for proxied requests only, not for the public API.
+func ExtractClaimsFromAPIKey(r *http.Request) (*Claims, error) {
+	if !GetConfig().EnableAPIKeys {
+		return nil, errors.New("API key verification failed: API keys disabled")
+	}
+	if strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+		return nil, errors.New("API key verification failed: API keys not accepted for public API")
+	}
+	apiKey := GetAPIKeyRepository().GetByKey(r.Header.Get("X-Api-Key"))
+	if apiKey == nil {
+		return nil, errors.New("API key verification failed: invalid API key")
+	}
+	user := GetUserRepository().GetOne(apiKey.UserID.String())
+	if user == nil || !user.Enabled || !user.Confirmed || user.Deleted {
+		return nil, errors.New("API key verification failed: invalid user")
+	}
+	GetAPIKeyRepository().UpdateLastUseDate(apiKey)
+	log.Println("Successfully verified API key", apiKey.ID.Hex(), "for UserID", user.ID.String())
+	claims := NewUserClaims(user)
+	claims.Scopes = apiKey.Scopes
+	return claims, nil
+}
+
+// ErrBasicAuthInvalidCredentials is returned by ExtractClaimsFromBasicAuth for a wrong password as well as for accounts requiring TOTP
+var ErrBasicAuthInvalidCredentials = errors.New("Basic auth verification failed: invalid credentials")
+
+// ExtractClaimsFromBasicAuth authenticates a request by HTTP Basic credentials.
+// Basic auth is accepted for proxied requests only, the upstream receives a freshly issued JWT.
+func ExtractClaimsFromBasicAuth(r *http.Request) (*Claims, string, error) {
+	if !GetConfig().EnableBasicAuth {
+		return nil, "", errors.New("Basic auth verification failed: basic auth disabled")
+	}
+	if strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+		return nil, "", errors.New("Basic auth verification failed: basic auth not accepted for public API")
+	}
+	email, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, "", errors.New("Basic auth verification failed: invalid auth header")
+	}
+	user := GetUserRepository().GetByEmail(email)
+	if user == nil || !user.Enabled || !user.Confirmed || user.Deleted {
+		return nil, "", errors.New("Basic auth verification failed: invalid user")
+	}
+	// Basic auth clients can neither solve a CAPTCHA nor provide a second factor
+	if IsCaptchaEnabled() && GetConfig().CaptchaLoginFailures > 0 && user.FailedLogins >= GetConfig().CaptchaLoginFailures {
+		return nil, "", errors.New("Basic auth verification failed: too many failed logins for UserID " + user.ID.String())
+	}
+	// The password is checked first and both failures look the same, so callers can't probe for enrolled TOTP
+	if !GetUserRepository().CheckPassword(user.HashedPassword, password) {
+		user.FailedLogins++
+		GetUserRepository().Update(user)
+		log.Println("Basic auth verification failed: invalid password for UserID", user.ID.String())
+		return nil, "", ErrBasicAuthInvalidCredentials
+	}
+	if (user.OTPEnabled && GetConfig().EnableTOTP) || GetConfig().EnforceTOTP {
+		log.Println("Basic auth verification failed: TOTP required for UserID", user.ID.String())
+		return nil, "", ErrBasicAuthInvalidCredentials
+	}
+	if !user.PasswordChangeRequired && GetUserRepository().IsPasswordExpired(user) {
+		user.PasswordChangeRequired = true
+		GetUserRepository().Update(user)
+	}
+	if user.FailedLogins != 0 {
+		user.FailedLogins = 0
+		GetUserRepository().Update(user)
+	}
+	GetUserRepository().UpgradePasswordHash(user, password)
+	claims := NewUserClaims(user)
+	accessToken := SignAccessToken(claims)
+	if accessToken == "" {
+		return nil, "", errors.New("Basic auth verification failed: could not sign JWT")
+	}
+	log.Println("Successfully verified basic auth for UserID", user.ID.String())
+	return claims, accessToken, nil
+}
+
 func VerifyJwtMiddleware(next http.Handler) http.Handler {
 	var isWhitelistMatch = func(url string, whitelistedURL string) bool {
 		whitelistedURL = strings.TrimSpace(whitelistedURL)
@@ -163,8 +454,9 @@ func VerifyJwtMiddleware(next http.Handler) http.Handler {
 
 	var IsWhitelisted = func(r *http.Request) bool {
 		url := r.URL.EscapedPath()
+		rules := GetRouteRules()
 		// Check for whitelisted public API paths
-		for _, whitelistedURL := range unauthorizedRoutes {
+		for _, whitelistedURL := range rules.PublicRoutes {
 			if isWhitelistMatch(url, whitelistedURL) {
 				return true
 			}
@@ -173,18 +465,22 @@ func VerifyJwtMiddleware(next http.Handler) http.Handler {
 		if strings.HasPrefix(url, GetConfig().PublicAPIPath) {
 			return false
 		}
+		// Static files of the frontend are public
+		if IsStaticRequest(r) {
+			return true
+		}
 		// Whitelist Mode: Check is URL is whitelisted, else assume auth token is required
-		if len(GetConfig().ProxyWhitelist) > 0 {
-			for _, whitelistedURL := range GetConfig().ProxyWhitelist {
-				if isWhitelistMatch(url, whitelistedURL) {
+		if len(rules.Whitelist) > 0 {
+			for _, rule := range rules.Whitelist {
+				if rule.Matches(r.Method, url) {
 					return true
 				}
 			}
 			return false
 		}
 		// Blacklist Mode: Check is URL is blacklisted, else assume auth token is NOT required
-		for _, blacklistedURL := range GetConfig().ProxyBlacklist {
-			if isWhitelistMatch(url, blacklistedURL) {
+		for _, rule := range rules.Blacklist {
+			if rule.Matches(r.Method, url) {
 				return false
 			}
 		}
@@ -193,25 +489,52 @@ func VerifyJwtMiddleware(next http.Handler) http.Handler {
 
 	var HandleWhitelistReq = func(w http.ResponseWriter, r *http.Request) {
 		claims, authHeader, err := ExtractClaimsFromRequest(r)
-		if err != nil {
+		if err != nil || claims.OTPEnrollment || claims.PasswordChangeRequired || (claims.Guest && !GetConfig().EnableGuest) {
 			next.ServeHTTP(w, r)
 			return
 		}
-		ctx := context.WithValue(r.Context(), contextKeyUserID, claims.UserID)
-		ctx = context.WithValue(ctx, contextKeyAuthHeader, authHeader)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims, authHeader)))
 	}
 
 	var HandleNonWhitelistReq = func(w http.ResponseWriter, r *http.Request) {
 		claims, authHeader, err := ExtractClaimsFromRequest(r)
+		if err != nil && IsRulesDryRun(r) {
+			LogDryRunRejection(r, http.StatusUnauthorized, err.Error())
+			HandleWhitelistReq(w, r)
+			return
+		}
 		if err != nil {
 			log.Println(err)
+			if IsLoginRedirectRequest(r) {
+				RedirectToLogin(w, r)
+				return
+			}
+			if GetConfig().EnableBasicAuth && !strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\""+GetConfig().BasicAuthRealm+"\", charset=\"UTF-8\"")
+			}
+			AddBearerChallengeForError(w, err)
 			SendUnauthorized(w)
 			return
 		}
-		ctx := context.WithValue(r.Context(), contextKeyUserID, claims.UserID)
-		ctx = context.WithValue(ctx, contextKeyAuthHeader, authHeader)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if claims.Guest && (!GetConfig().EnableGuest || !IsGuestRoute(r)) {
+			log.Println("Rejecting guest token for GuestID", claims.GuestID)
+			AddBearerChallenge(w, BearerErrorInvalidToken, "Guest tokens are not accepted")
+			SendUnauthorized(w)
+			return
+		}
+		if claims.OTPEnrollment && !IsOTPEnrollmentRoute(r) {
+			log.Println("Rejecting OTP enrollment token for non-enrollment route for UserID", claims.UserID)
+			AddBearerChallenge(w, BearerErrorInsufficientScope, "MFA enrollment required")
+			SendError(w, http.StatusForbidden, ErrorCodeMFAEnrollmentRequired)
+			return
+		}
+		if claims.PasswordChangeRequired && !strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+			log.Println("Rejecting proxied request until password is changed for UserID", claims.UserID)
+			AddBearerChallenge(w, BearerErrorInsufficientScope, "Password change required")
+			SendError(w, http.StatusForbidden, ErrorCodePasswordChangeRequired)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ContextWithClaims(r.Context(), claims, authHeader)))
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -225,6 +548,45 @@ func VerifyJwtMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// ContextWithClaims stores the verified identity in the request context
+func ContextWithClaims(ctx context.Context, claims *Claims, authHeader string) context.Context {
+	ctx = context.WithValue(ctx, contextKeyUserID, claims.UserID)
+	ctx = context.WithValue(ctx, contextKeyAuthHeader, authHeader)
+	ctx = context.WithValue(ctx, contextKeyScopes, claims.Scopes)
+	ctx = context.WithValue(ctx, contextKeyGuestID, claims.GuestID)
+	ctx = context.WithValue(ctx, contextKeyGuest, claims.Guest)
+	ctx = context.WithValue(ctx, contextKeyOrg, claims.Organization)
+	ctx = context.WithValue(ctx, contextKeyOrgRole, claims.OrganizationRole)
+	ctx = context.WithValue(ctx, contextKeyPhone, claims.Phone)
+	ctx = context.WithValue(ctx, contextKeyExpiry, claims.ExpiresAt)
+	ctx = context.WithValue(ctx, contextKeyClaims, claims)
+	if entry, ok := ctx.Value(contextKeyAccessLog).(*AccessLogEntry); ok {
+		entry.UserID = claims.UserID
+	}
+	return ctx
+}
+
+// IsOTPEnrollmentRoute checks if a request may be performed with an OTP enrollment token
+func IsOTPEnrollmentRoute(r *http.Request) bool {
+	url := r.URL.EscapedPath()
+	return url == GetConfig().PublicAPIPath+"otp/init" || url == GetConfig().PublicAPIPath+"otp/confirm"
+}
+
+// IsGuestRoute checks if guest tokens are accepted for a proxied request, i.e. if it matches GUEST_ROUTES
+func IsGuestRoute(r *http.Request) bool {
+	path := r.URL.Path
+	if strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+		return false
+	}
+	for _, route := range GetConfig().GuestRoutes {
+		route = strings.TrimSuffix(route, "/")
+		if route == "" || path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 func CorsHandler(w http.ResponseWriter, r *http.Request) {
 	SetCorsHeaders(w)
 	w.WriteHeader(http.StatusNoContent)
@@ -238,31 +600,120 @@ func ProxyHandler(w http.ResponseWriter, r *http.Request) {
 		return r.URL.Scheme
 	}
 
-	url := r.URL.RequestURI()
-	log.Println("Proxying request for", url)
+	// Proxied requests are logged by the access log if enabled, which is cheaper at high request rates
+	if GetConfig().AccessLog == "" {
+		log.Println("Proxying request for", r.URL.RequestURI())
+	}
 
-	r.Header.Set("X-Forwarded-For", r.RemoteAddr)
-	r.Header.Set("X-Forwarded-Host", r.Host)
-	r.Header.Set("X-Forwarded-Proto", getScheme(r.URL.Scheme))
-	r.Header.Set("Forwarded", fmt.Sprintf("for=%s;host=%s;proto=%s", r.RemoteAddr, r.Host, getScheme(r.URL.Scheme)))
-	r.Header.Set("X-Auth-UserID", GetUserIDFromContext(r))
+	// Headers of trusted proxies in front of us are retained, only appending our own entries
+	StripClientIdentityHeaders(r)
+	AppendForwardedHeaders(r, getScheme(r.URL.Scheme))
+	SetIdentityHeaders(r.Header, r)
+	r.Header.Del("X-Api-Key")
 	r.Header.Del("Authorization")
+	if IsWebSocketUpgrade(r) {
+		RemoveWebSocketToken(r)
+		w = &webSocketResponseWriter{w}
+	} else if IsAlternateTokenRoute(r) {
+		RemoveAlternateToken(r)
+	}
+	if IsSignedURL(r) {
+		RemoveSignedURLParams(r)
+	}
 	authHeader := GetAuthHeaderFromContext(r)
 	if authHeader != "" {
 		r.Header.Set("Authorization", "Bearer "+authHeader)
 	}
+	SetUpstreamCredentials(r.Header)
+
+	ApplyHeaderRules(HeaderRuleRequest, r.URL.Path, r.Header)
+	r.Header.Del("X-Auth-Timestamp")
+	r.Header.Del("X-Auth-Signature")
+	if GetConfig().IdentityHeaderSigningKey != "" {
+		SignIdentityHeaders(r.Header, GetConfig().IdentityHeaderSigningKey, time.Now())
+	}
 
-	target := GetConfig().ProxyTarget
+	if IsCacheableRequest(r) {
+		if ServeCachedResponse(w, r) {
+			return
+		}
+		cw := NewCacheResponseWriter(w, r)
+		defer cw.Store()
+		w = cw
+	}
+
+	// WebSocket connections are long-lived and not counted towards the concurrency limits
+	if limiter := GetProxyConcurrencyLimiter(); limiter != nil && !IsWebSocketUpgrade(r) {
+		if !limiter.Acquire(r.Context()) {
+			log.Println("Shedding request", r.Method, r.URL.Path, "as too many are in flight")
+			w.Header().Set("Retry-After", "1")
+			SendErrorPage(w, r, http.StatusServiceUnavailable, ErrorCodeOverloaded, "")
+			return
+		}
+		defer limiter.Release()
+	}
+
+	if IsMirroredRequest(r) {
+		MirrorRequest(r)
+	}
+
+	stickyKey := GetStickySessionKey(w, r)
+	var upstream *Upstream
+	if IsCanaryRequest(r) {
+		upstream = GetApp().CanaryUpstreams.NextFor(stickyKey)
+	}
+	if upstream == nil {
+		upstream = GetApp().Upstreams.NextFor(stickyKey)
+	}
+	if upstream == nil {
+		SendErrorPage(w, r, GetConfig().ProxyNoUpstreamStatus, ErrorCodeUpstreamUnavailable, "")
+		return
+	}
+	if upstream.Limiter != nil && !IsWebSocketUpgrade(r) {
+		if !upstream.Limiter.Acquire(r.Context()) {
+			log.Println("Shedding request", r.Method, r.URL.Path, "as too many are in flight to upstream", upstream.URL.Host)
+			w.Header().Set("Retry-After", "1")
+			SendErrorPage(w, r, http.StatusServiceUnavailable, ErrorCodeOverloaded, "")
+			return
+		}
+		defer upstream.Limiter.Release()
+	}
+	upstream.Acquire()
+	defer upstream.Release()
+	if entry := GetAccessLogEntryFromContext(r); entry != nil {
+		entry.Upstream = upstream.URL.Host
+	}
+	target := upstream.RequestURL()
 	r.URL.Host = target.Host
 	r.URL.Scheme = target.Scheme
-	r.Host = target.Host
+	r.Host = upstream.HostHeader()
 
-	GetApp().Proxy.ServeHTTP(w, r)
+	ctx := context.WithValue(r.Context(), contextKeyUpstream, upstream)
+	if GetConfig().ProxyRequestTimeout > 0 && !IsWebSocketUpgrade(r) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Second*GetConfig().ProxyRequestTimeout)
+		defer cancel()
+	}
+	ctx = context.WithValue(ctx, contextKeyProxyPath, r.URL.Path)
+	GetApp().Proxy.ServeHTTP(w, r.WithContext(ctx))
 }
 
-var unauthorizedRoutes = [...]string{
-	GetConfig().PublicAPIPath + "login",
-	GetConfig().PublicAPIPath + "signup",
-	GetConfig().PublicAPIPath + "confirm",
-	GetConfig().PublicAPIPath + "initpwreset",
+const ErrorCodeMFAEnrollmentRequired = "mfa_enrollment_required"
+const ErrorCodeInvitationRequired = "invitation_required"
+const ErrorCodeInvitationInvalid = "invitation_invalid"
+const ErrorCodeCaptchaRequired = "captcha_required"
+const ErrorCodeAccountExists = "account_exists"
+const ErrorCodeIdentityAlreadyLinked = "identity_already_linked"
+const ErrorCodePasswordChangeRequired = "password_change_required"
+const ErrorCodeTooManyEmails = "too_many_emails"
+const ErrorCodeEmailDomainNotAllowed = "email_domain_not_allowed"
+const ErrorCodeOTPRequired = "otp_required"
+const ErrorCodeUpstreamUnavailable = "upstream_unavailable"
+const ErrorCodeUpstreamTimeout = "upstream_timeout"
+const ErrorCodeEmailDeliveryFailed = "email_delivery_failed"
+
+// ErrorResponse holds the payload of structured error responses
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
 }
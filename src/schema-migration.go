@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// schemaMigrationLockTimeout is the time after which a lock held by a crashed instance is considered stale
+const schemaMigrationLockTimeout = 10 * time.Minute
+
+// SchemaMigration changes the stored documents from schema version Version-1 to Version.
+// Migrations must be idempotent, as a crash may leave them partly applied.
+type SchemaMigration struct {
+	Version     int
+	Description string
+	Migrate     func(ctx context.Context, db *Database) error
+}
+
+// SchemaVersion records an applied migration in the schema_version collection
+type SchemaVersion struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	ApplyDate   time.Time `bson:"applyDate"`
+}
+
+// schemaMigrations must be ordered by version, starting at 1 without gaps.
+// Append new migrations at the end, never change or remove applied ones.
+var schemaMigrations = []*SchemaMigration{
+	{
+		Version:     1,
+		Description: "Initial schema",
+		Migrate: func(ctx context.Context, db *Database) error {
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "Store refresh tokens hashed",
+		Migrate:     _MigrateHashRefreshTokens,
+	},
+}
+
+// _MigrateHashRefreshTokens replaces the plain text refresh tokens stored before by their hashes,
+// so existing sessions stay valid
+func _MigrateHashRefreshTokens(ctx context.Context, db *Database) error {
+	tokens := db.Collection("refresh_tokens")
+	// The unique index on the plain text tokens would reject more than one document without a token
+	if _, err := tokens.Indexes().DropOne(ctx, "token_1"); err != nil && !_IsMongoNotFoundError(err) {
+		return err
+	}
+	cur, err := tokens.Find(ctx, bson.M{"token": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Token string             `bson:"token"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return err
+		}
+		_, err := tokens.UpdateOne(ctx, bson.M{"_id": doc.ID}, bson.M{
+			"$set":   bson.M{"hashedToken": HashToken(doc.Token)},
+			"$unset": bson.M{"token": ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// _IsMongoNotFoundError checks if a command failed because the collection or index doesn't exist
+func _IsMongoNotFoundError(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Code == 26 || cmdErr.Code == 27)
+}
+
+// GetSchemaVersion returns the version of the last migration applied to the database, 0 if none
+func GetSchemaVersion(ctx context.Context, db *Database) (int, error) {
+	var version SchemaVersion
+	opts := options.FindOne().SetSort(bson.M{"_id": -1})
+	err := db.Collection("schema_version").FindOne(ctx, bson.M{}, opts).Decode(&version)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version.Version, nil
+}
+
+// MigrateSchema applies all pending migrations in order. Only one instance migrates at a time,
+// others wait for it to finish. Migrating fails if the database has been migrated by a newer version.
+func MigrateSchema(ctx context.Context, db *Database, migrations []*SchemaMigration, apply bool) error {
+	if err := _ValidateSchemaMigrations(migrations); err != nil {
+		return err
+	}
+	latest := len(migrations)
+	if apply {
+		if err := _LockSchemaMigration(ctx, db); err != nil {
+			return err
+		}
+		defer _UnlockSchemaMigration(db)
+	}
+	current, err := GetSchemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return errors.New("Database schema version " + strconv.Itoa(current) + " is newer than the supported version " + strconv.Itoa(latest))
+	}
+	if current == latest {
+		return nil
+	}
+	if !apply {
+		return errors.New("Database schema version " + strconv.Itoa(current) + " is outdated, set MONGO_DB_MIGRATE=1 to migrate to version " + strconv.Itoa(latest))
+	}
+	for _, migration := range migrations[current:] {
+		log.Println("Migrating database schema to version", migration.Version, "("+migration.Description+")...")
+		if err := migration.Migrate(ctx, db); err != nil {
+			return errors.New("Migration to schema version " + strconv.Itoa(migration.Version) + " failed: " + err.Error())
+		}
+		_, err := db.Collection("schema_version").InsertOne(ctx, &SchemaVersion{
+			Version:     migration.Version,
+			Description: migration.Description,
+			ApplyDate:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Println("Migrated database schema to version", latest)
+	return nil
+}
+
+func _ValidateSchemaMigrations(migrations []*SchemaMigration) error {
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return errors.New("Schema migrations must be numbered consecutively starting at 1, found version " + strconv.Itoa(migration.Version) + " at position " + strconv.Itoa(i+1))
+		}
+		if migration.Migrate == nil {
+			return errors.New("Schema migration " + strconv.Itoa(migration.Version) + " has no Migrate function")
+		}
+	}
+	return nil
+}
+
+func _LockSchemaMigration(ctx context.Context, db *Database) error {
+	hostname, _ := os.Hostname()
+	locks := db.Collection("schema_version_lock")
+	for {
+		_, err := locks.InsertOne(ctx, bson.M{"_id": "migration", "host": hostname, "lockDate": time.Now()})
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+		res, err := locks.DeleteOne(ctx, bson.M{"_id": "migration", "lockDate": bson.M{"$lt": time.Now().Add(-schemaMigrationLockTimeout)}})
+		if err != nil {
+			return err
+		}
+		if res.DeletedCount > 0 {
+			log.Println("Removed stale schema migration lock")
+			continue
+		}
+		log.Println("Waiting for another instance to finish migrating the database schema...")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func _UnlockSchemaMigration(db *Database) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if _, err := db.Collection("schema_version_lock").DeleteOne(ctx, bson.M{"_id": "migration"}); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidateSchemaMigrations(t *testing.T) {
+	if err := _ValidateSchemaMigrations(schemaMigrations); err != nil {
+		t.Fatal(err)
+	}
+	noop := func(ctx context.Context, db *Database) error {
+		return nil
+	}
+	gap := []*SchemaMigration{{Version: 1, Migrate: noop}, {Version: 3, Migrate: noop}}
+	if _ValidateSchemaMigrations(gap) == nil {
+		t.Error("Expected error for migrations with a gap")
+	}
+	missing := []*SchemaMigration{{Version: 1}}
+	if _ValidateSchemaMigrations(missing) == nil {
+		t.Error("Expected error for migration without Migrate function")
+	}
+}
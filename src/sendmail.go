@@ -2,56 +2,195 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/smtp"
+	"time"
 )
 
+const (
+	SMTPTLSNone     = "none"
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+)
+
+const (
+	SMTPAuthPlain   = "plain"
+	SMTPAuthLogin   = "login"
+	SMTPAuthCRAMMD5 = "cram-md5"
+)
+
+// smtpDialTimeout limits connecting to the SMTP server, so requests sending mails don't hang if it's unreachable
+const smtpDialTimeout = 30 * time.Second
+
 var (
-	smtpClient = func(addr string) (dialer, error) {
-		conn, err := net.Dial("tcp", addr)
-		if err != nil {
+	smtpClient = DialSMTP
+)
+
+// DialSMTP connects to the SMTP server, encrypting the connection and authenticating as configured
+func DialSMTP(addr string) (dialer, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	netDialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	tlsConfig, err := CreateSMTPTLSConfig(host)
+	if err != nil {
+		return nil, err
+	}
+	if GetConfig().SMTPTLS == SMTPTLSImplicit {
+		conn, err = tls.DialWithDialer(netDialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = netDialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if GetConfig().SMTPTLS == SMTPTLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
 			return nil, err
 		}
-		host, _, _ := net.SplitHostPort(addr)
-		c, err := smtp.NewClient(conn, host)
+	}
+	if GetConfig().SMTPUsername != "" {
+		if err := c.Auth(CreateSMTPAuth(host)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// CreateSMTPTLSConfig builds the TLS configuration for connections to the SMTP server
+func CreateSMTPTLSConfig(host string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: GetConfig().SMTPTLSInsecure,
+	}
+	if GetConfig().SMTPTLSCA != "" {
+		caCert, err := ioutil.ReadFile(GetConfig().SMTPTLSCA)
 		if err != nil {
 			return nil, err
 		}
-		return c, nil
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("No certificates found in SMTP_TLS_CA")
+		}
+		tlsConfig.RootCAs = caCertPool
 	}
-)
+	return tlsConfig, nil
+}
 
-func SendMail(recv string, body string) (dialer, error) {
+// CreateSMTPAuth returns the SMTP_AUTH mechanism with SMTP_USERNAME and SMTP_PASSWORD. PLAIN and LOGIN
+// send the password in clear text, so they are refused on unencrypted connections except to localhost.
+func CreateSMTPAuth(host string) smtp.Auth {
+	switch GetConfig().SMTPAuth {
+	case SMTPAuthLogin:
+		return &smtpLoginAuth{username: GetConfig().SMTPUsername, password: GetConfig().SMTPPassword, host: host}
+	case SMTPAuthCRAMMD5:
+		return smtp.CRAMMD5Auth(GetConfig().SMTPUsername, GetConfig().SMTPPassword)
+	default:
+		return smtp.PlainAuth("", GetConfig().SMTPUsername, GetConfig().SMTPPassword, host)
+	}
+}
+
+// smtpLoginAuth implements the LOGIN mechanism, which isn't included in net/smtp but required by some servers
+type smtpLoginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *smtpLoginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !_IsLocalSMTPHost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *smtpLoginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(bytes.ToLower(bytes.TrimSuffix(fromServer, []byte(":")))) {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	default:
+		return nil, errors.New("unexpected LOGIN challenge: " + string(fromServer))
+	}
+}
+
+func _IsLocalSMTPHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// MailSender delivers a message, which includes its headers, to a recipient
+type MailSender interface {
+	Send(recv, message string) error
+}
+
+// SMTPMailSender delivers messages via SMTP_SERVER
+type SMTPMailSender struct {
+}
+
+// SendMail sends a message using the configured MAIL_PROVIDER. With MAIL_QUEUE_ENABLE=1, the message is queued
+// and sent in the background instead, an error then means it couldn't be queued.
+func SendMail(recv string, body string) error {
+	if GetConfig().MailQueueEnable {
+		err := EnqueueMail(recv, body)
+		if err != nil {
+			log.Println("Could not queue mail:", err)
+		}
+		return err
+	}
+	err := mailSender().Send(recv, body)
+	if err != nil {
+		log.Println("Could not send mail:", err)
+	}
+	return err
+}
+
+func (s *SMTPMailSender) Send(recv, message string) error {
 	c, err := smtpClient(GetConfig().SMTPServer)
 	if err != nil {
-		log.Println(err)
-		return nil, err
+		return err
 	}
 	defer c.Close()
-	err = c.Mail(GetConfig().SMTPSenderAddr)
-	if err != nil {
-		log.Println(err)
-		return c, err
+	if err := c.Mail(GetConfig().SMTPSenderAddr); err != nil {
+		return err
 	}
-	err = c.Rcpt(recv)
-	if err != nil {
-		log.Println(err)
-		return c, err
+	if err := c.Rcpt(recv); err != nil {
+		return err
 	}
 	wc, err := c.Data()
 	if err != nil {
-		log.Println(err)
-		return c, err
+		return err
 	}
-	defer wc.Close()
-	buf := bytes.NewBufferString(body)
+	buf := bytes.NewBufferString(message)
 	if _, err := buf.WriteTo(wc); err != nil {
-		log.Println(err)
-		return c, err
+		wc.Close()
+		return err
 	}
-	return c, nil
+	// The server accepts the message only once the data is closed
+	return wc.Close()
 }
 
 type dialer interface {
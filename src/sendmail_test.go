@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
 	"io"
+	"net"
+	"net/smtp"
+	"strings"
 	"testing"
 )
 
@@ -58,3 +64,101 @@ func (r *writeCloserMock) Write(buf []byte) (int, error) {
 func (r *writeCloserMock) Close() error {
 	return nil
 }
+
+// runSMTPServerMock accepts a single connection offering the AUTH mechanisms and STARTTLS if enabled,
+// and reports the decoded AUTH exchange
+func runSMTPServerMock(t *testing.T, starttls bool) (string, chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		auth := make([]string, 0)
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				received <- auth
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "EHLO"):
+				if starttls {
+					fmt.Fprint(conn, "250-localhost\r\n250-STARTTLS\r\n250 AUTH PLAIN LOGIN CRAM-MD5\r\n")
+				} else {
+					fmt.Fprint(conn, "250-localhost\r\n250 AUTH PLAIN LOGIN CRAM-MD5\r\n")
+				}
+			case line == "AUTH LOGIN":
+				auth = append(auth, "LOGIN")
+				for _, prompt := range []string{"Username:", "Password:"} {
+					fmt.Fprint(conn, "334 "+base64.StdEncoding.EncodeToString([]byte(prompt))+"\r\n")
+					answer, _ := r.ReadString('\n')
+					decoded, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(answer))
+					auth = append(auth, string(decoded))
+				}
+				fmt.Fprint(conn, "235 Authenticated\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprint(conn, "221 Bye\r\n")
+				received <- auth
+				return
+			default:
+				fmt.Fprint(conn, "502 Not implemented\r\n")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func setTestSMTPConfig(t *testing.T, username, auth, tlsMode string) {
+	prevUsername, prevPassword, prevAuth, prevTLS := GetConfig().SMTPUsername, GetConfig().SMTPPassword, GetConfig().SMTPAuth, GetConfig().SMTPTLS
+	GetConfig().SMTPUsername = username
+	GetConfig().SMTPPassword = "secret"
+	GetConfig().SMTPAuth = auth
+	GetConfig().SMTPTLS = tlsMode
+	t.Cleanup(func() {
+		GetConfig().SMTPUsername, GetConfig().SMTPPassword, GetConfig().SMTPAuth, GetConfig().SMTPTLS = prevUsername, prevPassword, prevAuth, prevTLS
+	})
+}
+
+func TestDialSMTPLoginAuth(t *testing.T) {
+	addr, received := runSMTPServerMock(t, false)
+	setTestSMTPConfig(t, "mailer", SMTPAuthLogin, SMTPTLSNone)
+	c, err := DialSMTP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.(*smtp.Client).Quit()
+	auth := <-received
+	if len(auth) != 3 {
+		t.Fatalf("Expected LOGIN exchange, got %v", auth)
+	}
+	checkTestString(t, "mailer", auth[1])
+	checkTestString(t, "secret", auth[2])
+}
+
+func TestDialSMTPStartTLSRequired(t *testing.T) {
+	addr, _ := runSMTPServerMock(t, false)
+	setTestSMTPConfig(t, "", SMTPAuthPlain, SMTPTLSStartTLS)
+	if _, err := DialSMTP(addr); err == nil {
+		t.Error("Expected error for server without STARTTLS")
+	}
+}
+
+func TestSMTPLoginAuthRefusesUnencrypted(t *testing.T) {
+	auth := &smtpLoginAuth{username: "mailer", password: "secret", host: "mail.example.com"}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "mail.example.com", TLS: false}); err == nil {
+		t.Error("Expected LOGIN to be refused on unencrypted connections")
+	}
+	if mech, _, err := auth.Start(&smtp.ServerInfo{Name: "mail.example.com", TLS: true}); err != nil || mech != "LOGIN" {
+		t.Error("Expected LOGIN on encrypted connections")
+	}
+}
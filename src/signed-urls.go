@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	signedURLExpiresParam   = "auth_expires"
+	signedURLUserParam      = "auth_user"
+	signedURLSignatureParam = "auth_signature"
+)
+
+// SignURL appends the expiry date, the UserID and a signature to a path at the target server,
+// granting the user GET and HEAD access to exactly this path and query until the expiry date
+func SignURL(path string, userID string, expires time.Time) (string, error) {
+	u, err := url.Parse(path)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+		return "", errors.New("Invalid path to sign: " + path)
+	}
+	if strings.HasPrefix(u.EscapedPath(), GetConfig().PublicAPIPath) {
+		return "", errors.New("Can't sign public API path: " + path)
+	}
+	query := u.Query()
+	query.Del(signedURLSignatureParam)
+	query.Set(signedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(signedURLUserParam, userID)
+	query.Set(signedURLSignatureParam, _ComputeURLSignature(u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// IsSignedURL checks if the request carries a URL signature
+func IsSignedURL(r *http.Request) bool {
+	return r.URL.Query().Has(signedURLSignatureParam)
+}
+
+// ExtractClaimsFromSignedURL authenticates a request by its URL signature.
+// Signed URLs are accepted for GET and HEAD requests to proxied paths only.
+func ExtractClaimsFromSignedURL(r *http.Request) (*Claims, error) {
+	if GetConfig().SignedURLKey == "" {
+		return nil, errors.New("Signed URL verification failed: signed URLs disabled")
+	}
+	if (r.Method != "GET" && r.Method != "HEAD") || strings.HasPrefix(r.URL.EscapedPath(), GetConfig().PublicAPIPath) {
+		return nil, errors.New("Signed URL verification failed: not accepted for " + r.Method + " " + r.URL.EscapedPath())
+	}
+	query := r.URL.Query()
+	expected := _ComputeURLSignature(r.URL.EscapedPath(), query)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(signedURLSignatureParam))) {
+		return nil, errors.New("Signed URL verification failed: invalid signature")
+	}
+	expires, err := strconv.ParseInt(query.Get(signedURLExpiresParam), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, errors.New("Signed URL verification failed: expired")
+	}
+	user := GetUserRepository().GetOne(query.Get(signedURLUserParam))
+	if user == nil || !user.Enabled || !user.Confirmed || user.Deleted {
+		return nil, errors.New("Signed URL verification failed: invalid user")
+	}
+	log.Println("Successfully verified signed URL for UserID", user.ID.String())
+	return NewUserClaims(user), nil
+}
+
+// RemoveSignedURLParams strips the signature parameters from the query so they are not forwarded to the upstream
+func RemoveSignedURLParams(r *http.Request) {
+	query := r.URL.Query()
+	query.Del(signedURLExpiresParam)
+	query.Del(signedURLUserParam)
+	query.Del(signedURLSignatureParam)
+	r.URL.RawQuery = query.Encode()
+}
+
+// _ComputeURLSignature signs the escaped path followed by the sorted query without the signature itself
+func _ComputeURLSignature(path string, query url.Values) string {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != signedURLSignatureParam {
+			signed[name] = values
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(GetConfig().SignedURLKey))
+	mac.Write([]byte(path + "?" + signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// _CreateSignedURL validates the request payload, signs the URL for the user and sends it
+func _CreateSignedURL(w http.ResponseWriter, userID string, data *SignedURLRequest) {
+	if data.ExpiresIn > GetConfig().SignedURLMaxTTL {
+		log.Println("Invalid signed URL request: expiry exceeds SIGNED_URL_MAX_TTL")
+		SendBadRequest(w)
+		return
+	}
+	expires := time.Now().Add(time.Second * time.Duration(data.ExpiresIn))
+	signed, err := SignURL(data.Path, userID, expires)
+	if err != nil {
+		log.Println("Invalid signed URL request:", err)
+		SendBadRequest(w)
+		return
+	}
+	SendJSONWithStatus(w, http.StatusCreated, &SignedURLResponse{URL: signed, Expires: expires.Unix()})
+}
+
+type SignedURLRouter struct {
+}
+
+func (router *SignedURLRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/", router.createSignedURL).Methods("POST")
+}
+
+func (router *SignedURLRouter) createSignedURL(w http.ResponseWriter, r *http.Request) {
+	var data SignedURLRequest
+	if UnmarshalValidateBody(r, &data) != nil || data.UserID == "" {
+		SendBadRequest(w)
+		return
+	}
+	if GetUserRepository().GetOne(data.UserID) == nil {
+		SendNotFound(w)
+		return
+	}
+	_CreateSignedURL(w, data.UserID, &data)
+}
+
+// SignedURLRequest holds the payload of signed URL creation requests, the UserID is only accepted by the backend API
+type SignedURLRequest struct {
+	Path      string `json:"path" validate:"required,max=2048"`
+	ExpiresIn int    `json:"expiresIn" validate:"required,min=1"`
+	UserID    string `json:"userID"`
+}
+
+// SignedURLResponse holds the signed path and query to request at the proxy, and the expiry date as Unix timestamp
+type SignedURLResponse struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"`
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSignURL(t *testing.T) {
+	signed, err := SignURL("/files/1?download=1", "user", time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := newHTTPRequest("GET", signed, "", nil)
+	checkTestString(t, "1700000000", req.URL.Query().Get(signedURLExpiresParam))
+	checkTestString(t, "user", req.URL.Query().Get(signedURLUserParam))
+	checkTestString(t, _ComputeURLSignature("/files/1", req.URL.Query()), req.URL.Query().Get(signedURLSignatureParam))
+	RemoveSignedURLParams(req)
+	checkTestString(t, "download=1", req.URL.RawQuery)
+
+	if _, err := SignURL("https://example.com/files/1", "user", time.Now()); err == nil {
+		t.Error("Expected absolute URL to be rejected")
+	}
+	if _, err := SignURL("/auth/me", "user", time.Now()); err == nil {
+		t.Error("Expected public API path to be rejected")
+	}
+}
+
+func TestProxySignedURL(t *testing.T) {
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+	defer proxy.Shutdown(context.TODO())
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	payload := `{"path": "/blacklist/files/1?download=1", "expiresIn": 60}`
+	req := newHTTPRequest("POST", "/auth/signedurl", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var signedURL SignedURLResponse
+	json.Unmarshal(res.Body.Bytes(), &signedURL)
+
+	res = executePublicTestRequest(newHTTPRequest("GET", signedURL.URL, "", nil))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkStringNotEmpty(t, handler.Headers.Get("X-Auth-UserID"))
+
+	res = executePublicTestRequest(newHTTPRequest("POST", signedURL.URL, "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+	res = executePublicTestRequest(newHTTPRequest("GET", signedURL.URL+"&admin=1", "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	expired, _ := SignURL("/blacklist/files/1", handler.Headers.Get("X-Auth-UserID"), time.Now().Add(-time.Minute))
+	res = executePublicTestRequest(newHTTPRequest("GET", expired, "", nil))
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestBackendCreateSignedURL(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	payload := `{"path": "/files/1", "expiresIn": 60, "userID": "` + user.ID.String() + `"}`
+	req := newHTTPRequest("POST", "/signed-urls/", "", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+
+	payload = `{"path": "/files/1", "expiresIn": 999999, "userID": "` + user.ID.String() + `"}`
+	req = newHTTPRequest("POST", "/signed-urls/", "", bytes.NewBufferString(payload))
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
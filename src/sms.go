@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SMSProviderWebhook = "webhook"
+	SMSProviderLog     = "log"
+)
+
+// SMSProvider delivers text messages to phone numbers
+type SMSProvider interface {
+	Send(to, text string) error
+}
+
+// WebhookSMSProvider posts messages as JSON to a gateway URL
+type WebhookSMSProvider struct {
+	URL string
+}
+
+// LogSMSProvider writes messages to the log instead of sending them, for development only
+type LogSMSProvider struct {
+}
+
+var (
+	smsProvider = func() SMSProvider {
+		if GetConfig().SMSProvider == SMSProviderLog {
+			return &LogSMSProvider{}
+		}
+		return &WebhookSMSProvider{
+			URL: GetConfig().SMSWebhookURL,
+		}
+	}
+)
+
+// IsSMSEnabled checks if an SMS provider is configured
+func IsSMSEnabled() bool {
+	return GetConfig().SMSProvider != ""
+}
+
+// SendSMS sends a text message using the configured provider
+func SendSMS(to, text string) error {
+	err := smsProvider().Send(to, text)
+	if err != nil {
+		log.Println("Could not send SMS:", err)
+	}
+	return err
+}
+
+func (p *WebhookSMSProvider) Send(to, text string) error {
+	client := &http.Client{Timeout: time.Second * 10}
+	payload, err := json.Marshal(map[string]string{
+		"to":   to,
+		"text": text,
+	})
+	if err != nil {
+		return err
+	}
+	res, err := client.Post(p.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.New("Unexpected status code " + strconv.Itoa(res.StatusCode))
+	}
+	return nil
+}
+
+func (p *LogSMSProvider) Send(to, text string) error {
+	log.Println("SMS to", to+":", text)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type smsProviderMock struct {
+	To   string
+	Text string
+}
+
+var smsMockContent = &smsProviderMock{}
+
+func (p *smsProviderMock) Send(to, text string) error {
+	p.To = to
+	p.Text = text
+	return nil
+}
+
+func mockSMSProvider() func() {
+	oldProvider := smsProvider
+	smsMockContent = &smsProviderMock{}
+	smsProvider = func() SMSProvider {
+		return smsMockContent
+	}
+	return func() {
+		smsProvider = oldProvider
+	}
+}
+
+func TestWebhookSMSProvider(t *testing.T) {
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	provider := &WebhookSMSProvider{URL: server.URL}
+	if err := provider.Send("+4915112345678", "Hello"); err != nil {
+		t.Fatalf("Expected SMS to be sent, got %s", err)
+	}
+	checkTestString(t, "+4915112345678", payload["to"])
+	checkTestString(t, "Hello", payload["text"])
+}
+
+func TestVerifyPhone(t *testing.T) {
+	defer mockSMSProvider()()
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"phone": "+4915112345678"}`
+	req := newHTTPRequest("PUT", "/auth/phone", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestString(t, "+4915112345678", smsMockContent.To)
+	code := smsMockContent.Text[len(smsMockContent.Text)-6:]
+
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+	checkTestString(t, "", user.Phone)
+
+	payload = `{"code": "` + code + `"}`
+	req = newHTTPRequest("POST", "/auth/phone/verify", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	user = GetUserRepository().GetByEmail("foo@bar.com")
+	checkTestString(t, "+4915112345678", user.Phone)
+	if !user.PhoneVerified {
+		t.Error("Expected phone to be verified")
+	}
+
+	loginResponse = loginUser("foo@bar.com", "12345678")
+	claims := &Claims{}
+	jwt.ParseWithClaims(loginResponse.AccessToken, claims, JwtKeyFunc)
+	checkTestString(t, "+4915112345678", claims.Phone)
+
+	req = newHTTPRequest("DELETE", "/auth/phone", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	user = GetUserRepository().GetByEmail("foo@bar.com")
+	checkTestString(t, "", user.Phone)
+}
+
+func TestVerifyPhoneTooManyAttempts(t *testing.T) {
+	defer mockSMSProvider()()
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"phone": "+4915112345678"}`
+	req := newHTTPRequest("PUT", "/auth/phone", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	code := smsMockContent.Text[len(smsMockContent.Text)-6:]
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+
+	for i := 0; i < phoneVerifyMaxAttempts; i++ {
+		payload = `{"code": "` + wrongCode + `"}`
+		req = newHTTPRequest("POST", "/auth/phone/verify", loginResponse.AccessToken, bytes.NewBufferString(payload))
+		res = executePublicTestRequest(req)
+		checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	}
+
+	payload = `{"code": "` + code + `"}`
+	req = newHTTPRequest("POST", "/auth/phone/verify", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+}
+
+func TestSetPhoneInvalidNumber(t *testing.T) {
+	defer mockSMSProvider()()
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+
+	payload := `{"phone": "0151 12345678"}`
+	req := newHTTPRequest("PUT", "/auth/phone", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+	checkTestString(t, "", smsMockContent.To)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ErrorCodeMethodNotAllowed = "method_not_allowed"
+
+// IsStaticRequest checks if the request is served from STATIC_DIR instead of being proxied,
+// which applies to all paths outside the public API not matching STATIC_PROXY_ROUTES
+func IsStaticRequest(r *http.Request) bool {
+	if GetConfig().StaticDir == "" {
+		return false
+	}
+	url := r.URL.EscapedPath()
+	if strings.HasPrefix(url, GetConfig().PublicAPIPath) {
+		return false
+	}
+	for _, rule := range GetConfig().StaticProxyRoutes {
+		if rule.Matches(r.Method, url) {
+			return false
+		}
+	}
+	return true
+}
+
+// StaticHandler serves files from STATIC_DIR. Paths not matching a file are answered with index.html,
+// so single-page applications can handle their routes client-side. Missing files with an extension are answered with 404.
+func StaticHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		SendError(w, http.StatusMethodNotAllowed, ErrorCodeMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	file := filepath.Join(GetConfig().StaticDir, filepath.FromSlash(name))
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
+		file = filepath.Join(file, "index.html")
+	}
+	if info, err := os.Stat(file); err == nil && !info.IsDir() {
+		if filepath.Base(file) == "index.html" {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		http.ServeFile(w, r, file)
+		return
+	}
+	if path.Ext(name) != "" {
+		SendNotFound(w)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, filepath.Join(GetConfig().StaticDir, "index.html"))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticHandler(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>app</html>"), 0600)
+	os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(1)"), 0600)
+	os.Setenv("STATIC_DIR", dir)
+	os.Setenv("STATIC_PROXY_ROUTES", "/api:/ws")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("STATIC_DIR")
+		os.Unsetenv("STATIC_PROXY_ROUTES")
+		GetConfig().ReadConfig()
+	}()
+	if IsStaticRequest(newHTTPRequest("GET", "/api/users", "", nil)) || IsStaticRequest(newHTTPRequest("GET", "/auth/me", "", nil)) {
+		t.Error("Expected API requests not to be served statically")
+	}
+	if !IsStaticRequest(newHTTPRequest("GET", "/settings/profile", "", nil)) {
+		t.Error("Expected frontend route to be served statically")
+	}
+
+	for _, testCase := range []struct {
+		Method, URL string
+		Status      int
+		Body        string
+	}{
+		{"GET", "/app.js", http.StatusOK, "console.log(1)"},
+		{"GET", "/", http.StatusOK, "<html>app</html>"},
+		{"GET", "/settings/profile", http.StatusOK, "<html>app</html>"},
+		{"GET", "/missing.css", http.StatusNotFound, ""},
+		{"GET", "/../../etc/passwd", http.StatusBadRequest, ""},
+		{"POST", "/settings/profile", http.StatusMethodNotAllowed, ""},
+	} {
+		rr := httptest.NewRecorder()
+		req := newHTTPRequest(testCase.Method, "/", "", nil)
+		req.URL.Path = testCase.URL
+		StaticHandler(rr, req)
+		checkTestResponseCode(t, testCase.Status, rr.Code)
+		if testCase.Body != "" {
+			checkTestString(t, testCase.Body, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const statsDateFormat = "2006-01-02"
+const statsMaxDays = 366
+
+type StatsRouter struct {
+}
+
+func (router *StatsRouter) setupRoutes(s *mux.Router) {
+	s.HandleFunc("/", router.getStats).Methods("GET")
+}
+
+func (router *StatsRouter) getStats(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if s := r.URL.Query().Get("days"); s != "" {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days < 1 || days > statsMaxDays {
+			SendBadRequest(w)
+			return
+		}
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from := today.AddDate(0, 0, -(days - 1))
+	confirmed, unconfirmed := true, false
+	res := &StatsResponse{
+		Users: UserStats{
+			Total:       GetUserRepository().Count(nil),
+			Confirmed:   GetUserRepository().Count(&confirmed),
+			Unconfirmed: GetUserRepository().Count(&unconfirmed),
+		},
+		ActiveSessions: GetRefreshTokenRepository().CountActive(),
+		SignupsPerDay:  make([]*SignupStats, 0, days),
+		LoginsPerDay:   make([]*LoginStats, 0, days),
+	}
+	signups := GetUserRepository().CountSignupsPerDay(from)
+	logins := GetLoginStatsRepository().GetPerDay(from)
+	for day := from; !day.After(today); day = day.AddDate(0, 0, 1) {
+		date := day.Format(statsDateFormat)
+		res.SignupsPerDay = append(res.SignupsPerDay, &SignupStats{Date: date, Count: signups[date]})
+		if stats, ok := logins[date]; ok {
+			res.LoginsPerDay = append(res.LoginsPerDay, stats)
+		} else {
+			res.LoginsPerDay = append(res.LoginsPerDay, &LoginStats{Date: date})
+		}
+	}
+	SendJSON(w, res)
+}
+
+// StatsResponse holds the statistics returned by GET /stats/
+type StatsResponse struct {
+	Users          UserStats      `json:"users"`
+	ActiveSessions int64          `json:"activeSessions"`
+	SignupsPerDay  []*SignupStats `json:"signupsPerDay"`
+	LoginsPerDay   []*LoginStats  `json:"loginsPerDay"`
+}
+
+type UserStats struct {
+	Total       int64 `json:"total"`
+	Confirmed   int64 `json:"confirmed"`
+	Unconfirmed int64 `json:"unconfirmed"`
+}
+
+type SignupStats struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
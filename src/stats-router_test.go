@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	clearTestDB()
+	createTestUser(true)
+	GetUserRepository().Create(&User{
+		Email:      "foo2@bar.com",
+		CreateDate: time.Now(),
+		Enabled:    true,
+	})
+	loginUser("foo@bar.com", "12345678")
+	loginUser("foo@bar.com", "87654321")
+	loginUser("foo@bar.com", "12345678")
+
+	req, _ := http.NewRequest("GET", "/stats/?days=7", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var stats StatsResponse
+	json.Unmarshal(res.Body.Bytes(), &stats)
+	if stats.Users.Total != 2 || stats.Users.Confirmed != 1 || stats.Users.Unconfirmed != 1 {
+		t.Errorf("Unexpected user stats %+v", stats.Users)
+	}
+	if stats.ActiveSessions != 2 {
+		t.Errorf("Expected 2 active sessions, got %d", stats.ActiveSessions)
+	}
+	if len(stats.SignupsPerDay) != 7 || len(stats.LoginsPerDay) != 7 {
+		t.Fatalf("Expected 7 days, got %d and %d", len(stats.SignupsPerDay), len(stats.LoginsPerDay))
+	}
+	today := stats.LoginsPerDay[6]
+	checkTestString(t, time.Now().UTC().Format(statsDateFormat), today.Date)
+	if stats.SignupsPerDay[6].Count != 2 || today.Successful != 2 || today.Failed != 1 {
+		t.Errorf("Unexpected daily stats %+v %+v", stats.SignupsPerDay[6], today)
+	}
+	if stats.SignupsPerDay[0].Count != 0 || stats.LoginsPerDay[0].Successful != 0 {
+		t.Error("Expected no signups and logins on the first day")
+	}
+
+	req, _ = http.NewRequest("GET", "/stats/?days=0", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
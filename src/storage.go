@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	StorageMongoDB = "mongodb"
+	StorageMemory  = "memory"
+)
+
+// StorageDriver is a storage backend selectable via STORAGE. Connect is called once on startup,
+// before any of the stores are created.
+type StorageDriver interface {
+	Connect()
+	Disconnect()
+	NewUserStore() UserStore
+	NewRefreshTokenStore() RefreshTokenStore
+	NewPendingActionStore() PendingActionStore
+	NewTrustedDeviceStore() TrustedDeviceStore
+	NewAPIKeyStore() APIKeyStore
+	NewDeviceCodeStore() DeviceCodeStore
+	NewInvitationStore() InvitationStore
+	NewClientCertificateStore() ClientCertificateStore
+	NewLinkedIdentityStore() LinkedIdentityStore
+	NewOrganizationStore() OrganizationStore
+	NewAuditLogStore() AuditLogStore
+	NewLoginStatsStore() LoginStatsStore
+	NewMailQueueStore() MailQueueStore
+}
+
+var _storageDrivers = make(map[string]StorageDriver)
+var _storageDriversMutex sync.RWMutex
+
+// RegisterStorageDriver makes a storage backend available under the given STORAGE name.
+// Drivers must be registered before the config is read, i.e. in an init function.
+func RegisterStorageDriver(name string, driver StorageDriver) {
+	_storageDriversMutex.Lock()
+	defer _storageDriversMutex.Unlock()
+	if _, ok := _storageDrivers[name]; ok {
+		log.Fatal("Storage driver registered twice: " + name)
+	}
+	_storageDrivers[name] = driver
+}
+
+// GetStorageDrivers returns the names of all registered storage drivers in alphabetical order
+func GetStorageDrivers() []string {
+	_storageDriversMutex.RLock()
+	defer _storageDriversMutex.RUnlock()
+	names := make([]string, 0, len(_storageDrivers))
+	for name := range _storageDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func _GetStorageDriver(name string) StorageDriver {
+	_storageDriversMutex.RLock()
+	defer _storageDriversMutex.RUnlock()
+	return _storageDrivers[name]
+}
+
+// GetStorageDriver returns the storage driver configured with STORAGE
+func GetStorageDriver() StorageDriver {
+	driver := _GetStorageDriver(GetConfig().Storage)
+	if driver == nil {
+		log.Fatal("STORAGE must be one of: " + strings.Join(GetStorageDrivers(), ", "))
+	}
+	return driver
+}
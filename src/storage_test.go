@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetStorageDrivers(t *testing.T) {
+	drivers := GetStorageDrivers()
+	if len(drivers) != 2 {
+		t.Fatalf("Expected 2 storage drivers, got %d", len(drivers))
+	}
+	checkTestString(t, StorageMemory, drivers[0])
+	checkTestString(t, StorageMongoDB, drivers[1])
+	if _, ok := _GetStorageDriver(StorageMemory).(*MemoryStorageDriver); !ok {
+		t.Error("Expected memory storage driver to be registered")
+	}
+	if _GetStorageDriver("unknown") != nil {
+		t.Error("Expected no driver for unknown storage")
+	}
+}
+
+type userStoreStub struct {
+	UserStore
+	users map[string]*User
+}
+
+func (s *userStoreStub) GetOne(id string) *User {
+	return s.users[id]
+}
+
+func TestUserRepositoryWithStubStore(t *testing.T) {
+	repo := &UserRepository{&userStoreStub{users: map[string]*User{"1": {ID: "1", Email: "foo@bar.com"}}}}
+	user := repo.GetOne("1")
+	if user == nil {
+		t.Fatal("Expected user from stub store")
+	}
+	checkTestString(t, "foo@bar.com", user.Email)
+	if repo.HasAdditionalEmail(user, "foo@bar.com") {
+		t.Error("Expected primary email not to be an additional email")
+	}
+}
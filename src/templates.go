@@ -1,51 +1,344 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	htmltemplate "html/template"
+	"io"
 	"io/ioutil"
 	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
 	"text/template"
+
+	"golang.org/x/text/language"
 )
 
 type ConfirmMailVars struct {
-	From      string
-	To        string
-	ConfirmID string
+	From        string
+	To          string
+	ConfirmID   string
+	Preferences map[string]string
 }
 
 type PasswordMailVars struct {
-	From     string
-	To       string
-	Password string
+	From        string
+	To          string
+	Password    string
+	Preferences map[string]string
+}
+
+type EmailChangedMailVars struct {
+	From        string
+	To          string
+	NewEmail    string
+	Preferences map[string]string
+}
+
+type InvitationMailVars struct {
+	From         string
+	To           string
+	InvitationID string
+	Organization string
+}
+
+// MailTemplate renders mails from a text template with the headers and plain text body. If an HTML template
+// is set, the mail is sent as multipart/alternative with the HTML body and, if configured, the MAIL_LOGO inline.
+type MailTemplate struct {
+	Text *template.Template
+	HTML *htmltemplate.Template
+	// Locales are translations of the template found in TEMPLATE_LOCALES_DIR by locale
+	Locales map[string]*MailTemplate
 }
 
-var TemplateSignup *template.Template
-var TemplateChangeEmail *template.Template
-var TemplateResetPassword *template.Template
-var TemplateNewPassword *template.Template
+// MailAttachment is a file embedded in mails, referenced from HTML bodies as cid:<ContentID>
+type MailAttachment struct {
+	ContentID   string
+	ContentType string
+	Filename    string
+	Data        []byte
+}
+
+// mailLogoContentID references the MAIL_LOGO in HTML templates, i.e. <img src="cid:logo">
+const mailLogoContentID = "logo"
+
+var TemplateSignup *MailTemplate
+var TemplateChangeEmail *MailTemplate
+var TemplateResetPassword *MailTemplate
+var TemplateNewPassword *MailTemplate
+var TemplateInvitation *MailTemplate
+var TemplateChangeEmailOld *MailTemplate
+var TemplateEmailChanged *MailTemplate
+var TemplateAddEmail *MailTemplate
+
+var mailLogo *MailAttachment
+
+// mailLocales are the subdirectories of TEMPLATE_LOCALES_DIR, mailLocaleMatcher chooses among them
+// with TEMPLATE_DEFAULT_LOCALE at index 0
+var mailLocales []string
+var mailLocaleMatcher language.Matcher
 
 func readMailTemplatesFromFile() {
-	content, err := ioutil.ReadFile(GetConfig().TemplateChangeEmail)
+	mailLogo = nil
+	if GetConfig().MailLogo != "" {
+		data, err := ioutil.ReadFile(GetConfig().MailLogo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(GetConfig().MailLogo))
+		if contentType == "" {
+			contentType = http.DetectContentType(data)
+		}
+		mailLogo = &MailAttachment{
+			ContentID:   mailLogoContentID,
+			ContentType: contentType,
+			Filename:    filepath.Base(GetConfig().MailLogo),
+			Data:        data,
+		}
+	}
+	_ReadMailLocales()
+	TemplateChangeEmail = _ReadLocalizedMailTemplate("TemplateChangeEmail", GetConfig().TemplateChangeEmail, GetConfig().TemplateChangeEmailHTML)
+	TemplateSignup = _ReadLocalizedMailTemplate("TemplateSignup", GetConfig().TemplateSignup, GetConfig().TemplateSignupHTML)
+	TemplateResetPassword = _ReadLocalizedMailTemplate("TemplateResetPassword", GetConfig().TemplateResetPassword, GetConfig().TemplateResetPasswordHTML)
+	TemplateNewPassword = _ReadLocalizedMailTemplate("TemplateNewPassword", GetConfig().TemplateNewPassword, GetConfig().TemplateNewPasswordHTML)
+	TemplateChangeEmailOld = _ReadLocalizedMailTemplate("TemplateChangeEmailOld", GetConfig().TemplateChangeEmailOld, GetConfig().TemplateChangeEmailOldHTML)
+	TemplateEmailChanged = _ReadLocalizedMailTemplate("TemplateEmailChanged", GetConfig().TemplateEmailChanged, GetConfig().TemplateEmailChangedHTML)
+	TemplateAddEmail = _ReadLocalizedMailTemplate("TemplateAddEmail", GetConfig().TemplateAddEmail, GetConfig().TemplateAddEmailHTML)
+	if GetConfig().AllowInvitations {
+		TemplateInvitation = _ReadLocalizedMailTemplate("TemplateInvitation", GetConfig().TemplateInvitation, GetConfig().TemplateInvitationHTML)
+	}
+}
+
+// _ReadMailLocales lists the locales in TEMPLATE_LOCALES_DIR, each a directory named by its language tag
+func _ReadMailLocales() {
+	mailLocales = nil
+	mailLocaleMatcher = nil
+	if GetConfig().TemplateLocalesDir == "" {
+		return
+	}
+	entries, err := ioutil.ReadDir(GetConfig().TemplateLocalesDir)
 	if err != nil {
 		log.Fatal(err)
 	}
-	TemplateChangeEmail, _ = template.New("TemplateChangeEmail").Parse(string(content))
+	tags := []language.Tag{language.Make(GetConfig().TemplateDefaultLocale)}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		tag, err := language.Parse(entry.Name())
+		if err != nil {
+			log.Fatal("Invalid locale directory in TEMPLATE_LOCALES_DIR: " + entry.Name())
+		}
+		mailLocales = append(mailLocales, entry.Name())
+		tags = append(tags, tag)
+	}
+	mailLocaleMatcher = language.NewMatcher(tags)
+}
 
-	content, err = ioutil.ReadFile(GetConfig().TemplateSignup)
+// MatchMailLocale returns the locale of the mail templates best matching an Accept-Language header, or an empty
+// string if there are no localized templates or none matches
+func MatchMailLocale(acceptLanguage string) string {
+	if mailLocaleMatcher == nil {
+		return ""
+	}
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return ""
+	}
+	_, index, confidence := mailLocaleMatcher.Match(tags...)
+	if confidence == language.No {
+		return ""
+	}
+	if index == 0 {
+		return GetConfig().TemplateDefaultLocale
+	}
+	return mailLocales[index-1]
+}
+
+// Localize returns the translation of the template best matching the locale, falling back to the template
+// itself in TEMPLATE_DEFAULT_LOCALE
+func (t *MailTemplate) Localize(locale string) *MailTemplate {
+	if len(t.Locales) == 0 || locale == "" {
+		return t
+	}
+	tag, err := language.Parse(locale)
 	if err != nil {
-		log.Fatal(err)
+		return t
 	}
-	TemplateSignup, _ = template.New("TemplateSignup").Parse(string(content))
+	_, index, confidence := mailLocaleMatcher.Match(tag)
+	if confidence == language.No || index == 0 {
+		return t
+	}
+	if localized, ok := t.Locales[mailLocales[index-1]]; ok {
+		return localized
+	}
+	return t
+}
 
-	content, err = ioutil.ReadFile(GetConfig().TemplateResetPassword)
+// _ReadLocalizedMailTemplate reads a template and its translations, which are files of the same name in the
+// locale directories. A translation without an HTML body is sent as plain text.
+func _ReadLocalizedMailTemplate(name, path, htmlPath string) *MailTemplate {
+	t := _ReadMailTemplate(name, path, htmlPath)
+	for _, locale := range mailLocales {
+		dir := filepath.Join(GetConfig().TemplateLocalesDir, locale)
+		localePath := filepath.Join(dir, filepath.Base(path))
+		if _, err := os.Stat(localePath); err != nil {
+			continue
+		}
+		localeHTMLPath := ""
+		if htmlPath != "" {
+			if _, err := os.Stat(filepath.Join(dir, filepath.Base(htmlPath))); err == nil {
+				localeHTMLPath = filepath.Join(dir, filepath.Base(htmlPath))
+			}
+		}
+		if t.Locales == nil {
+			t.Locales = make(map[string]*MailTemplate)
+		}
+		t.Locales[locale] = _ReadMailTemplate(name+"_"+locale, localePath, localeHTMLPath)
+	}
+	return t
+}
+
+func _ReadMailTemplate(name, path, htmlPath string) *MailTemplate {
+	content, err := ioutil.ReadFile(path)
 	if err != nil {
 		log.Fatal(err)
 	}
-	TemplateResetPassword, _ = template.New("TemplateResetPassword").Parse(string(content))
-
-	content, err = ioutil.ReadFile(GetConfig().TemplateNewPassword)
+	t := &MailTemplate{}
+	t.Text, err = template.New(name).Parse(string(content))
 	if err != nil {
 		log.Fatal(err)
 	}
-	TemplateNewPassword, _ = template.New("TemplateNewPassword").Parse(string(content))
+	if htmlPath != "" {
+		content, err = ioutil.ReadFile(htmlPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		t.HTML, err = htmltemplate.New(name + "HTML").Parse(string(content))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	return t
+}
+
+// Execute writes the mail, including its headers, rendered with data
+func (t *MailTemplate) Execute(w io.Writer, data interface{}) error {
+	if t.HTML == nil {
+		return t.Text.Execute(w, data)
+	}
+	var text, html bytes.Buffer
+	if err := t.Text.Execute(&text, data); err != nil {
+		return err
+	}
+	if err := t.HTML.Execute(&html, data); err != nil {
+		return err
+	}
+	header, body := _SplitMailHeader(text.String())
+	var inline []*MailAttachment
+	if mailLogo != nil {
+		inline = append(inline, mailLogo)
+	}
+	return WriteMultipartMail(w, header, body, html.String(), inline)
+}
+
+// _SplitMailHeader separates the header lines from the body at the first empty line
+func _SplitMailHeader(message string) ([]string, string) {
+	message = strings.ReplaceAll(message, "\r\n", "\n")
+	pos := strings.Index(message, "\n\n")
+	if pos == -1 {
+		return strings.Split(strings.TrimRight(message, "\n"), "\n"), ""
+	}
+	return strings.Split(message[:pos], "\n"), message[pos+2:]
+}
 
+// WriteMultipartMail writes a multipart/alternative mail with a plain text and an HTML body. Inline attachments
+// are added to the HTML body in a multipart/related part.
+func WriteMultipartMail(w io.Writer, header []string, text, html string, inline []*MailAttachment) error {
+	var buf bytes.Buffer
+	alternative := multipart.NewWriter(&buf)
+	for _, line := range header {
+		buf.WriteString(line + "\r\n")
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: multipart/alternative; boundary=\"" + alternative.Boundary() + "\"\r\n\r\n")
+	if err := _WriteQuotedPrintablePart(alternative, "text/plain; charset=utf-8", text); err != nil {
+		return err
+	}
+	if len(inline) == 0 {
+		if err := _WriteQuotedPrintablePart(alternative, "text/html; charset=utf-8", html); err != nil {
+			return err
+		}
+	} else {
+		var relatedBuf bytes.Buffer
+		related := multipart.NewWriter(&relatedBuf)
+		if err := _WriteQuotedPrintablePart(related, "text/html; charset=utf-8", html); err != nil {
+			return err
+		}
+		for _, attachment := range inline {
+			if err := _WriteInlineAttachment(related, attachment); err != nil {
+				return err
+			}
+		}
+		if err := related.Close(); err != nil {
+			return err
+		}
+		part, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"multipart/related; boundary=\"" + related.Boundary() + "\""},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := relatedBuf.WriteTo(part); err != nil {
+			return err
+		}
+	}
+	if err := alternative.Close(); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func _WriteQuotedPrintablePart(mw *multipart.Writer, contentType, content string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qw := quotedprintable.NewWriter(part)
+	if _, err := qw.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qw.Close()
+}
+
+func _WriteInlineAttachment(mw *multipart.Writer, attachment *MailAttachment) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": attachment.Filename})},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Id":                {"<" + attachment.ContentID + ">"},
+		"Content-Disposition":       {mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename})},
+	})
+	if err != nil {
+		return err
+	}
+	// Lines of encoded data must not exceed 76 characters
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
 }
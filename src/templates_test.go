@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func newTestMailTemplate(html string) *MailTemplate {
+	t := &MailTemplate{
+		Text: template.Must(template.New("text").Parse("From: {{.From}}\nTo: {{.To}}\nSubject: Confirm\n\nPlease confirm {{.ConfirmID}}")),
+	}
+	if html != "" {
+		t.HTML = htmltemplate.Must(htmltemplate.New("html").Parse(html))
+	}
+	return t
+}
+
+func TestMailTemplatePlainText(t *testing.T) {
+	var buf bytes.Buffer
+	newTestMailTemplate("").Execute(&buf, ConfirmMailVars{From: "no-reply@localhost", To: "foo@bar.com", ConfirmID: "123"})
+	checkTestString(t, "From: no-reply@localhost\nTo: foo@bar.com\nSubject: Confirm\n\nPlease confirm 123", buf.String())
+}
+
+func TestMailTemplateMultipart(t *testing.T) {
+	prevLogo := mailLogo
+	mailLogo = &MailAttachment{ContentID: mailLogoContentID, ContentType: "image/png", Filename: "logo.png", Data: []byte("PNG")}
+	defer func() { mailLogo = prevLogo }()
+
+	var buf bytes.Buffer
+	tpl := newTestMailTemplate(`<img src="cid:logo"><p>Please confirm <b>{{.ConfirmID}}</b></p>`)
+	if err := tpl.Execute(&buf, ConfirmMailVars{From: "no-reply@localhost", To: "foo@bar.com", ConfirmID: "<123>"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "From: no-reply@localhost\r\nTo: foo@bar.com\r\nSubject: Confirm\r\nMIME-Version: 1.0\r\nContent-Type: multipart/alternative;") {
+		t.Errorf("Expected template headers followed by MIME headers, got %s", buf.String())
+	}
+
+	m, err := _ParseMail(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestString(t, "Confirm", m.Subject)
+	checkTestString(t, "Please confirm <123>", m.Body)
+	checkTestString(t, `<img src="cid:logo"><p>Please confirm <b>&lt;123&gt;</b></p>`, m.HTMLBody)
+	if len(m.Inline) != 1 {
+		t.Fatalf("Expected logo to be attached inline, got %d attachments", len(m.Inline))
+	}
+	checkTestString(t, "logo", m.Inline[0].ContentID)
+	checkTestString(t, "logo.png", m.Inline[0].Filename)
+	checkTestString(t, "PNG", string(m.Inline[0].Data))
+}
+
+func useTestMailLocales(t *testing.T) {
+	GetConfig().TemplateLocalesDir = "../test/res/locales"
+	readMailTemplatesFromFile()
+	t.Cleanup(func() {
+		GetConfig().TemplateLocalesDir = ""
+		readMailTemplatesFromFile()
+	})
+}
+
+func TestMailTemplateLocalize(t *testing.T) {
+	useTestMailLocales(t)
+	if TemplateSignup.Localize("de-AT") != TemplateSignup.Locales["de"] {
+		t.Error("Expected German template for de-AT")
+	}
+	if TemplateSignup.Localize("fr") != TemplateSignup {
+		t.Error("Expected default template for locale without translation")
+	}
+	if TemplateResetPassword.Localize("de") != TemplateResetPassword {
+		t.Error("Expected default template for missing translation")
+	}
+	checkTestString(t, "de", MatchMailLocale("fr;q=0.9, de-DE;q=0.8"))
+	checkTestString(t, "en", MatchMailLocale("en-US"))
+	checkTestString(t, "", MatchMailLocale(""))
+}
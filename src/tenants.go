@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const ErrorCodeUnknownTenant = "unknown_tenant"
+
+// tenantManagedSettings are set by the supervisor for each tenant process and can't be overridden per tenant
+var tenantManagedSettings = []string{
+	"TENANTS_FILE",
+	"TENANT_HEADER",
+	"PUBLIC_LISTEN_ADDR",
+	"PUBLIC_TLS_CERT",
+	"PUBLIC_TLS_KEY",
+	"PUBLIC_ACME_DOMAINS",
+	"PUBLIC_HTTP_REDIRECT_ADDR",
+	"PUBLIC_PROXY_PROTOCOL",
+	"MONGO_DB_NAME",
+	"MONGO_DB_COLLECTION_PREFIX",
+}
+
+// Tenant is a separate set of users and tokens, stored in its own database or behind its own collection prefix.
+// Config overrides the environment variables of the tenant's process.
+type Tenant struct {
+	ID               string            `json:"id"`
+	Hosts            []string          `json:"hosts"`
+	Database         string            `json:"database,omitempty"`
+	CollectionPrefix string            `json:"collectionPrefix,omitempty"`
+	Config           map[string]string `json:"config,omitempty"`
+	target           *url.URL
+	cmd              *exec.Cmd
+}
+
+// LoadTenants reads a JSON file containing a list of tenants
+func LoadTenants(file string) ([]*Tenant, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*Tenant
+	if err := json.Unmarshal(content, &tenants); err != nil {
+		return nil, errors.New("Invalid tenants file: " + err.Error())
+	}
+	if len(tenants) == 0 {
+		return nil, errors.New("Tenants file must contain at least one tenant")
+	}
+	ids := make(map[string]bool)
+	hosts := make(map[string]bool)
+	storages := make(map[string]bool)
+	backendAddrs := make(map[string]bool)
+	for _, tenant := range tenants {
+		if tenant.ID == "" {
+			return nil, errors.New("Tenant must have an id")
+		}
+		if ids[tenant.ID] {
+			return nil, errors.New("Duplicate tenant id: " + tenant.ID)
+		}
+		ids[tenant.ID] = true
+		if len(tenant.Hosts) == 0 {
+			return nil, errors.New("Tenant " + tenant.ID + " must have at least one host")
+		}
+		for i, host := range tenant.Hosts {
+			host = strings.ToLower(host)
+			if hosts[host] {
+				return nil, errors.New("Duplicate tenant host: " + host)
+			}
+			hosts[host] = true
+			tenant.Hosts[i] = host
+		}
+		if tenant.Database == "" && tenant.CollectionPrefix == "" {
+			return nil, errors.New("Tenant " + tenant.ID + " must have a database or a collection prefix")
+		}
+		storage := tenant.Database + "/" + tenant.CollectionPrefix
+		if storages[storage] {
+			return nil, errors.New("Tenant " + tenant.ID + " shares its database and collection prefix with another tenant")
+		}
+		storages[storage] = true
+		for _, name := range tenantManagedSettings {
+			if _, ok := tenant.Config[name]; ok {
+				return nil, errors.New("Tenant " + tenant.ID + " must not override " + name)
+			}
+		}
+		backendAddr := tenant.Config["BACKEND_LISTEN_ADDR"]
+		if backendAddr == "" {
+			return nil, errors.New("Tenant " + tenant.ID + " must set BACKEND_LISTEN_ADDR in its config")
+		}
+		if backendAddrs[backendAddr] {
+			return nil, errors.New("Duplicate tenant BACKEND_LISTEN_ADDR: " + backendAddr)
+		}
+		backendAddrs[backendAddr] = true
+	}
+	return tenants, nil
+}
+
+// GetDatabaseName returns the tenant's database, MONGO_DB_NAME if it only has its own collection prefix
+func (tenant *Tenant) GetDatabaseName() string {
+	if tenant.Database == "" {
+		return GetConfig().MongoDbName
+	}
+	return tenant.Database
+}
+
+// TenantRouter forwards user-facing requests to the process of the tenant selected by the header
+// configured with TENANT_HEADER, if set, or by the requested host
+type TenantRouter struct {
+	Tenants []*Tenant
+	Header  string
+	proxy   *httputil.ReverseProxy
+}
+
+func NewTenantRouter(tenants []*Tenant, header string) *TenantRouter {
+	tr := &TenantRouter{Tenants: tenants, Header: header}
+	tr.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			target := tr.Resolve(req).target
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			if _, ok := req.Header["User-Agent"]; !ok {
+				// explicitly disable User-Agent so it's not set to default value
+				req.Header.Set("User-Agent", "")
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			log.Println("Proxying to tenant", tr.Resolve(req).ID, "failed:", err)
+			SendErrorPage(w, req, http.StatusBadGateway, ErrorCodeUpstreamUnavailable, "")
+		},
+	}
+	return tr
+}
+
+// Resolve returns the tenant a request is addressed to, nil if none matches
+func (tr *TenantRouter) Resolve(r *http.Request) *Tenant {
+	if tr.Header != "" {
+		if id := r.Header.Get(tr.Header); id != "" {
+			for _, tenant := range tr.Tenants {
+				if tenant.ID == id {
+					return tenant
+				}
+			}
+			return nil
+		}
+	}
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, tenant := range tr.Tenants {
+		for _, h := range tenant.Hosts {
+			if h == host {
+				return tenant
+			}
+		}
+	}
+	return nil
+}
+
+func (tr *TenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if tr.Resolve(r) == nil {
+		SendErrorPage(w, r, http.StatusMisdirectedRequest, ErrorCodeUnknownTenant, "")
+		return
+	}
+	// Tenant processes trust the forwarded headers of the supervisor, so remove those not sent by trusted proxies
+	if !KeepsForwardedHeaders(r) {
+		for name := range r.Header {
+			if _HasPrefixFold(name, "x-forwarded-") || strings.EqualFold(name, "forwarded") || strings.EqualFold(name, "x-real-ip") {
+				delete(r.Header, name)
+			}
+		}
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	if r.Header.Get("X-Forwarded-Proto") == "" {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+	tr.proxy.ServeHTTP(w, r)
+}
+
+// _GetFreeLocalAddr returns a loopback address with a port currently not in use
+func _GetFreeLocalAddr() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer listener.Close()
+	return listener.Addr().String(), nil
+}
+
+// _GetTenantEnv returns the environment of a tenant's process: the supervisor's environment with the tenant's
+// overrides, listening for user-facing requests on listenAddr and trusting the supervisor's forwarded headers
+func (tenant *Tenant) _GetTenantEnv(listenAddr string) []string {
+	env := os.Environ()
+	for name, value := range tenant.Config {
+		env = append(env, name+"="+value)
+	}
+	trustedProxies, ok := tenant.Config["PROXY_TRUSTED_PROXIES"]
+	if !ok {
+		trustedProxies = os.Getenv("PROXY_TRUSTED_PROXIES")
+	}
+	trustedProxies = strings.TrimSpace(trustedProxies + " 127.0.0.1/32")
+	// Later entries take precedence over earlier ones with the same name
+	return append(env,
+		"TENANTS_FILE=",
+		"TENANT_HEADER=",
+		"PUBLIC_LISTEN_ADDR="+listenAddr,
+		"PUBLIC_TLS_CERT=",
+		"PUBLIC_TLS_KEY=",
+		"PUBLIC_ACME_DOMAINS=",
+		"PUBLIC_HTTP_REDIRECT_ADDR=",
+		"PUBLIC_PROXY_PROTOCOL=0",
+		"PROXY_TRUSTED_PROXIES="+trustedProxies,
+		"MONGO_DB_NAME="+tenant.GetDatabaseName(),
+		"MONGO_DB_COLLECTION_PREFIX="+tenant.CollectionPrefix,
+	)
+}
+
+func (tenant *Tenant) _Start() error {
+	listenAddr, err := _GetFreeLocalAddr()
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	tenant.target = &url.URL{Scheme: "http", Host: listenAddr}
+	tenant.cmd = exec.Command(executable, os.Args[1:]...)
+	tenant.cmd.Env = tenant._GetTenantEnv(listenAddr)
+	tenant.cmd.Stdout = os.Stdout
+	tenant.cmd.Stderr = os.Stderr
+	if err := tenant.cmd.Start(); err != nil {
+		return err
+	}
+	log.Println("Started tenant", tenant.ID, "listening on", listenAddr)
+	return nil
+}
+
+// RunTenants starts a process for each tenant in TENANTS_FILE and forwards user-facing requests to them.
+// Each process has its own config, storage and backend-facing server. Stops when interrupted or a process exits.
+func RunTenants() {
+	tenants, err := LoadTenants(GetConfig().TenantsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	exited := make(chan *Tenant, len(tenants))
+	for _, tenant := range tenants {
+		if err := tenant._Start(); err != nil {
+			log.Fatal("Could not start tenant ", tenant.ID, ": ", err)
+		}
+		go func(tenant *Tenant) {
+			if err := tenant.cmd.Wait(); err != nil {
+				log.Println("Tenant", tenant.ID, "exited:", err)
+			}
+			exited <- tenant
+		}(tenant)
+	}
+	publicListenAddr := GetConfig().PublicListenAddr
+	publicServer := &http.Server{
+		Addr:         publicListenAddr,
+		WriteTimeout: time.Second * GetConfig().ServerWriteTimeout,
+		ReadTimeout:  time.Second * GetConfig().ServerReadTimeout,
+		IdleTimeout:  time.Second * GetConfig().ServerIdleTimeout,
+		Handler:      NewTenantRouter(tenants, GetConfig().TenantHeader),
+	}
+	if GetConfig().PublicTLSCert != "" {
+		ConfigurePublicHTTP2(publicServer, true)
+		go func() {
+			if err := publicServer.ServeTLS(ListenPublic(publicListenAddr), GetConfig().PublicTLSCert, GetConfig().PublicTLSKey); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		log.Println("Public HTTPS Server listening on", publicListenAddr, "for", len(tenants), "tenants")
+	} else {
+		ConfigurePublicHTTP2(publicServer, false)
+		go func() {
+			if err := publicServer.Serve(ListenPublic(publicListenAddr)); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+		log.Println("Public HTTP Server listening on", publicListenAddr, "for", len(tenants), "tenants")
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGHUP)
+	running := len(tenants)
+	stopping := false
+	var failed *Tenant
+	for running > 0 {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				for _, tenant := range tenants {
+					tenant.cmd.Process.Signal(syscall.SIGHUP)
+				}
+				continue
+			}
+			log.Println("Shutting down...")
+			stopping = true
+			for _, tenant := range tenants {
+				tenant.cmd.Process.Signal(os.Interrupt)
+			}
+		case tenant := <-exited:
+			running--
+			if !stopping {
+				// Stop all tenants so that the supervisor gets restarted as a whole
+				log.Println("Tenant", tenant.ID, "stopped unexpectedly, shutting down...")
+				stopping = true
+				failed = tenant
+				for _, other := range tenants {
+					if other != tenant {
+						other.cmd.Process.Signal(os.Interrupt)
+					}
+				}
+			}
+		}
+	}
+	publicServer.Close()
+	if failed != nil {
+		log.Fatal("Tenant ", failed.ID, " stopped unexpectedly")
+	}
+}
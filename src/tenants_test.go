@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTenants = `[
+	{"id": "acme", "hosts": ["auth.ACME.com", "login.acme.com"], "database": "acme", "config": {"BACKEND_LISTEN_ADDR": "127.0.0.1:8444"}},
+	{"id": "globex", "hosts": ["auth.globex.com"], "collectionPrefix": "globex_", "config": {"BACKEND_LISTEN_ADDR": "127.0.0.1:8445", "SIGNUP_ENABLE": "0"}}
+]`
+
+func writeTestTenants(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadTenants(t *testing.T) {
+	tenants, err := LoadTenants(writeTestTenants(t, testTenants))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 2 {
+		t.Fatalf("Expected 2 tenants, got %d", len(tenants))
+	}
+	checkTestString(t, "auth.acme.com", tenants[0].Hosts[0])
+	invalid := map[string]string{
+		"missing storage":    `[{"id": "a", "hosts": ["a.com"], "config": {"BACKEND_LISTEN_ADDR": ":1"}}]`,
+		"duplicate host":     `[{"id": "a", "hosts": ["a.com"], "database": "a", "config": {"BACKEND_LISTEN_ADDR": ":1"}}, {"id": "b", "hosts": ["A.com"], "database": "b", "config": {"BACKEND_LISTEN_ADDR": ":2"}}]`,
+		"shared storage":     `[{"id": "a", "hosts": ["a.com"], "database": "x", "config": {"BACKEND_LISTEN_ADDR": ":1"}}, {"id": "b", "hosts": ["b.com"], "database": "x", "config": {"BACKEND_LISTEN_ADDR": ":2"}}]`,
+		"missing backend":    `[{"id": "a", "hosts": ["a.com"], "database": "a"}]`,
+		"duplicate backend":  `[{"id": "a", "hosts": ["a.com"], "database": "a", "config": {"BACKEND_LISTEN_ADDR": ":1"}}, {"id": "b", "hosts": ["b.com"], "database": "b", "config": {"BACKEND_LISTEN_ADDR": ":1"}}]`,
+		"managed setting":    `[{"id": "a", "hosts": ["a.com"], "database": "a", "config": {"BACKEND_LISTEN_ADDR": ":1", "MONGO_DB_NAME": "b"}}]`,
+		"no tenants":         `[]`,
+		"invalid json":       `{`,
+		"missing identifier": `[{"hosts": ["a.com"], "database": "a", "config": {"BACKEND_LISTEN_ADDR": ":1"}}]`,
+	}
+	for name, content := range invalid {
+		if _, err := LoadTenants(writeTestTenants(t, content)); err == nil {
+			t.Error("Expected error for tenants file with " + name)
+		}
+	}
+}
+
+func TestTenantRouterResolve(t *testing.T) {
+	tenants, err := LoadTenants(writeTestTenants(t, testTenants))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTenantRouter(tenants, "X-Tenant")
+	req, _ := http.NewRequest("GET", "/auth/login", nil)
+	req.Host = "Login.Acme.com:8080"
+	if tenant := tr.Resolve(req); tenant == nil || tenant.ID != "acme" {
+		t.Error("Expected tenant to be resolved by host")
+	}
+	req.Header.Set("X-Tenant", "globex")
+	if tenant := tr.Resolve(req); tenant == nil || tenant.ID != "globex" {
+		t.Error("Expected tenant header to take precedence over host")
+	}
+	req.Header.Set("X-Tenant", "unknown")
+	if tr.Resolve(req) != nil {
+		t.Error("Expected unknown tenant header not to be resolved")
+	}
+	req.Header.Del("X-Tenant")
+	req.Host = "example.com"
+	if tr.Resolve(req) != nil {
+		t.Error("Expected unknown host not to be resolved")
+	}
+	rec := httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+	checkTestResponseCode(t, http.StatusMisdirectedRequest, rec.Code)
+	if !strings.Contains(rec.Body.String(), ErrorCodeUnknownTenant) {
+		t.Error("Expected unknown_tenant error")
+	}
+}
+
+func TestTenantRouterProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + " " + r.Header.Get("X-Forwarded-Host") + " " + r.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer upstream.Close()
+	tenants, err := LoadTenants(writeTestTenants(t, testTenants))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants[0].target, _ = url.Parse(upstream.URL)
+	tr := NewTenantRouter(tenants, "")
+	req := httptest.NewRequest("GET", "/auth/login", nil)
+	req.Host = "auth.acme.com"
+	req.Header.Set("X-Forwarded-Host", "spoofed.com")
+	rec := httptest.NewRecorder()
+	tr.ServeHTTP(rec, req)
+	checkTestResponseCode(t, http.StatusOK, rec.Code)
+	checkTestString(t, "auth.acme.com auth.acme.com http", rec.Body.String())
+}
+
+func TestTenantEnv(t *testing.T) {
+	tenants, err := LoadTenants(writeTestTenants(t, testTenants))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := tenants[1]._GetTenantEnv("127.0.0.1:9000")
+	values := make(map[string]string)
+	for _, entry := range env {
+		parts := strings.SplitN(entry, "=", 2)
+		values[parts[0]] = parts[1]
+	}
+	checkTestString(t, "127.0.0.1:9000", values["PUBLIC_LISTEN_ADDR"])
+	checkTestString(t, "globex_", values["MONGO_DB_COLLECTION_PREFIX"])
+	checkTestString(t, GetConfig().MongoDbName, values["MONGO_DB_NAME"])
+	checkTestString(t, "0", values["SIGNUP_ENABLE"])
+	checkTestString(t, "", values["TENANTS_FILE"])
+	if !strings.HasSuffix(values["PROXY_TRUSTED_PROXIES"], "127.0.0.1/32") {
+		t.Error("Expected tenant process to trust the supervisor")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IsAlternateTokenRoute checks if the access token may be passed as access_token query parameter or PROXY_TOKEN_COOKIE
+// instead of the Authorization header, as browsers can't set headers for downloads and EventSource connections.
+// Only GET and HEAD requests to PROXY_TOKEN_QUERY_ROUTES qualify, so cookies can't be abused for cross-site requests changing data.
+func IsAlternateTokenRoute(r *http.Request) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	path := r.URL.EscapedPath()
+	if strings.HasPrefix(path, GetConfig().PublicAPIPath) {
+		return false
+	}
+	for _, rule := range GetConfig().ProxyTokenQueryRoutes {
+		if rule.Matches(r.Method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAlternateToken returns the JWT passed as access_token query parameter or PROXY_TOKEN_COOKIE
+func GetAlternateToken(r *http.Request) string {
+	if token := r.URL.Query().Get(webSocketTokenQueryParam); token != "" {
+		return token
+	}
+	if GetConfig().ProxyTokenCookie != "" {
+		if cookie, err := r.Cookie(GetConfig().ProxyTokenCookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// RemoveAlternateToken strips the token from the query and cookies so it is not forwarded to the upstream
+func RemoveAlternateToken(r *http.Request) {
+	query := r.URL.Query()
+	if query.Has(webSocketTokenQueryParam) {
+		query.Del(webSocketTokenQueryParam)
+		r.URL.RawQuery = query.Encode()
+	}
+	if GetConfig().ProxyTokenCookie == "" {
+		return
+	}
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != GetConfig().ProxyTokenCookie {
+			r.AddCookie(cookie)
+		}
+	}
+}
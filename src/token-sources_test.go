@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestAlternateTokenRoute(t *testing.T) {
+	os.Setenv("PROXY_TOKEN_QUERY_ROUTES", "/downloads:GET /events")
+	os.Setenv("PROXY_TOKEN_COOKIE", "token")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_TOKEN_QUERY_ROUTES")
+		os.Unsetenv("PROXY_TOKEN_COOKIE")
+		GetConfig().ReadConfig()
+	}()
+	if !IsAlternateTokenRoute(newHTTPRequest("GET", "/downloads/file.pdf", "", nil)) {
+		t.Error("Expected GET /downloads/file.pdf to accept alternate tokens")
+	}
+	if IsAlternateTokenRoute(newHTTPRequest("POST", "/downloads/file.pdf", "", nil)) {
+		t.Error("Expected POST to require the Authorization header")
+	}
+	if IsAlternateTokenRoute(newHTTPRequest("GET", "/other", "", nil)) {
+		t.Error("Expected /other to require the Authorization header")
+	}
+
+	req := newHTTPRequest("GET", "/downloads/file.pdf?a=b&access_token=abc", "", nil)
+	checkTestString(t, "abc", GetAlternateToken(req))
+	RemoveAlternateToken(req)
+	checkTestString(t, "a=b", req.URL.RawQuery)
+
+	req = newHTTPRequest("GET", "/events", "", nil)
+	req.Header.Set("Cookie", "session=1; token=def")
+	checkTestString(t, "def", GetAlternateToken(req))
+	RemoveAlternateToken(req)
+	checkTestString(t, "session=1", req.Header.Get("Cookie"))
+}
+
+func TestProxyAccessTokenInQuery(t *testing.T) {
+	os.Setenv("PROXY_TOKEN_QUERY_ROUTES", "/blacklist/downloads")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_TOKEN_QUERY_ROUTES")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	req := newHTTPRequest("GET", "/blacklist/downloads/file.pdf?access_token="+loginResponse.AccessToken, "", nil)
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkStringNotEmpty(t, handler.Headers.Get("X-Auth-UserID"))
+
+	req = newHTTPRequest("GET", "/blacklist/other?access_token="+loginResponse.AccessToken, "", nil)
+	res = executePublicTestRequest(req)
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryTrustedDeviceStore stores trusted devices in memory, see STORAGE
+type MemoryTrustedDeviceStore struct {
+	devices *MemoryCollection[TrustedDevice]
+}
+
+func NewMemoryTrustedDeviceStore() *MemoryTrustedDeviceStore {
+	return &MemoryTrustedDeviceStore{devices: NewMemoryCollection[TrustedDevice]()}
+}
+
+func (r *MemoryTrustedDeviceStore) Create(u *TrustedDevice) {
+	u.ID = primitive.NewObjectID()
+	r.devices.Insert(u)
+}
+
+func (r *MemoryTrustedDeviceStore) GetByHashedToken(hashedToken string) *TrustedDevice {
+	trustedDevice := r.devices.FindOne(func(d *TrustedDevice) bool {
+		return d.HashedToken == hashedToken
+	})
+	if trustedDevice == nil {
+		return nil
+	}
+	if trustedDevice.ExpiryDate.Before(time.Now()) {
+		r.Delete(trustedDevice)
+		return nil
+	}
+	return trustedDevice
+}
+
+func (r *MemoryTrustedDeviceStore) DeleteAllForUser(userID string) {
+	r.devices.Delete(func(d *TrustedDevice) bool {
+		return d.UserID == UserID(userID)
+	})
+}
+
+func (r *MemoryTrustedDeviceStore) Delete(u *TrustedDevice) {
+	r.devices.Delete(func(d *TrustedDevice) bool {
+		return d.ID == u.ID
+	})
+}
+
+func (r *MemoryTrustedDeviceStore) CleanUp() {
+	now := time.Now()
+	r.devices.Delete(func(d *TrustedDevice) bool {
+		return !d.ExpiryDate.After(now)
+	})
+}
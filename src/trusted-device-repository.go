@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TrustedDevice may skip the TOTP prompt. Its token is an opaque random string of which only the hash is stored,
+// the plain text Token is only known when the device is trusted or looked up.
+type TrustedDevice struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID      UserID             `json:"userId" bson:"userId"`
+	Token       string             `json:"-" bson:"-"`
+	HashedToken string             `json:"-" bson:"hashedToken"`
+	CreateDate  time.Time          `json:"createDate" bson:"createDate"`
+	ExpiryDate  time.Time          `json:"expiryDate" bson:"expiryDate"`
+}
+
+// TrustedDeviceStore persists trusted devices, see STORAGE
+type TrustedDeviceStore interface {
+	Create(u *TrustedDevice)
+	GetByHashedToken(hashedToken string) *TrustedDevice
+	DeleteAllForUser(userID string)
+	Delete(u *TrustedDevice)
+	CleanUp()
+}
+
+// TrustedDeviceRepository manages trusted devices, storing them in the TrustedDeviceStore of the configured storage backend
+type TrustedDeviceRepository struct {
+	TrustedDeviceStore
+}
+
+var _trustedDeviceRepositoryInstance *TrustedDeviceRepository
+var _trustedDeviceRepositoryOnce sync.Once
+
+func GetTrustedDeviceRepository() *TrustedDeviceRepository {
+	_trustedDeviceRepositoryOnce.Do(func() {
+		_trustedDeviceRepositoryInstance = &TrustedDeviceRepository{GetStorageDriver().NewTrustedDeviceStore()}
+	})
+	return _trustedDeviceRepositoryInstance
+}
+
+type MongoTrustedDeviceStore struct {
+}
+
+func NewMongoTrustedDeviceStore() *MongoTrustedDeviceStore {
+	return &MongoTrustedDeviceStore{}
+}
+
+func (r *MongoTrustedDeviceStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("trusted_devices")
+}
+
+func (r *MongoTrustedDeviceStore) Create(u *TrustedDevice) {
+	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+	if err != nil {
+		log.Println(err)
+	}
+	u.ID = res.InsertedID.(primitive.ObjectID)
+}
+
+func (r *TrustedDeviceRepository) Create(u *TrustedDevice) {
+	u.HashedToken = HashToken(u.Token)
+	r.TrustedDeviceStore.Create(u)
+}
+
+func (r *TrustedDeviceRepository) GetByToken(token string) *TrustedDevice {
+	trustedDevice := r.GetByHashedToken(HashToken(token))
+	if trustedDevice != nil {
+		trustedDevice.Token = token
+	}
+	return trustedDevice
+}
+
+func (r *MongoTrustedDeviceStore) GetByHashedToken(hashedToken string) *TrustedDevice {
+	var trustedDevice TrustedDevice
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"hashedToken": hashedToken}).Decode(&trustedDevice)
+	if err != nil {
+		return nil
+	}
+	if trustedDevice.ExpiryDate.Before(time.Now()) {
+		r.Delete(&trustedDevice)
+		return nil
+	}
+	return &trustedDevice
+}
+
+func (r *MongoTrustedDeviceStore) DeleteAllForUser(userID string) {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"userId": UserID(userID)})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoTrustedDeviceStore) Delete(u *TrustedDevice) {
+	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// FindUnusedToken returns a new random trusted device token in plain text; only its hash is ever stored
+func (r *TrustedDeviceRepository) FindUnusedToken() string {
+	var token string = ""
+	for i := 1; i <= 20 && token == ""; i++ {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Println(err)
+			continue
+		}
+		token = hex.EncodeToString(b)
+		if r.GetByToken(token) != nil {
+			token = ""
+		}
+	}
+	return token
+}
+
+func (r *MongoTrustedDeviceStore) CleanUp() {
+	_, err := r.GetCollection().DeleteMany(context.TODO(), bson.M{"expiryDate": bson.M{"$lte": time.Now()}})
+	if err != nil {
+		log.Println(err)
+	}
+}
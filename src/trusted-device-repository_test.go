@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrustedDeviceCleanUp(t *testing.T) {
+	clearTestDB()
+
+	token1 := GetTrustedDeviceRepository().FindUnusedToken()
+	td1 := &TrustedDevice{
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
+		UserID:     NewUserID(),
+		Token:      token1,
+	}
+	GetTrustedDeviceRepository().Create(td1)
+	token2 := GetTrustedDeviceRepository().FindUnusedToken()
+	td2 := &TrustedDevice{
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * -1),
+		UserID:     NewUserID(),
+		Token:      token2,
+	}
+	GetTrustedDeviceRepository().Create(td2)
+
+	GetTrustedDeviceRepository().CleanUp()
+
+	if GetTrustedDeviceRepository().GetByToken(token1) == nil {
+		t.Error("Expected td1 not to be nil")
+	}
+	if GetTrustedDeviceRepository().GetByToken(token2) != nil {
+		t.Error("Expected td2 to be nil")
+	}
+}
+
+func TestTrustedDeviceDeleteAllForUser(t *testing.T) {
+	clearTestDB()
+
+	userID := NewUserID()
+	token := GetTrustedDeviceRepository().FindUnusedToken()
+	td := &TrustedDevice{
+		CreateDate: time.Now(),
+		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * 1),
+		UserID:     userID,
+		Token:      token,
+	}
+	GetTrustedDeviceRepository().Create(td)
+
+	GetTrustedDeviceRepository().DeleteAllForUser(userID.String())
+
+	if GetTrustedDeviceRepository().GetByToken(token) != nil {
+		t.Error("Expected td to be nil")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseIPRanges parses a space-separated list of IP addresses and CIDR ranges
+func ParseIPRanges(s string) ([]*net.IPNet, error) {
+	res := make([]*net.IPNet, 0)
+	for _, entry := range strings.Fields(s) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.New("Invalid IP address: " + entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.New("Invalid IP range: " + entry)
+		}
+		res = append(res, ipNet)
+	}
+	return res, nil
+}
+
+// IsTrustedProxy checks if the request was received from one of the configured trusted proxies
+func IsTrustedProxy(r *http.Request) bool {
+	return IPRangesContain(GetConfig().ProxyTrustedProxies, GetPeerIP(r))
+}
+
+// ParseForwardedIP returns the IP address of an X-Forwarded-For entry, which may include a port, or an empty string if it's invalid
+func ParseForwardedIP(s string) string {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// IPRangesContain checks if the IP address is contained in one of the ranges
+func IPRangesContain(ranges []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range ranges {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripClientIdentityHeaders removes identity headers sent by the client, including those renamed by PROXY_IDENTITY_HEADERS, so they can't be spoofed.
+// X-Forwarded-* and Forwarded headers are kept according to PROXY_FORWARDED_HEADERS.
+func StripClientIdentityHeaders(r *http.Request) {
+	trusted := KeepsForwardedHeaders(r)
+	for name := range r.Header {
+		if IsIdentityHeader(name) {
+			delete(r.Header, name)
+		} else if !trusted && (_HasPrefixFold(name, "x-forwarded-") || strings.EqualFold(name, "forwarded") || strings.EqualFold(name, "x-real-ip")) {
+			delete(r.Header, name)
+		}
+	}
+}
+
+// _HasPrefixFold is strings.HasPrefix ignoring case, without allocating a lower-case copy of s
+func _HasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
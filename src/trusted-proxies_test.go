@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestParseIPRanges(t *testing.T) {
+	ipNets, err := ParseIPRanges("10.0.0.0/8 192.168.1.1 ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ipNets) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(ipNets))
+	}
+	if !ipNets[0].Contains(net.ParseIP("10.1.2.3")) || !ipNets[1].Contains(net.ParseIP("192.168.1.1")) || ipNets[1].Contains(net.ParseIP("192.168.1.2")) {
+		t.Error("Expected addresses to be matched")
+	}
+	if _, err := ParseIPRanges("10.0.0.0/33"); err == nil {
+		t.Error("Expected error for invalid range")
+	}
+}
+
+func TestProxyStripsClientIdentityHeaders(t *testing.T) {
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	req := newHTTPRequest("GET", "/some/route/test.html", "", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Auth-UserID", "spoofed")
+	req.Header.Set("X-Auth-Custom", "spoofed")
+	req.Header.Set("X-Forwarded-Host", "spoofed.com")
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "", handler.Headers.Get("X-Auth-UserID"))
+	checkTestString(t, "", handler.Headers.Get("X-Auth-Custom"))
+	if handler.Headers.Get("X-Forwarded-Host") == "spoofed.com" {
+		t.Error("Expected X-Forwarded-Host of untrusted client to be replaced")
+	}
+}
+
+func TestProxyKeepsTrustedForwardedHeaders(t *testing.T) {
+	os.Setenv("PROXY_TRUSTED_PROXIES", "10.0.0.0/8")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_TRUSTED_PROXIES")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	req := newHTTPRequest("GET", "/some/route/test.html", "", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Auth-UserID", "spoofed")
+	req.Header.Set("X-Forwarded-Host", "example.com")
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "", handler.Headers.Get("X-Auth-UserID"))
+	checkTestString(t, "example.com", handler.Headers.Get("X-Forwarded-Host"))
+}
+
+func TestGetClientIP(t *testing.T) {
+	os.Setenv("PROXY_TRUSTED_PROXIES", "10.0.0.0/8")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_TRUSTED_PROXIES")
+		GetConfig().ReadConfig()
+	}()
+	for _, testCase := range []struct{ RemoteAddr, XFF, Expected string }{
+		{"203.0.113.7:1234", "", "203.0.113.7"},
+		{"203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"10.1.2.3:1234", "", "10.1.2.3"},
+		{"10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.1.2.3:1234", "spoofed, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.1.2.3:1234", "10.0.0.3, 10.0.0.2", "10.0.0.3"},
+		{"10.1.2.3:1234", "[2001:db8::1]:4711", "2001:db8::1"},
+		{"10.1.2.3:1234", "invalid", "10.1.2.3"},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = testCase.RemoteAddr
+		if testCase.XFF != "" {
+			req.Header.Set("X-Forwarded-For", testCase.XFF)
+		}
+		checkTestString(t, testCase.Expected, GetClientIP(req))
+	}
+}
+
+func BenchmarkStripClientIdentityHeaders(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", "benchmark")
+		req.Header.Set("X-Auth-UserID", "spoofed")
+		req.Header.Set("X-Forwarded-For", "192.0.2.2")
+		StripClientIdentityHeaders(req)
+	}
+}
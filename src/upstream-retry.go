@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var idempotentMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PUT":     true,
+	"DELETE":  true,
+}
+
+// CircuitOpen returns true while the upstream is in its cool-down period after too many consecutive failures
+func (u *Upstream) CircuitOpen() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&u.circuitOpenUntil)
+}
+
+// RecordSuccess closes the upstream's circuit
+func (u *Upstream) RecordSuccess() {
+	atomic.StoreInt32(&u.failures, 0)
+}
+
+// RecordFailure counts a failed request and opens the circuit once the configured threshold is reached.
+// A failing request after the cool-down period opens the circuit again immediately.
+func (u *Upstream) RecordFailure() {
+	threshold := GetConfig().ProxyCircuitBreakerThreshold
+	failures := atomic.AddInt32(&u.failures, 1)
+	if threshold > 0 && failures >= int32(threshold) && !u.CircuitOpen() {
+		log.Println("Upstream", u.URL.Host, "failed", failures, "times in a row, opening circuit")
+		atomic.StoreInt64(&u.circuitOpenUntil, time.Now().Add(time.Second*GetConfig().ProxyCircuitBreakerCooldown).UnixNano())
+	}
+}
+
+// RetryTransport retries failed requests with idempotent methods with exponential backoff
+// and records the outcome of each attempt in the upstream's circuit breaker
+type RetryTransport struct {
+	Transport http.RoundTripper
+	Retries   int
+	Backoff   time.Duration
+}
+
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	upstream := GetUpstreamFromContext(req)
+	retries := 0
+	if idempotentMethods[req.Method] && (req.Body == nil || req.Body == http.NoBody) {
+		retries = t.Retries
+	}
+	backoff := t.Backoff
+	for attempt := 0; ; attempt++ {
+		res, err := t.Transport.RoundTrip(req)
+		failed := err != nil || t._IsRetryableStatus(res.StatusCode)
+		if upstream != nil {
+			if failed {
+				upstream.RecordFailure()
+			} else {
+				upstream.RecordSuccess()
+			}
+		}
+		if !failed || attempt >= retries || (upstream != nil && upstream.CircuitOpen()) {
+			return res, err
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+		log.Println("Retrying", req.Method, req.URL.Path, "after failed attempt", attempt+1)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (t *RetryTransport) _IsRetryableStatus(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
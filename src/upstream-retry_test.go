@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+type failingRoundTripper struct {
+	Calls    int
+	Failures int
+}
+
+func (rt *failingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.Calls++
+	if rt.Calls <= rt.Failures {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	rt := &failingRoundTripper{Failures: 2}
+	transport := &RetryTransport{Transport: rt, Retries: 2, Backoff: time.Millisecond}
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:8091/", nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestResponseCode(t, http.StatusOK, res.StatusCode)
+	if rt.Calls != 3 {
+		t.Errorf("Expected 3 attempts, got %d", rt.Calls)
+	}
+}
+
+func TestRetryTransportNonIdempotent(t *testing.T) {
+	rt := &failingRoundTripper{Failures: 1}
+	transport := &RetryTransport{Transport: rt, Retries: 2, Backoff: time.Millisecond}
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:8091/", nil)
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Error("Expected POST request not to be retried")
+	}
+	if rt.Calls != 1 {
+		t.Errorf("Expected 1 attempt, got %d", rt.Calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	os.Setenv("PROXY_CIRCUIT_BREAKER_THRESHOLD", "2")
+	os.Setenv("PROXY_CIRCUIT_BREAKER_COOLDOWN", "60")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_CIRCUIT_BREAKER_THRESHOLD")
+		os.Unsetenv("PROXY_CIRCUIT_BREAKER_COOLDOWN")
+		GetConfig().ReadConfig()
+	}()
+	target1, _ := url.Parse("http://127.0.0.1:8091")
+	target2, _ := url.Parse("http://127.0.0.1:8092")
+	pool := NewUpstreamPool([]*url.URL{target1, target2}, nil, LoadBalancingRoundRobin)
+	upstream := pool.Upstreams[0]
+
+	upstream.RecordFailure()
+	upstream.RecordSuccess()
+	upstream.RecordFailure()
+	if upstream.CircuitOpen() {
+		t.Error("Expected circuit to be closed after non-consecutive failures")
+	}
+	upstream.RecordFailure()
+	if !upstream.CircuitOpen() {
+		t.Fatal("Expected circuit to be open after consecutive failures")
+	}
+	for i := 0; i < 3; i++ {
+		checkTestString(t, target2.Host, pool.Next().URL.Host)
+	}
+	pool.Upstreams[1].RecordFailure()
+	pool.Upstreams[1].RecordFailure()
+	if pool.Next() != nil {
+		t.Error("Expected no upstream if all circuits are open")
+	}
+}
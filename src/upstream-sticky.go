@@ -0,0 +1,68 @@
+package main
+
+import (
+	"hash/fnv"
+	"net/http"
+
+	guuid "github.com/google/uuid"
+)
+
+const (
+	StickySessionsUser   = "user"
+	StickySessionsCookie = "cookie"
+)
+
+// GetStickySessionKey returns the key requests are pinned to an upstream by, or an empty string if the request isn't pinned.
+// In cookie mode, clients without a session cookie are assigned a new one.
+func GetStickySessionKey(w http.ResponseWriter, r *http.Request) string {
+	switch GetConfig().ProxyStickySessions {
+	case StickySessionsUser:
+		claims := GetClaimsFromContext(r)
+		if claims == nil {
+			return ""
+		}
+		if claims.Guest {
+			return claims.GuestID
+		}
+		return claims.UserID
+	case StickySessionsCookie:
+		if cookie, err := r.Cookie(GetConfig().ProxyStickyCookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+		key := guuid.New().String()
+		http.SetCookie(w, &http.Cookie{
+			Name:     GetConfig().ProxyStickyCookie,
+			Value:    key,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return key
+	}
+	return ""
+}
+
+// NextFor returns the upstream requests with the given sticky session key are pinned to.
+// Rendezvous hashing is used, so only the sessions of an upstream leaving the rotation are moved to other upstreams.
+// Requests without a key are distributed using the pool's strategy.
+func (p *UpstreamPool) NextFor(key string) *Upstream {
+	if key == "" {
+		return p.Next()
+	}
+	var res *Upstream
+	var max uint64
+	for _, upstream := range p.HealthyUpstreams() {
+		if upstream.CircuitOpen() {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(upstream.URL.String()))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); res == nil || score > max {
+			res, max = upstream, score
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestUpstreamStickySessions(t *testing.T) {
+	pool := newTestUpstreamPool(t, []int{1, 1, 1}, LoadBalancingRoundRobin)
+	assigned := make(map[string]*Upstream)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		assigned[key] = pool.NextFor(key)
+		for j := 0; j < 3; j++ {
+			if pool.NextFor(key) != assigned[key] {
+				t.Fatalf("Expected %s to stay on %s", key, assigned[key].URL.Host)
+			}
+		}
+	}
+	pool.Upstreams[0].SetHealthy(false)
+	for key, upstream := range assigned {
+		next := pool.NextFor(key)
+		if next == pool.Upstreams[0] {
+			t.Errorf("Expected %s not to be assigned to the unhealthy upstream", key)
+		}
+		if upstream != pool.Upstreams[0] && next != upstream {
+			t.Errorf("Expected %s to stay on %s after another upstream left the rotation", key, upstream.URL.Host)
+		}
+	}
+}
+
+func TestStickySessionCookie(t *testing.T) {
+	os.Setenv("PROXY_STICKY_SESSIONS", "cookie")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_STICKY_SESSIONS")
+		GetConfig().ReadConfig()
+	}()
+	res := httptest.NewRecorder()
+	key := GetStickySessionKey(res, httptest.NewRequest("GET", "/some/route", nil))
+	checkStringNotEmpty(t, key)
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "jwt_auth_proxy_upstream" || cookies[0].Value != key {
+		t.Fatal("Expected sticky session cookie to be set")
+	}
+
+	req := httptest.NewRequest("GET", "/some/route", nil)
+	req.AddCookie(&http.Cookie{Name: "jwt_auth_proxy_upstream", Value: "abc"})
+	res = httptest.NewRecorder()
+	checkTestString(t, "abc", GetStickySessionKey(res, req))
+	if len(res.Result().Cookies()) != 0 {
+		t.Error("Expected no new sticky session cookie")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"log"
+)
+
+// CreateUpstreamTLSConfig builds the TLS configuration used for connections to https upstreams:
+// an optional client certificate for mutual TLS, a custom CA bundle, pinned public keys and the server name to send via SNI
+func CreateUpstreamTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: GetConfig().ProxyTLSServerName,
+	}
+	if GetConfig().ProxyTLSClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(GetConfig().ProxyTLSClientCert, GetConfig().ProxyTLSClientKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if GetConfig().ProxyTLSCA != "" {
+		caCert, err := ioutil.ReadFile(GetConfig().ProxyTLSCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			log.Fatal("No certificates found in PROXY_TLS_CA")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	if len(GetConfig().ProxyTLSPins) > 0 {
+		pins := GetConfig().ProxyTLSPins
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return VerifyPublicKeyPins(rawCerts, pins)
+		}
+	}
+	return tlsConfig
+}
+
+// VerifyPublicKeyPins checks that one of the presented certificates has a public key matching one of the pins,
+// given as base64-encoded SHA-256 hashes of the DER-encoded SubjectPublicKeyInfo
+func VerifyPublicKeyPins(rawCerts [][]byte, pins []string) error {
+	for _, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			continue
+		}
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		fingerprint := base64.StdEncoding.EncodeToString(hash[:])
+		for _, pin := range pins {
+			if pin == fingerprint {
+				return nil
+			}
+		}
+	}
+	return errors.New("Upstream certificate does not match any pinned public key")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpstreamTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	hash := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(hash[:])
+
+	os.Setenv("PROXY_TLS_CA", caFile)
+	os.Setenv("PROXY_TLS_PINS", "invalid "+pin)
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_TLS_CA")
+		os.Unsetenv("PROXY_TLS_PINS")
+		GetConfig().ReadConfig()
+	}()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: CreateUpstreamTLSConfig()}}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	os.Setenv("PROXY_TLS_PINS", "invalid")
+	GetConfig().ReadConfig()
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: CreateUpstreamTLSConfig()}}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("Expected connection with mismatching pin to fail")
+	}
+}
+
+func TestUpstreamHostAndServerName(t *testing.T) {
+	var host, serverName string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		serverName = r.TLS.ServerName
+	}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+
+	os.Setenv("PROXY_TLS_CA", caFile)
+	os.Setenv("PROXY_TLS_SERVER_NAME", "example.com")
+	os.Setenv("PROXY_HOST_HEADER", "app.example.com")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_TLS_CA")
+		os.Unsetenv("PROXY_TLS_SERVER_NAME")
+		os.Unsetenv("PROXY_HOST_HEADER")
+		GetConfig().ReadConfig()
+	}()
+	target, _ := url.Parse(server.URL)
+	pool := NewUpstreamPool([]*url.URL{target}, nil, LoadBalancingRoundRobin)
+	pool.CheckHealth("/health", time.Second)
+	if !pool.Upstreams[0].Healthy() {
+		t.Fatal("Expected upstream to be healthy")
+	}
+	checkTestString(t, "app.example.com", host)
+	checkTestString(t, "example.com", serverName)
+}
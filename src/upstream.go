@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// UpstreamSchemeH2C marks upstreams which are contacted with HTTP/2 over cleartext, i.e. gRPC services without TLS
+const UpstreamSchemeH2C = "h2c"
+
+// UpstreamSchemeUnix marks upstreams listening on a Unix domain socket, i.e. unix:///run/app.sock
+const UpstreamSchemeUnix = "unix"
+
+const (
+	LoadBalancingRoundRobin       = "round-robin"
+	LoadBalancingLeastConnections = "least-connections"
+	LoadBalancingWeighted         = "weighted"
+)
+
+// Upstream is one of the target servers requests are proxied to
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+	// Limiter bounds the requests in flight to the upstream, nil if PROXY_MAX_CONCURRENT_PER_UPSTREAM is 0
+	Limiter       *ConcurrencyLimiter
+	connections   int64
+	currentWeight int
+	unhealthy     int32
+	failures      int32
+	// circuitOpenUntil is the end of the circuit breaker's cool-down period in Unix nanoseconds
+	circuitOpenUntil int64
+}
+
+// Healthy returns false if the upstream failed its latest health check
+func (u *Upstream) Healthy() bool {
+	return atomic.LoadInt32(&u.unhealthy) == 0
+}
+
+func (u *Upstream) SetHealthy(healthy bool) {
+	var unhealthy int32 = 1
+	if healthy {
+		unhealthy = 0
+	}
+	if atomic.SwapInt32(&u.unhealthy, unhealthy) != unhealthy {
+		if healthy {
+			log.Println("Upstream", u.URL.Host, "is healthy again, adding it back to rotation")
+		} else {
+			log.Println("Upstream", u.URL.Host, "is unhealthy, removing it from rotation")
+		}
+	}
+}
+
+// RequestURL returns the URL requests to the upstream are sent to: h2c and Unix domain socket upstreams are contacted via http,
+// the latter using a host name unique to the socket, so that connections to different sockets aren't mixed up by the transport
+func (u *Upstream) RequestURL() *url.URL {
+	target := *u.URL
+	switch target.Scheme {
+	case UpstreamSchemeH2C:
+		target.Scheme = "http"
+	case UpstreamSchemeUnix:
+		h := fnv.New32a()
+		h.Write([]byte(u.URL.Path))
+		target.Scheme = "http"
+		target.Host = fmt.Sprintf("unix-%x", h.Sum32())
+		target.Path = ""
+		target.RawPath = ""
+	}
+	return &target
+}
+
+// HostHeader returns the Host header sent to the upstream: PROXY_HOST_HEADER if set, otherwise the upstream's address
+func (u *Upstream) HostHeader() string {
+	if GetConfig().ProxyHostHeader != "" {
+		return GetConfig().ProxyHostHeader
+	}
+	if u.URL.Scheme == UpstreamSchemeUnix {
+		return "localhost"
+	}
+	return u.URL.Host
+}
+
+// SetUpstreamCredentials adds the static credentials configured by PROXY_UPSTREAM_BASIC_AUTH or PROXY_UPSTREAM_BEARER_TOKEN,
+// replacing the header set by the client or the user's access token
+func SetUpstreamCredentials(header http.Header) {
+	if GetConfig().ProxyUpstreamCredentials != "" {
+		header.Set(GetConfig().ProxyUpstreamAuthHeader, GetConfig().ProxyUpstreamCredentials)
+	}
+}
+
+// CheckHealth probes the upstream's health check path, any status below 400 counts as healthy
+func (u *Upstream) CheckHealth(client *http.Client, path string) bool {
+	target := *u.RequestURL()
+	target.Path = GetApp()._SingleJoiningSlash(target.Path, path)
+	target.RawQuery = ""
+	req, err := http.NewRequestWithContext(context.WithValue(context.Background(), contextKeyUpstream, u), "GET", target.String(), nil)
+	if err != nil {
+		return false
+	}
+	req.Host = u.HostHeader()
+	SetUpstreamCredentials(req.Header)
+	res, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode < 400
+}
+
+// Connections returns the number of requests currently proxied to the upstream
+func (u *Upstream) Connections() int64 {
+	return atomic.LoadInt64(&u.connections)
+}
+
+// Acquire counts a request being proxied to the upstream, it must be followed by Release
+func (u *Upstream) Acquire() {
+	atomic.AddInt64(&u.connections, 1)
+}
+
+func (u *Upstream) Release() {
+	atomic.AddInt64(&u.connections, -1)
+}
+
+// UpstreamPool selects the upstream for each proxied request using the configured load balancing strategy
+type UpstreamPool struct {
+	Upstreams []*Upstream
+	Strategy  string
+	Transport http.RoundTripper
+	mutex     sync.Mutex
+	next      int
+}
+
+// NewUpstreamPool creates a pool of the given targets, weights may be nil if all targets are weighted equally
+func NewUpstreamPool(targets []*url.URL, weights []int, strategy string) *UpstreamPool {
+	pool := &UpstreamPool{
+		Upstreams: make([]*Upstream, len(targets)),
+		Strategy:  strategy,
+		Transport: NewUpstreamTransport(),
+	}
+	for i, target := range targets {
+		weight := 1
+		if i < len(weights) {
+			weight = weights[i]
+		}
+		pool.Upstreams[i] = &Upstream{URL: target, Weight: weight}
+		if GetConfig().ProxyMaxConcurrentPerUpstream > 0 {
+			pool.Upstreams[i].Limiter = NewConcurrencyLimiter(GetConfig().ProxyMaxConcurrentPerUpstream, GetConfig().ProxyQueueSize, time.Second*GetConfig().ProxyQueueTimeout)
+		}
+	}
+	return pool
+}
+
+// Next returns the upstream the next request should be proxied to, or nil if none is healthy with a closed circuit
+func (p *UpstreamPool) Next() *Upstream {
+	candidates := make([]*Upstream, 0, len(p.Upstreams))
+	for _, upstream := range p.HealthyUpstreams() {
+		if !upstream.CircuitOpen() {
+			candidates = append(candidates, upstream)
+		}
+	}
+	return p.NextOf(candidates)
+}
+
+// HealthyUpstreams returns the upstreams which are currently in rotation
+func (p *UpstreamPool) HealthyUpstreams() []*Upstream {
+	res := make([]*Upstream, 0, len(p.Upstreams))
+	for _, upstream := range p.Upstreams {
+		if upstream.Healthy() {
+			res = append(res, upstream)
+		}
+	}
+	return res
+}
+
+// CheckHealth probes all upstreams in parallel and updates their health state
+func (p *UpstreamPool) CheckHealth(path string, timeout time.Duration) {
+	client := &http.Client{Timeout: timeout, Transport: p.Transport}
+	var wg sync.WaitGroup
+	for _, upstream := range p.Upstreams {
+		wg.Add(1)
+		go func(upstream *Upstream) {
+			defer wg.Done()
+			upstream.SetHealthy(upstream.CheckHealth(client, path))
+		}(upstream)
+	}
+	wg.Wait()
+}
+
+// NextOf selects one of the given upstreams of the pool using the pool's strategy
+func (p *UpstreamPool) NextOf(candidates []*Upstream) *Upstream {
+	if len(candidates) == 0 {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	switch p.Strategy {
+	case LoadBalancingLeastConnections:
+		return p._NextLeastConnections(candidates)
+	case LoadBalancingWeighted:
+		return p._NextWeighted(candidates)
+	default:
+		return p._NextRoundRobin(candidates)
+	}
+}
+
+func (p *UpstreamPool) _NextRoundRobin(candidates []*Upstream) *Upstream {
+	upstream := candidates[p.next%len(candidates)]
+	p.next++
+	return upstream
+}
+
+func (p *UpstreamPool) _NextLeastConnections(candidates []*Upstream) *Upstream {
+	// Start at a rotating offset so that ties are spread evenly
+	var res *Upstream
+	for i := range candidates {
+		upstream := candidates[(p.next+i)%len(candidates)]
+		if res == nil || upstream.Connections() < res.Connections() {
+			res = upstream
+		}
+	}
+	p.next++
+	return res
+}
+
+// _NextWeighted implements smooth weighted round-robin, spreading the picks of heavy upstreams over time
+func (p *UpstreamPool) _NextWeighted(candidates []*Upstream) *Upstream {
+	var res *Upstream
+	total := 0
+	for _, upstream := range candidates {
+		upstream.currentWeight += upstream.Weight
+		total += upstream.Weight
+		if res == nil || upstream.currentWeight > res.currentWeight {
+			res = upstream
+		}
+	}
+	res.currentWeight -= total
+	return res
+}
+
+// UpstreamTransport sends requests to h2c upstreams using cleartext HTTP/2 and all others using the default transport,
+// which negotiates HTTP/2 with TLS upstreams. Trailers and streamed bodies, as used by gRPC, are passed through by both.
+type UpstreamTransport struct {
+	Default http.RoundTripper
+	H2C     http.RoundTripper
+}
+
+func NewUpstreamTransport() *UpstreamTransport {
+	dialer := &net.Dialer{
+		Timeout:   time.Second * GetConfig().ProxyDialTimeout,
+		KeepAlive: time.Second * GetConfig().ProxyKeepAlive,
+	}
+	if GetConfig().ProxyKeepAlive == 0 {
+		dialer.KeepAlive = -1
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if upstream, ok := ctx.Value(contextKeyUpstream).(*Upstream); ok && upstream.URL.Scheme == UpstreamSchemeUnix {
+			return dialer.DialContext(ctx, "unix", upstream.URL.Path)
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	transport.DisableKeepAlives = GetConfig().ProxyDisableKeepAlives
+	transport.MaxIdleConns = GetConfig().ProxyMaxIdleConns
+	transport.MaxIdleConnsPerHost = GetConfig().ProxyMaxIdleConnsPerHost
+	transport.MaxConnsPerHost = GetConfig().ProxyMaxConnsPerHost
+	transport.IdleConnTimeout = time.Second * GetConfig().ProxyIdleConnTimeout
+	transport.TLSHandshakeTimeout = time.Second * GetConfig().ProxyTLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = time.Second * GetConfig().ProxyResponseHeaderTimeout
+	transport.TLSClientConfig = CreateUpstreamTLSConfig()
+	return &UpstreamTransport{
+		Default: transport,
+		H2C: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			},
+		},
+	}
+}
+
+func (t *UpstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if upstream := GetUpstreamFromContext(req); upstream != nil && upstream.URL.Scheme == UpstreamSchemeH2C {
+		return t.H2C.RoundTrip(req)
+	}
+	return t.Default.RoundTrip(req)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func newTestUpstreamPool(t *testing.T, weights []int, strategy string) *UpstreamPool {
+	targets := make([]*url.URL, 0)
+	for _, target := range []string{"http://127.0.0.1:8091", "http://127.0.0.1:8092", "http://127.0.0.1:8093"}[:len(weights)] {
+		u, err := url.Parse(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, u)
+	}
+	return NewUpstreamPool(targets, weights, strategy)
+}
+
+func TestUpstreamRoundRobin(t *testing.T) {
+	pool := newTestUpstreamPool(t, []int{1, 1, 1}, LoadBalancingRoundRobin)
+	for i := 0; i < 6; i++ {
+		checkTestString(t, pool.Upstreams[i%3].URL.Host, pool.Next().URL.Host)
+	}
+}
+
+func TestUpstreamLeastConnections(t *testing.T) {
+	pool := newTestUpstreamPool(t, []int{1, 1, 1}, LoadBalancingLeastConnections)
+	pool.Upstreams[0].Acquire()
+	pool.Upstreams[0].Acquire()
+	pool.Upstreams[2].Acquire()
+	for i := 0; i < 3; i++ {
+		checkTestString(t, pool.Upstreams[1].URL.Host, pool.Next().URL.Host)
+	}
+	pool.Upstreams[1].Acquire()
+	pool.Upstreams[1].Acquire()
+	checkTestString(t, pool.Upstreams[2].URL.Host, pool.Next().URL.Host)
+	pool.Upstreams[0].Release()
+	pool.Upstreams[0].Release()
+	checkTestString(t, pool.Upstreams[0].URL.Host, pool.Next().URL.Host)
+}
+
+func TestUpstreamWeighted(t *testing.T) {
+	pool := newTestUpstreamPool(t, []int{3, 1}, LoadBalancingWeighted)
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[pool.Next().URL.Host]++
+	}
+	if counts[pool.Upstreams[0].URL.Host] != 6 || counts[pool.Upstreams[1].URL.Host] != 2 {
+		t.Errorf("Expected weighted distribution 6:2, got %v", counts)
+	}
+}
+
+func TestUpstreamHealthCheck(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checkTestString(t, "/health", r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer unhealthy.Close()
+	healthyURL, _ := url.Parse(healthy.URL)
+	unhealthyURL, _ := url.Parse(unhealthy.URL)
+	downURL, _ := url.Parse("http://127.0.0.1:8099")
+	pool := NewUpstreamPool([]*url.URL{healthyURL, unhealthyURL, downURL}, nil, LoadBalancingRoundRobin)
+
+	pool.CheckHealth("/health", time.Second)
+	if len(pool.HealthyUpstreams()) != 1 {
+		t.Fatalf("Expected 1 healthy upstream, got %d", len(pool.HealthyUpstreams()))
+	}
+	for i := 0; i < 3; i++ {
+		checkTestString(t, healthyURL.Host, pool.Next().URL.Host)
+	}
+
+	healthy.Close()
+	pool.CheckHealth("/health", time.Second)
+	if pool.Next() != nil {
+		t.Error("Expected no upstream if all are unhealthy")
+	}
+}
+
+func TestUpstreamH2C(t *testing.T) {
+	var protoMajor int
+	server := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protoMajor = r.ProtoMajor
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer server.Close()
+	target, _ := url.Parse(strings.Replace(server.URL, "http://", "h2c://", 1))
+	pool := NewUpstreamPool([]*url.URL{target}, nil, LoadBalancingRoundRobin)
+
+	req, _ := http.NewRequest("POST", server.URL+"/service/Method", nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyUpstream, pool.Next()))
+	res, err := pool.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+	if protoMajor != 2 {
+		t.Errorf("Expected HTTP/2 request to upstream, got HTTP/%d", protoMajor)
+	}
+	checkTestString(t, "0", res.Trailer.Get("Grpc-Status"))
+
+	pool.CheckHealth("/health", time.Second)
+	if !pool.Upstreams[0].Healthy() {
+		t.Error("Expected h2c upstream to be healthy")
+	}
+}
+
+func TestUpstreamTransportTuning(t *testing.T) {
+	os.Setenv("PROXY_MAX_IDLE_CONNS_PER_HOST", "250")
+	os.Setenv("PROXY_MAX_CONNS_PER_HOST", "500")
+	os.Setenv("PROXY_IDLE_CONN_TIMEOUT", "30")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_MAX_IDLE_CONNS_PER_HOST")
+		os.Unsetenv("PROXY_MAX_CONNS_PER_HOST")
+		os.Unsetenv("PROXY_IDLE_CONN_TIMEOUT")
+		GetConfig().ReadConfig()
+	}()
+	transport := NewUpstreamTransport().Default.(*http.Transport)
+	if transport.MaxIdleConns != 100 || transport.MaxIdleConnsPerHost != 250 || transport.MaxConnsPerHost != 500 {
+		t.Errorf("Expected connection limits 100/250/500, got %d/%d/%d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.MaxConnsPerHost)
+	}
+	if transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("Expected idle connection timeout of 30s, got %s", transport.IdleConnTimeout)
+	}
+}
+
+func TestUpstreamUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	target, _ := url.Parse("unix://" + socket)
+	pool := NewUpstreamPool([]*url.URL{target}, nil, LoadBalancingRoundRobin)
+
+	req, _ := http.NewRequest("GET", pool.Next().RequestURL().String()+"/some/route", nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyUpstream, pool.Next()))
+	res, err := pool.Transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	checkTestResponseCode(t, http.StatusOK, res.StatusCode)
+	checkTestString(t, "/some/route", path)
+
+	pool.CheckHealth("/health", time.Second)
+	if !pool.Upstreams[0].Healthy() {
+		t.Error("Expected unix socket upstream to be healthy")
+	}
+}
+
+func TestProxyUpstreamCredentials(t *testing.T) {
+	os.Setenv("PROXY_UPSTREAM_BASIC_AUTH", "service:secret")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("PROXY_UPSTREAM_BASIC_AUTH")
+		os.Unsetenv("PROXY_UPSTREAM_BEARER_TOKEN")
+		os.Unsetenv("PROXY_UPSTREAM_AUTH_HEADER")
+		GetConfig().ReadConfig()
+	}()
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+	defer proxy.Shutdown(context.TODO())
+
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	res := executePublicTestRequest(newHTTPRequest("GET", "/blacklist/test.html", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "Basic c2VydmljZTpzZWNyZXQ=", handler.Headers.Get("Authorization"))
+	checkStringNotEmpty(t, handler.Headers.Get("X-Auth-UserID"))
+
+	os.Unsetenv("PROXY_UPSTREAM_BASIC_AUTH")
+	os.Setenv("PROXY_UPSTREAM_BEARER_TOKEN", "service-token")
+	os.Setenv("PROXY_UPSTREAM_AUTH_HEADER", "x-service-authorization")
+	GetConfig().ReadConfig()
+	// ReadConfig rotated the signing key, so log in again
+	loginResponse = loginUser("foo@bar.com", "12345678")
+	res = executePublicTestRequest(newHTTPRequest("GET", "/blacklist/test.html", loginResponse.AccessToken, nil))
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, "Bearer service-token", handler.Headers.Get("X-Service-Authorization"))
+	checkTestString(t, "Bearer "+loginResponse.AccessToken, handler.Headers.Get("Authorization"))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// userCacheMaxEntries limits the memory used by the user cache, expired entries are purged when it's reached
+const userCacheMaxEntries = 10000
+
+type _CachedUser struct {
+	user       *User
+	expiryDate time.Time
+}
+
+// CachingUserStore keeps users looked up by ID in memory for USER_CACHE_TTL seconds, so that authenticating
+// requests doesn't require a database query each. Writes through the store invalidate the cached user,
+// writes by other instances become visible after the TTL.
+type CachingUserStore struct {
+	UserStore
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[UserID]*_CachedUser
+	// generation is incremented on each invalidation, so that users read before a write aren't cached after it
+	generation uint64
+}
+
+func NewCachingUserStore(store UserStore, ttl time.Duration) *CachingUserStore {
+	return &CachingUserStore{
+		UserStore: store,
+		ttl:       ttl,
+		entries:   make(map[UserID]*_CachedUser),
+	}
+}
+
+func (r *CachingUserStore) GetOne(id string) *User {
+	now := time.Now()
+	r.mutex.Lock()
+	entry := r.entries[UserID(id)]
+	generation := r.generation
+	r.mutex.Unlock()
+	if entry != nil && entry.expiryDate.After(now) {
+		// Callers modify the users they get, so hand out copies only
+		return _CopyMemoryDocument(entry.user)
+	}
+	u := r.UserStore.GetOne(id)
+	if u == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.generation != generation {
+		return u
+	}
+	if len(r.entries) >= userCacheMaxEntries {
+		r._PurgeExpired(now)
+	}
+	r.entries[u.ID] = &_CachedUser{user: _CopyMemoryDocument(u), expiryDate: now.Add(r.ttl)}
+	return u
+}
+
+// _PurgeExpired removes expired entries, or all entries if none has expired. The mutex must be held.
+func (r *CachingUserStore) _PurgeExpired(now time.Time) {
+	for id, entry := range r.entries {
+		if !entry.expiryDate.After(now) {
+			delete(r.entries, id)
+		}
+	}
+	if len(r.entries) >= userCacheMaxEntries {
+		r.entries = make(map[UserID]*_CachedUser)
+	}
+}
+
+// Invalidate removes a user from the cache
+func (r *CachingUserStore) Invalidate(id UserID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.generation++
+	delete(r.entries, id)
+}
+
+// InvalidateAll empties the cache
+func (r *CachingUserStore) InvalidateAll() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.generation++
+	r.entries = make(map[UserID]*_CachedUser)
+}
+
+func (r *CachingUserStore) Create(u *User) {
+	r.UserStore.Create(u)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) Update(u *User) {
+	r.UserStore.Update(u)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) SetOrganization(u *User, organizationID, role string) {
+	r.UserStore.SetOrganization(u, organizationID, role)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) AddAdditionalEmail(u *User, email string) {
+	r.UserStore.AddAdditionalEmail(u, email)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) RemoveAdditionalEmail(u *User, email string) {
+	r.UserStore.RemoveAdditionalEmail(u, email)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) PromoteAdditionalEmail(u *User, email string) {
+	r.UserStore.PromoteAdditionalEmail(u, email)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) SetMetadata(u *User, metadata map[string]interface{}) {
+	r.UserStore.SetMetadata(u, metadata)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) SetAppMetadata(u *User, metadata map[string]interface{}) {
+	r.UserStore.SetAppMetadata(u, metadata)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) SetPreferences(u *User, preferences map[string]string) {
+	r.UserStore.SetPreferences(u, preferences)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) RemoveAllFromOrganization(organizationID string) {
+	r.UserStore.RemoveAllFromOrganization(organizationID)
+	r.InvalidateAll()
+}
+
+func (r *CachingUserStore) Delete(u *User) {
+	r.UserStore.Delete(u)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) SoftDelete(u *User) {
+	r.UserStore.SoftDelete(u)
+	r.Invalidate(u.ID)
+}
+
+func (r *CachingUserStore) Restore(u *User) {
+	r.UserStore.Restore(u)
+	r.Invalidate(u.ID)
+}
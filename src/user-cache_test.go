@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+type countingUserStore struct {
+	UserStore
+	reads int
+}
+
+func (s *countingUserStore) GetOne(id string) *User {
+	s.reads++
+	return s.UserStore.GetOne(id)
+}
+
+func TestCachingUserStore(t *testing.T) {
+	backing := &countingUserStore{UserStore: NewMemoryUserStore()}
+	store := NewCachingUserStore(backing, time.Minute)
+	u := &User{Email: "cached@example.com", Enabled: true, Roles: []string{"admin"}}
+	store.Create(u)
+
+	store.GetOne(string(u.ID))
+	cached := store.GetOne(string(u.ID))
+	if backing.reads != 1 {
+		t.Fatalf("Expected 1 read from the backing store, got %d", backing.reads)
+	}
+	checkTestString(t, "admin", cached.Roles[0])
+
+	// Modifying a returned user must not modify the cached one
+	cached.Enabled = false
+	if !store.GetOne(string(u.ID)).Enabled {
+		t.Error("Expected cached user not to be modified by callers")
+	}
+
+	u.Enabled = false
+	store.Update(u)
+	if store.GetOne(string(u.ID)).Enabled {
+		t.Error("Expected update to invalidate the cached user")
+	}
+	if backing.reads != 2 {
+		t.Errorf("Expected 2 reads from the backing store, got %d", backing.reads)
+	}
+
+	store.SetOrganization(u, "org1", "admin")
+	store.RemoveAllFromOrganization("org1")
+	if store.GetOne(string(u.ID)).Organization != "" {
+		t.Error("Expected removing all users from an organization to invalidate the cache")
+	}
+
+	store.Delete(u)
+	if store.GetOne(string(u.ID)) != nil {
+		t.Error("Expected deleted user not to be returned from the cache")
+	}
+}
+
+func TestCachingUserStoreExpiry(t *testing.T) {
+	backing := &countingUserStore{UserStore: NewMemoryUserStore()}
+	store := NewCachingUserStore(backing, time.Millisecond)
+	u := &User{Email: "expiring@example.com"}
+	store.Create(u)
+	store.GetOne(string(u.ID))
+	time.Sleep(5 * time.Millisecond)
+	store.GetOne(string(u.ID))
+	if backing.reads != 2 {
+		t.Errorf("Expected expired user to be read again, got %d reads", backing.reads)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+const (
+	UserIDFormatObjectID = "objectid"
+	UserIDFormatUUIDv4   = "uuidv4"
+	UserIDFormatUUIDv7   = "uuidv7"
+)
+
+// UserID identifies a user independent of the storage backend.
+// IDs in ObjectID format are stored as ObjectIDs to stay compatible with existing documents, all others as strings.
+type UserID string
+
+// NewUserID generates a new user ID in the configured format
+func NewUserID() UserID {
+	switch GetConfig().UserIDFormat {
+	case UserIDFormatUUIDv4:
+		return UserID(uuid.NewString())
+	case UserIDFormatUUIDv7:
+		return UserID(uuid.Must(uuid.NewV7()).String())
+	default:
+		return UserID(primitive.NewObjectID().Hex())
+	}
+}
+
+func (id UserID) String() string {
+	return string(id)
+}
+
+func (id UserID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if objID, err := primitive.ObjectIDFromHex(string(id)); err == nil {
+		return bsontype.ObjectID, bsoncore.AppendObjectID(nil, objID), nil
+	}
+	return bsontype.String, bsoncore.AppendString(nil, string(id)), nil
+}
+
+func (id *UserID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.ObjectID:
+		objID, _, ok := bsoncore.ReadObjectID(data)
+		if !ok {
+			return errors.New("invalid ObjectID")
+		}
+		*id = UserID(objID.Hex())
+	case bsontype.String:
+		s, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return errors.New("invalid string")
+		}
+		*id = UserID(s)
+	case bsontype.Null, bsontype.Undefined:
+		*id = ""
+	default:
+		return errors.New("invalid BSON type for user ID: " + t.String())
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUserIDBSON(t *testing.T) {
+	objID := primitive.NewObjectID()
+	data, _ := bson.Marshal(bson.M{"userId": UserID(objID.Hex())})
+	if bson.Raw(data).Lookup("userId").Type != bsontype.ObjectID {
+		t.Error("Expected ObjectID user ID to be stored as ObjectID")
+	}
+	var res struct {
+		UserID UserID `bson:"userId"`
+	}
+	bson.Unmarshal(data, &res)
+	checkTestString(t, objID.Hex(), res.UserID.String())
+
+	id := uuid.NewString()
+	data, _ = bson.Marshal(bson.M{"userId": UserID(id)})
+	if bson.Raw(data).Lookup("userId").Type != bsontype.String {
+		t.Error("Expected UUID user ID to be stored as string")
+	}
+	bson.Unmarshal(data, &res)
+	checkTestString(t, id, res.UserID.String())
+}
+
+func TestUserIDFormatUUIDv7(t *testing.T) {
+	os.Setenv("USER_ID_FORMAT", "uuidv7")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Unsetenv("USER_ID_FORMAT")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ := http.NewRequest("POST", "/auth/signup", bytes.NewBufferString(payload))
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	id, err := uuid.Parse(res.Header().Get("X-Object-ID"))
+	if err != nil || id.Version() != 7 {
+		t.Fatalf("Expected UUIDv7 user ID, got %s", res.Header().Get("X-Object-ID"))
+	}
+
+	user := GetUserRepository().GetOne(id.String())
+	if user == nil {
+		t.Fatal("Expected user to be found by UUID")
+	}
+	user.Confirmed = true
+	GetUserRepository().Update(user)
+	loginResponse := loginUser("foo@bar.com", "12345678")
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+
+	req = newHTTPRequest("GET", "/auth/sessions", loginResponse.AccessToken, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+}
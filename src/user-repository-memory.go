@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// MemoryUserStore stores users in memory, see STORAGE
+type MemoryUserStore struct {
+	users *MemoryCollection[User]
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: NewMemoryCollection[User]()}
+}
+
+func (r *MemoryUserStore) _ByID(id UserID) func(*User) bool {
+	return func(u *User) bool {
+		return u.ID == id
+	}
+}
+
+func (r *MemoryUserStore) _HasEmail(u *User, email string) bool {
+	if strings.EqualFold(u.Email, email) {
+		return true
+	}
+	for _, e := range u.AdditionalEmails {
+		if strings.EqualFold(e, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryUserStore) Create(u *User) {
+	if u.ID == "" {
+		u.ID = NewUserID()
+	}
+	// Enforce the unique index on email and additionalEmails like MongoDB does
+	if r.users.Count(func(existing *User) bool { return r._HasEmail(existing, u.Email) }) > 0 {
+		log.Println("Duplicate email address", u.Email)
+		return
+	}
+	r.users.Insert(u)
+}
+
+func (r *MemoryUserStore) GetOne(id string) *User {
+	return r.users.FindOne(r._ByID(UserID(id)))
+}
+
+func (r *MemoryUserStore) GetByEmail(email string) *User {
+	email = NormalizeEmail(email)
+	return r.users.FindOne(func(u *User) bool {
+		return r._HasEmail(u, email)
+	})
+}
+
+func (r *MemoryUserStore) Update(u *User) {
+	r.users.Replace(r._ByID(u.ID), u)
+}
+
+func (r *MemoryUserStore) SetOrganization(u *User, organizationID, role string) {
+	u.Organization = organizationID
+	u.OrganizationRole = role
+	if organizationID == "" {
+		u.OrganizationRole = ""
+	}
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.Organization = u.Organization
+		existing.OrganizationRole = u.OrganizationRole
+	})
+}
+
+func (r *MemoryUserStore) AddAdditionalEmail(u *User, email string) {
+	u.AdditionalEmails = append(u.AdditionalEmails, email)
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		for _, e := range existing.AdditionalEmails {
+			if e == email {
+				return
+			}
+		}
+		existing.AdditionalEmails = append(existing.AdditionalEmails, email)
+	})
+}
+
+func (r *MemoryUserStore) RemoveAdditionalEmail(u *User, email string) {
+	emails := make([]string, 0)
+	for _, e := range u.AdditionalEmails {
+		if !strings.EqualFold(e, email) {
+			emails = append(emails, e)
+		}
+	}
+	u.AdditionalEmails = emails
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.AdditionalEmails = append([]string(nil), emails...)
+	})
+}
+
+func (r *MemoryUserStore) PromoteAdditionalEmail(u *User, email string) {
+	emails := []string{u.Email}
+	for _, e := range u.AdditionalEmails {
+		if !strings.EqualFold(e, email) {
+			emails = append(emails, e)
+		}
+	}
+	u.Email = email
+	u.AdditionalEmails = emails
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.Email = u.Email
+		existing.AdditionalEmails = append([]string(nil), emails...)
+	})
+}
+
+func (r *MemoryUserStore) SetMetadata(u *User, metadata map[string]interface{}) {
+	u.Metadata = metadata
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.Metadata = metadata
+	})
+}
+
+func (r *MemoryUserStore) SetAppMetadata(u *User, metadata map[string]interface{}) {
+	u.AppMetadata = metadata
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.AppMetadata = metadata
+	})
+}
+
+func (r *MemoryUserStore) SetPreferences(u *User, preferences map[string]string) {
+	u.Preferences = preferences
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.Preferences = preferences
+	})
+}
+
+func (r *MemoryUserStore) GetAllForOrganization(organizationID string) []*User {
+	return r.users.Find(func(u *User) bool {
+		return u.Organization == organizationID
+	})
+}
+
+func (r *MemoryUserStore) RemoveAllFromOrganization(organizationID string) {
+	r.users.Update(func(u *User) bool {
+		return u.Organization == organizationID
+	}, func(u *User) {
+		u.Organization = ""
+		u.OrganizationRole = ""
+	})
+}
+
+func (r *MemoryUserStore) Delete(u *User) {
+	r.users.Delete(r._ByID(u.ID))
+}
+
+func (r *MemoryUserStore) SoftDelete(u *User) {
+	u.Deleted = true
+	u.DeleteDate = time.Now()
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.Deleted = true
+		existing.DeleteDate = u.DeleteDate
+	})
+}
+
+func (r *MemoryUserStore) Restore(u *User) {
+	u.Deleted = false
+	u.DeleteDate = time.Time{}
+	r.users.Update(r._ByID(u.ID), func(existing *User) {
+		existing.Deleted = false
+		existing.DeleteDate = time.Time{}
+	})
+}
+
+func (r *MemoryUserStore) Count(confirmed *bool) int64 {
+	return r.users.Count(func(u *User) bool {
+		return !u.Deleted && (confirmed == nil || u.Confirmed == *confirmed)
+	})
+}
+
+func (r *MemoryUserStore) CountSignupsPerDay(from time.Time) map[string]int64 {
+	results := make(map[string]int64)
+	for _, u := range r.users.Find(func(u *User) bool { return !u.CreateDate.Before(from) }) {
+		results[u.CreateDate.UTC().Format("2006-01-02")]++
+	}
+	return results
+}
+
+func (r *MemoryUserStore) GetDeletedBefore(date time.Time) []*User {
+	return r.users.Find(func(u *User) bool {
+		return u.Deleted && !u.DeleteDate.After(date)
+	})
+}
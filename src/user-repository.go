@@ -3,30 +3,69 @@ package main
 import (
 	"context"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Email          string             `json:"email" bson:"email"`
-	HashedPassword string             `json:"password,omitempty" bson:"password"`
-	Confirmed      bool               `json:"confirmed" bson:"confirmed"`
-	Enabled        bool               `json:"enabled" bson:"enabled"`
-	OTPEnabled     bool               `json:"otpEnabled" bson:"otpEnabled"`
-	OTPSecret      string             `bson:"otpSecret"`
-	CreateDate     time.Time          `json:"createDate" bson:"createDate"`
-	Data           interface{}        `json:"data" bson:"data,omitempty"`
+	ID                     UserID                 `json:"id" bson:"_id,omitempty"`
+	Email                  string                 `json:"email" bson:"email"`
+	AdditionalEmails       []string               `json:"additionalEmails,omitempty" bson:"additionalEmails,omitempty"`
+	Phone                  string                 `json:"phone,omitempty" bson:"phone"`
+	PhoneVerified          bool                   `json:"phoneVerified" bson:"phoneVerified"`
+	HashedPassword         string                 `json:"password,omitempty" bson:"password"`
+	Confirmed              bool                   `json:"confirmed" bson:"confirmed"`
+	Enabled                bool                   `json:"enabled" bson:"enabled"`
+	OTPEnabled             bool                   `json:"otpEnabled" bson:"otpEnabled"`
+	OTPSecret              string                 `bson:"otpSecret"`
+	FailedLogins           int                    `json:"failedLogins" bson:"failedLogins"`
+	Deleted                bool                   `json:"deleted" bson:"deleted"`
+	DeleteDate             time.Time              `json:"deleteDate,omitempty" bson:"deleteDate,omitempty"`
+	CreateDate             time.Time              `json:"createDate" bson:"createDate"`
+	PasswordChangeDate     time.Time              `json:"passwordChangeDate,omitempty" bson:"passwordChangeDate,omitempty"`
+	PasswordChangeRequired bool                   `json:"passwordChangeRequired" bson:"passwordChangeRequired"`
+	Roles                  []string               `json:"roles,omitempty" bson:"roles,omitempty"`
+	Organization           string                 `json:"organization,omitempty" bson:"organization,omitempty"`
+	OrganizationRole       string                 `json:"organizationRole,omitempty" bson:"organizationRole,omitempty"`
+	GuestID                string                 `json:"guestId,omitempty" bson:"guestId,omitempty"`
+	Data                   interface{}            `json:"data" bson:"data,omitempty"`
+	Metadata               map[string]interface{} `json:"metadata,omitempty" bson:"metadata,omitempty"`
+	AppMetadata            map[string]interface{} `json:"appMetadata,omitempty" bson:"appMetadata,omitempty"`
+	Preferences            map[string]string      `json:"preferences,omitempty" bson:"preferences,omitempty"`
 }
 
+// UserStore persists users, see STORAGE
+type UserStore interface {
+	Create(u *User)
+	GetOne(id string) *User
+	GetByEmail(email string) *User
+	Update(u *User)
+	SetOrganization(u *User, organizationID, role string)
+	AddAdditionalEmail(u *User, email string)
+	RemoveAdditionalEmail(u *User, email string)
+	PromoteAdditionalEmail(u *User, email string)
+	SetMetadata(u *User, metadata map[string]interface{})
+	SetAppMetadata(u *User, metadata map[string]interface{})
+	SetPreferences(u *User, preferences map[string]string)
+	GetAllForOrganization(organizationID string) []*User
+	RemoveAllFromOrganization(organizationID string)
+	Delete(u *User)
+	SoftDelete(u *User)
+	Restore(u *User)
+	Count(confirmed *bool) int64
+	CountSignupsPerDay(from time.Time) map[string]int64
+	GetDeletedBefore(date time.Time) []*User
+}
+
+// UserRepository manages users, storing them in the UserStore of the configured storage backend
 type UserRepository struct {
+	UserStore
 }
 
 var _userRepositoryInstance *UserRepository
@@ -34,83 +73,354 @@ var _userRepositoryOnce sync.Once
 
 func GetUserRepository() *UserRepository {
 	_userRepositoryOnce.Do(func() {
-		_userRepositoryInstance = &UserRepository{}
-		ctx, _ := context.WithTimeout(context.Background(), 15*time.Second)
-		// Create unique index on 'email'
-		col := &options.Collation{
-			Strength: 1,
-			Locale:   "en",
-		}
-		mod := mongo.IndexModel{
-			Keys: bson.M{
-				"email": 1,
-			},
-			Options: options.Index().SetUnique(true).SetCollation(col),
-		}
-		_, err := _userRepositoryInstance.GetCollection().Indexes().CreateOne(ctx, mod)
-		if err != nil {
-			log.Fatal(err)
+		store := GetStorageDriver().NewUserStore()
+		if GetConfig().UserCacheTTL > 0 {
+			store = NewCachingUserStore(store, time.Second*GetConfig().UserCacheTTL)
 		}
+		_userRepositoryInstance = &UserRepository{store}
 	})
 	return _userRepositoryInstance
 }
 
-func (r *UserRepository) GetCollection() *mongo.Collection {
-	return GetDatatabase().Database.Collection("users")
+type MongoUserStore struct {
+}
+
+func NewMongoUserStore() *MongoUserStore {
+	return &MongoUserStore{}
 }
 
-func (r *UserRepository) Create(u *User) {
-	res, err := r.GetCollection().InsertOne(context.TODO(), u)
+func (r *MongoUserStore) GetCollection() *mongo.Collection {
+	return GetDatatabase().Collection("users")
+}
+
+func (r *MongoUserStore) Create(u *User) {
+	if u.ID == "" {
+		u.ID = NewUserID()
+	}
+	_, err := r.GetCollection().InsertOne(context.TODO(), u)
 	if err != nil {
 		log.Println(err)
 	}
-	u.ID = res.InsertedID.(primitive.ObjectID)
 }
 
-func (r *UserRepository) GetOne(id string) *User {
+func (r *MongoUserStore) GetOne(id string) *User {
 	var user User
-	err := r.GetCollection().FindOne(context.TODO(), GetDatatabase().GetIDFilter(id)).Decode(&user)
+	err := r.GetCollection().FindOne(context.TODO(), bson.M{"_id": UserID(id)}).Decode(&user)
 	if err != nil {
 		return nil
 	}
 	return &user
 }
 
-func (r *UserRepository) GetByEmail(email string) *User {
+func (r *MongoUserStore) GetByEmail(email string) *User {
+	email = NormalizeEmail(email)
 	var user User
 	col := &options.Collation{
 		Strength: 1,
 		Locale:   "en",
 	}
-	err := r.GetCollection().FindOne(context.TODO(), bson.M{"email": email}, options.FindOne().SetCollation(col)).Decode(&user)
+	filter := bson.M{"$or": bson.A{
+		bson.M{"email": email},
+		bson.M{"additionalEmails": email},
+	}}
+	err := r.GetCollection().FindOne(context.TODO(), filter, options.FindOne().SetCollation(col)).Decode(&user)
 	if err != nil {
 		return nil
 	}
 	return &user
 }
 
-func (r *UserRepository) Update(u *User) {
+func (r *MongoUserStore) Update(u *User) {
 	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": u})
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// SetOrganization makes the user a member of an organization, or removes the membership if organizationID is empty
+func (r *MongoUserStore) SetOrganization(u *User, organizationID, role string) {
+	u.Organization = organizationID
+	u.OrganizationRole = role
+	update := bson.M{"$set": bson.M{"organization": organizationID, "organizationRole": role}}
+	if organizationID == "" {
+		u.OrganizationRole = ""
+		update = bson.M{"$unset": bson.M{"organization": "", "organizationRole": ""}}
+	}
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, update)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// SetMetadata replaces the user's self-service metadata
+// HasAdditionalEmail checks if the address is one of the user's verified additional email addresses
+func (r *UserRepository) HasAdditionalEmail(u *User, email string) bool {
+	for _, e := range u.AdditionalEmails {
+		if strings.EqualFold(e, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MongoUserStore) AddAdditionalEmail(u *User, email string) {
+	u.AdditionalEmails = append(u.AdditionalEmails, email)
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$addToSet": bson.M{"additionalEmails": email}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoUserStore) RemoveAdditionalEmail(u *User, email string) {
+	emails := make([]string, 0)
+	for _, e := range u.AdditionalEmails {
+		if !strings.EqualFold(e, email) {
+			emails = append(emails, e)
+		}
+	}
+	u.AdditionalEmails = emails
+	update := bson.M{"$set": bson.M{"additionalEmails": emails}}
+	if len(emails) == 0 {
+		// Unset rather than store an empty array, so the sparse unique index doesn't see it
+		update = bson.M{"$unset": bson.M{"additionalEmails": ""}}
+	}
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, update)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// PromoteAdditionalEmail makes an additional email address the primary one, keeping the old primary address as an additional one
+func (r *MongoUserStore) PromoteAdditionalEmail(u *User, email string) {
+	emails := []string{u.Email}
+	for _, e := range u.AdditionalEmails {
+		if !strings.EqualFold(e, email) {
+			emails = append(emails, e)
+		}
+	}
+	u.Email = email
+	u.AdditionalEmails = emails
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"email": u.Email, "additionalEmails": u.AdditionalEmails}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoUserStore) SetMetadata(u *User, metadata map[string]interface{}) {
+	u.Metadata = metadata
+	r._SetField(u, "metadata", metadata)
+}
+
+// SetAppMetadata replaces the user's admin-managed application metadata
+func (r *MongoUserStore) SetAppMetadata(u *User, metadata map[string]interface{}) {
+	u.AppMetadata = metadata
+	r._SetField(u, "appMetadata", metadata)
+}
+
+// SetPreferences replaces the user's preferences
+func (r *MongoUserStore) SetPreferences(u *User, preferences map[string]string) {
+	u.Preferences = preferences
+	r._SetField(u, "preferences", preferences)
+}
+
+func (r *MongoUserStore) _SetField(u *User, field string, value interface{}) {
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{field: value}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (r *MongoUserStore) GetAllForOrganization(organizationID string) []*User {
+	results := make([]*User, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{"organization": organizationID})
+	if err != nil {
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var user User
+		err := cur.Decode(&user)
+		if err != nil {
+			return results
+		}
+		results = append(results, &user)
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+func (r *MongoUserStore) RemoveAllFromOrganization(organizationID string) {
+	_, err := r.GetCollection().UpdateMany(context.TODO(), bson.M{"organization": organizationID}, bson.M{"$unset": bson.M{"organization": "", "organizationRole": ""}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
 func (r *UserRepository) Delete(u *User) {
-	GetPendingActionRepository().DeleteAllForUser(u.ID.Hex())
-	GetRefreshTokenRepository().DeleteAllForUser(u.ID.Hex())
+	GetPendingActionRepository().DeleteAllForUser(u.ID.String())
+	GetRefreshTokenRepository().DeleteAllForUser(u.ID.String())
+	GetTrustedDeviceRepository().DeleteAllForUser(u.ID.String())
+	GetAPIKeyRepository().DeleteAllForUser(u.ID.String())
+	GetDeviceCodeRepository().DeleteAllForUser(u.ID.String())
+	GetClientCertificateRepository().DeleteAllForUser(u.ID.String())
+	GetLinkedIdentityRepository().DeleteAllForUser(u.ID.String())
+	r.UserStore.Delete(u)
+}
+
+func (r *MongoUserStore) Delete(u *User) {
 	_, err := r.GetCollection().DeleteOne(context.TODO(), bson.M{"_id": u.ID})
 	if err != nil {
 		log.Println(err)
 	}
 }
 
+// SoftDelete marks the user as deleted and revokes all tokens. The user is purged by CleanUp
+// after DELETED_USER_RETENTION days, or immediately if the retention is 0.
+func (r *UserRepository) SoftDelete(u *User) {
+	if GetConfig().DeletedUserRetention == 0 {
+		r.Delete(u)
+		return
+	}
+	GetPendingActionRepository().DeleteAllForUser(u.ID.String())
+	GetRefreshTokenRepository().DeleteAllForUser(u.ID.String())
+	GetTrustedDeviceRepository().DeleteAllForUser(u.ID.String())
+	GetDeviceCodeRepository().DeleteAllForUser(u.ID.String())
+	r.UserStore.SoftDelete(u)
+}
+
+// SoftDelete marks the user as deleted
+func (r *MongoUserStore) SoftDelete(u *User) {
+	u.Deleted = true
+	u.DeleteDate = time.Now()
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"deleted": true, "deleteDate": u.DeleteDate}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Restore reactivates a soft-deleted user
+func (r *MongoUserStore) Restore(u *User) {
+	u.Deleted = false
+	u.DeleteDate = time.Time{}
+	_, err := r.GetCollection().UpdateOne(context.TODO(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"deleted": false}, "$unset": bson.M{"deleteDate": ""}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Count returns the number of users which are not deleted, optionally restricted to confirmed or unconfirmed users
+func (r *MongoUserStore) Count(confirmed *bool) int64 {
+	filter := bson.M{"deleted": bson.M{"$ne": true}}
+	if confirmed != nil {
+		filter["confirmed"] = *confirmed
+	}
+	count, err := r.GetCollection().CountDocuments(context.TODO(), filter)
+	if err != nil {
+		log.Println(err)
+	}
+	return count
+}
+
+// CountSignupsPerDay returns the number of users created per day (UTC, formatted as YYYY-MM-DD) since the given date
+func (r *MongoUserStore) CountSignupsPerDay(from time.Time) map[string]int64 {
+	results := make(map[string]int64)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"createDate": bson.M{"$gte": from}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$createDate"}},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	cur, err := r.GetCollection().Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		log.Println(err)
+		return results
+	}
+	for cur.Next(context.TODO()) {
+		var day struct {
+			Date  string `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+		if err := cur.Decode(&day); err != nil {
+			log.Println(err)
+			break
+		}
+		results[day.Date] = day.Count
+	}
+	cur.Close(context.TODO())
+	return results
+}
+
+// CleanUp purges soft-deleted users whose retention period has passed
+func (r *UserRepository) CleanUp() {
+	purgeDate := time.Now().Add(-GetConfig().DeletedUserRetention * 24 * time.Hour)
+	for _, user := range r.GetDeletedBefore(purgeDate) {
+		log.Println("Purging deleted UserID", user.ID.String())
+		r.Delete(user)
+	}
+}
+
+// GetDeletedBefore returns the soft-deleted users deleted on or before the given date
+func (r *MongoUserStore) GetDeletedBefore(date time.Time) []*User {
+	users := make([]*User, 0)
+	cur, err := r.GetCollection().Find(context.TODO(), bson.M{"deleted": true, "deleteDate": bson.M{"$lte": date}})
+	if err != nil {
+		log.Println(err)
+		return users
+	}
+	for cur.Next(context.TODO()) {
+		var user User
+		if err := cur.Decode(&user); err != nil {
+			log.Println(err)
+			break
+		}
+		users = append(users, &user)
+	}
+	cur.Close(context.TODO())
+	return users
+}
+
+// SetPassword stores a new password for the user, resetting its age
+func (r *UserRepository) SetPassword(u *User, password string) {
+	u.HashedPassword = r.GetHashedPassword(password)
+	u.PasswordChangeDate = time.Now()
+	u.PasswordChangeRequired = false
+	r.Update(u)
+}
+
+// DisableOTP removes the user's OTP enrollment including all trusted devices
+func (r *UserRepository) DisableOTP(u *User) {
+	u.OTPSecret = ""
+	u.OTPEnabled = false
+	r.Update(u)
+	GetTrustedDeviceRepository().DeleteAllForUser(u.ID.String())
+}
+
+// UpgradePasswordHash re-hashes a verified password if the stored hash doesn't match the configured algorithm and parameters
+func (r *UserRepository) UpgradePasswordHash(u *User, password string) {
+	if !PasswordNeedsRehash(u.HashedPassword) {
+		return
+	}
+	log.Println("Upgrading password hash for UserID", u.ID.String())
+	u.HashedPassword = r.GetHashedPassword(password)
+	r.Update(u)
+}
+
+// IsPasswordExpired checks if the user's password is older than PASSWORD_MAX_AGE days
+func (r *UserRepository) IsPasswordExpired(u *User) bool {
+	if GetConfig().PasswordMaxAge == 0 {
+		return false
+	}
+	changeDate := u.PasswordChangeDate
+	if changeDate.IsZero() {
+		changeDate = u.CreateDate
+	}
+	return time.Since(changeDate) > GetConfig().PasswordMaxAge*24*time.Hour
+}
+
 func (r *UserRepository) GetHashedPassword(password string) string {
-	pwHash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(pwHash)
+	return HashPassword(password)
 }
 
 func (r *UserRepository) CheckPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+	return VerifyPassword(hashedPassword, password)
 }
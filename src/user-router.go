@@ -17,11 +17,28 @@ func (router *UserRouter) setupRoutes(s *mux.Router) {
 	s.HandleFunc("/{id}", router.delete).Methods("DELETE")
 	s.HandleFunc("/{id}/email", router.setEmail).Methods("PUT")
 	s.HandleFunc("/{id}/password", router.setPassword).Methods("PUT")
+	s.HandleFunc("/{id}/pwreset", router.initPasswordReset).Methods("POST")
+	s.HandleFunc("/{id}/otp/disable", router.disableOTP).Methods("PUT")
 	s.HandleFunc("/{id}/enable", router.enableUser).Methods("PUT")
 	s.HandleFunc("/{id}/disable", router.disableUser).Methods("PUT")
+	s.HandleFunc("/{id}/restore", router.restoreUser).Methods("PUT")
 	s.HandleFunc("/{id}/data", router.getUserData).Methods("GET")
 	s.HandleFunc("/{id}/data", router.setUserData).Methods("PUT")
+	s.HandleFunc("/{id}/metadata", router.getMetadata).Methods("GET")
+	s.HandleFunc("/{id}/metadata", router.setMetadata).Methods("PUT")
+	s.HandleFunc("/{id}/appmetadata", router.getAppMetadata).Methods("GET")
+	s.HandleFunc("/{id}/appmetadata", router.setAppMetadata).Methods("PUT")
 	s.HandleFunc("/{id}/checkpw", router.checkPassword).Methods("POST")
+	if GetConfig().EnableAPIKeys {
+		s.HandleFunc("/{id}/apikeys", router.getAPIKeys).Methods("GET")
+		s.HandleFunc("/{id}/apikeys", router.createAPIKey).Methods("POST")
+		s.HandleFunc("/{id}/apikeys/{keyId}", router.deleteAPIKey).Methods("DELETE")
+	}
+	if GetConfig().EnableClientCertAuth {
+		s.HandleFunc("/{id}/certs", router.getClientCertificates).Methods("GET")
+		s.HandleFunc("/{id}/certs", router.addClientCertificate).Methods("POST")
+		s.HandleFunc("/{id}/certs/{certId}", router.deleteClientCertificate).Methods("DELETE")
+	}
 	s.HandleFunc("/", router.Create).Methods("POST")
 	s.HandleFunc("/", router.getAll).Methods("GET")
 }
@@ -33,6 +50,7 @@ func (router *UserRouter) Create(w http.ResponseWriter, r *http.Request) {
 		SendBadRequest(w)
 		return
 	}
+	data.Email = NormalizeEmail(data.Email)
 	if GetUserRepository().GetByEmail(data.Email) != nil {
 		SendAleadyExists(w)
 		return
@@ -50,7 +68,7 @@ func (router *UserRouter) Create(w http.ResponseWriter, r *http.Request) {
 		CreateDate:     time.Now(),
 	}
 	GetUserRepository().Create(user)
-	SendCreated(w, user.ID)
+	SendCreated(w, user.ID.String())
 }
 
 func (router *UserRouter) getOne(w http.ResponseWriter, r *http.Request) {
@@ -75,7 +93,21 @@ func (router *UserRouter) delete(w http.ResponseWriter, r *http.Request) {
 		SendNotFound(w)
 		return
 	}
-	GetUserRepository().Delete(user)
+	if r.URL.Query().Get("purge") == "1" {
+		GetUserRepository().Delete(user)
+	} else {
+		GetUserRepository().SoftDelete(user)
+	}
+	SendUpdated(w)
+}
+
+func (router *UserRouter) restoreUser(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil || !user.Deleted {
+		SendNotFound(w)
+		return
+	}
+	GetUserRepository().Restore(user)
 	SendUpdated(w)
 }
 
@@ -90,6 +122,7 @@ func (router *UserRouter) setEmail(w http.ResponseWriter, r *http.Request) {
 		SendBadRequest(w)
 		return
 	}
+	data.Email = NormalizeEmail(data.Email)
 	if GetUserRepository().GetByEmail(data.Email) != nil {
 		SendAleadyExists(w)
 		return
@@ -114,8 +147,40 @@ func (router *UserRouter) setPassword(w http.ResponseWriter, r *http.Request) {
 		SendBadRequest(w)
 		return
 	}
-	user.HashedPassword = GetUserRepository().GetHashedPassword(data.Password)
-	GetUserRepository().Update(user)
+	GetUserRepository().SetPassword(user, data.Password)
+	if data.ForceChange {
+		user.PasswordChangeRequired = true
+		GetUserRepository().Update(user)
+	}
+	if data.RevokeSessions {
+		GetRefreshTokenRepository().DeleteAllForUser(user.ID.String())
+	}
+	SendUpdated(w)
+}
+
+func (router *UserRouter) initPasswordReset(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	if !user.Enabled || user.Deleted {
+		log.Println("Invalid init password reset attempt: disabled or deleted UserID", user.ID.String())
+		SendBadRequest(w)
+		return
+	}
+	InitPasswordReset(user, user.Email)
+	SendUpdated(w)
+}
+
+func (router *UserRouter) disableOTP(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	GetUserRepository().DisableOTP(user)
+	GetRefreshTokenRepository().DeleteAllForUser(user.ID.String())
 	SendUpdated(w)
 }
 
@@ -171,6 +236,62 @@ func (router *UserRouter) getUserData(w http.ResponseWriter, r *http.Request) {
 	SendJSON(w, data)
 }
 
+func (router *UserRouter) getMetadata(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, NonNilMetadata(user.Metadata))
+}
+
+func (router *UserRouter) setMetadata(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	metadata, err := UnmarshalMetadataBody(r)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	GetUserRepository().SetMetadata(user, metadata)
+	SendUpdated(w)
+}
+
+func (router *UserRouter) getAppMetadata(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, NonNilMetadata(user.AppMetadata))
+}
+
+func (router *UserRouter) setAppMetadata(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	metadata, err := UnmarshalMetadataBody(r)
+	if err != nil {
+		SendBadRequest(w)
+		return
+	}
+	GetUserRepository().SetAppMetadata(user, metadata)
+	SendUpdated(w)
+}
+
+// NonNilMetadata returns an empty metadata document instead of nil, so it is sent as {} rather than null
+func NonNilMetadata(metadata map[string]interface{}) map[string]interface{} {
+	if metadata == nil {
+		return make(map[string]interface{})
+	}
+	return metadata
+}
+
 func (router *UserRouter) checkPassword(w http.ResponseWriter, r *http.Request) {
 	user := router.getUserFromMuxVars(w, r)
 	if user == nil {
@@ -188,6 +309,105 @@ func (router *UserRouter) checkPassword(w http.ResponseWriter, r *http.Request)
 	SendJSON(w, result)
 }
 
+func (router *UserRouter) getAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, GetAPIKeyRepository().GetAllForUser(user.ID.String()))
+}
+
+func (router *UserRouter) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	var data CreateAPIKeyRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		SendBadRequest(w)
+		return
+	}
+	apiKey, key := GetAPIKeyRepository().CreateForUser(user, data.Name, data.Scopes)
+	w.Header().Set("X-Object-ID", apiKey.ID.Hex())
+	SendJSONWithStatus(w, http.StatusCreated, &CreateAPIKeyResponse{
+		ID:  apiKey.ID.Hex(),
+		Key: key,
+	})
+}
+
+func (router *UserRouter) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	vars := mux.Vars(r)
+	apiKey := GetAPIKeyRepository().GetOne(vars["keyId"])
+	if apiKey == nil || apiKey.UserID != user.ID {
+		SendNotFound(w)
+		return
+	}
+	GetAPIKeyRepository().Delete(apiKey)
+	SendUpdated(w)
+}
+
+func (router *UserRouter) getClientCertificates(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	SendJSON(w, GetClientCertificateRepository().GetAllForUser(user.ID.String()))
+}
+
+func (router *UserRouter) addClientCertificate(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	var data AddClientCertificateRequest
+	if UnmarshalValidateBody(r, &data) != nil {
+		SendBadRequest(w)
+		return
+	}
+	fingerprint := GetClientCertificateRepository().NormalizeFingerprint(data.Fingerprint)
+	if len(fingerprint) != 64 {
+		SendBadRequest(w)
+		return
+	}
+	if GetClientCertificateRepository().GetByFingerprint(fingerprint) != nil {
+		SendAleadyExists(w)
+		return
+	}
+	cert := &ClientCertificate{
+		UserID:      user.ID,
+		Name:        data.Name,
+		Fingerprint: fingerprint,
+		CreateDate:  time.Now(),
+	}
+	GetClientCertificateRepository().Create(cert)
+	SendCreated(w, cert.ID.Hex())
+}
+
+func (router *UserRouter) deleteClientCertificate(w http.ResponseWriter, r *http.Request) {
+	user := router.getUserFromMuxVars(w, r)
+	if user == nil {
+		SendNotFound(w)
+		return
+	}
+	vars := mux.Vars(r)
+	cert := GetClientCertificateRepository().GetOne(vars["certId"])
+	if cert == nil || cert.UserID != user.ID {
+		SendNotFound(w)
+		return
+	}
+	GetClientCertificateRepository().Delete(cert)
+	SendUpdated(w)
+}
+
 func (router *UserRouter) getAll(w http.ResponseWriter, r *http.Request) {
 	// TODO Implement method
 	SendInternalServerError(w)
@@ -224,7 +444,9 @@ type SetEmailRequest struct {
 }
 
 type SetPasswordRequest struct {
-	Password string `json:"password" validate:"required,min=8,max=32"`
+	Password       string `json:"password" validate:"required,min=8,max=32"`
+	ForceChange    bool   `json:"forceChange"`
+	RevokeSessions bool   `json:"revokeSessions"`
 }
 
 type BoolResult struct {
@@ -243,3 +465,8 @@ type CreateUserRequest struct {
 	Enabled   bool        `json:"enabled,omitempty"`
 	Data      interface{} `json:"data,omitempty"`
 }
+
+type AddClientCertificateRequest struct {
+	Name        string `json:"name" validate:"required,max=64"`
+	Fingerprint string `json:"fingerprint" validate:"required"`
+}
@@ -5,10 +5,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"testing"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"github.com/dgrijalva/jwt-go"
 )
 
 func TestCreateUser(t *testing.T) {
@@ -89,7 +90,7 @@ func TestCreateUserConflictingPendingChange(t *testing.T) {
 		ActionType: PendingActionTypeChangeEmail,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * GetConfig().PendingActionLifetime),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "foo@bar.com",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
@@ -105,7 +106,7 @@ func TestGetSimpleUser(t *testing.T) {
 	clearTestDB()
 	user := createTestUser(true)
 
-	req, _ := http.NewRequest("GET", "/users/"+user.ID.Hex(), nil)
+	req, _ := http.NewRequest("GET", "/users/"+user.ID.String(), nil)
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusOK, res.Code)
 
@@ -118,7 +119,7 @@ func TestGetSimpleUser(t *testing.T) {
 		t.Error(err)
 	}
 	checkTestString(t, user.Email, data.Email)
-	checkTestString(t, user.ID.Hex(), data.ID)
+	checkTestString(t, user.ID.String(), data.ID)
 	if data.Data != (dummyUserData{}) {
 		t.Error("Expected empty user data")
 	}
@@ -138,15 +139,46 @@ func TestDeleteUser(t *testing.T) {
 	clearTestDB()
 	user := createTestUser(true)
 
-	req, _ := http.NewRequest("DELETE", "/users/"+user.ID.Hex(), nil)
+	req, _ := http.NewRequest("DELETE", "/users/"+user.ID.String()+"?purge=1", nil)
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
-	req, _ = http.NewRequest("GET", "/users/"+user.ID.Hex(), nil)
+	req, _ = http.NewRequest("GET", "/users/"+user.ID.String(), nil)
 	res = executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNotFound, res.Code)
 }
 
+func TestSoftDeleteAndRestoreUser(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+
+	// Restoring an active user fails
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/restore", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNotFound, res.Code)
+
+	req, _ = http.NewRequest("DELETE", "/users/"+user.ID.String(), nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if !GetUserRepository().GetOne(user.ID.String()).Deleted {
+		t.Fatal("Expected user to be soft-deleted")
+	}
+	payload := `{"email": "foo@bar.com", "password": "12345678"}`
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+
+	req, _ = http.NewRequest("PUT", "/users/"+user.ID.String()+"/restore", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if GetUserRepository().GetOne(user.ID.String()).Deleted {
+		t.Fatal("Expected user to be restored")
+	}
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+}
+
 func TestDeleteNonExistingUser(t *testing.T) {
 	clearTestDB()
 	createTestUser(true)
@@ -162,7 +194,7 @@ func TestSetPassword(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"password": "x1x2x3x4"}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/password", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/password", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
@@ -177,12 +209,63 @@ func TestSetPassword(t *testing.T) {
 	checkTestResponseCode(t, http.StatusOK, res.Code)
 }
 
+func TestSetPasswordForceChangeRevokeSessions(t *testing.T) {
+	clearTestDB()
+	loginResponse := createLoginTestUser()
+	user := GetUserRepository().GetByEmail("foo@bar.com")
+
+	payload := `{"password": "x1x2x3x4", "forceChange": true, "revokeSessions": true}`
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/password", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	payload = `{"refreshToken": "` + loginResponse.RefreshToken + `"}`
+	req = newHTTPRequest("POST", "/auth/refresh", loginResponse.AccessToken, bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+
+	loginResponse = loginUser("foo@bar.com", "x1x2x3x4")
+	if !loginResponse.RequirePasswordChange {
+		t.Error("Expected password change to be required")
+	}
+}
+
+func TestInitPasswordReset(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+
+	req, _ := http.NewRequest("POST", "/users/"+user.ID.String()+"/pwreset", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	checkTestString(t, user.Email, smtpMockContent.RcptValue)
+
+	req, _ = http.NewRequest("POST", "/auth/confirm/"+smtpMockContent.Buffer.DataValue, nil)
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	payload := `{"email": "foo@bar.com", "password": "` + smtpMockContent.Buffer.DataValue + `"}`
+	req, _ = http.NewRequest("POST", "/auth/login", bytes.NewBufferString(payload))
+	res = executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+}
+
+func TestInitPasswordResetDisabledUser(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	user.Enabled = false
+	GetUserRepository().Update(user)
+
+	req, _ := http.NewRequest("POST", "/users/"+user.ID.String()+"/pwreset", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
+}
+
 func TestSetPasswordShort(t *testing.T) {
 	clearTestDB()
 	user := createTestUser(true)
 
 	payload := `{"password": "x1x2x3"}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/password", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/password", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
 }
@@ -192,7 +275,7 @@ func TestChangeEmail(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"email": "foo2@bar.com"}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/email", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/email", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
@@ -214,14 +297,14 @@ func TestChangeEmailConflictingChange(t *testing.T) {
 		ActionType: PendingActionTypeChangeEmail,
 		CreateDate: time.Now(),
 		ExpiryDate: time.Now().Add(time.Duration(time.Minute) * GetConfig().PendingActionLifetime),
-		UserID:     primitive.NewObjectID(),
+		UserID:     NewUserID(),
 		Payload:    "foo2@bar.com",
 		Token:      GetPendingActionRepository().FindUnusedToken(),
 	}
 	GetPendingActionRepository().Create(&pa)
 
 	payload := `{"email": "fOo2@bAr.com"}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/email", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/email", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusConflict, res.Code)
 }
@@ -235,7 +318,7 @@ func TestChangeEmailAlreadyExists(t *testing.T) {
 	GetUserRepository().Create(user2)
 
 	payload := `{"email": "fOo2@bAr.com"}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/email", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/email", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusConflict, res.Code)
 }
@@ -245,16 +328,37 @@ func TestChangeEmailInvalidAddress(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"email": "foobar.com"}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/email", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/email", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusBadRequest, res.Code)
 }
 
+func TestDisableOTP(t *testing.T) {
+	clearTestDB()
+	user, _ := createOTPTestUser(true)
+
+	loginResponse := loginUser("foo@bar.com", "12345678")
+	if !loginResponse.RequireOTP {
+		t.Fatal("Expected OTP to be required")
+	}
+
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/otp/disable", nil)
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	user = GetUserRepository().GetOne(user.ID.String())
+	if user.OTPEnabled || user.OTPSecret != "" {
+		t.Error("Expected OTP enrollment to be removed")
+	}
+	loginResponse = loginUser("foo@bar.com", "12345678")
+	checkStringNotEmpty(t, loginResponse.AccessToken)
+}
+
 func TestDisableEnableUser(t *testing.T) {
 	clearTestDB()
 	user := createTestUser(true)
 
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/disable", nil)
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/disable", nil)
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
@@ -263,7 +367,7 @@ func TestDisableEnableUser(t *testing.T) {
 	res = executePublicTestRequest(req)
 	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
 
-	req, _ = http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/enable", nil)
+	req, _ = http.NewRequest("PUT", "/users/"+user.ID.String()+"/enable", nil)
 	res = executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
@@ -278,7 +382,7 @@ func TestSetUserData(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"color": "red", "height": 1.85}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/data", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/data", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 }
@@ -288,11 +392,11 @@ func TestGetUserData(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"color": "red", "height": 1.85}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/data", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/data", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
-	req, _ = http.NewRequest("GET", "/users/"+user.ID.Hex()+"/data", nil)
+	req, _ = http.NewRequest("GET", "/users/"+user.ID.String()+"/data", nil)
 	res = executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusOK, res.Code)
 	body, err := ioutil.ReadAll(res.Body)
@@ -314,11 +418,11 @@ func TestGetUserWithData(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"color": "red", "height": 1.85}`
-	req, _ := http.NewRequest("PUT", "/users/"+user.ID.Hex()+"/data", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/data", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusNoContent, res.Code)
 
-	req, _ = http.NewRequest("GET", "/users/"+user.ID.Hex(), nil)
+	req, _ = http.NewRequest("GET", "/users/"+user.ID.String(), nil)
 	res = executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusOK, res.Code)
 	body, err := ioutil.ReadAll(res.Body)
@@ -330,7 +434,7 @@ func TestGetUserWithData(t *testing.T) {
 		t.Error(err)
 	}
 	checkTestString(t, user.Email, data.Email)
-	checkTestString(t, user.ID.Hex(), data.ID)
+	checkTestString(t, user.ID.String(), data.ID)
 	checkTestString(t, "red", data.Data.Color)
 	if data.Data.Height != 1.85 {
 		t.Errorf("Expected 1.85, got %f", data.Data.Height)
@@ -342,7 +446,7 @@ func TestCheckPasswordPositive(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"password": "12345678"}`
-	req, _ := http.NewRequest("POST", "/users/"+user.ID.Hex()+"/checkpw", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("POST", "/users/"+user.ID.String()+"/checkpw", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusOK, res.Code)
 
@@ -358,7 +462,7 @@ func TestCheckPasswordNegative(t *testing.T) {
 	user := createTestUser(true)
 
 	payload := `{"password": "00000000"}`
-	req, _ := http.NewRequest("POST", "/users/"+user.ID.Hex()+"/checkpw", bytes.NewBufferString(payload))
+	req, _ := http.NewRequest("POST", "/users/"+user.ID.String()+"/checkpw", bytes.NewBufferString(payload))
 	res := executeBackendTestRequest(req)
 	checkTestResponseCode(t, http.StatusOK, res.Code)
 
@@ -382,3 +486,85 @@ type dummyUserData struct {
 	Color  string  `json:"color"`
 	Height float32 `json:"height"`
 }
+
+func TestCreateDeleteAPIKey(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+
+	payload := `{"name": "service"}`
+	req, _ := http.NewRequest("POST", "/users/"+user.ID.String()+"/apikeys", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	var createResponse CreateAPIKeyResponse
+	json.Unmarshal(res.Body.Bytes(), &createResponse)
+	if GetAPIKeyRepository().GetByKey(createResponse.Key) == nil {
+		t.Fatal("Expected API key to exist")
+	}
+
+	req, _ = http.NewRequest("DELETE", "/users/"+user.ID.String()+"/apikeys/"+createResponse.ID, nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if GetAPIKeyRepository().GetByKey(createResponse.Key) != nil {
+		t.Error("Expected API key to be deleted")
+	}
+}
+
+func TestAddDeleteClientCertificate(t *testing.T) {
+	clearTestDB()
+	user := createTestUser(true)
+	fingerprint := GetClientCertificateRepository().GetFingerprint(createTestClientCertificate("foo@bar.com"))
+
+	payload := `{"name": "laptop", "fingerprint": "` + fingerprint + `"}`
+	req, _ := http.NewRequest("POST", "/users/"+user.ID.String()+"/certs", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusCreated, res.Code)
+	certID := res.Header().Get("X-Object-ID")
+
+	req, _ = http.NewRequest("POST", "/users/"+user.ID.String()+"/certs", bytes.NewBufferString(payload))
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusConflict, res.Code)
+
+	req, _ = http.NewRequest("DELETE", "/users/"+user.ID.String()+"/certs/"+certID, nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+	if GetClientCertificateRepository().GetByFingerprint(fingerprint) != nil {
+		t.Error("Expected client certificate to be deleted")
+	}
+}
+
+func TestSetAppMetadataIncludedInToken(t *testing.T) {
+	os.Setenv("TOKEN_APP_METADATA_FIELDS", "plan")
+	GetConfig().ReadConfig()
+	defer func() {
+		os.Setenv("TOKEN_APP_METADATA_FIELDS", "")
+		GetConfig().ReadConfig()
+	}()
+	clearTestDB()
+	user := createTestUser(true)
+
+	payload := `{"plan": "pro", "limits": {"projects": 10}}`
+	req, _ := http.NewRequest("PUT", "/users/"+user.ID.String()+"/appmetadata", bytes.NewBufferString(payload))
+	res := executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusNoContent, res.Code)
+
+	req, _ = http.NewRequest("GET", "/users/"+user.ID.String()+"/appmetadata", nil)
+	res = executeBackendTestRequest(req)
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	var data struct {
+		Plan   string         `json:"plan"`
+		Limits map[string]int `json:"limits"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &data)
+	checkTestString(t, "pro", data.Plan)
+	if data.Limits["projects"] != 10 {
+		t.Error("Expected nested app metadata to be returned")
+	}
+
+	loginResponse := loginUser("foo@bar.com", "12345678")
+	claims := &Claims{}
+	jwt.ParseWithClaims(loginResponse.AccessToken, claims, JwtKeyFunc)
+	checkTestString(t, "pro", claims.AppMetadata["plan"].(string))
+	if _, ok := claims.AppMetadata["limits"]; ok {
+		t.Error("Expected only configured fields to be included in token")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const webSocketTokenQueryParam = "access_token"
+const webSocketTokenProtocolPrefix = "bearer."
+
+// IsWebSocketUpgrade checks if the request asks for an upgrade to the WebSocket protocol
+func IsWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetWebSocketToken returns the JWT passed with a WebSocket upgrade request.
+// Browsers cannot set the Authorization header for WebSockets, so the token may also be passed
+// as the access_token query parameter or as a "bearer.<token>" entry in Sec-WebSocket-Protocol.
+func GetWebSocketToken(r *http.Request) string {
+	if token := r.URL.Query().Get(webSocketTokenQueryParam); token != "" {
+		return token
+	}
+	for _, protocol := range _GetWebSocketProtocols(r) {
+		if strings.HasPrefix(protocol, webSocketTokenProtocolPrefix) {
+			return strings.TrimPrefix(protocol, webSocketTokenProtocolPrefix)
+		}
+	}
+	return ""
+}
+
+// RemoveWebSocketToken strips the token from the query and subprotocols so it is not forwarded to the upstream
+func RemoveWebSocketToken(r *http.Request) {
+	query := r.URL.Query()
+	if query.Has(webSocketTokenQueryParam) {
+		query.Del(webSocketTokenQueryParam)
+		r.URL.RawQuery = query.Encode()
+	}
+	protocols := make([]string, 0)
+	for _, protocol := range _GetWebSocketProtocols(r) {
+		if !strings.HasPrefix(protocol, webSocketTokenProtocolPrefix) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	r.Header.Del("Sec-WebSocket-Protocol")
+	if len(protocols) > 0 {
+		r.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+}
+
+func _GetWebSocketProtocols(r *http.Request) []string {
+	res := make([]string, 0)
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(value, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				res = append(res, protocol)
+			}
+		}
+	}
+	return res
+}
+
+// webSocketResponseWriter clears the server's read and write timeouts once the connection
+// has been hijacked, as they would otherwise terminate long-lived WebSocket tunnels
+type webSocketResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *webSocketResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, rw, nil
+}
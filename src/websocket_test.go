@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func newWebSocketTestRequest(url string) *http.Request {
+	req := newHTTPRequest("GET", url, "", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	return req
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	if !IsWebSocketUpgrade(newWebSocketTestRequest("/ws")) {
+		t.Error("Expected WebSocket upgrade to be detected")
+	}
+	req := newHTTPRequest("GET", "/ws", "", nil)
+	req.Header.Set("Upgrade", "websocket")
+	if IsWebSocketUpgrade(req) {
+		t.Error("Expected no WebSocket upgrade without Connection: Upgrade")
+	}
+}
+
+func TestWebSocketToken(t *testing.T) {
+	req := newWebSocketTestRequest("/ws?foo=bar&access_token=abc")
+	checkTestString(t, "abc", GetWebSocketToken(req))
+	RemoveWebSocketToken(req)
+	checkTestString(t, "foo=bar", req.URL.RawQuery)
+
+	req = newWebSocketTestRequest("/ws")
+	req.Header.Set("Sec-WebSocket-Protocol", "chat, bearer.abc.def")
+	checkTestString(t, "abc.def", GetWebSocketToken(req))
+	RemoveWebSocketToken(req)
+	checkTestString(t, "chat", req.Header.Get("Sec-WebSocket-Protocol"))
+}
+
+func TestProxyWebSocketUnauthorized(t *testing.T) {
+	clearTestDB()
+	req := newWebSocketTestRequest("/blacklist/ws?access_token=invalid")
+	res := executePublicTestRequest(req)
+	checkTestResponseCode(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestProxyWebSocketProtocolToken(t *testing.T) {
+	handler := &dummyProxyHandler{}
+	var proxy *http.Server = &http.Server{
+		Addr:    "0.0.0.0:8090",
+		Handler: handler,
+	}
+	go func() {
+		proxy.ListenAndServe()
+	}()
+
+	clearTestDB()
+	user := createTestUser(true)
+	loginResponse := loginUser("foo@bar.com", "12345678")
+
+	req := newWebSocketTestRequest("/some/route/ws")
+	req.Header.Set("Sec-WebSocket-Protocol", "chat, bearer."+loginResponse.AccessToken)
+	res := executePublicTestRequest(req)
+
+	proxy.Shutdown(context.TODO())
+	checkTestResponseCode(t, http.StatusOK, res.Code)
+	checkTestString(t, user.ID.String(), handler.Headers.Get("X-Auth-UserID"))
+	checkTestString(t, "chat", handler.Headers.Get("Sec-WebSocket-Protocol"))
+	checkTestString(t, "websocket", handler.Headers.Get("Upgrade"))
+}
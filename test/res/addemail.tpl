@@ -0,0 +1 @@
+{{.ConfirmID}}
\ No newline at end of file
@@ -0,0 +1 @@
+{{.NewEmail}}
\ No newline at end of file
@@ -0,0 +1 @@
+{{.InvitationID}}
\ No newline at end of file
@@ -0,0 +1 @@
+de:{{.ConfirmID}}
\ No newline at end of file